@@ -25,6 +25,7 @@ import (
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"time"
 )
 
 var runCmd = &cobra.Command{
@@ -77,6 +78,45 @@ func init() {
 	runCmd.Flags().String("unifiedLoggingAddress", "localhost:8322", "Unified Logging Slave Address")
 	runCmd.Flags().String("storageFabricAddress", "", "Storage Fabric Address (host:port)")
 
+	runCmd.Flags().String("queueType", "memory", "Type of queue for the deployment requests: memory or file")
+	runCmd.Flags().String("queuePath", "/var/lib/deployment-manager/queue", "Directory where the file queue stores the deployment requests")
+	runCmd.Flags().Int("maxQueueLength", 0, "Maximum number of deployment requests waiting to be processed, 0 for no limit")
+	runCmd.Flags().Int("maxConcurrentFragments", 5, "Maximum number of fragments deployed concurrently")
+	runCmd.Flags().Int("maxConcurrentFragmentsPerOrg", 0, "Maximum number of fragments of the same organization deployed concurrently, 0 for no limit")
+
+	runCmd.Flags().Int("retryMaxAttempts", 3, "Maximum number of attempts to deploy a stage when the rollback policy is limited retry")
+	runCmd.Flags().Duration("retryInitialBackoff", 10*time.Second, "Time to wait after the first failed attempt to deploy a stage")
+	runCmd.Flags().Duration("retryMaxBackoff", 80*time.Second, "Maximum time to wait between attempts to deploy a stage")
+	runCmd.Flags().Float64("retryJitter", 0.2, "Fraction of the backoff randomly added or subtracted, between 0 and 1")
+	runCmd.Flags().Duration("stageCheckTimeout", 480*time.Second, "Time to wait for the resources of a stage before considering the attempt failed")
+	runCmd.Flags().Duration("shutdownTimeout", time.Minute, "Maximum time to wait for the running deployments to finish when shutting down")
+	runCmd.Flags().Int64("fragmentStorageQuota", 0, "Maximum storage in bytes requested by a deployment fragment, 0 for no limit")
+
+	runCmd.Flags().String("auditLogPath", "/var/log/deployment-manager/audit.log", "Path of the audit log of the mutating operations, empty to disable it")
+	runCmd.Flags().Bool("auditLogStdout", false, "Write the audit log of the mutating operations to the standard output as JSON lines")
+	runCmd.Flags().Int64("auditLogMaxSize", 100*1024*1024, "Maximum size in bytes of the audit log before rotating it, 0 for no rotation")
+	runCmd.Flags().Int("auditLogMaxBackups", 5, "Number of rotated audit log files kept")
+
+	runCmd.Flags().String("serverCertPath", "", "Directory with the tls.crt and tls.key of the gRPC server, empty to serve without TLS")
+	runCmd.Flags().String("clientCAPath", "", "Path for the CA certificate used to verify the client certificates, empty to not request them")
+	runCmd.Flags().String("authSecret", "", "Key used to verify the tokens of the callers, empty to disable the authentication. Alternatively you may use AUTH_SECRET")
+	runCmd.Flags().String("authPermissionsPath", "", "Path of the JSON file with the roles allowed per method, empty to use the default permissions")
+
+	runCmd.Flags().Duration("healthCheckInterval", 10*time.Second, "Time between checks of the dependencies reported by the readiness endpoints")
+	runCmd.Flags().Duration("healthCheckTimeout", 5*time.Second, "Maximum time to wait for a dependency check")
+
+	runCmd.Flags().Bool("leaderElection", false, "Elect a leader among the replicas, only the leader processes the deployment requests. The requests queued by a leader are lost when it loses the leadership")
+	runCmd.Flags().String("leaseNamespace", "", "Namespace of the lease used to elect the leader. Alternatively you may use POD_NAMESPACE")
+	runCmd.Flags().String("leaseName", "deployment-manager", "Name of the lease used to elect the leader")
+	runCmd.Flags().Duration("leaseDuration", 15*time.Second, "Time the followers wait before trying to acquire a lease not renewed")
+	runCmd.Flags().Duration("leaseRenewDeadline", 10*time.Second, "Time the leader retries to renew the lease before giving up the leadership")
+	runCmd.Flags().Duration("leaseRetryPeriod", 2*time.Second, "Time between attempts to acquire or renew the lease")
+
+	runCmd.Flags().Duration("terminatedRetention", 10*time.Minute, "Time the status of the fragments removed from the cluster is retained")
+	runCmd.Flags().String("monitorStoreType", "memory", "Type of store for the status of the monitored fragments: memory or file")
+	runCmd.Flags().String("monitorStorePath", "/var/lib/deployment-manager/monitor", "Directory where the file store keeps the status of the monitored fragments")
+	runCmd.Flags().Duration("notificationResyncPeriod", 5*time.Minute, "Time between notifications to conductor of every monitored service")
+
 	viper.BindPFlags(runCmd.Flags())
 }
 
@@ -87,6 +127,18 @@ func Run() {
 		return
 	}
 
+	queueType, err := config.QueueTypeFromString(viper.GetString("queueType"))
+	if err != nil {
+		log.Error().Err(err).Msg("invalid queue type")
+		return
+	}
+
+	monitorStoreType, err := config.MonitorStoreTypeFromString(viper.GetString("monitorStoreType"))
+	if err != nil {
+		log.Error().Err(err).Msg("invalid monitor store type")
+		return
+	}
+
 	config := config.Config{
 		Debug:                 debugLevel,
 		Port:                  uint32(viper.GetInt32("port")),
@@ -111,14 +163,46 @@ func Run() {
 			Email:            "devops@nalej.com",
 			DockerRepository: viper.GetString("publicRegistryURL"),
 		},
-		ZTSidecarPort:            uint32(viper.GetInt32("ztSidecarPort")),
-		ZTNalejImage:             viper.GetString("ztNalejImage"),
-		CACertPath:               viper.GetString("caCertPath"),
-		ClientCertPath:           viper.GetString("clientCertPath"),
-		SkipServerCertValidation: viper.GetBool("skipServerCertValidation"),
-		NetworkType:              netType,
-		UnifiedLoggingAddress:    viper.GetString("unifiedLoggingAddress"),
-		StorageFabricAddress:     viper.GetString("storageFabricAddress"),
+		ZTSidecarPort:                uint32(viper.GetInt32("ztSidecarPort")),
+		ZTNalejImage:                 viper.GetString("ztNalejImage"),
+		CACertPath:                   viper.GetString("caCertPath"),
+		ClientCertPath:               viper.GetString("clientCertPath"),
+		SkipServerCertValidation:     viper.GetBool("skipServerCertValidation"),
+		NetworkType:                  netType,
+		UnifiedLoggingAddress:        viper.GetString("unifiedLoggingAddress"),
+		StorageFabricAddress:         viper.GetString("storageFabricAddress"),
+		QueueType:                    queueType,
+		QueuePath:                    viper.GetString("queuePath"),
+		MaxQueueLength:               viper.GetInt("maxQueueLength"),
+		MaxConcurrentFragments:       viper.GetInt("maxConcurrentFragments"),
+		MaxConcurrentFragmentsPerOrg: viper.GetInt("maxConcurrentFragmentsPerOrg"),
+		RetryMaxAttempts:             viper.GetInt("retryMaxAttempts"),
+		RetryInitialBackoff:          viper.GetDuration("retryInitialBackoff"),
+		RetryMaxBackoff:              viper.GetDuration("retryMaxBackoff"),
+		RetryJitter:                  viper.GetFloat64("retryJitter"),
+		StageCheckTimeout:            viper.GetDuration("stageCheckTimeout"),
+		ShutdownTimeout:              viper.GetDuration("shutdownTimeout"),
+		FragmentStorageQuota:         viper.GetInt64("fragmentStorageQuota"),
+		AuditLogPath:                 viper.GetString("auditLogPath"),
+		AuditLogMaxSize:              viper.GetInt64("auditLogMaxSize"),
+		AuditLogMaxBackups:           viper.GetInt("auditLogMaxBackups"),
+		AuditLogStdout:               viper.GetBool("auditLogStdout"),
+		ServerCertPath:               viper.GetString("serverCertPath"),
+		ClientCAPath:                 viper.GetString("clientCAPath"),
+		AuthSecret:                   viper.GetString("authSecret"),
+		AuthPermissionsPath:          viper.GetString("authPermissionsPath"),
+		HealthCheckInterval:          viper.GetDuration("healthCheckInterval"),
+		HealthCheckTimeout:           viper.GetDuration("healthCheckTimeout"),
+		LeaderElection:               viper.GetBool("leaderElection"),
+		LeaseNamespace:               viper.GetString("leaseNamespace"),
+		LeaseName:                    viper.GetString("leaseName"),
+		LeaseDuration:                viper.GetDuration("leaseDuration"),
+		LeaseRenewDeadline:           viper.GetDuration("leaseRenewDeadline"),
+		LeaseRetryPeriod:             viper.GetDuration("leaseRetryPeriod"),
+		TerminatedRetention:          viper.GetDuration("terminatedRetention"),
+		MonitorStoreType:             monitorStoreType,
+		MonitorStorePath:             viper.GetString("monitorStorePath"),
+		NotificationResyncPeriod:     viper.GetDuration("notificationResyncPeriod"),
 	}
 
 	log.Info().Msg("launching deployment manager...")
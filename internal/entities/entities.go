@@ -36,6 +36,8 @@ const (
 	NALEJ_SERVICE_RUNNING
 	NALEJ_SERVICE_ERROR
 	NALEJ_SERVICE_TERMINATING
+	// The service has been scaled down to zero replicas until it is resumed
+	NALEJ_SERVICE_SUSPENDED
 )
 
 var ServiceStatusToGRPC = map[NalejServiceStatus]pbApplication.ServiceStatus{
@@ -45,6 +47,7 @@ var ServiceStatusToGRPC = map[NalejServiceStatus]pbApplication.ServiceStatus{
 	NALEJ_SERVICE_RUNNING:     pbApplication.ServiceStatus_SERVICE_RUNNING,
 	NALEJ_SERVICE_ERROR:       pbApplication.ServiceStatus_SERVICE_ERROR,
 	NALEJ_SERVICE_TERMINATING: pbApplication.ServiceStatus_SERVICE_TERMINATING,
+	NALEJ_SERVICE_SUSPENDED:   pbApplication.ServiceStatus_SERVICE_SUSPENDED,
 }
 
 // Equivalence table between status services and their corresponding fragment status.
@@ -55,16 +58,20 @@ var ServicesToFragmentStatus = map[NalejServiceStatus]FragmentStatus{
 	NALEJ_SERVICE_RUNNING:     FRAGMENT_DONE,
 	NALEJ_SERVICE_ERROR:       FRAGMENT_ERROR,
 	NALEJ_SERVICE_TERMINATING: FRAGMENT_TERMINATING,
+	NALEJ_SERVICE_SUSPENDED:   FRAGMENT_SUSPENDED,
 }
 
 var FragmentStatusToNalejServiceStatus = map[FragmentStatus]NalejServiceStatus{
 	FRAGMENT_WAITING:     NALEJ_SERVICE_WAITING,
 	FRAGMENT_TERMINATING: NALEJ_SERVICE_TERMINATING,
 	// We assume that retrying is equivalent to deploying
-	FRAGMENT_RETRYING:  NALEJ_SERVICE_DEPLOYING,
-	FRAGMENT_DEPLOYING: NALEJ_SERVICE_DEPLOYING,
-	FRAGMENT_ERROR:     NALEJ_SERVICE_ERROR,
-	FRAGMENT_DONE:      NALEJ_SERVICE_RUNNING,
+	FRAGMENT_RETRYING:   NALEJ_SERVICE_DEPLOYING,
+	FRAGMENT_DEPLOYING:  NALEJ_SERVICE_DEPLOYING,
+	FRAGMENT_ERROR:      NALEJ_SERVICE_ERROR,
+	FRAGMENT_DONE:       NALEJ_SERVICE_RUNNING,
+	FRAGMENT_CANCELLED:  NALEJ_SERVICE_TERMINATING,
+	FRAGMENT_SUSPENDED:  NALEJ_SERVICE_SUSPENDED,
+	FRAGMENT_TERMINATED: NALEJ_SERVICE_TERMINATING,
 }
 
 // Translate a kubenetes deployment status into a Nalej service status
@@ -125,8 +132,18 @@ const (
 	FRAGMENT_ERROR
 	FRAGMENT_RETRYING
 	FRAGMENT_TERMINATING
+	FRAGMENT_CANCELLED
+	// All the services of the fragment have been scaled down to zero replicas
+	FRAGMENT_SUSPENDED
+	// The fragment has been removed from the cluster and is only retained to report its status
+	FRAGMENT_TERMINATED
 )
 
+// Check if a fragment is being removed or has been removed from the cluster.
+func IsFragmentRemoved(status FragmentStatus) bool {
+	return status == FRAGMENT_TERMINATING || status == FRAGMENT_CANCELLED || status == FRAGMENT_TERMINATED
+}
+
 var FragmentStatusToGRPC = map[FragmentStatus]pbConductor.DeploymentFragmentStatus{
 	FRAGMENT_WAITING:     pbConductor.DeploymentFragmentStatus_WAITING,
 	FRAGMENT_DEPLOYING:   pbConductor.DeploymentFragmentStatus_DEPLOYING,
@@ -134,6 +151,10 @@ var FragmentStatusToGRPC = map[FragmentStatus]pbConductor.DeploymentFragmentStat
 	FRAGMENT_ERROR:       pbConductor.DeploymentFragmentStatus_ERROR,
 	FRAGMENT_RETRYING:    pbConductor.DeploymentFragmentStatus_RETRYING,
 	FRAGMENT_TERMINATING: pbConductor.DeploymentFragmentStatus_TERMINATING,
+	// A cancelled fragment has been removed from the cluster
+	FRAGMENT_CANCELLED:  pbConductor.DeploymentFragmentStatus_TERMINATED,
+	FRAGMENT_SUSPENDED:  pbConductor.DeploymentFragmentStatus_SUSPENDED,
+	FRAGMENT_TERMINATED: pbConductor.DeploymentFragmentStatus_TERMINATED,
 }
 
 // Deployment metadata
@@ -153,6 +174,8 @@ type DeploymentMetadata struct {
 	ClusterPublicHostname string                         `json:"cluster_public_hostname,omitempty"`
 	DNSHosts              []string                       `json:"dns_hosts,omitempty"`
 	PublicCredentials     pbApplication.ImageCredentials `json:"public_credentials,omitempty"`
+	// Rollout strategies of the services indexed by service id, services not found are rolled out in place
+	RolloutStrategies map[string]RolloutStrategy `json:"rollout_strategies,omitempty"`
 }
 
 // EndpointType ---
@@ -240,3 +263,76 @@ func ValidateLeaveZTNetworkRequest(request *grpc_deployment_manager_go.LeaveZTNe
 	}
 	return nil
 }
+
+func ValidateCancelDeploymentRequest(request *grpc_deployment_manager_go.CancelDeploymentRequest) derrors.Error {
+	if request.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError("organization_id cannot be empty")
+	}
+	if request.RequestId == "" && request.DeploymentFragmentId == "" {
+		return derrors.NewInvalidArgumentError("request_id or deployment_fragment_id must be set")
+	}
+	return nil
+}
+
+func ValidateDeploymentFragmentId(request *grpc_deployment_manager_go.DeploymentFragmentId) derrors.Error {
+	if request.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError("organization_id cannot be empty")
+	}
+	if request.FragmentId == "" {
+		return derrors.NewInvalidArgumentError("fragment_id cannot be empty")
+	}
+	return nil
+}
+
+func ValidateListFragmentsRequest(request *grpc_deployment_manager_go.ListFragmentsRequest) derrors.Error {
+	if request.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError("organization_id cannot be empty")
+	}
+	return nil
+}
+
+func ValidateWatchStatusRequest(request *grpc_deployment_manager_go.WatchStatusRequest) derrors.Error {
+	if request.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError("organization_id cannot be empty")
+	}
+	return nil
+}
+
+func ValidateAppInstanceId(request *pbApplication.AppInstanceId) derrors.Error {
+	if request.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError("organization_id cannot be empty")
+	}
+	if request.AppInstanceId == "" {
+		return derrors.NewInvalidArgumentError("app_instance_id cannot be empty")
+	}
+	return nil
+}
+
+func ValidateScaleServiceRequest(request *grpc_deployment_manager_go.ScaleServiceRequest) derrors.Error {
+	if request.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError("organization_id cannot be empty")
+	}
+	if request.AppInstanceId == "" {
+		return derrors.NewInvalidArgumentError("app_instance_id cannot be empty")
+	}
+	if request.ServiceInstanceId == "" {
+		return derrors.NewInvalidArgumentError("service_instance_id cannot be empty")
+	}
+	if request.Replicas < 0 {
+		return derrors.NewInvalidArgumentError("replicas cannot be negative")
+	}
+	return nil
+}
+
+func ValidateRestartServiceRequest(request *grpc_deployment_manager_go.RestartServiceRequest) derrors.Error {
+	if request.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError("organization_id cannot be empty")
+	}
+	if request.AppInstanceId == "" {
+		return derrors.NewInvalidArgumentError("app_instance_id cannot be empty")
+	}
+	if request.ServiceInstanceId == "" {
+		return derrors.NewInvalidArgumentError("service_instance_id cannot be empty")
+	}
+	return nil
+}
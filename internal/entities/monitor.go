@@ -16,7 +16,11 @@
 
 package entities
 
-import "github.com/rs/zerolog/log"
+import (
+	pbApplication "github.com/nalej/grpc-application-go"
+	pbDeploymentMgr "github.com/nalej/grpc-deployment-manager-go"
+	"github.com/rs/zerolog/log"
+)
 
 // Set of entities used for monitoring services.
 
@@ -47,6 +51,8 @@ type MonitoredAppEntry struct {
 	NewStatus bool `json: "new_status, omitempty"`
 	// Namespace
 	Namespace string `json: "namespace, omitempty"`
+	// Sequence number of the last notification of the fragment
+	NotificationSequence uint64 `json:"notification_sequence,omitempty"`
 }
 
 // Add a new application entry. If the entry already exists, it adds the new services.
@@ -62,6 +68,36 @@ func (m *MonitoredAppEntry) AppendServices(new *MonitoredAppEntry) {
 	}
 }
 
+// Copy returns a deep copy of the entry that can be read without holding any lock.
+func (m *MonitoredAppEntry) Copy() *MonitoredAppEntry {
+	toReturn := *m
+	toReturn.Services = make(map[string]*MonitoredServiceEntry, len(m.Services))
+	for id, serv := range m.Services {
+		toReturn.Services[id] = serv.Copy()
+	}
+	return &toReturn
+}
+
+func (m *MonitoredAppEntry) ToGRPC() *pbDeploymentMgr.MonitoredFragment {
+	services := make([]*pbDeploymentMgr.MonitoredService, 0, len(m.Services))
+	for _, serv := range m.Services {
+		services = append(services, serv.ToGRPC())
+	}
+	return &pbDeploymentMgr.MonitoredFragment{
+		OrganizationId:   m.OrganizationId,
+		AppDescriptorId:  m.AppDescriptorId,
+		AppInstanceId:    m.AppInstanceId,
+		DeploymentId:     m.DeploymentId,
+		FragmentId:       m.FragmentId,
+		Namespace:        m.Namespace,
+		Status:           FragmentStatusToGRPC[m.Status],
+		Info:             m.Info,
+		NumPendingChecks: int32(m.NumPendingChecks),
+		TotalServices:    int32(m.TotalServices),
+		Services:         services,
+	}
+}
+
 type MonitoredServiceEntry struct {
 	// Organization Id these stages are running into
 	OrganizationId string `json: "organization_id, omitempty"`
@@ -123,6 +159,44 @@ func (m *MonitoredServiceEntry) RemovePendingResource(uid string) {
 		Msg("the number of pending checks was modified")
 }
 
+// Copy returns a deep copy of the service entry.
+func (m *MonitoredServiceEntry) Copy() *MonitoredServiceEntry {
+	toReturn := *m
+	toReturn.Resources = make(map[string]*MonitoredPlatformResource, len(m.Resources))
+	for uid, res := range m.Resources {
+		resCopy := *res
+		toReturn.Resources[uid] = &resCopy
+	}
+	toReturn.Endpoints = append([]EndpointInstance(nil), m.Endpoints...)
+	return &toReturn
+}
+
+func (m *MonitoredServiceEntry) ToGRPC() *pbDeploymentMgr.MonitoredService {
+	endpoints := make([]*pbApplication.EndpointInstance, len(m.Endpoints))
+	for i, e := range m.Endpoints {
+		endpoints[i] = e.ToGRPC()
+	}
+	resources := make([]*pbDeploymentMgr.MonitoredResource, 0, len(m.Resources))
+	for _, res := range m.Resources {
+		resources = append(resources, res.ToGRPC())
+	}
+	return &pbDeploymentMgr.MonitoredService{
+		OrganizationId:         m.OrganizationId,
+		AppDescriptorId:        m.AppDescriptorId,
+		AppInstanceId:          m.AppInstanceId,
+		ServiceGroupId:         m.ServiceGroupId,
+		ServiceGroupInstanceId: m.ServiceGroupInstanceId,
+		ServiceId:              m.ServiceID,
+		ServiceName:            m.ServiceName,
+		ServiceInstanceId:      m.ServiceInstanceID,
+		Status:                 ServiceStatusToGRPC[m.Status],
+		Info:                   m.Info,
+		NumPendingChecks:       int32(m.NumPendingChecks),
+		Endpoints:              endpoints,
+		Resources:              resources,
+	}
+}
+
 type MonitoredPlatformResource struct {
 	// Fragment Id
 	FragmentId string `json: "fragment_id, omitempty"`
@@ -148,6 +222,15 @@ type MonitoredPlatformResource struct {
 	Status NalejServiceStatus `json: "status, omitempty"`
 }
 
+func (m *MonitoredPlatformResource) ToGRPC() *pbDeploymentMgr.MonitoredResource {
+	return &pbDeploymentMgr.MonitoredResource{
+		Uid:     m.UID,
+		Status:  ServiceStatusToGRPC[m.Status],
+		Info:    m.Info,
+		Pending: m.Pending,
+	}
+}
+
 func NewMonitoredPlatformResource(fragmentId string, uid string, appDescriptorId string, appInstanceId string, serviceGroupId string,
 	serviceGroupInstanceId string, serviceId string, serviceInstanceId string, info string) MonitoredPlatformResource {
 	return MonitoredPlatformResource{
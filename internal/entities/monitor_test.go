@@ -0,0 +1,102 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package entities
+
+import (
+	pbApplication "github.com/nalej/grpc-application-go"
+	pbConductor "github.com/nalej/grpc-conductor-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("monitored entries conversion", func() {
+
+	entry := &MonitoredAppEntry{
+		OrganizationId:   "org",
+		AppDescriptorId:  "descriptor",
+		AppInstanceId:    "app",
+		DeploymentId:     "deployment",
+		FragmentId:       "fragment",
+		Namespace:        "namespace",
+		Status:           FRAGMENT_DEPLOYING,
+		Info:             "info",
+		NumPendingChecks: 1,
+		TotalServices:    1,
+		Services: map[string]*MonitoredServiceEntry{
+			"service": {
+				OrganizationId:         "org",
+				AppDescriptorId:        "descriptor",
+				AppInstanceId:          "app",
+				ServiceGroupId:         "group",
+				ServiceGroupInstanceId: "groupInstance",
+				FragmentId:             "fragment",
+				ServiceID:              "serviceId",
+				ServiceName:            "name",
+				ServiceInstanceID:      "service",
+				NumPendingChecks:       1,
+				Status:                 NALEJ_SERVICE_DEPLOYING,
+				Info:                   "service info",
+				Endpoints: []EndpointInstance{
+					{EndpointInstanceId: "endpoint", EndpointType: ENDPOINT_TYPE_WEB, FQDN: "web.nalej", Port: 80},
+				},
+				Resources: map[string]*MonitoredPlatformResource{
+					"uid": {UID: "uid", Info: "resource info", Pending: true, Status: NALEJ_SERVICE_DEPLOYING},
+				},
+			},
+		},
+	}
+
+	ginkgo.It("should convert a fragment with its services", func() {
+		fragment := entry.ToGRPC()
+		gomega.Expect(fragment.OrganizationId).To(gomega.Equal("org"))
+		gomega.Expect(fragment.AppDescriptorId).To(gomega.Equal("descriptor"))
+		gomega.Expect(fragment.AppInstanceId).To(gomega.Equal("app"))
+		gomega.Expect(fragment.DeploymentId).To(gomega.Equal("deployment"))
+		gomega.Expect(fragment.FragmentId).To(gomega.Equal("fragment"))
+		gomega.Expect(fragment.Namespace).To(gomega.Equal("namespace"))
+		gomega.Expect(fragment.Status).To(gomega.Equal(pbConductor.DeploymentFragmentStatus_DEPLOYING))
+		gomega.Expect(fragment.Info).To(gomega.Equal("info"))
+		gomega.Expect(fragment.NumPendingChecks).To(gomega.Equal(int32(1)))
+		gomega.Expect(fragment.TotalServices).To(gomega.Equal(int32(1)))
+		gomega.Expect(fragment.Services).To(gomega.HaveLen(1))
+	})
+
+	ginkgo.It("should convert a service with its endpoints and resources", func() {
+		service := entry.Services["service"].ToGRPC()
+		gomega.Expect(service.ServiceGroupId).To(gomega.Equal("group"))
+		gomega.Expect(service.ServiceGroupInstanceId).To(gomega.Equal("groupInstance"))
+		gomega.Expect(service.ServiceId).To(gomega.Equal("serviceId"))
+		gomega.Expect(service.ServiceName).To(gomega.Equal("name"))
+		gomega.Expect(service.ServiceInstanceId).To(gomega.Equal("service"))
+		gomega.Expect(service.Status).To(gomega.Equal(pbApplication.ServiceStatus_SERVICE_DEPLOYING))
+		gomega.Expect(service.Info).To(gomega.Equal("service info"))
+		gomega.Expect(service.NumPendingChecks).To(gomega.Equal(int32(1)))
+
+		gomega.Expect(service.Endpoints).To(gomega.HaveLen(1))
+		gomega.Expect(service.Endpoints[0].EndpointInstanceId).To(gomega.Equal("endpoint"))
+		gomega.Expect(service.Endpoints[0].Type).To(gomega.Equal(pbApplication.EndpointType_WEB))
+		gomega.Expect(service.Endpoints[0].Fqdn).To(gomega.Equal("web.nalej"))
+		gomega.Expect(service.Endpoints[0].Port).To(gomega.Equal(int32(80)))
+
+		gomega.Expect(service.Resources).To(gomega.HaveLen(1))
+		gomega.Expect(service.Resources[0].Uid).To(gomega.Equal("uid"))
+		gomega.Expect(service.Resources[0].Status).To(gomega.Equal(pbApplication.ServiceStatus_SERVICE_DEPLOYING))
+		gomega.Expect(service.Resources[0].Info).To(gomega.Equal("resource info"))
+		gomega.Expect(service.Resources[0].Pending).To(gomega.BeTrue())
+	})
+})
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"github.com/nalej/derrors"
+	pbDeploymentMgr "github.com/nalej/grpc-deployment-manager-go"
+)
+
+// Strategy used to roll out the new version of a service when its fragment is updated.
+type RolloutStrategyType int
+
+const (
+	// The platform replaces the pods of the service progressively
+	ROLLOUT_ROLLING = iota
+	// The new version runs next to the current one with a share of the traffic until it is promoted
+	ROLLOUT_CANARY
+	// The traffic is switched to the new version once its pods are ready
+	ROLLOUT_BLUE_GREEN
+)
+
+var RolloutStrategyTypeFromGRPC = map[pbDeploymentMgr.RolloutStrategyType]RolloutStrategyType{
+	pbDeploymentMgr.RolloutStrategyType_ROLLING:    ROLLOUT_ROLLING,
+	pbDeploymentMgr.RolloutStrategyType_CANARY:     ROLLOUT_CANARY,
+	pbDeploymentMgr.RolloutStrategyType_BLUE_GREEN: ROLLOUT_BLUE_GREEN,
+}
+
+type RolloutStrategy struct {
+	Type RolloutStrategyType `json:"type,omitempty"`
+	// Share of the replicas, or of the traffic when the network supports it, sent to a canary
+	CanaryPercentage int32 `json:"canary_percentage,omitempty"`
+}
+
+// Get the rollout strategies of a request indexed by service id.
+func NewRolloutStrategiesFromGRPC(strategies []*pbDeploymentMgr.RolloutStrategy) map[string]RolloutStrategy {
+	if len(strategies) == 0 {
+		return nil
+	}
+	result := make(map[string]RolloutStrategy, len(strategies))
+	for _, s := range strategies {
+		result[s.ServiceId] = RolloutStrategy{
+			Type:             RolloutStrategyTypeFromGRPC[s.Type],
+			CanaryPercentage: s.CanaryPercentage,
+		}
+	}
+	return result
+}
+
+func ValidateRolloutStrategies(strategies []*pbDeploymentMgr.RolloutStrategy) derrors.Error {
+	for _, s := range strategies {
+		if s.ServiceId == "" {
+			return derrors.NewInvalidArgumentError("service_id cannot be empty")
+		}
+		if _, found := RolloutStrategyTypeFromGRPC[s.Type]; !found {
+			return derrors.NewInvalidArgumentError("unknown rollout strategy").WithParams(s.ServiceId, s.Type)
+		}
+		if s.Type == pbDeploymentMgr.RolloutStrategyType_CANARY && (s.CanaryPercentage <= 0 || s.CanaryPercentage >= 100) {
+			return derrors.NewInvalidArgumentError("canary_percentage must be between 1 and 99").
+				WithParams(s.ServiceId, s.CanaryPercentage)
+		}
+	}
+	return nil
+}
+
+func ValidateRolloutRequest(request *pbDeploymentMgr.RolloutRequest) derrors.Error {
+	if request.OrganizationId == "" {
+		return derrors.NewInvalidArgumentError("organization_id cannot be empty")
+	}
+	if request.AppInstanceId == "" {
+		return derrors.NewInvalidArgumentError("app_instance_id cannot be empty")
+	}
+	if request.DeploymentFragmentId == "" {
+		return derrors.NewInvalidArgumentError("deployment_fragment_id cannot be empty")
+	}
+	if request.ServiceInstanceId == "" {
+		return derrors.NewInvalidArgumentError("service_instance_id cannot be empty")
+	}
+	return nil
+}
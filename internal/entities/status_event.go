@@ -0,0 +1,101 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package entities
+
+import (
+	"fmt"
+	pbDeploymentMgr "github.com/nalej/grpc-deployment-manager-go"
+	"time"
+)
+
+// Element whose status changed
+type StatusEventType int
+
+const (
+	STATUS_EVENT_FRAGMENT = iota
+	STATUS_EVENT_SERVICE
+	STATUS_EVENT_RESOURCE
+)
+
+var StatusEventTypeToGRPC = map[StatusEventType]pbDeploymentMgr.StatusEventType{
+	STATUS_EVENT_FRAGMENT: pbDeploymentMgr.StatusEventType_FRAGMENT,
+	STATUS_EVENT_SERVICE:  pbDeploymentMgr.StatusEventType_SERVICE,
+	STATUS_EVENT_RESOURCE: pbDeploymentMgr.StatusEventType_RESOURCE,
+}
+
+// Status transition of a monitored fragment, service or resource.
+type StatusEvent struct {
+	// Epoch of the emitter, events from different epochs cannot be compared
+	Epoch int64 `json:"epoch,omitempty"`
+	// Sequence number of the event in its epoch
+	Sequence uint64 `json:"sequence,omitempty"`
+	// Timestamp of the transition
+	Timestamp time.Time `json:"timestamp,omitempty"`
+	// Type of element that changed
+	Type StatusEventType `json:"type,omitempty"`
+	// Organization Id
+	OrganizationId string `json:"organization_id,omitempty"`
+	// Application instance Id
+	AppInstanceId string `json:"app_instance_id,omitempty"`
+	// Fragment Id
+	FragmentId string `json:"fragment_id,omitempty"`
+	// Service instance Id, empty for fragment events
+	ServiceInstanceId string `json:"service_instance_id,omitempty"`
+	// Platform resource UID, only for resource events
+	ResourceUID string `json:"resource_uid,omitempty"`
+	// New fragment status, only for fragment events
+	FragmentStatus FragmentStatus `json:"fragment_status,omitempty"`
+	// New service or resource status
+	ServiceStatus NalejServiceStatus `json:"service_status,omitempty"`
+	// Textual information
+	Info string `json:"info,omitempty"`
+}
+
+// Token that can be used to resume watching after this event.
+func (e *StatusEvent) ResumeToken() string {
+	return fmt.Sprintf("%d-%d", e.Epoch, e.Sequence)
+}
+
+func (e *StatusEvent) ToGRPC() *pbDeploymentMgr.StatusEvent {
+	return &pbDeploymentMgr.StatusEvent{
+		ResumeToken:       e.ResumeToken(),
+		Timestamp:         e.Timestamp.Unix(),
+		Type:              StatusEventTypeToGRPC[e.Type],
+		OrganizationId:    e.OrganizationId,
+		AppInstanceId:     e.AppInstanceId,
+		FragmentId:        e.FragmentId,
+		ServiceInstanceId: e.ServiceInstanceId,
+		ResourceUid:       e.ResourceUID,
+		FragmentStatus:    FragmentStatusToGRPC[e.FragmentStatus],
+		ServiceStatus:     ServiceStatusToGRPC[e.ServiceStatus],
+		Info:              e.Info,
+	}
+}
+
+// Filter of status events. Empty fields match any value.
+type StatusEventFilter struct {
+	OrganizationId string
+	AppInstanceId  string
+	FragmentId     string
+}
+
+// Check if an event passes the filter.
+func (f *StatusEventFilter) Matches(e *StatusEvent) bool {
+	return (f.OrganizationId == "" || f.OrganizationId == e.OrganizationId) &&
+		(f.AppInstanceId == "" || f.AppInstanceId == e.AppInstanceId) &&
+		(f.FragmentId == "" || f.FragmentId == e.FragmentId)
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package entities
+
+import (
+	pbApplication "github.com/nalej/grpc-application-go"
+	pbConductor "github.com/nalej/grpc-conductor-go"
+	pbDeploymentMgr "github.com/nalej/grpc-deployment-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"time"
+)
+
+var _ = ginkgo.Describe("status events", func() {
+
+	event := &StatusEvent{
+		Epoch:             10,
+		Sequence:          3,
+		Timestamp:         time.Unix(1000, 0),
+		Type:              STATUS_EVENT_SERVICE,
+		OrganizationId:    "org",
+		AppInstanceId:     "app",
+		FragmentId:        "fragment",
+		ServiceInstanceId: "service",
+		ResourceUID:       "uid",
+		FragmentStatus:    FRAGMENT_DONE,
+		ServiceStatus:     NALEJ_SERVICE_RUNNING,
+		Info:              "info",
+	}
+
+	ginkgo.It("should convert an event", func() {
+		converted := event.ToGRPC()
+		gomega.Expect(converted.ResumeToken).To(gomega.Equal("10-3"))
+		gomega.Expect(converted.Timestamp).To(gomega.Equal(int64(1000)))
+		gomega.Expect(converted.Type).To(gomega.Equal(pbDeploymentMgr.StatusEventType_SERVICE))
+		gomega.Expect(converted.OrganizationId).To(gomega.Equal("org"))
+		gomega.Expect(converted.AppInstanceId).To(gomega.Equal("app"))
+		gomega.Expect(converted.FragmentId).To(gomega.Equal("fragment"))
+		gomega.Expect(converted.ServiceInstanceId).To(gomega.Equal("service"))
+		gomega.Expect(converted.ResourceUid).To(gomega.Equal("uid"))
+		gomega.Expect(converted.FragmentStatus).To(gomega.Equal(pbConductor.DeploymentFragmentStatus_DONE))
+		gomega.Expect(converted.ServiceStatus).To(gomega.Equal(pbApplication.ServiceStatus_SERVICE_RUNNING))
+		gomega.Expect(converted.Info).To(gomega.Equal("info"))
+	})
+
+	ginkgo.It("should filter events", func() {
+		gomega.Expect((&StatusEventFilter{OrganizationId: "org"}).Matches(event)).To(gomega.BeTrue())
+		gomega.Expect((&StatusEventFilter{OrganizationId: "org", AppInstanceId: "app", FragmentId: "fragment"}).Matches(event)).To(gomega.BeTrue())
+		gomega.Expect((&StatusEventFilter{OrganizationId: "other"}).Matches(event)).To(gomega.BeFalse())
+		gomega.Expect((&StatusEventFilter{OrganizationId: "org", FragmentId: "other"}).Matches(event)).To(gomega.BeFalse())
+	})
+})
@@ -0,0 +1,344 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package structures
+
+import (
+	"fmt"
+	"github.com/golang/protobuf/proto"
+	"github.com/nalej/derrors"
+	pbDeploymentManager "github.com/nalej/grpc-deployment-manager-go"
+	"github.com/phf/go-queue/queue"
+	"github.com/rs/zerolog/log"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// Extension for requests waiting to be processed
+	PendingRequestExtension = ".pending"
+	// Extension for requests being processed
+	InFlightRequestExtension = ".inflight"
+	// Extension for requests being written
+	tmpRequestExtension = ".tmp"
+	// Suffix added before the extension to the files of update requests
+	UpdateRequestSuffix = ".update"
+)
+
+// Entry of the file queue
+type fileQueueEntry struct {
+	// sequence number of the request, used to sort and name the files
+	seq uint64
+	// queued request
+	request *pbDeploymentManager.DeploymentFragmentRequest
+	// operation requested
+	operation RequestOperation
+}
+
+// Durable queue storing every request in a local directory. Each request is kept in its own file named after a
+// sequence number so the arrival order survives a restart. The file extension indicates whether the request is
+// pending or in-flight, and the files of update requests carry a suffix before the extension. Files are removed once
+// the request is done. Requests found on startup, either pending or in-flight, are queued again in the original order.
+type FileRequestQueue struct {
+	// directory where the requests are stored
+	path string
+	// queue of pending entries
+	queue *queue.Queue
+	// every stored entry indexed by request id
+	stored map[string]fileQueueEntry
+	// next sequence number
+	nextSeq uint64
+	// Mutex for queue operations
+	mux sync.Mutex
+}
+
+// Create a new file backed queue. Unfinished requests found in the path are replayed.
+//  params:
+//   path directory to store the requests
+//  returns:
+//   the queue or error if the directory cannot be used
+func NewFileRequestQueue(path string) (RequestsQueue, derrors.Error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, derrors.AsError(err, "impossible to create requests queue directory")
+	}
+	toReturn := &FileRequestQueue{
+		path:   path,
+		queue:  queue.New(),
+		stored: make(map[string]fileQueueEntry, 0),
+	}
+	toReturn.queue.Init()
+	if err := toReturn.load(); err != nil {
+		return nil, err
+	}
+	return toReturn, nil
+}
+
+// Load the stored requests into the queue.
+func (q *FileRequestQueue) load() derrors.Error {
+	files, err := ioutil.ReadDir(q.path)
+	if err != nil {
+		return derrors.AsError(err, "impossible to read requests queue directory")
+	}
+
+	entries := make([]fileQueueEntry, 0)
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if ext == tmpRequestExtension {
+			// incomplete write, the request was never acknowledged
+			os.Remove(filepath.Join(q.path, f.Name()))
+			continue
+		}
+		if ext != PendingRequestExtension && ext != InFlightRequestExtension {
+			continue
+		}
+		name := strings.TrimSuffix(f.Name(), ext)
+		operation := DeployOperation
+		if strings.HasSuffix(name, UpdateRequestSuffix) {
+			operation = UpdateOperation
+			name = strings.TrimSuffix(name, UpdateRequestSuffix)
+		}
+		seq, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			log.Warn().Str("file", f.Name()).Msg("ignoring unknown file in requests queue directory")
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(q.path, f.Name()))
+		if err != nil {
+			return derrors.AsError(err, "impossible to read stored request")
+		}
+		request := &pbDeploymentManager.DeploymentFragmentRequest{}
+		if err := proto.Unmarshal(data, request); err != nil {
+			log.Error().Err(err).Str("file", f.Name()).Msg("discarding corrupted request")
+			os.Remove(filepath.Join(q.path, f.Name()))
+			continue
+		}
+		if ext == InFlightRequestExtension {
+			// the process was interrupted, the request is pending again
+			log.Info().Str("requestId", request.RequestId).Msg("replaying unfinished request")
+			if err := os.Rename(q.fileName(seq, operation, InFlightRequestExtension),
+				q.fileName(seq, operation, PendingRequestExtension)); err != nil {
+				return derrors.AsError(err, "impossible to restore in-flight request")
+			}
+		}
+		entries = append(entries, fileQueueEntry{seq: seq, request: request, operation: operation})
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+	for _, e := range entries {
+		q.queue.PushBack(e)
+		q.stored[e.request.RequestId] = e
+		q.nextSeq = e.seq + 1
+	}
+	log.Info().Int("requests", len(entries)).Str("path", q.path).Msg("requests queue loaded")
+	return nil
+}
+
+// Build the name of the file storing a request
+func (q *FileRequestQueue) fileName(seq uint64, operation RequestOperation, ext string) string {
+	if operation == UpdateOperation {
+		return filepath.Join(q.path, fmt.Sprintf("%020d%s%s", seq, UpdateRequestSuffix, ext))
+	}
+	return filepath.Join(q.path, fmt.Sprintf("%020d%s", seq, ext))
+}
+
+// Write the request into disk. The data is written into a temporary file that is renamed once it is synced so
+// a crash never leaves a partial request behind.
+func (q *FileRequestQueue) write(seq uint64, operation RequestOperation, req *pbDeploymentManager.DeploymentFragmentRequest) derrors.Error {
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return derrors.AsError(err, "impossible to marshal request")
+	}
+	tmpName := q.fileName(seq, operation, tmpRequestExtension)
+	f, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return derrors.AsError(err, "impossible to create request file")
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return derrors.AsError(err, "impossible to write request file")
+	}
+	if err := os.Rename(tmpName, q.fileName(seq, operation, PendingRequestExtension)); err != nil {
+		os.Remove(tmpName)
+		return derrors.AsError(err, "impossible to store request file")
+	}
+	q.syncDir()
+	return nil
+}
+
+// Sync the directory so renames and removals are persisted.
+func (q *FileRequestQueue) syncDir() {
+	dir, err := os.Open(q.path)
+	if err != nil {
+		return
+	}
+	dir.Sync()
+	dir.Close()
+}
+
+// Thread-safe method to access queued requests
+func (q *FileRequestQueue) NextRequest() *pbDeploymentManager.DeploymentFragmentRequest {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if q.queue.Len() == 0 {
+		return nil
+	}
+	return q.queue.PopFront().(fileQueueEntry).request
+}
+
+// Thread-safe method to access the first queued request matching a condition. Unlike RemoveRequest the request
+// is kept in disk until it is marked as done.
+func (q *FileRequestQueue) NextMatchingRequest(match func(req *pbDeploymentManager.DeploymentFragmentRequest, operation RequestOperation) bool) (*pbDeploymentManager.DeploymentFragmentRequest, RequestOperation) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	found := q.removeEntry(func(entry fileQueueEntry) bool {
+		return match(entry.request, entry.operation)
+	})
+	if found == nil {
+		return nil, DeployOperation
+	}
+	return found.request, found.operation
+}
+
+// Thread-safe function to find whether there are more requests available or not.
+func (q *FileRequestQueue) AvailableRequests() bool {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return q.queue.Len() != 0
+}
+
+// Push a new request to the queue. The request is stored in disk before returning.
+//  params:
+//   req entry to be enqueued
+func (q *FileRequestQueue) PushRequest(req *pbDeploymentManager.DeploymentFragmentRequest) error {
+	return q.PushOperation(req, DeployOperation)
+}
+
+// Push a new request for an operation to the queue. The request is stored in disk before returning.
+//  params:
+//   req entry to be enqueued
+//   operation to be done with the request
+func (q *FileRequestQueue) PushOperation(req *pbDeploymentManager.DeploymentFragmentRequest, operation RequestOperation) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	if _, found := q.stored[req.RequestId]; found {
+		return derrors.NewAlreadyExistsError("request already queued").WithParams(req.RequestId)
+	}
+	entry := fileQueueEntry{seq: q.nextSeq, request: req, operation: operation}
+	if err := q.write(entry.seq, operation, req); err != nil {
+		return err
+	}
+	q.nextSeq++
+	q.stored[req.RequestId] = entry
+	q.queue.PushBack(entry)
+	return nil
+}
+
+// Remove the first matching request from the queue and from disk keeping the order of the rest.
+func (q *FileRequestQueue) RemoveRequest(match func(req *pbDeploymentManager.DeploymentFragmentRequest) bool) (*pbDeploymentManager.DeploymentFragmentRequest, RequestOperation) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	removed := q.removeEntry(func(entry fileQueueEntry) bool {
+		return match(entry.request)
+	})
+	if removed == nil {
+		return nil, DeployOperation
+	}
+	delete(q.stored, removed.request.RequestId)
+	os.Remove(q.fileName(removed.seq, removed.operation, PendingRequestExtension))
+	q.syncDir()
+	return removed.request, removed.operation
+}
+
+// Remove the first matching entry from the queue keeping the order of the rest. The entry is kept in disk. This
+// function must be called with the lock held.
+func (q *FileRequestQueue) removeEntry(match func(entry fileQueueEntry) bool) *fileQueueEntry {
+	var removed *fileQueueEntry
+	for i := q.queue.Len(); i > 0; i-- {
+		entry := q.queue.PopFront().(fileQueueEntry)
+		if removed == nil && match(entry) {
+			removed = &entry
+			continue
+		}
+		q.queue.PushBack(entry)
+	}
+	return removed
+}
+
+// Remove every stored request.
+func (q *FileRequestQueue) Clear() {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	for _, entry := range q.stored {
+		os.Remove(q.fileName(entry.seq, entry.operation, PendingRequestExtension))
+		os.Remove(q.fileName(entry.seq, entry.operation, InFlightRequestExtension))
+	}
+	q.syncDir()
+	q.stored = make(map[string]fileQueueEntry, 0)
+	q.queue.Init()
+}
+
+func (q *FileRequestQueue) Len() int {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return q.queue.Len()
+}
+
+// Mark a request as in-flight. The request will be replayed on startup until it is marked as done.
+func (q *FileRequestQueue) MarkInFlight(requestId string) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	entry, found := q.stored[requestId]
+	if !found {
+		return derrors.NewNotFoundError("request not found in queue").WithParams(requestId)
+	}
+	if err := os.Rename(q.fileName(entry.seq, entry.operation, PendingRequestExtension),
+		q.fileName(entry.seq, entry.operation, InFlightRequestExtension)); err != nil {
+		return derrors.AsError(err, "impossible to mark request as in-flight")
+	}
+	q.syncDir()
+	return nil
+}
+
+// Mark a request as done removing its file.
+func (q *FileRequestQueue) MarkDone(requestId string) error {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	entry, found := q.stored[requestId]
+	if !found {
+		return derrors.NewNotFoundError("request not found in queue").WithParams(requestId)
+	}
+	delete(q.stored, requestId)
+	// the request may still be pending if it was never marked as in-flight
+	os.Remove(q.fileName(entry.seq, entry.operation, PendingRequestExtension))
+	if err := os.Remove(q.fileName(entry.seq, entry.operation, InFlightRequestExtension)); err != nil && !os.IsNotExist(err) {
+		return derrors.AsError(err, "impossible to remove request file")
+	}
+	q.syncDir()
+	return nil
+}
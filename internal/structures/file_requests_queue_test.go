@@ -0,0 +1,166 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package structures
+
+import (
+	"fmt"
+	pbConductor "github.com/nalej/grpc-conductor-go"
+	pbDeploymentManager "github.com/nalej/grpc-deployment-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"io/ioutil"
+	"os"
+)
+
+func testRequest(id int) *pbDeploymentManager.DeploymentFragmentRequest {
+	return &pbDeploymentManager.DeploymentFragmentRequest{
+		RequestId: fmt.Sprintf("request-%d", id),
+		Fragment:  &pbConductor.DeploymentFragment{FragmentId: fmt.Sprintf("fragment-%d", id)},
+	}
+}
+
+var _ = ginkgo.Describe("file requests queue", func() {
+
+	var path string
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		path, err = ioutil.TempDir("", "requests-queue")
+		gomega.Expect(err).To(gomega.Succeed())
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(path)
+	})
+
+	ginkgo.It("should return requests in order", func() {
+		q, err := NewFileRequestQueue(path)
+		gomega.Expect(err).To(gomega.BeNil())
+		for i := 0; i < 3; i++ {
+			gomega.Expect(q.PushRequest(testRequest(i))).To(gomega.Succeed())
+		}
+		gomega.Expect(q.Len()).To(gomega.Equal(3))
+		for i := 0; i < 3; i++ {
+			gomega.Expect(q.NextRequest().RequestId).To(gomega.Equal(testRequest(i).RequestId))
+		}
+		gomega.Expect(q.AvailableRequests()).To(gomega.BeFalse())
+		gomega.Expect(q.NextRequest()).To(gomega.BeNil())
+	})
+
+	ginkgo.It("should replay pending and in-flight requests but not done ones", func() {
+		q, err := NewFileRequestQueue(path)
+		gomega.Expect(err).To(gomega.BeNil())
+		for i := 0; i < 3; i++ {
+			gomega.Expect(q.PushRequest(testRequest(i))).To(gomega.Succeed())
+		}
+		// first request done, second one in-flight, third one pending
+		for i := 0; i < 2; i++ {
+			req := q.NextRequest()
+			gomega.Expect(q.MarkInFlight(req.RequestId)).To(gomega.Succeed())
+		}
+		gomega.Expect(q.MarkDone(testRequest(0).RequestId)).To(gomega.Succeed())
+
+		restored, err := NewFileRequestQueue(path)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(restored.Len()).To(gomega.Equal(2))
+		gomega.Expect(restored.NextRequest().RequestId).To(gomega.Equal(testRequest(1).RequestId))
+		gomega.Expect(restored.NextRequest().RequestId).To(gomega.Equal(testRequest(2).RequestId))
+
+		// new requests are queued after the restored ones
+		gomega.Expect(restored.PushRequest(testRequest(3))).To(gomega.Succeed())
+		gomega.Expect(restored.NextRequest().Fragment.FragmentId).To(gomega.Equal("fragment-3"))
+	})
+
+	ginkgo.It("should reject duplicated requests", func() {
+		q, err := NewFileRequestQueue(path)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(q.PushRequest(testRequest(0))).To(gomega.Succeed())
+		gomega.Expect(q.PushRequest(testRequest(0))).ShouldNot(gomega.Succeed())
+	})
+
+	ginkgo.It("should remove a request keeping the order", func() {
+		q, err := NewFileRequestQueue(path)
+		gomega.Expect(err).To(gomega.BeNil())
+		for i := 0; i < 3; i++ {
+			gomega.Expect(q.PushRequest(testRequest(i))).To(gomega.Succeed())
+		}
+		removed, _ := q.RemoveRequest(func(req *pbDeploymentManager.DeploymentFragmentRequest) bool {
+			return req.Fragment.FragmentId == "fragment-1"
+		})
+		gomega.Expect(removed).ShouldNot(gomega.BeNil())
+		gomega.Expect(removed.RequestId).To(gomega.Equal(testRequest(1).RequestId))
+
+		restored, err := NewFileRequestQueue(path)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(restored.Len()).To(gomega.Equal(2))
+		gomega.Expect(restored.NextRequest().RequestId).To(gomega.Equal(testRequest(0).RequestId))
+		gomega.Expect(restored.NextRequest().RequestId).To(gomega.Equal(testRequest(2).RequestId))
+	})
+
+	ginkgo.It("should skip the requests not matching keeping them stored", func() {
+		q, err := NewFileRequestQueue(path)
+		gomega.Expect(err).To(gomega.BeNil())
+		for i := 0; i < 3; i++ {
+			gomega.Expect(q.PushRequest(testRequest(i))).To(gomega.Succeed())
+		}
+		next, _ := q.NextMatchingRequest(func(req *pbDeploymentManager.DeploymentFragmentRequest, operation RequestOperation) bool {
+			return req.Fragment.FragmentId != "fragment-0"
+		})
+		gomega.Expect(next.RequestId).To(gomega.Equal(testRequest(1).RequestId))
+		gomega.Expect(q.MarkInFlight(next.RequestId)).To(gomega.Succeed())
+		gomega.Expect(q.Len()).To(gomega.Equal(2))
+		gomega.Expect(q.NextRequest().RequestId).To(gomega.Equal(testRequest(0).RequestId))
+
+		// the obtained request is replayed until it is done
+		restored, err := NewFileRequestQueue(path)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(restored.Len()).To(gomega.Equal(3))
+	})
+
+	ginkgo.It("should restore the operation of the requests", func() {
+		q, err := NewFileRequestQueue(path)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(q.PushRequest(testRequest(0))).To(gomega.Succeed())
+		gomega.Expect(q.PushOperation(testRequest(1), UpdateOperation)).To(gomega.Succeed())
+		gomega.Expect(q.MarkInFlight(testRequest(1).RequestId)).To(gomega.Succeed())
+
+		restored, err := NewFileRequestQueue(path)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(restored.Len()).To(gomega.Equal(2))
+		next, operation := restored.NextMatchingRequest(func(req *pbDeploymentManager.DeploymentFragmentRequest, operation RequestOperation) bool {
+			return operation == UpdateOperation
+		})
+		gomega.Expect(next.RequestId).To(gomega.Equal(testRequest(1).RequestId))
+		gomega.Expect(operation).To(gomega.Equal(UpdateOperation))
+		gomega.Expect(restored.MarkDone(next.RequestId)).To(gomega.Succeed())
+
+		restored, err = NewFileRequestQueue(path)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(restored.Len()).To(gomega.Equal(1))
+		gomega.Expect(restored.NextRequest().RequestId).To(gomega.Equal(testRequest(0).RequestId))
+	})
+
+	ginkgo.It("should remove every request on clear", func() {
+		q, err := NewFileRequestQueue(path)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(q.PushRequest(testRequest(0))).To(gomega.Succeed())
+		q.Clear()
+		restored, err := NewFileRequestQueue(path)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(restored.Len()).To(gomega.Equal(0))
+	})
+})
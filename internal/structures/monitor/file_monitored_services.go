@@ -0,0 +1,312 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package monitor
+
+// File based implementation of a monitored services control structure.
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Extension for the stored entries
+	entryExtension = ".json"
+	// Extension for entries being written
+	tmpEntryExtension = ".tmp"
+)
+
+// Monitored instances stored in a local directory so the status, endpoints and pending checks of the fragments
+// survive a restart. The entries are kept in memory and every entry is written in its own file each time it is
+// modified. The waiters of the pending checks and the status subscriptions are not stored.
+type FileMonitoredInstances struct {
+	*MemoryMonitoredInstances
+	// directory where the entries are stored
+	path string
+	// Mutex serializing the writes so the last stored version of an entry is always the latest one
+	storeMu sync.Mutex
+}
+
+// Create a new file backed monitored instances structure. The entries found in the path are loaded.
+//  params:
+//   path directory to store the entries
+//  return:
+//   monitored instances or error if the directory cannot be used
+func NewFileMonitoredInstances(path string) (MonitoredInstances, derrors.Error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, derrors.AsError(err, "impossible to create monitored instances directory")
+	}
+	toReturn := &FileMonitoredInstances{
+		MemoryMonitoredInstances: NewMemoryMonitoredInstances().(*MemoryMonitoredInstances),
+		path:                     path,
+	}
+	if err := toReturn.load(); err != nil {
+		return nil, err
+	}
+	return toReturn, nil
+}
+
+// Load the stored entries.
+func (p *FileMonitoredInstances) load() derrors.Error {
+	files, err := ioutil.ReadDir(p.path)
+	if err != nil {
+		return derrors.AsError(err, "impossible to read monitored instances directory")
+	}
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if ext == tmpEntryExtension {
+			// incomplete write, the previous version of the entry is still stored
+			os.Remove(filepath.Join(p.path, f.Name()))
+			continue
+		}
+		if ext != entryExtension {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(p.path, f.Name()))
+		if err != nil {
+			return derrors.AsError(err, "impossible to read monitored entry")
+		}
+		entry := &entities.MonitoredAppEntry{}
+		if err := json.Unmarshal(data, entry); err != nil || entry.FragmentId == "" {
+			log.Error().Err(err).Str("file", f.Name()).Msg("discarding corrupted monitored entry")
+			os.Remove(filepath.Join(p.path, f.Name()))
+			continue
+		}
+		if entry.Services == nil {
+			entry.Services = make(map[string]*entities.MonitoredServiceEntry, 0)
+		}
+		for _, serv := range entry.Services {
+			if serv.Resources == nil {
+				serv.Resources = make(map[string]*entities.MonitoredPlatformResource, 0)
+			}
+		}
+		p.monitoredEntries[entry.FragmentId] = entry
+	}
+	log.Info().Int("fragments", len(p.monitoredEntries)).Str("path", p.path).Msg("monitored instances loaded")
+	return nil
+}
+
+// Build the name of the file storing an entry
+func (p *FileMonitoredInstances) fileName(fragmentId string, ext string) string {
+	return filepath.Join(p.path, url.PathEscape(fragmentId)+ext)
+}
+
+// Store the current version of an entry, removing its file if the entry is no longer monitored.
+//  params:
+//   fragmentId identifier of the entry
+func (p *FileMonitoredInstances) store(fragmentId string) {
+	p.storeMu.Lock()
+	defer p.storeMu.Unlock()
+	// the copy is taken once the previous writes finished so the file ends with the latest version
+	var entry *entities.MonitoredAppEntry
+	p.mu.RLock()
+	if current, found := p.monitoredEntries[fragmentId]; found {
+		entry = current.Copy()
+	}
+	p.mu.RUnlock()
+
+	var err derrors.Error
+	if entry == nil {
+		err = p.remove(fragmentId)
+	} else {
+		err = p.write(entry)
+	}
+	if err != nil {
+		log.Error().Str("err", err.DebugReport()).Str("fragmentId", fragmentId).Msg("impossible to store monitored entry")
+	}
+}
+
+// Store the current version of the entries of an application.
+//  params:
+//   appInstanceId identifier of the application
+func (p *FileMonitoredInstances) storeApp(appInstanceId string) {
+	for _, fragmentId := range p.fragmentIds(func(entry *entities.MonitoredAppEntry) bool {
+		return entry.AppInstanceId == appInstanceId
+	}) {
+		p.store(fragmentId)
+	}
+}
+
+// List the identifiers of the entries matching a condition.
+func (p *FileMonitoredInstances) fragmentIds(match func(entry *entities.MonitoredAppEntry) bool) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	toReturn := make([]string, 0)
+	for fragmentId, entry := range p.monitoredEntries {
+		if match(entry) {
+			toReturn = append(toReturn, fragmentId)
+		}
+	}
+	return toReturn
+}
+
+// Write the entry into disk. The data is written into a temporary file that is renamed once it is synced so
+// a crash never leaves a partial entry behind.
+func (p *FileMonitoredInstances) write(entry *entities.MonitoredAppEntry) derrors.Error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return derrors.AsError(err, "impossible to marshal monitored entry")
+	}
+	tmpName := p.fileName(entry.FragmentId, tmpEntryExtension)
+	f, err := os.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return derrors.AsError(err, "impossible to create monitored entry file")
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return derrors.AsError(err, "impossible to write monitored entry file")
+	}
+	if err := os.Rename(tmpName, p.fileName(entry.FragmentId, entryExtension)); err != nil {
+		os.Remove(tmpName)
+		return derrors.AsError(err, "impossible to store monitored entry file")
+	}
+	p.syncDir()
+	return nil
+}
+
+// Remove the file of an entry.
+func (p *FileMonitoredInstances) remove(fragmentId string) derrors.Error {
+	if err := os.Remove(p.fileName(fragmentId, entryExtension)); err != nil && !os.IsNotExist(err) {
+		return derrors.AsError(err, "impossible to remove monitored entry file")
+	}
+	p.syncDir()
+	return nil
+}
+
+// Sync the directory so renames and removals are persisted.
+func (p *FileMonitoredInstances) syncDir() {
+	dir, err := os.Open(p.path)
+	if err != nil {
+		return
+	}
+	dir.Sync()
+	dir.Close()
+}
+
+func (p *FileMonitoredInstances) WaitPendingChecks(ctx context.Context, fragmentId string, services []string, timeout int) error {
+	err := p.MemoryMonitoredInstances.WaitPendingChecks(ctx, fragmentId, services, timeout)
+	// the number of pending checks of the entry is updated while waiting
+	p.store(fragmentId)
+	return err
+}
+
+func (p *FileMonitoredInstances) AddEntry(toAdd *entities.MonitoredAppEntry) {
+	p.MemoryMonitoredInstances.AddEntry(toAdd)
+	p.store(toAdd.FragmentId)
+}
+
+func (p *FileMonitoredInstances) SetEntryStatus(fragmentId string, status entities.FragmentStatus, err error) {
+	p.MemoryMonitoredInstances.SetEntryStatus(fragmentId, status, err)
+	p.store(fragmentId)
+}
+
+func (p *FileMonitoredInstances) SetAppStatus(appInstanceId string, status entities.FragmentStatus, err error) {
+	p.MemoryMonitoredInstances.SetAppStatus(appInstanceId, status, err)
+	p.storeApp(appInstanceId)
+}
+
+func (p *FileMonitoredInstances) AddPendingResource(newResource *entities.MonitoredPlatformResource) bool {
+	added := p.MemoryMonitoredInstances.AddPendingResource(newResource)
+	if added {
+		p.store(newResource.FragmentId)
+	}
+	return added
+}
+
+func (p *FileMonitoredInstances) RemovePendingResource(fragmentId string, serviceInstanceID string, uid string) bool {
+	removed := p.MemoryMonitoredInstances.RemovePendingResource(fragmentId, serviceInstanceID, uid)
+	if removed {
+		p.store(fragmentId)
+	}
+	return removed
+}
+
+func (p *FileMonitoredInstances) RemoveResource(fragmentId string, serviceInstanceID string, uid string) bool {
+	removed := p.MemoryMonitoredInstances.RemoveResource(fragmentId, serviceInstanceID, uid)
+	if removed {
+		p.store(fragmentId)
+	}
+	return removed
+}
+
+func (p *FileMonitoredInstances) RemoveService(fragmentId string, serviceInstanceID string) bool {
+	removed := p.MemoryMonitoredInstances.RemoveService(fragmentId, serviceInstanceID)
+	if removed {
+		p.store(fragmentId)
+	}
+	return removed
+}
+
+func (p *FileMonitoredInstances) SetResourceStatus(fragmentId string, serviceInstanceId string, uid string,
+	status entities.NalejServiceStatus, info string, endpoints []entities.EndpointInstance) error {
+	err := p.MemoryMonitoredInstances.SetResourceStatus(fragmentId, serviceInstanceId, uid, status, info, endpoints)
+	if err == nil {
+		p.store(fragmentId)
+	}
+	return err
+}
+
+func (p *FileMonitoredInstances) NextNotificationSequence(fragmentId string) (uint64, bool) {
+	sequence, found := p.MemoryMonitoredInstances.NextNotificationSequence(fragmentId)
+	if found {
+		p.store(fragmentId)
+	}
+	return sequence, found
+}
+
+func (p *FileMonitoredInstances) ResetNotifiedStatus(notified *entities.MonitoredAppEntry) {
+	p.MemoryMonitoredInstances.ResetNotifiedStatus(notified)
+	p.store(notified.FragmentId)
+}
+
+func (p *FileMonitoredInstances) UpdateAppStatus(fragmentId string) {
+	p.MemoryMonitoredInstances.UpdateAppStatus(fragmentId)
+	p.store(fragmentId)
+}
+
+func (p *FileMonitoredInstances) RemoveEntry(fragmentId string) bool {
+	removed := p.MemoryMonitoredInstances.RemoveEntry(fragmentId)
+	if removed {
+		p.store(fragmentId)
+	}
+	return removed
+}
+
+func (p *FileMonitoredInstances) RemoveTerminatedEntry(fragmentId string) bool {
+	removed := p.MemoryMonitoredInstances.RemoveTerminatedEntry(fragmentId)
+	if removed {
+		p.store(fragmentId)
+	}
+	return removed
+}
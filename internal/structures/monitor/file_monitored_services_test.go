@@ -0,0 +1,110 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package monitor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("file monitored instances", func() {
+
+	var path string
+	var monitored MonitoredInstances
+
+	ginkgo.BeforeEach(func() {
+		var err error
+		path, err = ioutil.TempDir("", "monitored-instances")
+		gomega.Expect(err).To(gomega.Succeed())
+		var derr error
+		monitored, derr = NewFileMonitoredInstances(path)
+		gomega.Expect(derr).To(gomega.BeNil())
+		monitored.AddEntry(testMonitoredEntry("fragment-1"))
+		monitored.AddPendingResource(testResource("fragment-1", "deployment"))
+		monitored.AddPendingResource(testResource("fragment-1", "service"))
+	})
+
+	ginkgo.AfterEach(func() {
+		os.RemoveAll(path)
+	})
+
+	restore := func() MonitoredInstances {
+		restored, err := NewFileMonitoredInstances(path)
+		gomega.Expect(err).To(gomega.BeNil())
+		return restored
+	}
+
+	ginkgo.It("should restore the status, endpoints and pending checks", func() {
+		endpoints := []entities.EndpointInstance{{EndpointInstanceId: "endpoint", FQDN: "web.nalej", Port: 80}}
+		gomega.Expect(monitored.SetResourceStatus("fragment-1", "service", "deployment",
+			entities.NALEJ_SERVICE_RUNNING, "", endpoints)).To(gomega.Succeed())
+		monitored.UpdateAppStatus("fragment-1")
+
+		restored := restore()
+		gomega.Expect(restored.GetNumFragments()).To(gomega.Equal(1))
+		gomega.Expect(restored.GetNumResources()).To(gomega.Equal(2))
+		entry := restored.GetEntry("fragment-1")
+		gomega.Expect(entry).To(gomega.Equal(monitored.GetEntry("fragment-1")))
+		gomega.Expect(entry.Services["service"].Endpoints).To(gomega.Equal(endpoints))
+		gomega.Expect(entry.Services["service"].NumPendingChecks).To(gomega.Equal(1))
+		gomega.Expect(entry.Services["service"].Resources["service"].Pending).To(gomega.BeTrue())
+
+		// the pending checks go on after restoring the entry
+		result := waitPendingChecks(context.Background(), restored, "fragment-1", 60)
+		gomega.Consistently(result, 100*time.Millisecond).ShouldNot(gomega.Receive())
+		gomega.Expect(restored.SetResourceStatus("fragment-1", "service", "service",
+			entities.NALEJ_SERVICE_RUNNING, "", nil)).To(gomega.Succeed())
+		gomega.Eventually(result, time.Second).Should(gomega.Receive(gomega.BeNil()))
+	})
+
+	ginkgo.It("should restore the pending notifications until they are reset", func() {
+		monitored.SetEntryStatus("fragment-1", entities.FRAGMENT_DEPLOYING, nil)
+		gomega.Expect(restore().GetPendingNotifications()).To(gomega.HaveLen(1))
+		resetNotifiedStatus(monitored)
+		gomega.Expect(restore().GetPendingNotifications()).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should not restore the removed entries", func() {
+		gomega.Expect(monitored.RemoveEntry("fragment-1")).To(gomega.BeTrue())
+		gomega.Expect(restore().GetNumFragments()).To(gomega.Equal(0))
+	})
+
+	ginkgo.It("should not restore the removed terminated entries", func() {
+		gomega.Expect(monitored.RemoveTerminatedEntry("fragment-1")).To(gomega.BeFalse())
+		gomega.Expect(restore().GetNumFragments()).To(gomega.Equal(1))
+		monitored.SetEntryStatus("fragment-1", entities.FRAGMENT_TERMINATED, nil)
+		gomega.Expect(monitored.RemoveTerminatedEntry("fragment-1")).To(gomega.BeTrue())
+		gomega.Expect(restore().GetNumFragments()).To(gomega.Equal(0))
+	})
+
+	ginkgo.It("should discard corrupted and incomplete entries", func() {
+		gomega.Expect(ioutil.WriteFile(filepath.Join(path, "fragment-2"+entryExtension), []byte("{"), 0600)).To(gomega.Succeed())
+		gomega.Expect(ioutil.WriteFile(filepath.Join(path, "fragment-3"+tmpEntryExtension), []byte("{}"), 0600)).To(gomega.Succeed())
+		restored := restore()
+		gomega.Expect(restored.GetNumFragments()).To(gomega.Equal(1))
+		files, err := ioutil.ReadDir(path)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(files).To(gomega.HaveLen(1))
+	})
+})
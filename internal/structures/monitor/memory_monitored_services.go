@@ -19,9 +19,11 @@ package monitor
 // In memory implementation of a monitored services control structure.
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/derrors"
 	"github.com/rs/zerolog/log"
 	"sync"
 	"time"
@@ -34,23 +36,109 @@ type MemoryMonitoredInstances struct {
 	// Monitored resources for a given stage
 	// fragment id -> entry
 	monitoredEntries map[string]*entities.MonitoredAppEntry
+	// Status transitions broadcaster
+	events *StatusEventsBroadcaster
+	// Waiters of the pending checks of the fragments
+	// fragment id -> waiters
+	waiters map[string][]*pendingChecksWaiter
 	// Mutex
 	mu sync.RWMutex
 }
 
+// Waiter of the pending checks of a fragment.
+type pendingChecksWaiter struct {
+	// Signaled when the fragment changes
+	changed chan struct{}
+	// Service instances waited for, all of them if empty
+	services map[string]bool
+	// First resource error found while waiting
+	failure error
+}
+
+// Check if a service is waited for.
+func (w *pendingChecksWaiter) watches(serviceInstanceId string) bool {
+	return len(w.services) == 0 || w.services[serviceInstanceId]
+}
+
 // Constructor to instantiate a basic memory monitored instances object.
 func NewMemoryMonitoredInstances() MonitoredInstances {
 	return &MemoryMonitoredInstances{
 		monitoredEntries: make(map[string]*entities.MonitoredAppEntry, 0),
+		events:           NewStatusEventsBroadcaster(),
+		waiters:          make(map[string][]*pendingChecksWaiter, 0),
+	}
+}
+
+// Wake up the waiters of the pending checks of a fragment so they check it again. Must be called holding the lock.
+func (p *MemoryMonitoredInstances) notifyWaiters(fragmentId string) {
+	for _, w := range p.waiters[fragmentId] {
+		select {
+		case w.changed <- struct{}{}:
+		default:
+			// the waiter has not consumed the previous signal yet
+		}
+	}
+}
+
+// Record the error of a resource in the waiters of its service. The error is kept even if the resource
+// changes its status afterwards. Must be called holding the lock.
+func (p *MemoryMonitoredInstances) failWaiters(fragmentId string, serviceInstanceId string, failure error) {
+	for _, w := range p.waiters[fragmentId] {
+		if w.failure == nil && w.watches(serviceInstanceId) {
+			w.failure = failure
+		}
 	}
 }
 
+func (p *MemoryMonitoredInstances) WatchStatus(filter entities.StatusEventFilter, resumeToken string) (*StatusSubscription, derrors.Error) {
+	return p.events.Subscribe(filter, resumeToken)
+}
+
+// Publish the current status of a fragment.
+func (p *MemoryMonitoredInstances) publishFragmentStatus(entry *entities.MonitoredAppEntry) {
+	p.events.Publish(&entities.StatusEvent{
+		Type:           entities.STATUS_EVENT_FRAGMENT,
+		OrganizationId: entry.OrganizationId,
+		AppInstanceId:  entry.AppInstanceId,
+		FragmentId:     entry.FragmentId,
+		FragmentStatus: entry.Status,
+		Info:           entry.Info,
+	})
+}
+
+// Publish the current status of a service.
+func (p *MemoryMonitoredInstances) publishServiceStatus(entry *entities.MonitoredAppEntry, serv *entities.MonitoredServiceEntry) {
+	p.events.Publish(&entities.StatusEvent{
+		Type:              entities.STATUS_EVENT_SERVICE,
+		OrganizationId:    entry.OrganizationId,
+		AppInstanceId:     entry.AppInstanceId,
+		FragmentId:        entry.FragmentId,
+		ServiceInstanceId: serv.ServiceInstanceID,
+		ServiceStatus:     serv.Status,
+		Info:              serv.Info,
+	})
+}
+
+// Publish the current status of a platform resource.
+func (p *MemoryMonitoredInstances) publishResourceStatus(entry *entities.MonitoredAppEntry, res *entities.MonitoredPlatformResource) {
+	p.events.Publish(&entities.StatusEvent{
+		Type:              entities.STATUS_EVENT_RESOURCE,
+		OrganizationId:    entry.OrganizationId,
+		AppInstanceId:     entry.AppInstanceId,
+		FragmentId:        entry.FragmentId,
+		ServiceInstanceId: res.ServiceInstanceID,
+		ResourceUID:       res.UID,
+		ServiceStatus:     res.Status,
+		Info:              res.Info,
+	})
+}
+
 func (p *MemoryMonitoredInstances) AddEntry(toAdd *entities.MonitoredAppEntry) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	current, found := p.monitoredEntries[toAdd.FragmentId]
-	if !found {
-		// new entry
+	if !found || current.Status == entities.FRAGMENT_TERMINATED {
+		// new entry, a fragment removed from the cluster is deployed again from scratch
 		log.Debug().Str("fragmentId", toAdd.FragmentId).Msg("new fragment to be monitorized")
 		p.monitoredEntries[toAdd.FragmentId] = toAdd
 	} else {
@@ -58,6 +146,7 @@ func (p *MemoryMonitoredInstances) AddEntry(toAdd *entities.MonitoredAppEntry) {
 		log.Debug().Str("fragmentId", toAdd.FragmentId).Msg("append new services to fragment")
 		current.AppendServices(toAdd)
 	}
+	p.notifyWaiters(toAdd.FragmentId)
 }
 
 func (p *MemoryMonitoredInstances) GetEntry(fragmentId string) *entities.MonitoredAppEntry {
@@ -70,6 +159,26 @@ func (p *MemoryMonitoredInstances) GetEntry(fragmentId string) *entities.Monitor
 	return current
 }
 
+func (p *MemoryMonitoredInstances) GetEntryCopy(fragmentId string) *entities.MonitoredAppEntry {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	current, found := p.monitoredEntries[fragmentId]
+	if !found {
+		return nil
+	}
+	return current.Copy()
+}
+
+func (p *MemoryMonitoredInstances) ListEntries() []*entities.MonitoredAppEntry {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	toReturn := make([]*entities.MonitoredAppEntry, 0, len(p.monitoredEntries))
+	for _, entry := range p.monitoredEntries {
+		toReturn = append(toReturn, entry.Copy())
+	}
+	return toReturn
+}
+
 func (p *MemoryMonitoredInstances) SetEntryStatus(fragmentId string, status entities.FragmentStatus, err error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -79,7 +188,8 @@ func (p *MemoryMonitoredInstances) SetEntryStatus(fragmentId string, status enti
 	} else {
 		// Add new services if they were not previously added
 		log.Debug().Str("fragmentId", fragmentId).Interface("status", status).Msg("set instance status")
-		if status != current.Status {
+		changed := status != current.Status
+		if changed {
 			current.NewStatus = true
 		}
 		current.Status = status
@@ -88,13 +198,31 @@ func (p *MemoryMonitoredInstances) SetEntryStatus(fragmentId string, status enti
 		} else {
 			current.Info = ""
 		}
+		if changed {
+			p.publishFragmentStatus(current)
+		}
 		// if this entry is terminating everything below is terminated
 		// TODO revisit this terminating force operation
 		if current.Status == entities.FRAGMENT_TERMINATING {
 			for _, serv := range current.Services {
-				serv.Status = entities.NALEJ_SERVICE_TERMINATING
+				if serv.Status != entities.NALEJ_SERVICE_TERMINATING {
+					serv.Status = entities.NALEJ_SERVICE_TERMINATING
+					serv.NewStatus = true
+					p.publishServiceStatus(current, serv)
+				}
 			}
 		}
+		// cancelled and terminated entries have been removed, notify it for every service
+		if current.Status == entities.FRAGMENT_CANCELLED || current.Status == entities.FRAGMENT_TERMINATED {
+			for _, serv := range current.Services {
+				if serv.Status != entities.NALEJ_SERVICE_TERMINATING {
+					serv.Status = entities.NALEJ_SERVICE_TERMINATING
+					p.publishServiceStatus(current, serv)
+				}
+				serv.NewStatus = true
+			}
+		}
+		p.notifyWaiters(fragmentId)
 	}
 }
 
@@ -104,7 +232,8 @@ func (p *MemoryMonitoredInstances) SetAppStatus(appInstanceId string, status ent
 	// iterate and update the status of the entries
 	for _, current := range p.monitoredEntries {
 		if current.AppInstanceId == appInstanceId {
-			if status != current.Status {
+			changed := status != current.Status
+			if changed {
 				current.NewStatus = true
 				current.Status = status
 				// all services are in the same status
@@ -113,6 +242,7 @@ func (p *MemoryMonitoredInstances) SetAppStatus(appInstanceId string, status ent
 					if current.Services[i].Status != newStatus {
 						current.Services[i].Status = newStatus
 						current.Services[i].NewStatus = true
+						p.publishServiceStatus(current, current.Services[i])
 					}
 				}
 
@@ -122,6 +252,10 @@ func (p *MemoryMonitoredInstances) SetAppStatus(appInstanceId string, status ent
 			} else {
 				current.Info = ""
 			}
+			if changed {
+				p.publishFragmentStatus(current)
+			}
+			p.notifyWaiters(current.FragmentId)
 		}
 	}
 }
@@ -129,45 +263,121 @@ func (p *MemoryMonitoredInstances) SetAppStatus(appInstanceId string, status ent
 func (p *MemoryMonitoredInstances) GetAppStatus(appInstanceId string) (*entities.FragmentStatus, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	var removed *entities.FragmentStatus
 	for _, current := range p.monitoredEntries {
-		// all deployment fragments belong to the same appInstance, the first found value must be the same for all
-		if current.AppInstanceId == appInstanceId {
-			return &current.Status, nil
+		if current.AppInstanceId != appInstanceId {
+			continue
+		}
+		// the fragments being removed only determine the status of the app if there is no other fragment,
+		// the retained ones may belong to a previous instance with the same id
+		if !entities.IsFragmentRemoved(current.Status) {
+			status := current.Status
+			return &status, nil
+		}
+		if removed == nil || *removed == entities.FRAGMENT_TERMINATED {
+			status := current.Status
+			removed = &status
 		}
 	}
+	if removed != nil {
+		return removed, nil
+	}
 
 	return nil, errors.New(fmt.Sprintf("cannot get status of app %s because it does not exist", appInstanceId))
 
 }
 
-// Check iteratively if the stage has any pending resource to be deployed. This is done using the kubernetes controller.
-// If after the maximum expiration time the check is not successful, the execution is considered to be failed.
-func (p *MemoryMonitoredInstances) WaitPendingChecks(fragmentId string, checkingSleepTime int, stageCheckingTimeout int) error {
+// Wait until the watched services of a fragment have no pending resources. The fragment is checked again every
+// time it changes, so the wait finishes as soon as their last resource is ready or any of their resources fails.
+// The services of other stages do not affect the wait.
+func (p *MemoryMonitoredInstances) WaitPendingChecks(ctx context.Context, fragmentId string, services []string, stageCheckingTimeout int) error {
 	log.Info().Msgf("fragment %s wait until services for the instance are ready", fragmentId)
+	w := &pendingChecksWaiter{changed: make(chan struct{}, 1), services: make(map[string]bool, len(services))}
+	for _, serviceInstanceId := range services {
+		w.services[serviceInstanceId] = true
+	}
+	p.mu.Lock()
+	p.waiters[fragmentId] = append(p.waiters[fragmentId], w)
+	p.mu.Unlock()
+	defer p.removeWaiter(fragmentId, w)
+
 	timeout := time.After(time.Second * time.Duration(stageCheckingTimeout))
-	tick := time.Tick(time.Second * time.Duration(checkingSleepTime))
 	for {
+		done, err := p.checkPendingChecks(fragmentId, w)
+		if done {
+			return err
+		}
 		select {
+		case <-ctx.Done():
+			log.Info().Str("fragmentId", fragmentId).Msg("stop waiting for pending checks, context done")
+			return ctx.Err()
 		// Got a timeout! Error
 		case <-timeout:
 			log.Error().Str("fragmentId", fragmentId).Msg("checking pendingStages resources exceeded for stage")
 			return errors.New(fmt.Sprintf("checking pendingStages resources exceeded for fragment %s", fragmentId))
-			// Next check
-		case <-tick:
-			p.UpdateAppStatus(fragmentId)
-			monitoredEntry, found := p.monitoredEntries[fragmentId]
-			if !found {
-				log.Info().Str("fragmentId", fragmentId).Msg("fragment not monitored")
-				return errors.New(fmt.Sprintf("not monitored fragment %s", fragmentId))
-			}
-			if monitoredEntry.NumPendingChecks == 0 {
-				log.Info().Str("fragmentId", fragmentId).Msg("fragment has no pendingStages checks. Exit checking stage")
-				return nil
-			}
+		// The fragment changed, check it again
+		case <-w.changed:
 		}
 	}
 }
 
+// Check if the wait for the pending checks of a fragment is finished.
+//  params:
+//   fragmentId identifier of the fragment
+//   w waiter of the fragment
+//  return:
+//   true if the wait is finished and the error that finished it if any
+func (p *MemoryMonitoredInstances) checkPendingChecks(fragmentId string, w *pendingChecksWaiter) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if w.failure != nil {
+		log.Info().Str("fragmentId", fragmentId).Str("failure", w.failure.Error()).Msg("fragment failed. Exit checking stage")
+		return true, w.failure
+	}
+	monitoredEntry, found := p.monitoredEntries[fragmentId]
+	if !found {
+		log.Info().Str("fragmentId", fragmentId).Msg("fragment not monitored")
+		return true, errors.New(fmt.Sprintf("not monitored fragment %s", fragmentId))
+	}
+	p.updateAppStatus(monitoredEntry)
+	pending := 0
+	for _, serv := range monitoredEntry.Services {
+		if !w.watches(serv.ServiceInstanceID) {
+			continue
+		}
+		if serv.Status == entities.NALEJ_SERVICE_ERROR {
+			log.Info().Str("fragmentId", fragmentId).Str("serviceInstanceId", serv.ServiceInstanceID).
+				Msg("service failed. Exit checking stage")
+			return true, errors.New(fmt.Sprintf("service %s of fragment %s failed: %s", serv.ServiceInstanceID, fragmentId, serv.Info))
+		}
+		if serv.Status != entities.NALEJ_SERVICE_RUNNING {
+			pending++
+		}
+	}
+	if pending == 0 {
+		log.Info().Str("fragmentId", fragmentId).Msg("fragment has no pendingStages checks. Exit checking stage")
+		return true, nil
+	}
+	return false, nil
+}
+
+func (p *MemoryMonitoredInstances) removeWaiter(fragmentId string, w *pendingChecksWaiter) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	waiters := p.waiters[fragmentId]
+	for i, current := range waiters {
+		if current == w {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(p.waiters, fragmentId)
+	} else {
+		p.waiters[fragmentId] = waiters
+	}
+}
+
 // Add a new resource pending to be checked.
 func (p *MemoryMonitoredInstances) AddPendingResource(newResource *entities.MonitoredPlatformResource) bool {
 	p.mu.Lock()
@@ -189,6 +399,7 @@ func (p *MemoryMonitoredInstances) AddPendingResource(newResource *entities.Moni
 		return false
 	}
 	service.AddPendingResource(newResource)
+	p.notifyWaiters(newResource.FragmentId)
 
 	log.Debug().Str("fragmentId", newResource.FragmentId).Int("pending checks", service.NumPendingChecks).
 		Msg("a new resource has been added")
@@ -228,6 +439,7 @@ func (p *MemoryMonitoredInstances) RemovePendingResource(fragmentId string, serv
 	if service.NumPendingChecks == 0 {
 		appEntry.NumPendingChecks = appEntry.NumPendingChecks - 1
 	}
+	p.notifyWaiters(fragmentId)
 
 	return true
 }
@@ -300,6 +512,10 @@ func (p *MemoryMonitoredInstances) SetResourceStatus(fragmentId string, serviceI
 	}
 	resource.Status = status
 	resource.Info = info
+	p.publishResourceStatus(app, resource)
+	if status == entities.NALEJ_SERVICE_ERROR {
+		p.failWaiters(fragmentId, serviceInstanceId, fmt.Errorf("resource %s of service %s failed: %s", uid, serviceInstanceId, info))
+	}
 
 	// set the endpoints for this entry
 	if len(endpoints) > 0 {
@@ -318,6 +534,19 @@ func (p *MemoryMonitoredInstances) SetResourceStatus(fragmentId string, serviceI
 		service.RemovePendingResource(resource.UID)
 	}
 
+	p.updateServiceStatus(app, service)
+	p.notifyWaiters(fragmentId)
+
+	return nil
+}
+
+// Update the status of a service and its fragment with the worst status found in the resources of the service.
+// Must be called holding the lock.
+func (p *MemoryMonitoredInstances) updateServiceStatus(app *entities.MonitoredAppEntry, service *entities.MonitoredServiceEntry) {
+	// suspended fragments are not computed from their resources until they are resumed
+	if app.Status == entities.FRAGMENT_SUSPENDED {
+		return
+	}
 	// Update service status
 	// get the worst status found in the resources required by this service
 	previousStatus := service.Status
@@ -331,7 +560,6 @@ func (p *MemoryMonitoredInstances) SetResourceStatus(fragmentId string, serviceI
 			break
 		} else if res.Status < finalStatus {
 			finalStatus = res.Status
-			info = res.Info
 		}
 	}
 	if finalStatus != previousStatus {
@@ -347,7 +575,15 @@ func (p *MemoryMonitoredInstances) SetResourceStatus(fragmentId string, serviceI
 
 	service.Status = finalStatus
 	service.Info = newServiceInfo
+	if finalStatus != previousStatus {
+		p.publishServiceStatus(app, service)
+	}
+
+	p.updateFragmentStatus(app)
+}
 
+// Set the status of a fragment with the worst status of its services. Must be called holding the lock.
+func (p *MemoryMonitoredInstances) updateFragmentStatus(app *entities.MonitoredAppEntry) {
 	var newAppStatus entities.NalejServiceStatus
 	newAppStatus = entities.NALEJ_SERVICE_RUNNING
 	newAppInfo := app.Info
@@ -365,51 +601,109 @@ func (p *MemoryMonitoredInstances) SetResourceStatus(fragmentId string, serviceI
 	log.Debug().Interface("previous", app.Status).Interface("now", newAppStatus).
 		Msg("final status of the app after updating services")
 
+	previousAppStatus := app.Status
 	app.Status = entities.ServicesToFragmentStatus[newAppStatus]
 	app.Info = newAppInfo
+	if app.Status != previousAppStatus {
+		p.publishFragmentStatus(app)
+	}
+}
 
-	return nil
+// Remove a resource that no longer exists in the platform. The status of its service is computed again
+// with the remaining resources.
+func (p *MemoryMonitoredInstances) RemoveResource(fragmentId string, serviceInstanceID string, uid string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	app, found := p.monitoredEntries[fragmentId]
+	if !found {
+		log.Error().Str("fragmentId", fragmentId).Msg("impossible to remove resource. Fragment not monitored.")
+		return false
+	}
+
+	service, found := app.Services[serviceInstanceID]
+	if !found {
+		log.Error().Str("fragmentId", fragmentId).Str("serviceInstanceID", serviceInstanceID).
+			Msg("impossible to remove resource. Service not monitored.")
+		return false
+	}
+
+	resource, found := service.Resources[uid]
+	if !found {
+		log.Debug().Str("fragmentId", fragmentId).Str("serviceInstanceID", serviceInstanceID).
+			Str("resource uid", uid).Msg("resource to be removed was not monitored")
+		return false
+	}
+
+	// resources that are not running count as pending checks
+	if resource.Status != entities.NALEJ_SERVICE_RUNNING {
+		service.NumPendingChecks = service.NumPendingChecks - 1
+	}
+	delete(service.Resources, uid)
+	p.updateServiceStatus(app, service)
+	p.notifyWaiters(fragmentId)
+
+	return true
+}
+
+func (p *MemoryMonitoredInstances) RemoveService(fragmentId string, serviceInstanceID string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	app, found := p.monitoredEntries[fragmentId]
+	if !found {
+		log.Error().Str("fragmentId", fragmentId).Msg("impossible to remove service. Fragment not monitored.")
+		return false
+	}
+
+	if _, found := app.Services[serviceInstanceID]; !found {
+		log.Debug().Str("fragmentId", fragmentId).Str("serviceInstanceID", serviceInstanceID).
+			Msg("service to be removed was not monitored")
+		return false
+	}
+
+	delete(app.Services, serviceInstanceID)
+	app.TotalServices = app.TotalServices - 1
+	p.updateAppStatus(app)
+	// suspended fragments are not computed from their services until they are resumed
+	if app.Status != entities.FRAGMENT_SUSPENDED {
+		p.updateFragmentStatus(app)
+	}
+	p.notifyWaiters(fragmentId)
+
+	return true
 }
 
 func (p *MemoryMonitoredInstances) GetPendingNotifications() []*entities.MonitoredAppEntry {
 	p.mu.RLock()
+	defer p.mu.RUnlock()
 	toReturn := make([]*entities.MonitoredAppEntry, 0)
-	// list of apps to be removed
-	toRemove := make([]string, 0)
+	// the entries removed from the cluster are kept until the janitor removes them
 	for _, entry := range p.monitoredEntries {
-		if entry.Status == entities.FRAGMENT_TERMINATING {
-			toRemove = append(toRemove, entry.FragmentId)
-		}
 		pendingServices := make(map[string]*entities.MonitoredServiceEntry, 0)
 		// for every monitored entry
 		for _, x := range entry.Services {
 			// for every monitored service
-			if entry.NewStatus || x.NewStatus {
-				pendingServices[x.ServiceInstanceID] = x
+			if x.NewStatus {
+				pendingServices[x.ServiceInstanceID] = x.Copy()
 			}
 		}
-		if len(pendingServices) > 0 {
+		if entry.NewStatus || len(pendingServices) > 0 {
 			newApp := entities.MonitoredAppEntry{
-				FragmentId:       entry.FragmentId,
-				NumPendingChecks: entry.NumPendingChecks,
-				OrganizationId:   entry.OrganizationId,
-				AppInstanceId:    entry.AppInstanceId,
-				Services:         pendingServices,
-				Info:             entry.Info,
-				DeploymentId:     entry.DeploymentId,
-				Status:           entry.Status,
-				AppDescriptorId:  entry.AppDescriptorId,
+				FragmentId:           entry.FragmentId,
+				NumPendingChecks:     entry.NumPendingChecks,
+				OrganizationId:       entry.OrganizationId,
+				AppInstanceId:        entry.AppInstanceId,
+				Services:             pendingServices,
+				Info:                 entry.Info,
+				DeploymentId:         entry.DeploymentId,
+				Status:               entry.Status,
+				AppDescriptorId:      entry.AppDescriptorId,
+				NotificationSequence: entry.NotificationSequence,
 			}
 			toReturn = append(toReturn, &newApp)
 		}
 	}
-	// remove entries in terminating status
-	for _, fragmentId := range toRemove {
-		log.Debug().Str("fragmentId", fragmentId).Msg("remove terminating entry from the list of monitored")
-		defer p.RemoveEntry(fragmentId)
-	}
-	// This is the latest unlock to be deferred to respect the order and avoid race conditions
-	defer p.mu.RUnlock()
 	return toReturn
 }
 
@@ -432,29 +726,64 @@ func (p *MemoryMonitoredInstances) GetServicesUnnotifiedStatus() []*entities.Mon
 	return toNotify
 }
 
-// Set to already notified all services.
-func (p *MemoryMonitoredInstances) ResetServicesUnnotifiedStatus() {
+func (p *MemoryMonitoredInstances) NextNotificationSequence(fragmentId string) (uint64, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	for _, stage := range p.monitoredEntries {
-		stage.NewStatus = false
-		// for every monitored stage
-		for _, x := range stage.Services {
-			// for every monitored service
-			if x.NewStatus {
-				x.NewStatus = false
-			}
+	current, found := p.monitoredEntries[fragmentId]
+	if !found {
+		return 0, false
+	}
+	current.NotificationSequence++
+	return current.NotificationSequence, true
+}
+
+func (p *MemoryMonitoredInstances) ResetNotifiedStatus(notified *entities.MonitoredAppEntry) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	current, found := p.monitoredEntries[notified.FragmentId]
+	if !found {
+		return
+	}
+	if current.Status == notified.Status && current.Info == notified.Info {
+		current.NewStatus = false
+	}
+	for id, serv := range notified.Services {
+		x, found := current.Services[id]
+		if !found {
+			continue
+		}
+		if x.Status == serv.Status && x.Info == serv.Info && sameEndpoints(x.Endpoints, serv.Endpoints) {
+			x.NewStatus = false
 		}
 	}
-	// log.Debug().Interface("monitored stages",p.monitoredStages).Msg("monitored after reset")
+}
+
+// Check if two lists of endpoints are the same.
+func sameEndpoints(a []entities.EndpointInstance, b []entities.EndpointInstance) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
 func (p *MemoryMonitoredInstances) UpdateAppStatus(fragmentId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
 	app, found := p.monitoredEntries[fragmentId]
 	if !found {
 		log.Error().Str("fragmentId", fragmentId).Msg("impossible to update app status. App not monitored.")
 		return
 	}
+	p.updateAppStatus(app)
+}
+
+// Set the number of pending checks of a fragment with the services that are not running. Must be called holding the lock.
+func (p *MemoryMonitoredInstances) updateAppStatus(app *entities.MonitoredAppEntry) {
 	pendingServices := 0
 	for _, serv := range app.Services {
 		if serv.Status != entities.NALEJ_SERVICE_RUNNING {
@@ -463,7 +792,7 @@ func (p *MemoryMonitoredInstances) UpdateAppStatus(fragmentId string) {
 	}
 	if app.NumPendingChecks != pendingServices {
 		app.NumPendingChecks = pendingServices
-		log.Info().Str("fragmentId", fragmentId).Int("pendingServices", app.NumPendingChecks).
+		log.Info().Str("fragmentId", app.FragmentId).Int("pendingServices", app.NumPendingChecks).
 			Msg("updated number of pending services for app")
 	}
 }
@@ -480,10 +809,28 @@ func (p *MemoryMonitoredInstances) RemoveEntry(fragmentId string) bool {
 	}
 
 	delete(p.monitoredEntries, fragmentId)
+	p.notifyWaiters(fragmentId)
+	return true
+}
+
+func (p *MemoryMonitoredInstances) RemoveTerminatedEntry(fragmentId string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	current, found := p.monitoredEntries[fragmentId]
+	if !found || current.Status != entities.FRAGMENT_TERMINATED {
+		log.Debug().Str("fragmentId", fragmentId).Msg("fragment is no longer terminated, entry kept")
+		return false
+	}
+
+	delete(p.monitoredEntries, fragmentId)
+	p.notifyWaiters(fragmentId)
 	return true
 }
 
 func (p *MemoryMonitoredInstances) GetNumFragments() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	return len(p.monitoredEntries)
 }
 
@@ -0,0 +1,359 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package monitor
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+func testResource(fragmentId string, uid string) *entities.MonitoredPlatformResource {
+	return &entities.MonitoredPlatformResource{
+		FragmentId:        fragmentId,
+		ServiceInstanceID: "service",
+		UID:               uid,
+		Status:            entities.NALEJ_SERVICE_SCHEDULED,
+	}
+}
+
+// Wait for the pending checks of a fragment in the background.
+func waitPendingChecks(ctx context.Context, monitored MonitoredInstances, fragmentId string, timeout int,
+	services ...string) chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- monitored.WaitPendingChecks(ctx, fragmentId, services, timeout)
+	}()
+	return result
+}
+
+// Set as notified all the pending notifications.
+func resetNotifiedStatus(monitored MonitoredInstances) {
+	for _, notified := range monitored.GetPendingNotifications() {
+		monitored.ResetNotifiedStatus(notified)
+	}
+}
+
+// Define the same tests for every implementation of the monitored instances.
+//  params:
+//   text description of the tests
+//   body tests using the given constructor to instantiate the monitored instances
+func describeMonitoredInstances(text string, body func(newMonitored func() MonitoredInstances)) bool {
+	ginkgo.Describe(text+" in memory", func() {
+		body(NewMemoryMonitoredInstances)
+	})
+	return ginkgo.Describe(text+" in file", func() {
+		var path string
+
+		ginkgo.BeforeEach(func() {
+			var err error
+			path, err = ioutil.TempDir("", "monitored-instances")
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+
+		ginkgo.AfterEach(func() {
+			os.RemoveAll(path)
+		})
+
+		body(func() MonitoredInstances {
+			monitored, err := NewFileMonitoredInstances(path)
+			gomega.Expect(err).To(gomega.BeNil())
+			return monitored
+		})
+	})
+}
+
+var _ = describeMonitoredInstances("entries", func(newMonitored func() MonitoredInstances) {
+
+	var monitored MonitoredInstances
+
+	ginkgo.BeforeEach(func() {
+		monitored = newMonitored()
+		monitored.AddEntry(testMonitoredEntry("fragment-1"))
+	})
+
+	ginkgo.It("should return copies not changed by the updates of the entry", func() {
+		entry := monitored.GetEntryCopy("fragment-1")
+		gomega.Expect(entry).NotTo(gomega.BeNil())
+		status := entry.Status
+		monitored.SetEntryStatus("fragment-1", entities.FRAGMENT_ERROR, nil)
+		gomega.Expect(entry.Status).To(gomega.Equal(status))
+		gomega.Expect(monitored.GetEntryCopy("fragment-1").Status).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_ERROR)))
+		gomega.Expect(monitored.GetEntryCopy("fragment-2")).To(gomega.BeNil())
+	})
+})
+
+var _ = describeMonitoredInstances("wait pending checks", func(newMonitored func() MonitoredInstances) {
+
+	var monitored MonitoredInstances
+
+	ginkgo.BeforeEach(func() {
+		monitored = newMonitored()
+		monitored.AddEntry(testMonitoredEntry("fragment-1"))
+		monitored.AddPendingResource(testResource("fragment-1", "deployment"))
+		monitored.AddPendingResource(testResource("fragment-1", "service"))
+	})
+
+	ginkgo.It("should finish as soon as the resources are running", func() {
+		result := waitPendingChecks(context.Background(), monitored, "fragment-1", 60)
+		gomega.Consistently(result, 100*time.Millisecond).ShouldNot(gomega.Receive())
+
+		gomega.Expect(monitored.SetResourceStatus("fragment-1", "service", "deployment",
+			entities.NALEJ_SERVICE_RUNNING, "", nil)).To(gomega.Succeed())
+		gomega.Consistently(result, 100*time.Millisecond).ShouldNot(gomega.Receive())
+
+		gomega.Expect(monitored.SetResourceStatus("fragment-1", "service", "service",
+			entities.NALEJ_SERVICE_RUNNING, "", nil)).To(gomega.Succeed())
+		gomega.Eventually(result, time.Second).Should(gomega.Receive(gomega.BeNil()))
+	})
+
+	ginkgo.It("should fail as soon as a resource fails", func() {
+		result := waitPendingChecks(context.Background(), monitored, "fragment-1", 60)
+		gomega.Consistently(result, 100*time.Millisecond).ShouldNot(gomega.Receive())
+
+		gomega.Expect(monitored.SetResourceStatus("fragment-1", "service", "deployment",
+			entities.NALEJ_SERVICE_ERROR, "ImagePullBackOff", nil)).To(gomega.Succeed())
+		// the failure is kept even if the resource changes again before the waiter checks it
+		gomega.Expect(monitored.SetResourceStatus("fragment-1", "service", "deployment",
+			entities.NALEJ_SERVICE_DEPLOYING, "", nil)).To(gomega.Succeed())
+		var err error
+		gomega.Eventually(result, time.Second).Should(gomega.Receive(&err))
+		gomega.Expect(err).NotTo(gomega.BeNil())
+		gomega.Expect(err.Error()).To(gomega.ContainSubstring("ImagePullBackOff"))
+	})
+
+	ginkgo.It("should ignore the services of other stages", func() {
+		other := testMonitoredEntry("fragment-1")
+		other.Services = map[string]*entities.MonitoredServiceEntry{"other": other.Services["service"]}
+		other.Services["other"].ServiceInstanceID = "other"
+		monitored.AddEntry(other)
+		resource := testResource("fragment-1", "other-deployment")
+		resource.ServiceInstanceID = "other"
+		monitored.AddPendingResource(resource)
+
+		result := waitPendingChecks(context.Background(), monitored, "fragment-1", 60, "service")
+		gomega.Expect(monitored.SetResourceStatus("fragment-1", "other", "other-deployment",
+			entities.NALEJ_SERVICE_ERROR, "CrashLoopBackOff", nil)).To(gomega.Succeed())
+		gomega.Consistently(result, 100*time.Millisecond).ShouldNot(gomega.Receive())
+
+		for _, uid := range []string{"deployment", "service"} {
+			gomega.Expect(monitored.SetResourceStatus("fragment-1", "service", uid,
+				entities.NALEJ_SERVICE_RUNNING, "", nil)).To(gomega.Succeed())
+		}
+		gomega.Eventually(result, time.Second).Should(gomega.Receive(gomega.BeNil()))
+	})
+
+	ginkgo.It("should fail when the fragment is removed", func() {
+		result := waitPendingChecks(context.Background(), monitored, "fragment-1", 60)
+		gomega.Consistently(result, 100*time.Millisecond).ShouldNot(gomega.Receive())
+		monitored.RemoveEntry("fragment-1")
+		gomega.Eventually(result, time.Second).Should(gomega.Receive(gomega.HaveOccurred()))
+	})
+
+	ginkgo.It("should fail after the timeout", func() {
+		result := waitPendingChecks(context.Background(), monitored, "fragment-1", 1)
+		gomega.Eventually(result, 3*time.Second).Should(gomega.Receive(gomega.HaveOccurred()))
+	})
+
+	ginkgo.It("should stop when the context is cancelled", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		result := waitPendingChecks(ctx, monitored, "fragment-1", 60)
+		cancel()
+		gomega.Eventually(result, time.Second).Should(gomega.Receive(gomega.Equal(context.Canceled)))
+	})
+})
+
+var _ = describeMonitoredInstances("removed fragments", func(newMonitored func() MonitoredInstances) {
+
+	var monitored MonitoredInstances
+
+	ginkgo.BeforeEach(func() {
+		monitored = newMonitored()
+		monitored.AddEntry(testMonitoredEntry("fragment-1"))
+		monitored.AddEntry(testMonitoredEntry("fragment-2"))
+		monitored.SetEntryStatus("fragment-1", entities.FRAGMENT_TERMINATED, nil)
+	})
+
+	ginkgo.It("should ignore the removed fragments in the status of the application", func() {
+		status, err := monitored.GetAppStatus("app")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(*status).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_WAITING)))
+
+		monitored.SetEntryStatus("fragment-2", entities.FRAGMENT_TERMINATING, nil)
+		status, err = monitored.GetAppStatus("app")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(*status).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_TERMINATING)))
+	})
+
+	ginkgo.It("should retain the removed fragments after notifying them", func() {
+		pending := monitored.GetPendingNotifications()
+		gomega.Expect(pending).To(gomega.HaveLen(1))
+		gomega.Expect(pending[0].FragmentId).To(gomega.Equal("fragment-1"))
+		monitored.ResetNotifiedStatus(pending[0])
+		gomega.Expect(monitored.GetNumFragments()).To(gomega.Equal(2))
+		gomega.Expect(monitored.GetEntry("fragment-1").Status).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_TERMINATED)))
+	})
+
+	ginkgo.It("should remove only the entries still terminated", func() {
+		gomega.Expect(monitored.RemoveTerminatedEntry("fragment-2")).To(gomega.BeFalse())
+		gomega.Expect(monitored.GetEntry("fragment-2")).NotTo(gomega.BeNil())
+
+		// deployed again after the janitor checked it
+		monitored.AddEntry(testMonitoredEntry("fragment-1"))
+		gomega.Expect(monitored.RemoveTerminatedEntry("fragment-1")).To(gomega.BeFalse())
+		gomega.Expect(monitored.GetEntry("fragment-1")).NotTo(gomega.BeNil())
+
+		monitored.SetEntryStatus("fragment-1", entities.FRAGMENT_TERMINATED, nil)
+		gomega.Expect(monitored.RemoveTerminatedEntry("fragment-1")).To(gomega.BeTrue())
+		gomega.Expect(monitored.GetEntry("fragment-1")).To(gomega.BeNil())
+		gomega.Expect(monitored.RemoveTerminatedEntry("fragment-1")).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should replace a removed fragment deployed again", func() {
+		monitored.AddEntry(testMonitoredEntry("fragment-1"))
+		entry := monitored.GetEntry("fragment-1")
+		gomega.Expect(entry.Status).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_WAITING)))
+		gomega.Expect(entry.Services["service"].Status).To(gomega.Equal(entities.NalejServiceStatus(entities.NALEJ_SERVICE_SCHEDULED)))
+	})
+})
+
+var _ = describeMonitoredInstances("notified status", func(newMonitored func() MonitoredInstances) {
+
+	var monitored MonitoredInstances
+
+	ginkgo.BeforeEach(func() {
+		monitored = newMonitored()
+		monitored.AddEntry(testMonitoredEntry("fragment-1"))
+		monitored.AddPendingResource(testResource("fragment-1", "deployment"))
+		gomega.Expect(monitored.SetResourceStatus("fragment-1", "service", "deployment",
+			entities.NALEJ_SERVICE_DEPLOYING, "", nil)).To(gomega.Succeed())
+	})
+
+	ginkgo.It("should reset the flags of an acknowledged notification", func() {
+		pending := monitored.GetPendingNotifications()
+		gomega.Expect(pending).To(gomega.HaveLen(1))
+		monitored.ResetNotifiedStatus(pending[0])
+		gomega.Expect(monitored.GetPendingNotifications()).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should keep the flags of the services changed after the notification", func() {
+		pending := monitored.GetPendingNotifications()
+		gomega.Expect(monitored.SetResourceStatus("fragment-1", "service", "deployment",
+			entities.NALEJ_SERVICE_RUNNING, "", nil)).To(gomega.Succeed())
+		// the notification is a copy that is not modified
+		gomega.Expect(pending[0].Services["service"].Status).To(gomega.Equal(entities.NalejServiceStatus(entities.NALEJ_SERVICE_DEPLOYING)))
+		monitored.ResetNotifiedStatus(pending[0])
+		pending = monitored.GetPendingNotifications()
+		gomega.Expect(pending).To(gomega.HaveLen(1))
+		gomega.Expect(pending[0].Services["service"].Status).To(gomega.Equal(entities.NalejServiceStatus(entities.NALEJ_SERVICE_RUNNING)))
+	})
+})
+
+var _ = describeMonitoredInstances("delta notifications", func(newMonitored func() MonitoredInstances) {
+
+	var monitored MonitoredInstances
+
+	ginkgo.BeforeEach(func() {
+		monitored = newMonitored()
+		entry := testMonitoredEntry("fragment-1")
+		entry.Services["other"] = &entities.MonitoredServiceEntry{
+			OrganizationId:    "org",
+			AppInstanceId:     "app",
+			FragmentId:        "fragment-1",
+			ServiceInstanceID: "other",
+			Status:            entities.NALEJ_SERVICE_SCHEDULED,
+			Resources:         make(map[string]*entities.MonitoredPlatformResource, 0),
+		}
+		monitored.AddEntry(entry)
+		monitored.AddPendingResource(testResource("fragment-1", "deployment"))
+	})
+
+	ginkgo.It("should only notify the changed services", func() {
+		monitored.SetEntryStatus("fragment-1", entities.FRAGMENT_DEPLOYING, nil)
+		pending := monitored.GetPendingNotifications()
+		gomega.Expect(pending).To(gomega.HaveLen(1))
+		gomega.Expect(pending[0].Services).To(gomega.BeEmpty())
+		monitored.ResetNotifiedStatus(pending[0])
+
+		gomega.Expect(monitored.SetResourceStatus("fragment-1", "service", "deployment",
+			entities.NALEJ_SERVICE_DEPLOYING, "", nil)).To(gomega.Succeed())
+		pending = monitored.GetPendingNotifications()
+		gomega.Expect(pending).To(gomega.HaveLen(1))
+		gomega.Expect(pending[0].Services).To(gomega.HaveLen(1))
+		gomega.Expect(pending[0].Services).To(gomega.HaveKey("service"))
+	})
+
+	ginkgo.It("should notify every service of a fragment being removed", func() {
+		monitored.SetEntryStatus("fragment-1", entities.FRAGMENT_TERMINATING, nil)
+		pending := monitored.GetPendingNotifications()
+		gomega.Expect(pending).To(gomega.HaveLen(1))
+		gomega.Expect(pending[0].Services).To(gomega.HaveLen(2))
+	})
+
+	ginkgo.It("should increase the sequence number of the notifications", func() {
+		first, found := monitored.NextNotificationSequence("fragment-1")
+		gomega.Expect(found).To(gomega.BeTrue())
+		second, _ := monitored.NextNotificationSequence("fragment-1")
+		gomega.Expect(second).To(gomega.Equal(first + 1))
+		gomega.Expect(monitored.GetEntry("fragment-1").NotificationSequence).To(gomega.Equal(second))
+		_, found = monitored.NextNotificationSequence("fragment-2")
+		gomega.Expect(found).To(gomega.BeFalse())
+	})
+})
+
+var _ = describeMonitoredInstances("removed services", func(newMonitored func() MonitoredInstances) {
+
+	var monitored MonitoredInstances
+
+	ginkgo.BeforeEach(func() {
+		monitored = newMonitored()
+		entry := testMonitoredEntry("fragment-1")
+		entry.Services["running"] = &entities.MonitoredServiceEntry{
+			OrganizationId:    "org",
+			AppInstanceId:     "app",
+			FragmentId:        "fragment-1",
+			ServiceInstanceID: "running",
+			Status:            entities.NALEJ_SERVICE_RUNNING,
+			Resources:         make(map[string]*entities.MonitoredPlatformResource, 0),
+		}
+		monitored.AddEntry(entry)
+		monitored.AddPendingResource(testResource("fragment-1", "deployment"))
+	})
+
+	ginkgo.It("should compute the status of the fragment without the removed service", func() {
+		result := waitPendingChecks(context.Background(), monitored, "fragment-1", 60)
+		gomega.Consistently(result, 100*time.Millisecond).ShouldNot(gomega.Receive())
+
+		gomega.Expect(monitored.RemoveService("fragment-1", "service")).To(gomega.BeTrue())
+		gomega.Eventually(result, time.Second).Should(gomega.Receive(gomega.BeNil()))
+		entry := monitored.GetEntry("fragment-1")
+		gomega.Expect(entry.Services).NotTo(gomega.HaveKey("service"))
+		gomega.Expect(entry.Status).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_DONE)))
+		gomega.Expect(monitored.IsMonitoredResource("fragment-1", "service", "deployment")).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should not remove services not monitored", func() {
+		gomega.Expect(monitored.RemoveService("fragment-1", "unknown")).To(gomega.BeFalse())
+		gomega.Expect(monitored.RemoveService("fragment-2", "service")).To(gomega.BeFalse())
+		gomega.Expect(monitored.GetEntry("fragment-1").Services).To(gomega.HaveLen(2))
+	})
+})
@@ -17,24 +17,30 @@
 package monitor
 
 import (
+	"context"
 	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/derrors"
 )
 
 // Structure designed to observe the evolution of ongoing deployed/deployments in the current cluster.
 // This structure can be used to inform other solutions about the current deployment.
 
 type MonitoredInstances interface {
-	// Check iteratively if the app has any pending resource to be deployed. This is done using the kubernetes controller.
-	// If after the maximum expiration time the check is not successful, the execution is considered to be failed.
+	// Wait until the given services of the app have no pending resource to be deployed. The status of the
+	// resources is updated by the kubernetes controller and the wait finishes as soon as all of them are ready.
+	// If any of their resources fails or after the maximum expiration time the resources are not ready, the
+	// execution is considered to be failed.
 	// params:
+	//  ctx context to stop waiting
 	//  fragmentId
-	//  timecheck seconds between checks
+	//  services service instances to wait for, all the services of the fragment if empty
 	//  timeout seconds to wait until considering the task to be failed
 	// return:
 	//  error if any
-	WaitPendingChecks(fragmentId string, timecheck int, timeout int) error
+	WaitPendingChecks(ctx context.Context, fragmentId string, services []string, timeout int) error
 
 	// Add a new app to be monitored. If the application already exists, the services are added to the current instance.
+	// An entry retained after being removed from the cluster is replaced.
 	// params:
 	//  toAdd application to be added.
 	AddEntry(toAdd *entities.MonitoredAppEntry)
@@ -46,6 +52,18 @@ type MonitoredInstances interface {
 	//  the monitored app entry if any or error
 	GetEntry(fragmentId string) *entities.MonitoredAppEntry
 
+	// Get a copy of a monitored entry that can be read while the entry is being updated.
+	// params:
+	//  fragmentId identifying an existing entry
+	// return:
+	//  copy of the monitored app entry or nil if not found
+	GetEntryCopy(fragmentId string) *entities.MonitoredAppEntry
+
+	// List copies of the monitored entries.
+	// return:
+	//  copy of every monitored entry
+	ListEntries() []*entities.MonitoredAppEntry
+
 	// Set the status of a fragment
 	// params:
 	//  fragmentId
@@ -53,14 +71,16 @@ type MonitoredInstances interface {
 	//  err execution error
 	SetEntryStatus(fragmentId string, status entities.FragmentStatus, err error)
 
-	// Modify the status of all the entries with the application id
+	// Modify the status of all the entries with the application id. The status of the resources of a suspended
+	// application is ignored until the application gets a different status.
 	// params:
 	//  appInstanceId
 	//  status
 	//  err execution error
 	SetAppStatus(appInstanceId string, status entities.FragmentStatus, err error)
 
-	// Get the status of an application
+	// Get the status of an application. The fragments being removed are ignored if the application has any other
+	// fragment.
 	// params:
 	//  appInstanceId
 	// return:
@@ -79,6 +99,25 @@ type MonitoredInstances interface {
 	//  false if not found
 	RemovePendingResource(stageID string, serviceInstanceID string, uid string) bool
 
+	// Remove a resource that no longer exists in the platform. The status of its service is computed
+	// again with the remaining resources.
+	// params:
+	//  fragmentId deployment identifier
+	//  serviceInstanceID service the resource belongs to
+	//  uid internal platform identifier
+	// returns:
+	//  false if not found
+	RemoveResource(fragmentId string, serviceInstanceID string, uid string) bool
+
+	// Remove a service that is no longer part of its fragment. The status of the fragment is computed
+	// again with the remaining services.
+	// params:
+	//  fragmentId deployment identifier
+	//  serviceInstanceID service to be removed
+	// returns:
+	//  false if not found
+	RemoveService(fragmentId string, serviceInstanceID string) bool
+
 	// Check if a platform resource is monitored
 	// params:
 	//  uid internal platform identifier
@@ -97,13 +136,24 @@ type MonitoredInstances interface {
 	SetResourceStatus(fragmentId string, serviceInstanceId string, uid string, status entities.NalejServiceStatus, info string,
 		endpoints []entities.EndpointInstance) error
 
-	// This function returns a list of monitored apps with pending notifications and their services with pending notifications.
+	// This function returns a list of monitored apps with pending notifications and only their services with pending
+	// notifications. The returned entries are copies. The entries removed from the cluster are retained until they are explicitly removed.
 	// returns:
 	//  array with the collection of monitored apps with pending notifications
 	GetPendingNotifications() []*entities.MonitoredAppEntry
 
-	// Set to already notified all services.
-	ResetServicesUnnotifiedStatus()
+	// Increase the sequence number of the notifications of a fragment.
+	// params:
+	//  fragmentId identifier of the fragment
+	// return:
+	//  the new sequence number and false if the fragment is not monitored
+	NextNotificationSequence(fragmentId string) (uint64, bool)
+
+	// Set as notified the fragment and the services of a notification acknowledged by conductor. The flags of the
+	// fragment and of every service are kept if their status changed after the notification was built.
+	// params:
+	//  notified entry returned by GetPendingNotifications that has been delivered
+	ResetNotifiedStatus(notified *entities.MonitoredAppEntry)
 
 	// Check the status of the services and set the app status and update entries accordingly.
 	//  params:
@@ -117,6 +167,13 @@ type MonitoredInstances interface {
 	//  true if the app was deleted
 	RemoveEntry(fragmentId string) bool
 
+	// Remove an entry only if it is still terminated, so a fragment deployed again after being checked is kept.
+	// params:
+	//  fragmentId fragment to be removed
+	// return:
+	//  true if the entry was terminated and it was deleted
+	RemoveTerminatedEntry(fragmentId string) bool
+
 	// Return the number of monitored fragments
 	// return:
 	//  number of monitored fragments
@@ -136,4 +193,12 @@ type MonitoredInstances interface {
 	// return:
 	//  number of monitored resources
 	GetNumResources() int
+
+	// Watch the status transitions of fragments, services and resources.
+	// params:
+	//  filter of the transitions to receive
+	//  resumeToken token of the last received transition, empty to receive only new transitions
+	// return:
+	//  subscription to the transitions or error if the token cannot be resumed
+	WatchStatus(filter entities.StatusEventFilter, resumeToken string) (*StatusSubscription, derrors.Error)
 }
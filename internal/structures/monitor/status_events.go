@@ -0,0 +1,174 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package monitor
+
+import (
+	"fmt"
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/derrors"
+	"sync"
+	"time"
+)
+
+const (
+	// Number of past events kept to resume watchers
+	StatusEventsHistorySize = 1024
+	// Number of events a watcher can have pending before being disconnected
+	StatusEventsWatcherBufferSize = 256
+)
+
+// Subscription to status events.
+type StatusSubscription struct {
+	// Channel receiving the events. It is closed when the subscription finishes.
+	Events <-chan *entities.StatusEvent
+	// writable side of the events channel
+	events chan *entities.StatusEvent
+	// filter of the events to receive
+	filter entities.StatusEventFilter
+	// the watcher was disconnected because it did not keep up with the events
+	overflow bool
+	// broadcaster the subscription belongs to
+	broadcaster *StatusEventsBroadcaster
+}
+
+// Finish the subscription. It is safe to call it more than once.
+func (s *StatusSubscription) Close() {
+	s.broadcaster.unsubscribe(s)
+}
+
+// Overflowed returns true if the subscription was finished because the watcher was too slow. The watcher may
+// subscribe again resuming from the last received event.
+func (s *StatusSubscription) Overflowed() bool {
+	s.broadcaster.mu.Lock()
+	defer s.broadcaster.mu.Unlock()
+	return s.overflow
+}
+
+// StatusEventsBroadcaster assigns a sequence number to every status transition and fans it out to the subscribed
+// watchers. The latest events are kept so watchers can resume from a previous event without losing transitions.
+// Publishing never blocks, watchers that do not keep up are disconnected.
+type StatusEventsBroadcaster struct {
+	// epoch of this broadcaster, sequence numbers restart with every instance
+	epoch int64
+	// sequence number of the last published event
+	lastSeq uint64
+	// latest events in publication order
+	history []*entities.StatusEvent
+	// active subscriptions
+	subscriptions map[*StatusSubscription]bool
+	// Mutex
+	mu sync.Mutex
+}
+
+func NewStatusEventsBroadcaster() *StatusEventsBroadcaster {
+	return &StatusEventsBroadcaster{
+		epoch:         time.Now().UnixNano(),
+		history:       make([]*entities.StatusEvent, 0, StatusEventsHistorySize),
+		subscriptions: make(map[*StatusSubscription]bool, 0),
+	}
+}
+
+// Publish a new event. The sequence number, epoch and timestamp are set by the broadcaster.
+//  params:
+//   event to be published
+func (b *StatusEventsBroadcaster) Publish(event *entities.StatusEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastSeq++
+	event.Epoch = b.epoch
+	event.Sequence = b.lastSeq
+	event.Timestamp = time.Now()
+
+	if len(b.history) == StatusEventsHistorySize {
+		b.history = append(b.history[:0], b.history[1:]...)
+	}
+	b.history = append(b.history, event)
+
+	for s := range b.subscriptions {
+		if !s.filter.Matches(event) {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+			s.overflow = true
+			b.remove(s)
+		}
+	}
+}
+
+// Subscribe to the events passing a filter.
+//  params:
+//   filter of the events to receive
+//   resumeToken token of the last received event, empty to receive only new events
+//  returns:
+//   the subscription or error if the token cannot be resumed
+func (b *StatusEventsBroadcaster) Subscribe(filter entities.StatusEventFilter, resumeToken string) (*StatusSubscription, derrors.Error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	replay := make([]*entities.StatusEvent, 0)
+	if resumeToken != "" {
+		var epoch int64
+		var seq uint64
+		if _, err := fmt.Sscanf(resumeToken, "%d-%d", &epoch, &seq); err != nil {
+			return nil, derrors.NewInvalidArgumentError("invalid resume token").WithParams(resumeToken)
+		}
+		if epoch != b.epoch {
+			return nil, derrors.NewFailedPreconditionError("resume token belongs to a previous execution").WithParams(resumeToken)
+		}
+		if seq > b.lastSeq {
+			return nil, derrors.NewInvalidArgumentError("resume token not issued yet").WithParams(resumeToken)
+		}
+		if len(b.history) > 0 && seq+1 < b.history[0].Sequence {
+			return nil, derrors.NewFailedPreconditionError("resume token expired").WithParams(resumeToken)
+		}
+		for _, event := range b.history {
+			if event.Sequence > seq && filter.Matches(event) {
+				replay = append(replay, event)
+			}
+		}
+	}
+
+	events := make(chan *entities.StatusEvent, StatusEventsWatcherBufferSize+len(replay))
+	for _, event := range replay {
+		events <- event
+	}
+	toReturn := &StatusSubscription{
+		Events:      events,
+		events:      events,
+		filter:      filter,
+		broadcaster: b,
+	}
+	b.subscriptions[toReturn] = true
+	return toReturn, nil
+}
+
+func (b *StatusEventsBroadcaster) unsubscribe(s *StatusSubscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(s)
+}
+
+// Remove a subscription closing its channel. This function must be called with the lock held.
+func (b *StatusEventsBroadcaster) remove(s *StatusSubscription) {
+	if _, found := b.subscriptions[s]; found {
+		delete(b.subscriptions, s)
+		close(s.events)
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package monitor
+
+import (
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+func testMonitoredEntry(fragmentId string) *entities.MonitoredAppEntry {
+	return &entities.MonitoredAppEntry{
+		OrganizationId: "org",
+		AppInstanceId:  "app",
+		FragmentId:     fragmentId,
+		Status:         entities.FRAGMENT_WAITING,
+		Services: map[string]*entities.MonitoredServiceEntry{
+			"service": {
+				OrganizationId:    "org",
+				AppInstanceId:     "app",
+				FragmentId:        fragmentId,
+				ServiceInstanceID: "service",
+				Status:            entities.NALEJ_SERVICE_SCHEDULED,
+				Resources:         make(map[string]*entities.MonitoredPlatformResource, 0),
+			},
+		},
+	}
+}
+
+var _ = describeMonitoredInstances("status events", func(newMonitored func() MonitoredInstances) {
+
+	var monitored MonitoredInstances
+
+	ginkgo.BeforeEach(func() {
+		monitored = newMonitored()
+		monitored.AddEntry(testMonitoredEntry("fragment-1"))
+		monitored.AddEntry(testMonitoredEntry("fragment-2"))
+	})
+
+	ginkgo.It("should publish fragment and service transitions", func() {
+		sub, err := monitored.WatchStatus(entities.StatusEventFilter{OrganizationId: "org"}, "")
+		gomega.Expect(err).To(gomega.BeNil())
+		defer sub.Close()
+
+		monitored.SetEntryStatus("fragment-1", entities.FRAGMENT_DEPLOYING, nil)
+		// same status, no transition
+		monitored.SetEntryStatus("fragment-1", entities.FRAGMENT_DEPLOYING, nil)
+		monitored.SetEntryStatus("fragment-1", entities.FRAGMENT_TERMINATING, nil)
+
+		event := <-sub.Events
+		gomega.Expect(event.Type).To(gomega.Equal(entities.StatusEventType(entities.STATUS_EVENT_FRAGMENT)))
+		gomega.Expect(event.FragmentStatus).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_DEPLOYING)))
+		event = <-sub.Events
+		gomega.Expect(event.FragmentStatus).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_TERMINATING)))
+		event = <-sub.Events
+		gomega.Expect(event.Type).To(gomega.Equal(entities.StatusEventType(entities.STATUS_EVENT_SERVICE)))
+		gomega.Expect(event.ServiceInstanceId).To(gomega.Equal("service"))
+		gomega.Expect(event.ServiceStatus).To(gomega.Equal(entities.NalejServiceStatus(entities.NALEJ_SERVICE_TERMINATING)))
+		gomega.Expect(sub.Events).ShouldNot(gomega.Receive())
+	})
+
+	ginkgo.It("should filter by fragment", func() {
+		sub, err := monitored.WatchStatus(entities.StatusEventFilter{OrganizationId: "org", FragmentId: "fragment-2"}, "")
+		gomega.Expect(err).To(gomega.BeNil())
+		defer sub.Close()
+
+		monitored.SetEntryStatus("fragment-1", entities.FRAGMENT_DEPLOYING, nil)
+		monitored.SetEntryStatus("fragment-2", entities.FRAGMENT_DONE, nil)
+
+		event := <-sub.Events
+		gomega.Expect(event.FragmentId).To(gomega.Equal("fragment-2"))
+		gomega.Expect(sub.Events).ShouldNot(gomega.Receive())
+	})
+
+	ginkgo.It("should resume after the last received event", func() {
+		sub, err := monitored.WatchStatus(entities.StatusEventFilter{OrganizationId: "org"}, "")
+		gomega.Expect(err).To(gomega.BeNil())
+		monitored.SetEntryStatus("fragment-1", entities.FRAGMENT_DEPLOYING, nil)
+		received := <-sub.Events
+		sub.Close()
+		_, open := <-sub.Events
+		gomega.Expect(open).To(gomega.BeFalse())
+
+		monitored.SetEntryStatus("fragment-2", entities.FRAGMENT_DEPLOYING, nil)
+
+		resumed, err := monitored.WatchStatus(entities.StatusEventFilter{OrganizationId: "org"}, received.ResumeToken())
+		gomega.Expect(err).To(gomega.BeNil())
+		defer resumed.Close()
+		event := <-resumed.Events
+		gomega.Expect(event.FragmentId).To(gomega.Equal("fragment-2"))
+		gomega.Expect(event.Sequence).To(gomega.Equal(received.Sequence + 1))
+	})
+
+	ginkgo.It("should reject tokens from other executions", func() {
+		_, err := monitored.WatchStatus(entities.StatusEventFilter{OrganizationId: "org"}, "1-1")
+		gomega.Expect(err).NotTo(gomega.BeNil())
+		_, err = monitored.WatchStatus(entities.StatusEventFilter{OrganizationId: "org"}, "invalid")
+		gomega.Expect(err).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("should disconnect slow watchers", func() {
+		broadcaster := NewStatusEventsBroadcaster()
+		sub, err := broadcaster.Subscribe(entities.StatusEventFilter{}, "")
+		gomega.Expect(err).To(gomega.BeNil())
+		for i := 0; i <= StatusEventsWatcherBufferSize; i++ {
+			broadcaster.Publish(&entities.StatusEvent{FragmentId: "fragment"})
+		}
+		gomega.Expect(sub.Overflowed()).To(gomega.BeTrue())
+		sub.Close()
+	})
+})
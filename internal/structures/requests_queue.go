@@ -22,6 +22,21 @@ import (
 	"sync"
 )
 
+// Operation requested by a queued deployment request
+type RequestOperation int
+
+const (
+	// Deploy a new fragment
+	DeployOperation RequestOperation = iota
+	// Update in place a running fragment
+	UpdateOperation
+)
+
+var RequestOperationToString = map[RequestOperation]string{
+	DeployOperation: "deploy",
+	UpdateOperation: "update",
+}
+
 // Interface for a queue storing deployment requests
 type RequestsQueue interface {
 
@@ -30,6 +45,13 @@ type RequestsQueue interface {
 	//   next deployment request, nil if nothing is ready
 	NextRequest() *pbDeploymentManager.DeploymentFragmentRequest
 
+	// Obtain the first deployment request matching a condition. The requests before it keep their position.
+	//  params:
+	//   match function returning true for the request to be obtained given its operation
+	//  returns:
+	//   first matching request, nil if none matches, and its operation
+	NextMatchingRequest(match func(req *pbDeploymentManager.DeploymentFragmentRequest, operation RequestOperation) bool) (*pbDeploymentManager.DeploymentFragmentRequest, RequestOperation)
+
 	// Check if there are more available requests.
 	AvailableRequests() bool
 
@@ -40,11 +62,49 @@ type RequestsQueue interface {
 	//   error if any
 	PushRequest(req *pbDeploymentManager.DeploymentFragmentRequest) error
 
+	// Push a request for a given operation into the queue.
+	//  params:
+	//   req the requirement to be pushed into.
+	//   operation to be done with the request
+	//  returns:
+	//   error if any
+	PushOperation(req *pbDeploymentManager.DeploymentFragmentRequest, operation RequestOperation) error
+
+	// Remove the first request matching a condition from the queue.
+	//  params:
+	//   match function returning true for the request to be removed
+	//  returns:
+	//   the removed request, nil if not found, and its operation
+	RemoveRequest(match func(req *pbDeploymentManager.DeploymentFragmentRequest) bool) (*pbDeploymentManager.DeploymentFragmentRequest, RequestOperation)
+
 	// Clear the queue
 	Clear()
 
 	// queue length
 	Len() int
+
+	// Mark a request as in-flight. In-flight requests are replayed on startup by durable queues
+	// unless they are marked as done.
+	//  params:
+	//   requestId identifier of the request being processed
+	//  returns:
+	//   error if any
+	MarkInFlight(requestId string) error
+
+	// Mark a request as done so it is not replayed anymore.
+	//  params:
+	//   requestId identifier of the processed request
+	//  returns:
+	//   error if any
+	MarkDone(requestId string) error
+}
+
+// Entry of the memory queue
+type memoryQueueEntry struct {
+	// queued request
+	request *pbDeploymentManager.DeploymentFragmentRequest
+	// operation requested
+	operation RequestOperation
 }
 
 // Basic queue in memory solution.
@@ -65,10 +125,22 @@ func NewMemoryRequestQueue() RequestsQueue {
 func (q *MemoryRequestQueue) NextRequest() *pbDeploymentManager.DeploymentFragmentRequest {
 	q.mux.Lock()
 	defer q.mux.Unlock()
-	toReturn := q.queue.PopFront().(*pbDeploymentManager.DeploymentFragmentRequest)
+	if q.queue.Len() == 0 {
+		return nil
+	}
+	toReturn := q.queue.PopFront().(memoryQueueEntry).request
 	return toReturn
 }
 
+// Thread-safe method to access the first queued request matching a condition.
+func (q *MemoryRequestQueue) NextMatchingRequest(match func(req *pbDeploymentManager.DeploymentFragmentRequest, operation RequestOperation) bool) (*pbDeploymentManager.DeploymentFragmentRequest, RequestOperation) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return q.removeEntry(func(entry memoryQueueEntry) bool {
+		return match(entry.request, entry.operation)
+	})
+}
+
 // Thread-safe function to find whether there are more requests available or not.
 func (q *MemoryRequestQueue) AvailableRequests() bool {
 	q.mux.RLock()
@@ -81,12 +153,46 @@ func (q *MemoryRequestQueue) AvailableRequests() bool {
 //  params:
 //   req entry to be enqueued
 func (q *MemoryRequestQueue) PushRequest(req *pbDeploymentManager.DeploymentFragmentRequest) error {
+	return q.PushOperation(req, DeployOperation)
+}
+
+// Push a new request for an operation to the queue for later processing.
+//  params:
+//   req entry to be enqueued
+//   operation to be done with the request
+func (q *MemoryRequestQueue) PushOperation(req *pbDeploymentManager.DeploymentFragmentRequest, operation RequestOperation) error {
 	q.mux.Lock()
 	defer q.mux.Unlock()
-	q.queue.PushBack(req)
+	q.queue.PushBack(memoryQueueEntry{request: req, operation: operation})
 	return nil
 }
 
+// Remove the first matching request from the queue keeping the order of the rest.
+func (q *MemoryRequestQueue) RemoveRequest(match func(req *pbDeploymentManager.DeploymentFragmentRequest) bool) (*pbDeploymentManager.DeploymentFragmentRequest, RequestOperation) {
+	q.mux.Lock()
+	defer q.mux.Unlock()
+	return q.removeEntry(func(entry memoryQueueEntry) bool {
+		return match(entry.request)
+	})
+}
+
+// Remove the first matching entry keeping the order of the rest. This function must be called with the lock held.
+func (q *MemoryRequestQueue) removeEntry(match func(entry memoryQueueEntry) bool) (*pbDeploymentManager.DeploymentFragmentRequest, RequestOperation) {
+	var removed *memoryQueueEntry
+	for i := q.queue.Len(); i > 0; i-- {
+		entry := q.queue.PopFront().(memoryQueueEntry)
+		if removed == nil && match(entry) {
+			removed = &entry
+			continue
+		}
+		q.queue.PushBack(entry)
+	}
+	if removed == nil {
+		return nil, DeployOperation
+	}
+	return removed.request, removed.operation
+}
+
 func (q *MemoryRequestQueue) Clear() {
 	q.mux.Lock()
 	defer q.mux.Unlock()
@@ -98,3 +204,13 @@ func (q *MemoryRequestQueue) Len() int {
 	defer q.mux.Unlock()
 	return q.queue.Len()
 }
+
+// The memory queue loses its content on restart, nothing to track.
+func (q *MemoryRequestQueue) MarkInFlight(requestId string) error {
+	return nil
+}
+
+// The memory queue loses its content on restart, nothing to track.
+func (q *MemoryRequestQueue) MarkDone(requestId string) error {
+	return nil
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package structures
+
+import (
+	pbDeploymentManager "github.com/nalej/grpc-deployment-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("memory requests queue", func() {
+
+	ginkgo.It("should return nil when empty", func() {
+		q := NewMemoryRequestQueue()
+		gomega.Expect(q.NextRequest()).To(gomega.BeNil())
+		gomega.Expect(q.PushRequest(testRequest(0))).To(gomega.Succeed())
+		gomega.Expect(q.NextRequest().RequestId).To(gomega.Equal(testRequest(0).RequestId))
+		gomega.Expect(q.NextRequest()).To(gomega.BeNil())
+	})
+
+	ginkgo.It("should skip the requests not matching keeping their order", func() {
+		q := NewMemoryRequestQueue()
+		for i := 0; i < 3; i++ {
+			gomega.Expect(q.PushRequest(testRequest(i))).To(gomega.Succeed())
+		}
+		next, _ := q.NextMatchingRequest(func(req *pbDeploymentManager.DeploymentFragmentRequest, operation RequestOperation) bool {
+			return req.Fragment.FragmentId == "fragment-2"
+		})
+		gomega.Expect(next.RequestId).To(gomega.Equal(testRequest(2).RequestId))
+		gomega.Expect(q.NextRequest().RequestId).To(gomega.Equal(testRequest(0).RequestId))
+		gomega.Expect(q.NextRequest().RequestId).To(gomega.Equal(testRequest(1).RequestId))
+	})
+
+	ginkgo.It("should keep the operation of the requests", func() {
+		q := NewMemoryRequestQueue()
+		gomega.Expect(q.PushRequest(testRequest(0))).To(gomega.Succeed())
+		gomega.Expect(q.PushOperation(testRequest(1), UpdateOperation)).To(gomega.Succeed())
+		next, operation := q.NextMatchingRequest(func(req *pbDeploymentManager.DeploymentFragmentRequest, operation RequestOperation) bool {
+			return operation == UpdateOperation
+		})
+		gomega.Expect(next.RequestId).To(gomega.Equal(testRequest(1).RequestId))
+		gomega.Expect(operation).To(gomega.Equal(UpdateOperation))
+		removed, operation := q.RemoveRequest(func(req *pbDeploymentManager.DeploymentFragmentRequest) bool {
+			return true
+		})
+		gomega.Expect(removed.RequestId).To(gomega.Equal(testRequest(0).RequestId))
+		gomega.Expect(operation).To(gomega.Equal(DeployOperation))
+	})
+})
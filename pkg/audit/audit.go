@@ -0,0 +1,261 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Audit trail of the mutating operations requested to the deployment manager.
+
+package audit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	pbDeploymentMgr "github.com/nalej/grpc-deployment-manager-go"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// Outcome of an operation that finished successfully
+	OutcomeSuccess = "success"
+	// Outcome of an operation that returned an error
+	OutcomeFailure = "failure"
+	// Maximum length of the summary of a request
+	MaxSummaryLength = 512
+)
+
+// Metadata keys with the identity of the caller. When the authentication is enabled the identity is the one
+// verified by the authorization layer, the calls it rejects are recorded with the identity sent by the caller.
+var CallerMetadataKeys = []string{"user_id", "organization_id", "role", "user-agent"}
+
+// Methods of the mutating operations that are audited by default
+var DefaultAuditedMethods = []string{
+	"/deployment_manager.DeploymentManager/Execute",
+	"/deployment_manager.DeploymentManager/Undeploy",
+	"/deployment_manager.DeploymentManager/UndeployFragment",
+	"/deployment_manager.DeploymentManager/CancelDeployment",
+	"/deployment_manager.DeploymentManager/UpdateFragment",
+	"/deployment_manager.DeploymentManager/PromoteRollout",
+	"/deployment_manager.DeploymentManager/AbortRollout",
+	"/deployment_manager.DeploymentManager/ScaleService",
+	"/deployment_manager.DeploymentManager/RestartService",
+	"/deployment_manager.DeploymentManager/SuspendApplication",
+	"/deployment_manager.DeploymentManager/ResumeApplication",
+	"/deployment_manager.DeploymentManagerNetwork/SetServiceRoute",
+	"/deployment_manager.DeploymentManagerNetwork/JoinZTNetwork",
+	"/deployment_manager.DeploymentManagerNetwork/LeaveZTNetwork",
+	"/deployment_manager.DeploymentManagerNetwork/AuthorizeZTConnection",
+	"/deployment_manager.OfflinePolicy/RemoveAll",
+}
+
+// Identity of the caller of an operation
+type Caller struct {
+	// Address of the peer
+	Address string `json:"address,omitempty"`
+	// Values of the caller metadata keys found in the request
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Entry of the audit log
+type Entry struct {
+	// Time the operation was received
+	Timestamp time.Time `json:"timestamp"`
+	// Full name of the gRPC method
+	Method string `json:"method"`
+	// Identity of the caller
+	Caller Caller `json:"caller"`
+	// Summary of the request
+	Request string `json:"request,omitempty"`
+	// Outcome of the operation
+	Outcome string `json:"outcome"`
+	// gRPC status code returned
+	Code string `json:"code"`
+	// Error message if the operation failed
+	Error string `json:"error,omitempty"`
+	// Duration of the operation in milliseconds
+	DurationMs int64 `json:"duration_ms"`
+}
+
+// A Sink stores the audit entries.
+type Sink interface {
+	// Store an entry.
+	//  params:
+	//   entry to be stored
+	//  return:
+	//   error if the entry cannot be stored
+	Write(entry *Entry) error
+	// Release the resources of the sink.
+	Close() error
+}
+
+// Auditor records the audited operations in a set of sinks.
+type Auditor struct {
+	// methods to be audited
+	methods map[string]bool
+	// sinks where the entries are written
+	sinks []Sink
+	// Mutex to write the entries in order
+	mu sync.Mutex
+}
+
+// Create a new auditor.
+//  params:
+//   methods full names of the gRPC methods to be audited
+//   sinks where the entries are written
+//  return:
+//   auditor
+func NewAuditor(methods []string, sinks ...Sink) *Auditor {
+	audited := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		audited[method] = true
+	}
+	return &Auditor{methods: audited, sinks: sinks}
+}
+
+// Add a new sink to the auditor.
+func (a *Auditor) AddSink(sink Sink) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.sinks = append(a.sinks, sink)
+}
+
+// Write an entry in every sink. Errors are logged, a failing sink does not stop the operation being audited.
+func (a *Auditor) Record(entry *Entry) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, sink := range a.sinks {
+		if err := sink.Write(entry); err != nil {
+			log.Error().Err(err).Str("method", entry.Method).Msg("cannot write audit entry")
+		}
+	}
+}
+
+// Close all the sinks.
+func (a *Auditor) Close() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, sink := range a.sinks {
+		if err := sink.Close(); err != nil {
+			log.Error().Err(err).Msg("cannot close audit sink")
+		}
+	}
+}
+
+// Key of the audited call in the context of the call
+type auditedCallKey struct{}
+
+// Call being audited
+type auditedCall struct {
+	// context of the call with the identity of the caller
+	ctx context.Context
+}
+
+// Interceptor recording the audited unary calls once they finish. It goes before the authorization layer so the
+// rejected calls are recorded too.
+func (a *Auditor) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if !a.methods[info.FullMethod] {
+			return handler(ctx, req)
+		}
+		start := time.Now()
+		call := &auditedCall{ctx: ctx}
+		resp, err := handler(context.WithValue(ctx, auditedCallKey{}, call), req)
+		a.Record(NewEntry(call.ctx, info.FullMethod, req, start, err))
+		return resp, err
+	}
+}
+
+// Interceptor going after the authorization layer so the audited calls it accepts are recorded with the identity
+// verified by it.
+func (a *Auditor) IdentityServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if call, ok := ctx.Value(auditedCallKey{}).(*auditedCall); ok {
+			call.ctx = ctx
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Build the audit entry of a finished call.
+//  params:
+//   ctx context of the call
+//   method full name of the gRPC method
+//   req request of the call
+//   start time the call was received
+//   err error returned by the call
+//  return:
+//   audit entry
+func NewEntry(ctx context.Context, method string, req interface{}, start time.Time, err error) *Entry {
+	entry := &Entry{
+		Timestamp:  start.UTC(),
+		Method:     method,
+		Caller:     callerFromContext(ctx),
+		Request:    Summarize(req),
+		Outcome:    OutcomeSuccess,
+		Code:       status.Code(err).String(),
+		DurationMs: time.Since(start).Nanoseconds() / int64(time.Millisecond),
+	}
+	if err != nil {
+		entry.Outcome = OutcomeFailure
+		entry.Error = status.Convert(err).Message()
+	}
+	return entry
+}
+
+// Get the identity of the caller from the peer and the incoming metadata.
+func callerFromContext(ctx context.Context) Caller {
+	caller := Caller{}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		caller.Address = p.Addr.String()
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for _, key := range CallerMetadataKeys {
+			if values := md.Get(key); len(values) > 0 {
+				if caller.Metadata == nil {
+					caller.Metadata = make(map[string]string, 0)
+				}
+				caller.Metadata[key] = strings.Join(values, ",")
+			}
+		}
+	}
+	return caller
+}
+
+// Summarize a request. Deployment fragments are reduced to their identifiers as they may contain the content of
+// configuration files, any other request is truncated to the maximum summary length.
+func Summarize(req interface{}) string {
+	var summary string
+	switch r := req.(type) {
+	case *pbDeploymentMgr.DeploymentFragmentRequest:
+		summary = fmt.Sprintf("request_id:%q organization_id:%q app_instance_id:%q fragment_id:%q",
+			r.RequestId, r.GetFragment().GetOrganizationId(), r.GetFragment().GetAppInstanceId(),
+			r.GetFragment().GetFragmentId())
+	case proto.Message:
+		summary = proto.CompactTextString(r)
+	default:
+		summary = fmt.Sprintf("%v", r)
+	}
+	if len(summary) > MaxSummaryLength {
+		summary = summary[:MaxSummaryLength] + "..."
+	}
+	return summary
+}
@@ -0,0 +1,243 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nalej/derrors"
+	pbConductor "github.com/nalej/grpc-conductor-go"
+	pbDeploymentMgr "github.com/nalej/grpc-deployment-manager-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Sink keeping the entries in memory
+type memorySink struct {
+	entries []*Entry
+	closed  bool
+}
+
+func (s *memorySink) Write(entry *Entry) error {
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+var _ = ginkgo.Describe("Audit", func() {
+
+	ginkgo.Context("interceptor", func() {
+		var sink *memorySink
+		var interceptor grpc.UnaryServerInterceptor
+		var ctx context.Context
+
+		ginkgo.BeforeEach(func() {
+			sink = &memorySink{}
+			interceptor = NewAuditor(DefaultAuditedMethods, sink).UnaryServerInterceptor()
+			ctx = peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5200}})
+			ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("user_id", "user", "organization_id", "org"))
+		})
+
+		ginkgo.It("should record the successful audited calls", func() {
+			request := &pbDeploymentMgr.UndeployRequest{OrganizationId: "org", AppInstanceId: "app"}
+			info := &grpc.UnaryServerInfo{FullMethod: "/deployment_manager.DeploymentManager/Undeploy"}
+			_, err := interceptor(ctx, request, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return "done", nil
+			})
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(sink.entries).To(gomega.HaveLen(1))
+			entry := sink.entries[0]
+			gomega.Expect(entry.Method).To(gomega.Equal(info.FullMethod))
+			gomega.Expect(entry.Outcome).To(gomega.Equal(OutcomeSuccess))
+			gomega.Expect(entry.Code).To(gomega.Equal("OK"))
+			gomega.Expect(entry.Caller.Address).To(gomega.Equal("10.0.0.1:5200"))
+			gomega.Expect(entry.Caller.Metadata).To(gomega.Equal(map[string]string{"user_id": "user", "organization_id": "org"}))
+			gomega.Expect(entry.Request).To(gomega.ContainSubstring("app"))
+		})
+
+		ginkgo.It("should record the failed audited calls", func() {
+			info := &grpc.UnaryServerInfo{FullMethod: "/deployment_manager.OfflinePolicy/RemoveAll"}
+			_, err := interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, conversions.ToGRPCError(derrors.NewPermissionDeniedError("not allowed"))
+			})
+			gomega.Expect(err).NotTo(gomega.Succeed())
+			gomega.Expect(sink.entries).To(gomega.HaveLen(1))
+			gomega.Expect(sink.entries[0].Outcome).To(gomega.Equal(OutcomeFailure))
+			gomega.Expect(sink.entries[0].Code).To(gomega.Equal("PermissionDenied"))
+			gomega.Expect(sink.entries[0].Error).To(gomega.ContainSubstring("not allowed"))
+		})
+
+		ginkgo.It("should record the calls rejected by the interceptors after it", func() {
+			info := &grpc.UnaryServerInfo{FullMethod: "/deployment_manager.OfflinePolicy/RemoveAll"}
+			_, err := interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				// an authorization layer rejecting the call
+				return nil, conversions.ToGRPCError(derrors.NewUnauthenticatedError("no token found in the request"))
+			})
+			gomega.Expect(err).NotTo(gomega.Succeed())
+			gomega.Expect(sink.entries).To(gomega.HaveLen(1))
+			gomega.Expect(sink.entries[0].Code).To(gomega.Equal("Unauthenticated"))
+			gomega.Expect(sink.entries[0].Caller.Metadata).To(gomega.HaveKeyWithValue("user_id", "user"))
+		})
+
+		ginkgo.It("should record the identity verified by the authorization layer", func() {
+			auditor := NewAuditor(DefaultAuditedMethods, sink)
+			identity := auditor.IdentityServerInterceptor()
+			info := &grpc.UnaryServerInfo{FullMethod: "/deployment_manager.DeploymentManager/Undeploy"}
+			_, err := auditor.UnaryServerInterceptor()(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				// an authorization layer replacing the identity sent by the caller
+				verified := metadata.NewIncomingContext(ctx, metadata.Pairs("user_id", "verified", "role", "admin"))
+				return identity(verified, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return "done", nil
+				})
+			})
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(sink.entries).To(gomega.HaveLen(1))
+			gomega.Expect(sink.entries[0].Caller.Metadata).To(gomega.Equal(map[string]string{"user_id": "verified", "role": "admin"}))
+			gomega.Expect(sink.entries[0].Caller.Address).To(gomega.Equal("10.0.0.1:5200"))
+		})
+
+		ginkgo.It("should not record the calls that are not audited", func() {
+			info := &grpc.UnaryServerInfo{FullMethod: "/deployment_manager.DeploymentManager/RenderFragment"}
+			_, err := interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, nil
+			})
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(sink.entries).To(gomega.BeEmpty())
+		})
+	})
+
+	ginkgo.Context("summaries", func() {
+		ginkgo.It("should reduce deployment fragments to their identifiers", func() {
+			request := &pbDeploymentMgr.DeploymentFragmentRequest{
+				RequestId: "request",
+				Fragment: &pbConductor.DeploymentFragment{OrganizationId: "org", AppInstanceId: "app", FragmentId: "fragment",
+					Stages: []*pbConductor.DeploymentStage{{StageId: "stage"}}},
+			}
+			summary := Summarize(request)
+			gomega.Expect(summary).To(gomega.ContainSubstring("fragment"))
+			gomega.Expect(summary).NotTo(gomega.ContainSubstring("stage"))
+		})
+
+		ginkgo.It("should truncate long requests", func() {
+			request := &pbDeploymentMgr.UndeployRequest{OrganizationId: strings.Repeat("o", 2*MaxSummaryLength)}
+			gomega.Expect(len(Summarize(request))).To(gomega.Equal(MaxSummaryLength + len("...")))
+		})
+	})
+
+	ginkgo.Context("file sink", func() {
+		var dir string
+
+		ginkgo.BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "audit")
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+
+		ginkgo.AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		ginkgo.It("should append JSON lines", func() {
+			path := filepath.Join(dir, "logs", "audit.log")
+			sink, err := NewFileSink(path, 0, 0)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(sink.Write(&Entry{Timestamp: time.Now(), Method: "first", Outcome: OutcomeSuccess})).To(gomega.Succeed())
+			gomega.Expect(sink.Write(&Entry{Timestamp: time.Now(), Method: "second", Outcome: OutcomeSuccess})).To(gomega.Succeed())
+			gomega.Expect(sink.Close()).To(gomega.Succeed())
+
+			content, rErr := ioutil.ReadFile(path)
+			gomega.Expect(rErr).To(gomega.Succeed())
+			lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+			gomega.Expect(lines).To(gomega.HaveLen(2))
+			var entry Entry
+			gomega.Expect(json.Unmarshal([]byte(lines[1]), &entry)).To(gomega.Succeed())
+			gomega.Expect(entry.Method).To(gomega.Equal("second"))
+		})
+
+		ginkgo.It("should rotate the file keeping the maximum number of backups", func() {
+			path := filepath.Join(dir, "audit.log")
+			sink, err := NewFileSink(path, 1, 2)
+			gomega.Expect(err).To(gomega.BeNil())
+			for _, method := range []string{"first", "second", "third", "fourth"} {
+				gomega.Expect(sink.Write(&Entry{Method: method})).To(gomega.Succeed())
+			}
+			gomega.Expect(sink.Close()).To(gomega.Succeed())
+
+			for file, method := range map[string]string{path: "fourth", path + ".1": "third", path + ".2": "second"} {
+				content, rErr := ioutil.ReadFile(file)
+				gomega.Expect(rErr).To(gomega.Succeed())
+				gomega.Expect(string(content)).To(gomega.ContainSubstring(method))
+			}
+			_, sErr := os.Stat(path + ".3")
+			gomega.Expect(os.IsNotExist(sErr)).To(gomega.BeTrue())
+		})
+
+		ginkgo.It("should keep writing in the current file if it cannot be rotated", func() {
+			path := filepath.Join(dir, "audit.log")
+			// a directory in the path of the backup makes the rotation fail
+			gomega.Expect(os.MkdirAll(filepath.Join(path+".1", "blocked"), 0750)).To(gomega.Succeed())
+			sink, err := NewFileSink(path, 1, 1)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(sink.Write(&Entry{Method: "first"})).To(gomega.Succeed())
+			gomega.Expect(sink.Write(&Entry{Method: "second"})).To(gomega.Succeed())
+
+			gomega.Expect(os.RemoveAll(path + ".1")).To(gomega.Succeed())
+			gomega.Expect(sink.Write(&Entry{Method: "third"})).To(gomega.Succeed())
+			gomega.Expect(sink.Close()).To(gomega.Succeed())
+
+			content, rErr := ioutil.ReadFile(path + ".1")
+			gomega.Expect(rErr).To(gomega.Succeed())
+			gomega.Expect(string(content)).To(gomega.ContainSubstring("first"))
+			gomega.Expect(string(content)).To(gomega.ContainSubstring("second"))
+			content, rErr = ioutil.ReadFile(path)
+			gomega.Expect(rErr).To(gomega.Succeed())
+			gomega.Expect(string(content)).To(gomega.ContainSubstring("third"))
+		})
+	})
+	ginkgo.Context("stream sink", func() {
+		ginkgo.It("should write JSON lines", func() {
+			buffer := &bytes.Buffer{}
+			sink := NewStreamSink(buffer)
+			gomega.Expect(sink.Write(&Entry{Method: "first", Outcome: OutcomeSuccess})).To(gomega.Succeed())
+			gomega.Expect(sink.Write(&Entry{Method: "second", Outcome: OutcomeFailure})).To(gomega.Succeed())
+			gomega.Expect(sink.Close()).To(gomega.Succeed())
+
+			lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+			gomega.Expect(lines).To(gomega.HaveLen(2))
+			var entry Entry
+			gomega.Expect(json.Unmarshal([]byte(lines[1]), &entry)).To(gomega.Succeed())
+			gomega.Expect(entry.Method).To(gomega.Equal("second"))
+			gomega.Expect(entry.Outcome).To(gomega.Equal(OutcomeFailure))
+		})
+	})
+})
@@ -0,0 +1,133 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+)
+
+// FileSink appends the audit entries to a JSON-lines file. When the file reaches its maximum size it is
+// rotated: the current file is renamed with the .1 suffix, older files are shifted and the oldest is removed.
+type FileSink struct {
+	// path of the current file
+	path string
+	// maximum size in bytes of a file before rotating it
+	maxSize int64
+	// number of rotated files kept
+	maxBackups int
+	// current file
+	file *os.File
+	// size of the current file
+	size int64
+}
+
+// Create a new file sink.
+//  params:
+//   path of the audit file, its directory is created if it does not exist
+//   maxSize maximum size in bytes of a file before rotating it
+//   maxBackups number of rotated files kept
+//  return:
+//   file sink or error if the file cannot be opened
+func NewFileSink(path string, maxSize int64, maxBackups int) (*FileSink, derrors.Error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return nil, derrors.AsError(err, "cannot create audit log directory")
+	}
+	sink := &FileSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := sink.open(); err != nil {
+		return nil, derrors.AsError(err, "cannot open audit log")
+	}
+	return sink, nil
+}
+
+// Open the current file in append mode.
+func (s *FileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	return nil
+}
+
+func (s *FileSink) Write(entry *Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		// the entry is kept in the current file, the rotation is retried with the next entry
+		if err := s.rotate(); err != nil {
+			log.Warn().Err(err).Str("path", s.path).Msg("cannot rotate audit log")
+		}
+	}
+	written, err := s.file.Write(line)
+	s.size = s.size + int64(written)
+	if err != nil {
+		return err
+	}
+	// entries must survive a crash of the process
+	return s.file.Sync()
+}
+
+// Rotate the current file and open a new one. The current file is kept open until the new one is opened so
+// the entries are written to it if the rotation fails.
+func (s *FileSink) rotate() error {
+	if s.maxBackups > 0 {
+		os.Remove(s.backupPath(s.maxBackups))
+		for i := s.maxBackups - 1; i > 0; i-- {
+			if err := os.Rename(s.backupPath(i), s.backupPath(i+1)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+		if err := os.Rename(s.path, s.backupPath(1)); err != nil {
+			return err
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return err
+	}
+	previous := s.file
+	if err := s.open(); err != nil {
+		return err
+	}
+	if err := previous.Close(); err != nil {
+		log.Warn().Err(err).Str("path", s.path).Msg("cannot close rotated audit log")
+	}
+	log.Info().Str("path", s.path).Msg("audit log rotated")
+	return nil
+}
+
+// Path of a rotated file
+func (s *FileSink) backupPath(index int) string {
+	return fmt.Sprintf("%s.%d", s.path, index)
+}
+
+func (s *FileSink) Close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package audit
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// StreamSink writes the audit entries as JSON lines to a stream, such as the standard output collected by the
+// logging stack of the cluster. It does not need any storage in the node running the deployment manager.
+type StreamSink struct {
+	// stream where the entries are written
+	writer io.Writer
+}
+
+// Create a new stream sink.
+//  params:
+//   writer stream where the entries are written, it is not closed by the sink
+//  return:
+//   stream sink
+func NewStreamSink(writer io.Writer) *StreamSink {
+	return &StreamSink{writer: writer}
+}
+
+func (s *StreamSink) Write(entry *Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	// a single write per entry so the collector reads whole lines
+	_, err = s.writer.Write(append(line, '\n'))
+	return err
+}
+
+func (s *StreamSink) Close() error {
+	return nil
+}
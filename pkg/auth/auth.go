@@ -0,0 +1,210 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Authentication and authorization of the calls received by the deployment manager.
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	// Metadata key with the token of the caller
+	AuthorizationHeader = "authorization"
+	// Optional prefix of the token
+	BearerPrefix = "Bearer "
+	// Role with access to the administrative operations
+	AdminRole = "admin"
+	// Metadata keys where the verified identity of the caller is stored
+	UserIdKey         = "user_id"
+	OrganizationIdKey = "organization_id"
+	RoleKey           = "role"
+)
+
+// Claims of the tokens accepted by the deployment manager
+type Claims struct {
+	jwt.StandardClaims
+	// User identifier
+	UserID string `json:"userID,omitempty"`
+	// Organization of the user
+	OrganizationID string `json:"organizationID,omitempty"`
+	// Role of the user
+	RoleName string `json:"roleName,omitempty"`
+}
+
+// Permissions contains the roles allowed to call a method indexed by the full name of the method. Methods not
+// included can be called by any authenticated caller.
+type Permissions map[string][]string
+
+// Permissions applied when no permissions file is set
+var DefaultPermissions = Permissions{
+	"/deployment_manager.OfflinePolicy/RemoveAll": {AdminRole},
+}
+
+// Load the permissions from a JSON file with the roles of every method. The file replaces the default permissions.
+//  params:
+//   path of the file
+//  return:
+//   permissions or error if the file cannot be read
+func LoadPermissions(path string) (Permissions, derrors.Error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, derrors.AsError(err, "cannot read permissions file")
+	}
+	permissions := make(Permissions, 0)
+	if err := json.Unmarshal(content, &permissions); err != nil {
+		return nil, derrors.NewInvalidArgumentError("cannot parse permissions file", err).WithParams(path)
+	}
+	return permissions, nil
+}
+
+// Authorizer validates the token of the calls and checks the role of the caller.
+type Authorizer struct {
+	// key to verify the signature of the tokens
+	secret []byte
+	// roles allowed per method
+	permissions Permissions
+	// methods that do not require a token
+	public map[string]bool
+}
+
+// Create a new authorizer.
+//  params:
+//   secret key used to sign the tokens with HMAC
+//   permissions roles allowed per method
+//   publicMethods full names of the methods that do not require a token
+//  return:
+//   authorizer
+func NewAuthorizer(secret []byte, permissions Permissions, publicMethods ...string) *Authorizer {
+	public := make(map[string]bool, len(publicMethods))
+	for _, method := range publicMethods {
+		public[method] = true
+	}
+	return &Authorizer{secret: secret, permissions: permissions, public: public}
+}
+
+// Authorize a call to a method. The verified identity of the caller replaces any identity sent in the metadata.
+//  params:
+//   ctx context of the call
+//   method full name of the method
+//  return:
+//   context with the identity of the caller or error if the caller is not allowed
+func (a *Authorizer) Authorize(ctx context.Context, method string) (context.Context, derrors.Error) {
+	if a.public[method] {
+		return ctx, nil
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, derrors.NewUnauthenticatedError("no metadata found in the request")
+	}
+	values := md.Get(AuthorizationHeader)
+	if len(values) == 0 || values[0] == "" {
+		return nil, derrors.NewUnauthenticatedError("no token found in the request")
+	}
+	claims, err := a.parseToken(strings.TrimPrefix(values[0], BearerPrefix))
+	if err != nil {
+		return nil, err
+	}
+	if roles, restricted := a.permissions[method]; restricted && !hasRole(roles, claims.RoleName) {
+		return nil, derrors.NewPermissionDeniedError("role not allowed to call the method").
+			WithParams(method, claims.RoleName)
+	}
+
+	identity := md.Copy()
+	identity.Set(UserIdKey, claims.UserID)
+	identity.Set(OrganizationIdKey, claims.OrganizationID)
+	identity.Set(RoleKey, claims.RoleName)
+	return metadata.NewIncomingContext(ctx, identity), nil
+}
+
+// Parse a token verifying its signature and expiration.
+func (a *Authorizer) parseToken(token string) (*Claims, derrors.Error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, derrors.NewUnauthenticatedError("unexpected signing method").WithParams(token.Header["alg"])
+		}
+		return a.secret, nil
+	})
+	if err != nil {
+		return nil, derrors.NewUnauthenticatedError("invalid token", err)
+	}
+	return claims, nil
+}
+
+func hasRole(roles []string, role string) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Log a rejected call.
+func logRejected(ctx context.Context, method string, err derrors.Error) {
+	address := ""
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		address = p.Addr.String()
+	}
+	log.Warn().Str("method", method).Str("peer", address).Str("err", err.DebugReport()).Msg("call rejected")
+}
+
+// Interceptor authorizing the unary calls.
+func (a *Authorizer) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		authorized, err := a.Authorize(ctx, info.FullMethod)
+		if err != nil {
+			logRejected(ctx, info.FullMethod, err)
+			return nil, conversions.ToGRPCError(err)
+		}
+		return handler(authorized, req)
+	}
+}
+
+// Interceptor authorizing the streaming calls.
+func (a *Authorizer) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		authorized, err := a.Authorize(ss.Context(), info.FullMethod)
+		if err != nil {
+			logRejected(ss.Context(), info.FullMethod, err)
+			return conversions.ToGRPCError(err)
+		}
+		return handler(srv, &authorizedStream{ServerStream: ss, ctx: authorized})
+	}
+}
+
+// Server stream with the context of an authorized call
+type authorizedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authorizedStream) Context() context.Context {
+	return s.ctx
+}
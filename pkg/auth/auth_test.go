@@ -0,0 +1,185 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testSecret    = "secret"
+	testMethod    = "/deployment_manager.DeploymentManager/Execute"
+	testAdminOnly = "/deployment_manager.OfflinePolicy/RemoveAll"
+	testPublic    = "/grpc.health.v1.Health/Check"
+)
+
+func testToken(secret string, role string, expiresAt time.Time) string {
+	claims := Claims{
+		StandardClaims: jwt.StandardClaims{ExpiresAt: expiresAt.Unix()},
+		UserID:         "user",
+		OrganizationID: "org",
+		RoleName:       role,
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+	gomega.Expect(err).To(gomega.Succeed())
+	return token
+}
+
+func testContext(pairs ...string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(pairs...))
+}
+
+func expectErrorType(err derrors.Error, errorType derrors.ErrorType) {
+	gomega.Expect(err).NotTo(gomega.BeNil())
+	gomega.Expect(err.Type()).To(gomega.Equal(errorType))
+}
+
+// Stream with only a context
+type testStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testStream) Context() context.Context {
+	return s.ctx
+}
+
+var _ = ginkgo.Describe("Authorizer", func() {
+
+	var authorizer *Authorizer
+
+	ginkgo.BeforeEach(func() {
+		authorizer = NewAuthorizer([]byte(testSecret), DefaultPermissions, testPublic)
+	})
+
+	ginkgo.Context("tokens", func() {
+		ginkgo.It("should accept a valid token and set the identity of the caller", func() {
+			token := testToken(testSecret, "developer", time.Now().Add(time.Hour))
+			ctx, err := authorizer.Authorize(testContext(AuthorizationHeader, BearerPrefix+token, UserIdKey, "spoofed"), testMethod)
+			gomega.Expect(err).To(gomega.BeNil())
+			md, _ := metadata.FromIncomingContext(ctx)
+			gomega.Expect(md.Get(UserIdKey)).To(gomega.Equal([]string{"user"}))
+			gomega.Expect(md.Get(OrganizationIdKey)).To(gomega.Equal([]string{"org"}))
+			gomega.Expect(md.Get(RoleKey)).To(gomega.Equal([]string{"developer"}))
+		})
+
+		ginkgo.It("should accept a token without the bearer prefix", func() {
+			token := testToken(testSecret, "developer", time.Now().Add(time.Hour))
+			_, err := authorizer.Authorize(testContext(AuthorizationHeader, token), testMethod)
+			gomega.Expect(err).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should reject calls without token", func() {
+			_, err := authorizer.Authorize(context.Background(), testMethod)
+			expectErrorType(err, derrors.Unauthenticated)
+			_, err = authorizer.Authorize(testContext("user_id", "user"), testMethod)
+			expectErrorType(err, derrors.Unauthenticated)
+		})
+
+		ginkgo.It("should reject tokens signed with another key", func() {
+			token := testToken("other", "developer", time.Now().Add(time.Hour))
+			_, err := authorizer.Authorize(testContext(AuthorizationHeader, token), testMethod)
+			expectErrorType(err, derrors.Unauthenticated)
+		})
+
+		ginkgo.It("should reject expired tokens", func() {
+			token := testToken(testSecret, "developer", time.Now().Add(-time.Minute))
+			_, err := authorizer.Authorize(testContext(AuthorizationHeader, token), testMethod)
+			expectErrorType(err, derrors.Unauthenticated)
+		})
+
+		ginkgo.It("should reject unsigned tokens", func() {
+			token, sErr := jwt.NewWithClaims(jwt.SigningMethodNone, Claims{RoleName: AdminRole}).
+				SignedString(jwt.UnsafeAllowNoneSignatureType)
+			gomega.Expect(sErr).To(gomega.Succeed())
+			_, err := authorizer.Authorize(testContext(AuthorizationHeader, token), testAdminOnly)
+			expectErrorType(err, derrors.Unauthenticated)
+		})
+
+		ginkgo.It("should not require a token for the public methods", func() {
+			_, err := authorizer.Authorize(context.Background(), testPublic)
+			gomega.Expect(err).To(gomega.BeNil())
+		})
+	})
+
+	ginkgo.Context("permissions", func() {
+		ginkgo.It("should restrict the offline policy to administrators", func() {
+			token := testToken(testSecret, "developer", time.Now().Add(time.Hour))
+			_, err := authorizer.Authorize(testContext(AuthorizationHeader, token), testAdminOnly)
+			expectErrorType(err, derrors.PermissionDenied)
+
+			token = testToken(testSecret, AdminRole, time.Now().Add(time.Hour))
+			_, err = authorizer.Authorize(testContext(AuthorizationHeader, token), testAdminOnly)
+			gomega.Expect(err).To(gomega.BeNil())
+		})
+
+		ginkgo.It("should load the permissions from a file", func() {
+			dir, tErr := ioutil.TempDir("", "auth")
+			gomega.Expect(tErr).To(gomega.Succeed())
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "permissions.json")
+			content := `{"/deployment_manager.DeploymentManager/Execute": ["operator", "admin"]}`
+			gomega.Expect(ioutil.WriteFile(path, []byte(content), 0600)).To(gomega.Succeed())
+
+			permissions, err := LoadPermissions(path)
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(permissions).To(gomega.Equal(Permissions{testMethod: {"operator", AdminRole}}))
+
+			gomega.Expect(ioutil.WriteFile(path, []byte("{"), 0600)).To(gomega.Succeed())
+			_, err = LoadPermissions(path)
+			expectErrorType(err, derrors.InvalidArgument)
+		})
+	})
+
+	ginkgo.Context("interceptors", func() {
+		ginkgo.It("should return the gRPC status of the rejected unary calls", func() {
+			called := false
+			_, err := authorizer.UnaryServerInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: testMethod},
+				func(ctx context.Context, req interface{}) (interface{}, error) {
+					called = true
+					return nil, nil
+				})
+			gomega.Expect(called).To(gomega.BeFalse())
+			gomega.Expect(status.Code(err)).To(gomega.Equal(codes.Unauthenticated))
+		})
+
+		ginkgo.It("should pass the identity of the caller to the streaming handlers", func() {
+			token := testToken(testSecret, "developer", time.Now().Add(time.Hour))
+			stream := &testStream{ctx: testContext(AuthorizationHeader, token)}
+			err := authorizer.StreamServerInterceptor()(nil, stream, &grpc.StreamServerInfo{FullMethod: testMethod},
+				func(srv interface{}, ss grpc.ServerStream) error {
+					md, _ := metadata.FromIncomingContext(ss.Context())
+					gomega.Expect(md.Get(UserIdKey)).To(gomega.Equal([]string{"user"}))
+					return nil
+				})
+			gomega.Expect(err).To(gomega.Succeed())
+		})
+	})
+})
@@ -0,0 +1,62 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/credentials"
+)
+
+// Create the TLS credentials of the gRPC server.
+//  params:
+//   serverCertPath directory with the tls.crt and tls.key files of the server
+//   clientCAPath CA certificate used to verify the client certificates, empty to not request them
+//  return:
+//   transport credentials or error if the certificates cannot be loaded
+func NewServerCredentials(serverCertPath string, clientCAPath string) (credentials.TransportCredentials, derrors.Error) {
+	log.Debug().Str("serverCertPath", serverCertPath).Msg("loading server certificate")
+	serverCert, err := tls.LoadX509KeyPair(fmt.Sprintf("%s/tls.crt", serverCertPath), fmt.Sprintf("%s/tls.key", serverCertPath))
+	if err != nil {
+		return nil, derrors.NewInternalError("Error loading server certificate", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if clientCAPath != "" {
+		log.Debug().Str("clientCAPath", clientCAPath).Msg("loading client CA cert")
+		caCert, err := ioutil.ReadFile(clientCAPath)
+		if err != nil {
+			return nil, derrors.NewInternalError("Error loading client CA certificate", err)
+		}
+		clientCAs := x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(caCert) {
+			return nil, derrors.NewInternalError("cannot add client CA certificate to the pool")
+		}
+		tlsConfig.ClientCAs = clientCAs
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
@@ -25,10 +25,17 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"time"
 )
 
 const EnvClusterId = "CLUSTER_ID"
 
+// Environment variable with the key to verify the tokens of the callers
+const EnvAuthSecret = "AUTH_SECRET"
+
+// Environment variable with the namespace of the pod
+const EnvPodNamespace = "POD_NAMESPACE"
+
 type NetworkType string
 
 const (
@@ -48,6 +55,44 @@ func NetworkTypeFromString(net string) (NetworkType, error) {
 	}
 }
 
+type QueueType string
+
+const (
+	QueueTypeError  = ""
+	QueueTypeMemory = "memory"
+	QueueTypeFile   = "file"
+)
+
+func QueueTypeFromString(queue string) (QueueType, error) {
+	switch queue {
+	case QueueTypeMemory:
+		return QueueTypeMemory, nil
+	case QueueTypeFile:
+		return QueueTypeFile, nil
+	default:
+		return QueueTypeError, derrors.NewInvalidArgumentError("unknown queue type")
+	}
+}
+
+type MonitorStoreType string
+
+const (
+	MonitorStoreTypeError  = ""
+	MonitorStoreTypeMemory = "memory"
+	MonitorStoreTypeFile   = "file"
+)
+
+func MonitorStoreTypeFromString(store string) (MonitorStoreType, error) {
+	switch store {
+	case MonitorStoreTypeMemory:
+		return MonitorStoreTypeMemory, nil
+	case MonitorStoreTypeFile:
+		return MonitorStoreTypeFile, nil
+	default:
+		return MonitorStoreTypeError, derrors.NewInvalidArgumentError("unknown monitor store type")
+	}
+}
+
 // Configuration structure
 type Config struct {
 	// Debug is enabled
@@ -107,6 +152,70 @@ type Config struct {
 	UnifiedLoggingAddress string
 	// Storage Fabric Address (host:port)
 	StorageFabricAddress string
+	// Type of queue storing incoming deployment requests
+	QueueType QueueType
+	// Directory where the file queue stores the requests
+	QueuePath string
+	// Maximum number of requests waiting in the queue, 0 for no limit
+	MaxQueueLength int
+	// Maximum number of fragments deployed concurrently
+	MaxConcurrentFragments int
+	// Maximum number of fragments of the same organization deployed concurrently, 0 for no limit
+	MaxConcurrentFragmentsPerOrg int
+	// Maximum number of attempts to deploy a stage with a limited retry policy
+	RetryMaxAttempts int
+	// Time to wait after the first failed attempt to deploy a stage
+	RetryInitialBackoff time.Duration
+	// Maximum time to wait between attempts to deploy a stage
+	RetryMaxBackoff time.Duration
+	// Fraction of the backoff randomly added or subtracted
+	RetryJitter float64
+	// Time to wait for the resources of a stage before considering the attempt failed
+	StageCheckTimeout time.Duration
+	// Maximum time to wait for the running requests to finish when shutting down
+	ShutdownTimeout time.Duration
+	// Maximum storage in bytes requested by a deployment fragment, 0 for no limit
+	FragmentStorageQuota int64
+	// Path of the audit log file, empty to disable it
+	AuditLogPath string
+	// Maximum size in bytes of the audit log before rotating it
+	AuditLogMaxSize int64
+	// Number of rotated audit log files kept
+	AuditLogMaxBackups int
+	// Write the audit log to the standard output
+	AuditLogStdout bool
+	// Directory with the certificate and key of the gRPC server, empty to serve without TLS
+	ServerCertPath string
+	// Path for the CA certificate used to verify the client certificates, empty to not request them
+	ClientCAPath string
+	// Key used to verify the tokens of the callers, empty to disable the authentication
+	AuthSecret string
+	// Path of the JSON file with the roles allowed per method, empty to use the default permissions
+	AuthPermissionsPath string
+	// Time between checks of the dependencies of the service
+	HealthCheckInterval time.Duration
+	// Maximum time to wait for a dependency check
+	HealthCheckTimeout time.Duration
+	// Elect a leader among the replicas of the deployment manager
+	LeaderElection bool
+	// Namespace of the lease used to elect the leader
+	LeaseNamespace string
+	// Name of the lease used to elect the leader
+	LeaseName string
+	// Time the followers wait before trying to acquire a lease not renewed
+	LeaseDuration time.Duration
+	// Time the leader retries to renew the lease before giving up the leadership
+	LeaseRenewDeadline time.Duration
+	// Time between attempts to acquire or renew the lease
+	LeaseRetryPeriod time.Duration
+	// Time the entries of the fragments removed from the cluster are retained
+	TerminatedRetention time.Duration
+	// Type of store keeping the status of the monitored fragments
+	MonitorStoreType MonitorStoreType
+	// Directory where the file store keeps the monitored fragments
+	MonitorStorePath string
+	// Time between notifications to conductor of every service of the monitored fragments
+	NotificationResyncPeriod time.Duration
 }
 
 func (conf *Config) envOrElse(envName string, paramValue string) string {
@@ -122,6 +231,8 @@ func (conf *Config) envOrElse(envName string, paramValue string) string {
 
 func (conf *Config) Resolve() derrors.Error {
 	conf.ClusterId = conf.envOrElse(EnvClusterId, conf.ClusterId)
+	conf.AuthSecret = conf.envOrElse(EnvAuthSecret, conf.AuthSecret)
+	conf.LeaseNamespace = conf.envOrElse(EnvPodNamespace, conf.LeaseNamespace)
 	return nil
 }
 
@@ -179,6 +290,82 @@ func (conf *Config) Validate() derrors.Error {
 	if conf.NetworkType == NetworkTypeZt &&  conf.ZTNalejImage == "" {
 		return derrors.NewInvalidArgumentError("ZTNalejImage must be set")
 	}
+	if conf.MaxConcurrentFragments <= 0 {
+		return derrors.NewInvalidArgumentError("maxConcurrentFragments must be greater than zero")
+	}
+
+	if conf.MaxConcurrentFragmentsPerOrg < 0 || conf.MaxQueueLength < 0 {
+		return derrors.NewInvalidArgumentError("maxConcurrentFragmentsPerOrg and maxQueueLength cannot be negative")
+	}
+
+	if conf.RetryMaxAttempts <= 0 {
+		return derrors.NewInvalidArgumentError("retryMaxAttempts must be greater than zero")
+	}
+
+	if conf.RetryInitialBackoff <= 0 || conf.RetryMaxBackoff < conf.RetryInitialBackoff {
+		return derrors.NewInvalidArgumentError("retryInitialBackoff must be positive and not greater than retryMaxBackoff")
+	}
+
+	if conf.RetryJitter < 0 || conf.RetryJitter > 1 {
+		return derrors.NewInvalidArgumentError("retryJitter must be between 0 and 1")
+	}
+
+	if conf.StageCheckTimeout < time.Second {
+		return derrors.NewInvalidArgumentError("stageCheckTimeout must be at least one second")
+	}
+
+	if conf.ShutdownTimeout < 0 {
+		return derrors.NewInvalidArgumentError("shutdownTimeout cannot be negative")
+	}
+
+	if conf.FragmentStorageQuota < 0 {
+		return derrors.NewInvalidArgumentError("fragmentStorageQuota cannot be negative")
+	}
+
+	if conf.AuditLogMaxSize < 0 || conf.AuditLogMaxBackups < 0 {
+		return derrors.NewInvalidArgumentError("auditLogMaxSize and auditLogMaxBackups cannot be negative")
+	}
+
+	// client certificates can only be verified on a TLS server
+	if conf.ClientCAPath != "" && conf.ServerCertPath == "" {
+		return derrors.NewInvalidArgumentError("serverCertPath must be set to verify client certificates")
+	}
+
+	if conf.AuthPermissionsPath != "" && conf.AuthSecret == "" {
+		return derrors.NewInvalidArgumentError("authSecret must be set to apply the permissions")
+	}
+
+	if conf.HealthCheckInterval <= 0 || conf.HealthCheckTimeout <= 0 {
+		return derrors.NewInvalidArgumentError("healthCheckInterval and healthCheckTimeout must be positive")
+	}
+
+	if conf.LeaderElection {
+		if conf.LeaseNamespace == "" || conf.LeaseName == "" {
+			return derrors.NewInvalidArgumentError("leaseNamespace and leaseName must be set")
+		}
+		if conf.LeaseRetryPeriod <= 0 || conf.LeaseRenewDeadline <= conf.LeaseRetryPeriod ||
+			conf.LeaseDuration <= conf.LeaseRenewDeadline {
+			return derrors.NewInvalidArgumentError("leaseDuration must be greater than leaseRenewDeadline and leaseRenewDeadline greater than leaseRetryPeriod")
+		}
+	}
+
+	if conf.TerminatedRetention < 0 {
+		return derrors.NewInvalidArgumentError("terminatedRetention cannot be negative")
+	}
+
+	// the file queue needs a directory to store the requests
+	if conf.QueueType == QueueTypeFile && conf.QueuePath == "" {
+		return derrors.NewInvalidArgumentError("queuePath must be set")
+	}
+
+	if conf.MonitorStoreType == MonitorStoreTypeFile && conf.MonitorStorePath == "" {
+		return derrors.NewInvalidArgumentError("monitorStorePath must be set")
+	}
+
+	if conf.NotificationResyncPeriod <= 0 {
+		return derrors.NewInvalidArgumentError("notificationResyncPeriod must be positive")
+	}
+
 	conf.TargetPlatform = grpc_installer_go.Platform(grpc_installer_go.Platform_value[conf.TargetPlatformName])
 
 	return nil
@@ -207,6 +394,37 @@ func (conf *Config) Print() {
 		log.Info().Str("ZTNalejImage", conf.ZTNalejImage).Msg("ZT-Nalej image")
 	}
 	log.Info().Str("unifiedLoggingAddress", conf.UnifiedLoggingAddress).Msg("Unified Logging Slave Address")
+	log.Info().Interface("queueType", conf.QueueType).Msg("Requests queue type")
+	if conf.QueueType == QueueTypeFile {
+		log.Info().Str("queuePath", conf.QueuePath).Msg("Requests queue path")
+	}
+	log.Info().Int("maxQueueLength", conf.MaxQueueLength).Int("maxConcurrentFragments", conf.MaxConcurrentFragments).
+		Int("maxConcurrentFragmentsPerOrg", conf.MaxConcurrentFragmentsPerOrg).Msg("Requests processing limits")
+	log.Info().Int("maxAttempts", conf.RetryMaxAttempts).Str("initialBackoff", conf.RetryInitialBackoff.String()).
+		Str("maxBackoff", conf.RetryMaxBackoff.String()).Float64("jitter", conf.RetryJitter).
+		Str("stageCheckTimeout", conf.StageCheckTimeout.String()).Msg("Stage retry policy")
+	log.Info().Str("shutdownTimeout", conf.ShutdownTimeout.String()).Msg("Graceful shutdown")
+	log.Info().Int64("fragmentStorageQuota", conf.FragmentStorageQuota).Msg("Deployment fragment validation")
+	log.Info().Str("path", conf.AuditLogPath).Int64("maxSize", conf.AuditLogMaxSize).
+		Int("maxBackups", conf.AuditLogMaxBackups).Bool("stdout", conf.AuditLogStdout).Msg("Audit log")
+	log.Info().Str("serverCertPath", conf.ServerCertPath).Str("clientCAPath", conf.ClientCAPath).Msg("gRPC server TLS")
+	log.Info().Bool("enabled", conf.AuthSecret != "").Str("authSecret", strings.Repeat("*", len(conf.AuthSecret))).
+		Str("permissionsPath", conf.AuthPermissionsPath).Msg("gRPC server authentication")
+	log.Info().Str("interval", conf.HealthCheckInterval.String()).Str("timeout", conf.HealthCheckTimeout.String()).
+		Msg("Health checks")
+	log.Info().Bool("enabled", conf.LeaderElection).Str("namespace", conf.LeaseNamespace).Str("name", conf.LeaseName).
+		Str("duration", conf.LeaseDuration.String()).Str("renewDeadline", conf.LeaseRenewDeadline.String()).
+		Str("retryPeriod", conf.LeaseRetryPeriod.String()).Msg("Leader election")
+	if conf.LeaderElection {
+		// the queues are kept by every replica, they are not shared with the next leader
+		log.Warn().Msg("the deployment requests queued in this replica are lost if it loses the leadership")
+	}
+	log.Info().Str("retention", conf.TerminatedRetention.String()).Msg("Terminated fragments")
+	log.Info().Interface("monitorStoreType", conf.MonitorStoreType).Msg("Monitored fragments store type")
+	if conf.MonitorStoreType == MonitorStoreTypeFile {
+		log.Info().Str("monitorStorePath", conf.MonitorStorePath).Msg("Monitored fragments store path")
+	}
+	log.Info().Str("resyncPeriod", conf.NotificationResyncPeriod.String()).Msg("Conductor notifications")
 
 }
 
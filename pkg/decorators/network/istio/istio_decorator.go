@@ -19,6 +19,7 @@ package istio
 
 import (
 	"fmt"
+	"github.com/nalej/deployment-manager/internal/entities"
 	"github.com/nalej/deployment-manager/pkg/common"
 	"github.com/nalej/deployment-manager/pkg/executor"
 	"github.com/nalej/deployment-manager/pkg/kubernetes"
@@ -42,6 +43,9 @@ const (
 	IstioLabelInjection = "istio-injection"
 	InstPrefixLength    = 6
 	OrgPrefixLength     = 8
+	// Subsets of a service being rolled out
+	StableSubset    = "stable"
+	CandidateSubset = "candidate"
 )
 
 type IstioDecorator struct {
@@ -95,15 +99,39 @@ func (id *IstioDecorator) Deploy(aux executor.Deployable, args ...interface{}) d
 	switch target := aux.(type) {
 	case *kubernetes.DeployableServices:
 		return id.decorateServices(target)
+	case *kubernetes.DeployableRollout:
+		return id.splitRolloutTraffic(target)
 	}
 	return nil
 }
 
 // Remove any unnecessary entries when a deployable element is removed.
 func (id *IstioDecorator) Undeploy(aux executor.Deployable, args ...interface{}) derrors.Error {
+	switch target := aux.(type) {
+	case *kubernetes.DeployableRollout:
+		return id.restoreRolloutTraffic(target)
+	}
 	return nil
 }
 
+// Entries the decorator creates when the services are deployed: a service and a virtual service for every
+// public rule not declared by the stage. Rules whose service already exists in the cluster only add the port
+// to the existing entries, so they are rendered as they would be created from scratch.
+func (id *IstioDecorator) Render(aux executor.Deployable, args ...interface{}) ([]interface{}, derrors.Error) {
+	toReturn := make([]interface{}, 0)
+	switch target := aux.(type) {
+	case *kubernetes.DeployableServices:
+		for _, publicRule := range id.undeclaredRules(target) {
+			service := id.newRuleService(target, publicRule)
+			service.TypeMeta = metaV1.TypeMeta{Kind: "Service", APIVersion: "v1"}
+			virtualService := id.newRuleVirtualService(target, publicRule)
+			virtualService.TypeMeta = metaV1.TypeMeta{Kind: "VirtualService", APIVersion: "networking.istio.io/v1alpha3"}
+			toReturn = append(toReturn, service, virtualService)
+		}
+	}
+	return toReturn, nil
+}
+
 // Decorate services by extending the number of available services to include those services
 // that are declared to be accessible but are not deployed onto this cluster.
 // params:
@@ -113,21 +141,7 @@ func (id *IstioDecorator) Undeploy(aux executor.Deployable, args ...interface{})
 func (id *IstioDecorator) decorateServices(target *kubernetes.DeployableServices) derrors.Error {
 
 	// Create a service for every rule allowing internal traffic if it is not declared yet.
-	for _, publicRule := range target.Data.Stage.PublicRules {
-
-		found := false
-		for _, s := range target.Services {
-			// If we already have a service for this public rule skip to the next one
-			if publicRule.ServiceName == s.Service.Name {
-				found = true
-				break
-			}
-		}
-
-		log.Debug().Str("serviceName", publicRule.ServiceName).Msgf("comparing existing services we have found it %t", found)
-		if found {
-			continue
-		}
+	for _, publicRule := range id.undeclaredRules(target) {
 
 		// Try to get the service and if it is there add the port for this service if not available.
 		foundServ, errServ := id.KClient.CoreV1().Services(target.Data.Namespace).
@@ -136,36 +150,7 @@ func (id *IstioDecorator) decorateServices(target *kubernetes.DeployableServices
 			log.Debug().Str("serviceName", common.FormatName(publicRule.ServiceName)).
 				Msg("service does not exists. Create it")
 			// Service not found for this rule, create one
-			newServ := apiv1.Service{
-				ObjectMeta: metaV1.ObjectMeta{
-					Name:      common.FormatName(publicRule.ServiceName),
-					Namespace: target.Data.Namespace,
-					Labels: map[string]string{
-						utils.NALEJ_ANNOTATION_ORGANIZATION_ID: target.Data.OrganizationId,
-						utils.NALEJ_ANNOTATION_APP_DESCRIPTOR:  target.Data.AppDescriptorId,
-						utils.NALEJ_ANNOTATION_APP_INSTANCE_ID: target.Data.AppInstanceId,
-						utils.NALEJ_ANNOTATION_IS_PROXY:        "false",
-					},
-				},
-				Spec: apiv1.ServiceSpec{
-					ExternalName: common.FormatName(publicRule.ServiceName),
-					Ports: []apiv1.ServicePort{
-						{
-							Port: publicRule.TargetPort,
-							// TODO we have to assume that the internal port matches
-							TargetPort: intstr.IntOrString{IntVal: publicRule.TargetPort},
-							Name:       fmt.Sprintf("port%d", publicRule.TargetPort),
-						},
-					},
-					Type: apiv1.ServiceTypeClusterIP,
-					Selector: map[string]string{
-						utils.NALEJ_ANNOTATION_APP_INSTANCE_ID: target.Data.AppInstanceId,
-						utils.NALEJ_ANNOTATION_ORGANIZATION_ID: target.Data.OrganizationId,
-						utils.NALEJ_ANNOTATION_SERVICE_NAME:    common.FormatName(publicRule.ServiceName),
-					},
-				},
-			}
-			_, errCreateServ := id.KClient.CoreV1().Services(target.Data.Namespace).Create(&newServ)
+			_, errCreateServ := id.KClient.CoreV1().Services(target.Data.Namespace).Create(id.newRuleService(target, publicRule))
 			if errCreateServ != nil {
 				log.Error().Err(errCreateServ).Msg("error creating service from Istio decorator")
 			}
@@ -186,34 +171,9 @@ func (id *IstioDecorator) decorateServices(target *kubernetes.DeployableServices
 			VirtualServices(target.Data.Namespace).Get(common.FormatName(publicRule.ServiceName), metaV1.GetOptions{})
 		if errors.IsNotFound(virtualServErr) {
 			// we have to create the virtual service
-			// Create a virtual service to redirect this
-			vs := &istioNetworking.VirtualService{
-				ObjectMeta: metaV1.ObjectMeta{
-					Name: common.FormatName(publicRule.ServiceName),
-					Namespace: target.Data.Namespace,
-				},
-				Spec: v1alpha3.VirtualService{
-					Hosts: []string{publicRule.ServiceName},
-					Tcp: []*v1alpha3.TCPRoute{
-						{
-							Route: []*v1alpha3.RouteDestination{
-								{
-									Destination: &v1alpha3.Destination{
-										Host: common.FormatName(publicRule.ServiceName),
-										Port: &v1alpha3.PortSelector{Number: uint32(publicRule.TargetPort)}},
-								},
-							},
-							Match: []*v1alpha3.L4MatchAttributes {
-								{
-									Port: uint32(publicRule.TargetPort),
-								},
-							},
-						},
-					},
-				},
-			}
 			log.Debug().Msg("create virtual service")
-			_, errVS := id.Client.NetworkingV1alpha3().VirtualServices(target.Data.Namespace).Create(vs)
+			_, errVS := id.Client.NetworkingV1alpha3().VirtualServices(target.Data.Namespace).
+				Create(id.newRuleVirtualService(target, publicRule))
 			if errVS != nil {
 				return derrors.NewInternalError("impossible to generate virtual service", errVS)
 			}
@@ -230,6 +190,90 @@ func (id *IstioDecorator) decorateServices(target *kubernetes.DeployableServices
 	return nil
 }
 
+// Public rules of the stage whose service is not declared by the stage services.
+func (id *IstioDecorator) undeclaredRules(target *kubernetes.DeployableServices) []*grpc_conductor_go.PublicSecurityRuleInstance {
+	toReturn := make([]*grpc_conductor_go.PublicSecurityRuleInstance, 0)
+	for _, publicRule := range target.Data.Stage.PublicRules {
+		found := false
+		for _, s := range target.Services {
+			// If we already have a service for this public rule skip to the next one
+			if publicRule.ServiceName == s.Service.Name {
+				found = true
+				break
+			}
+		}
+		log.Debug().Str("serviceName", publicRule.ServiceName).Msgf("comparing existing services we have found it %t", found)
+		if !found {
+			toReturn = append(toReturn, publicRule)
+		}
+	}
+	return toReturn
+}
+
+// Service giving access to the service of a public rule not declared by the stage.
+func (id *IstioDecorator) newRuleService(target *kubernetes.DeployableServices,
+	publicRule *grpc_conductor_go.PublicSecurityRuleInstance) *apiv1.Service {
+	return &apiv1.Service{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      common.FormatName(publicRule.ServiceName),
+			Namespace: target.Data.Namespace,
+			Labels: map[string]string{
+				utils.NALEJ_ANNOTATION_ORGANIZATION_ID: target.Data.OrganizationId,
+				utils.NALEJ_ANNOTATION_APP_DESCRIPTOR:  target.Data.AppDescriptorId,
+				utils.NALEJ_ANNOTATION_APP_INSTANCE_ID: target.Data.AppInstanceId,
+				utils.NALEJ_ANNOTATION_IS_PROXY:        "false",
+			},
+		},
+		Spec: apiv1.ServiceSpec{
+			ExternalName: common.FormatName(publicRule.ServiceName),
+			Ports: []apiv1.ServicePort{
+				{
+					Port: publicRule.TargetPort,
+					// TODO we have to assume that the internal port matches
+					TargetPort: intstr.IntOrString{IntVal: publicRule.TargetPort},
+					Name:       fmt.Sprintf("port%d", publicRule.TargetPort),
+				},
+			},
+			Type: apiv1.ServiceTypeClusterIP,
+			Selector: map[string]string{
+				utils.NALEJ_ANNOTATION_APP_INSTANCE_ID: target.Data.AppInstanceId,
+				utils.NALEJ_ANNOTATION_ORGANIZATION_ID: target.Data.OrganizationId,
+				utils.NALEJ_ANNOTATION_SERVICE_NAME:    common.FormatName(publicRule.ServiceName),
+			},
+		},
+	}
+}
+
+// Virtual service redirecting the traffic of a public rule to the service created for it.
+func (id *IstioDecorator) newRuleVirtualService(target *kubernetes.DeployableServices,
+	publicRule *grpc_conductor_go.PublicSecurityRuleInstance) *istioNetworking.VirtualService {
+	return &istioNetworking.VirtualService{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      common.FormatName(publicRule.ServiceName),
+			Namespace: target.Data.Namespace,
+		},
+		Spec: v1alpha3.VirtualService{
+			Hosts: []string{publicRule.ServiceName},
+			Tcp: []*v1alpha3.TCPRoute{
+				{
+					Route: []*v1alpha3.RouteDestination{
+						{
+							Destination: &v1alpha3.Destination{
+								Host: common.FormatName(publicRule.ServiceName),
+								Port: &v1alpha3.PortSelector{Number: uint32(publicRule.TargetPort)}},
+						},
+					},
+					Match: []*v1alpha3.L4MatchAttributes{
+						{
+							Port: uint32(publicRule.TargetPort),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
 // Decorate deployments to skip Istio network catching.
 // params:
 //  target kubernetes deployment to be decorated
@@ -387,4 +431,136 @@ func (id *IstioDecorator) updateVirtualServicePorts(virtualService *istioNetwork
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// Split the traffic of a service between the stable version and its canary using the weight of the rollout.
+// A destination rule defines a subset for the pods of each track and the routes of the virtual service of the
+// service are weighted between both subsets. The virtual service is created if the service had none.
+// params:
+//  target rollout in progress
+// return:
+//  error if any
+func (id *IstioDecorator) splitRolloutTraffic(target *kubernetes.DeployableRollout) derrors.Error {
+	if target.Strategy.Type != entities.ROLLOUT_CANARY {
+		return nil
+	}
+	namespace := target.Desired.Namespace
+	host := target.ServiceName()
+
+	rule := &istioNetworking.DestinationRule{
+		ObjectMeta: metaV1.ObjectMeta{
+			Name:      host,
+			Namespace: namespace,
+		},
+		Spec: v1alpha3.DestinationRule{
+			Host: host,
+			Subsets: []*v1alpha3.Subset{
+				{
+					Name:   StableSubset,
+					Labels: map[string]string{utils.NALEJ_ANNOTATION_ROLLOUT_TRACK: utils.NALEJ_ANNOTATION_VALUE_STABLE_TRACK},
+				},
+				{
+					Name:   CandidateSubset,
+					Labels: map[string]string{utils.NALEJ_ANNOTATION_ROLLOUT_TRACK: target.CandidateTrack()},
+				},
+			},
+		},
+	}
+	foundRule, err := id.Client.NetworkingV1alpha3().DestinationRules(namespace).Get(host, metaV1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = id.Client.NetworkingV1alpha3().DestinationRules(namespace).Create(rule)
+	} else if err == nil {
+		rule.ResourceVersion = foundRule.ResourceVersion
+		_, err = id.Client.NetworkingV1alpha3().DestinationRules(namespace).Update(rule)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("host", host).Msg("istio decorator cannot set rollout destination rule")
+		return derrors.NewInternalError("istio decorator cannot set rollout destination rule", err)
+	}
+
+	weight := target.Strategy.CanaryPercentage
+	foundVirtualServ, err := id.Client.NetworkingV1alpha3().VirtualServices(namespace).Get(host, metaV1.GetOptions{})
+	if errors.IsNotFound(err) {
+		vs := &istioNetworking.VirtualService{
+			ObjectMeta: metaV1.ObjectMeta{
+				Name:      host,
+				Namespace: namespace,
+				Labels:    map[string]string{utils.NALEJ_ANNOTATION_ROLLOUT_TRACK: target.CandidateTrack()},
+			},
+			Spec: v1alpha3.VirtualService{
+				Hosts: []string{host},
+				Tcp:   []*v1alpha3.TCPRoute{{Route: weightedDestinations(host, nil, weight)}},
+			},
+		}
+		_, err = id.Client.NetworkingV1alpha3().VirtualServices(namespace).Create(vs)
+	} else if err == nil {
+		for _, route := range foundVirtualServ.Spec.Tcp {
+			var port *v1alpha3.PortSelector
+			if len(route.Route) > 0 && route.Route[0].Destination != nil {
+				port = route.Route[0].Destination.Port
+			}
+			route.Route = weightedDestinations(host, port, weight)
+		}
+		_, err = id.Client.NetworkingV1alpha3().VirtualServices(namespace).Update(foundVirtualServ)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("host", host).Msg("istio decorator cannot split rollout traffic")
+		return derrors.NewInternalError("istio decorator cannot split rollout traffic", err)
+	}
+	return nil
+}
+
+// Send back all the traffic of a service to its stable version. Virtual services created for the rollout are
+// removed and the routes of the other ones are restored.
+// params:
+//  target rollout being finished
+// return:
+//  error if any
+func (id *IstioDecorator) restoreRolloutTraffic(target *kubernetes.DeployableRollout) derrors.Error {
+	if target.Strategy.Type != entities.ROLLOUT_CANARY {
+		return nil
+	}
+	namespace := target.Desired.Namespace
+	host := target.ServiceName()
+
+	foundVirtualServ, err := id.Client.NetworkingV1alpha3().VirtualServices(namespace).Get(host, metaV1.GetOptions{})
+	if err == nil {
+		if _, created := foundVirtualServ.Labels[utils.NALEJ_ANNOTATION_ROLLOUT_TRACK]; created {
+			err = id.Client.NetworkingV1alpha3().VirtualServices(namespace).Delete(host, metaV1.NewDeleteOptions(0))
+		} else {
+			for _, route := range foundVirtualServ.Spec.Tcp {
+				if len(route.Route) > 0 && route.Route[0].Destination != nil {
+					route.Route = []*v1alpha3.RouteDestination{{
+						Destination: &v1alpha3.Destination{Host: host, Port: route.Route[0].Destination.Port},
+					}}
+				}
+			}
+			_, err = id.Client.NetworkingV1alpha3().VirtualServices(namespace).Update(foundVirtualServ)
+		}
+	}
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error().Err(err).Str("host", host).Msg("istio decorator cannot restore rollout traffic")
+		return derrors.NewInternalError("istio decorator cannot restore rollout traffic", err)
+	}
+
+	err = id.Client.NetworkingV1alpha3().DestinationRules(namespace).Delete(host, metaV1.NewDeleteOptions(0))
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error().Err(err).Str("host", host).Msg("istio decorator cannot remove rollout destination rule")
+		return derrors.NewInternalError("istio decorator cannot remove rollout destination rule", err)
+	}
+	return nil
+}
+
+// Route destinations sending a share of the traffic to the candidate subset.
+func weightedDestinations(host string, port *v1alpha3.PortSelector, candidateWeight int32) []*v1alpha3.RouteDestination {
+	return []*v1alpha3.RouteDestination{
+		{
+			Destination: &v1alpha3.Destination{Host: host, Subset: StableSubset, Port: port},
+			Weight:      100 - candidateWeight,
+		},
+		{
+			Destination: &v1alpha3.Destination{Host: host, Subset: CandidateSubset, Port: port},
+			Weight:      candidateWeight,
+		},
+	}
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package election
+
+import (
+	"context"
+	"time"
+
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	testLeaderOnly = "/deployment_manager.DeploymentManager/Execute"
+	testQuery      = "/deployment_manager.DeploymentManager/ListMonitoredEntries"
+)
+
+// Leadership with a fixed state
+type testLeadership struct {
+	leader bool
+}
+
+func (l testLeadership) IsLeader() bool {
+	return l.leader
+}
+
+func (l testLeadership) GetLeader() string {
+	return "other"
+}
+
+func callInterceptor(interceptor grpc.UnaryServerInterceptor, method string) (bool, error) {
+	called := false
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		called = true
+		return "ok", nil
+	}
+	_, err := interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: method}, handler)
+	return called, err
+}
+
+func newTestElector(identity string, callbacks Callbacks) *Elector {
+	elector, err := NewElector(fake.NewSimpleClientset(), "nalej", "deployment-manager", identity,
+		time.Second, 500*time.Millisecond, 100*time.Millisecond, callbacks)
+	gomega.Expect(err).To(gomega.Succeed())
+	return elector
+}
+
+var _ = ginkgo.Describe("Leader election", func() {
+
+	ginkgo.Context("leader only interceptor", func() {
+		ginkgo.It("should serve every method in the leader", func() {
+			interceptor := LeaderOnlyInterceptor(AlwaysLeader{}, []string{testLeaderOnly})
+			called, err := callInterceptor(interceptor, testLeaderOnly)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(called).To(gomega.BeTrue())
+		})
+
+		ginkgo.It("should reject the leader only methods in a follower", func() {
+			interceptor := LeaderOnlyInterceptor(testLeadership{leader: false}, []string{testLeaderOnly})
+			called, err := callInterceptor(interceptor, testLeaderOnly)
+			gomega.Expect(called).To(gomega.BeFalse())
+			gomega.Expect(status.Code(err)).To(gomega.Equal(codes.Unavailable))
+		})
+
+		ginkgo.It("should serve the queries in a follower", func() {
+			interceptor := LeaderOnlyInterceptor(testLeadership{leader: false}, []string{testLeaderOnly})
+			called, err := callInterceptor(interceptor, testQuery)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(called).To(gomega.BeTrue())
+		})
+	})
+
+	ginkgo.Context("elector", func() {
+		ginkgo.It("should fail with an invalid configuration", func() {
+			_, err := NewElector(fake.NewSimpleClientset(), "nalej", "deployment-manager", "id",
+				time.Second, 2*time.Second, 100*time.Millisecond, Callbacks{})
+			gomega.Expect(err).NotTo(gomega.Succeed())
+		})
+
+		ginkgo.It("should stop an elector that is not running", func() {
+			elector := newTestElector("id", Callbacks{})
+			elector.Stop()
+			gomega.Expect(elector.IsLeader()).To(gomega.BeFalse())
+		})
+
+		ginkgo.It("should acquire and release the lease", func() {
+			started := make(chan struct{})
+			lost := make(chan struct{})
+			elector := newTestElector("id", Callbacks{
+				OnStartedLeading: func() { close(started) },
+				OnStoppedLeading: func() { close(lost) },
+			})
+			go elector.Run()
+			gomega.Eventually(started, 5*time.Second).Should(gomega.BeClosed())
+			gomega.Expect(elector.IsLeader()).To(gomega.BeTrue())
+			gomega.Expect(elector.GetLeader()).To(gomega.Equal("id"))
+
+			// stopping the elector is not a loss of the leadership
+			elector.Stop()
+			gomega.Expect(lost).NotTo(gomega.BeClosed())
+			gomega.Expect(elector.IsLeader()).To(gomega.BeFalse())
+		})
+
+		ginkgo.It("should not elect a follower while the lease is held", func() {
+			client := fake.NewSimpleClientset()
+			leader, err := NewElector(client, "nalej", "deployment-manager", "leader",
+				time.Second, 500*time.Millisecond, 100*time.Millisecond, Callbacks{})
+			gomega.Expect(err).To(gomega.Succeed())
+			go leader.Run()
+			defer leader.Stop()
+			gomega.Eventually(leader.IsLeader, 5*time.Second).Should(gomega.BeTrue())
+
+			started := make(chan struct{})
+			follower, err := NewElector(client, "nalej", "deployment-manager", "follower",
+				time.Second, 500*time.Millisecond, 100*time.Millisecond, Callbacks{OnStartedLeading: func() { close(started) }})
+			gomega.Expect(err).To(gomega.Succeed())
+			go follower.Run()
+			defer follower.Stop()
+			gomega.Eventually(follower.GetLeader, 5*time.Second).Should(gomega.Equal("leader"))
+			gomega.Consistently(follower.IsLeader, 1500*time.Millisecond).Should(gomega.BeFalse())
+			gomega.Expect(started).NotTo(gomega.BeClosed())
+
+			lease, lErr := client.CoordinationV1().Leases("nalej").Get("deployment-manager", metav1.GetOptions{})
+			gomega.Expect(lErr).To(gomega.Succeed())
+			gomega.Expect(*lease.Spec.HolderIdentity).To(gomega.Equal("leader"))
+		})
+	})
+})
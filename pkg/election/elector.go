@@ -0,0 +1,211 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Leader election among the replicas of the deployment manager. Only the leader processes the deployment
+// requests and reports the status of the applications, the rest of replicas serve the read-only queries.
+
+package election
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// Environment variable with the name of the pod used as identity of the instance
+const EnvPodName = "POD_NAME"
+
+// Leadership of the instance
+type Leadership interface {
+	// Check if the instance is the leader.
+	IsLeader() bool
+	// Get the identity of the current leader, empty if unknown.
+	GetLeader() string
+}
+
+// Leadership of an instance running alone
+type AlwaysLeader struct{}
+
+func (l AlwaysLeader) IsLeader() bool {
+	return true
+}
+
+func (l AlwaysLeader) GetLeader() string {
+	return ""
+}
+
+// Functions called when the leadership of the instance changes
+type Callbacks struct {
+	// Called when the instance becomes the leader.
+	OnStartedLeading func()
+	// Called when the instance loses the leadership while running. The instance must stop doing the work
+	// of the leader as another instance may take over at any time.
+	OnStoppedLeading func()
+}
+
+// Elector takes part in the election of the leader using a Kubernetes lease.
+type Elector struct {
+	// Kubernetes leader elector
+	elector *leaderelection.LeaderElector
+	// Identity of this instance
+	identity string
+	// Callbacks of the service
+	callbacks Callbacks
+	// The instance is the leader
+	leading bool
+	// Identity of the current leader
+	leader string
+	// The elector is being stopped
+	stopping bool
+	// Function to stop the election
+	cancel context.CancelFunc
+	// Channel closed when the elector is stopped
+	done chan struct{}
+	// Mutex for the leadership flags
+	mu sync.Mutex
+}
+
+// Get the identity of this instance: the name of the pod or the hostname.
+func DefaultIdentity() (string, derrors.Error) {
+	if podName := os.Getenv(EnvPodName); podName != "" {
+		return podName, nil
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", derrors.AsError(err, "cannot get hostname")
+	}
+	return hostname, nil
+}
+
+// Create a new elector.
+//  params:
+//   client kubernetes client
+//   namespace of the lease
+//   name of the lease
+//   identity of this instance
+//   leaseDuration time the followers wait before trying to acquire a lease not renewed
+//   renewDeadline time the leader retries to renew the lease before giving up the leadership
+//   retryPeriod time between attempts to acquire or renew the lease
+//   callbacks called when the leadership changes
+//  return:
+//   elector or error if the configuration is not valid
+func NewElector(client kubernetes.Interface, namespace string, name string, identity string,
+	leaseDuration time.Duration, renewDeadline time.Duration, retryPeriod time.Duration, callbacks Callbacks) (*Elector, derrors.Error) {
+	e := &Elector{identity: identity, callbacks: callbacks, done: make(chan struct{})}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Client:     client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: identity},
+	}
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:          lock,
+		LeaseDuration: leaseDuration,
+		RenewDeadline: renewDeadline,
+		RetryPeriod:   retryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: e.startedLeading,
+			OnStoppedLeading: e.stoppedLeading,
+			OnNewLeader:      e.newLeader,
+		},
+		// the lease is released on stop so another instance takes over immediately
+		ReleaseOnCancel: true,
+		Name:            name,
+	})
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError("invalid leader election configuration", err)
+	}
+	e.elector = elector
+	return e, nil
+}
+
+// Take part in the election until the elector is stopped.
+func (e *Elector) Run() {
+	ctx, cancel := context.WithCancel(context.Background())
+	e.mu.Lock()
+	e.cancel = cancel
+	e.mu.Unlock()
+	defer close(e.done)
+	log.Info().Str("identity", e.identity).Msg("waiting to become the leader")
+	e.elector.Run(ctx)
+}
+
+// Stop taking part in the election releasing the lease if this instance is the leader. It returns once the
+// elector is stopped.
+func (e *Elector) Stop() {
+	e.mu.Lock()
+	e.stopping = true
+	cancel := e.cancel
+	e.mu.Unlock()
+	if cancel == nil {
+		// the elector was not running
+		return
+	}
+	cancel()
+	<-e.done
+}
+
+// The state of the kubernetes elector is not safe for concurrent use, the leadership is tracked from its callbacks.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leading
+}
+
+func (e *Elector) GetLeader() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+func (e *Elector) newLeader(leader string) {
+	log.Info().Str("leader", leader).Str("identity", e.identity).Msg("new leader elected")
+	e.mu.Lock()
+	e.leader = leader
+	e.mu.Unlock()
+}
+
+func (e *Elector) startedLeading(ctx context.Context) {
+	log.Info().Str("identity", e.identity).Msg("leadership acquired")
+	e.mu.Lock()
+	e.leading = true
+	e.mu.Unlock()
+	if e.callbacks.OnStartedLeading != nil {
+		e.callbacks.OnStartedLeading()
+	}
+}
+
+// The kubernetes elector calls this function when its run finishes, even if the instance was never the leader.
+func (e *Elector) stoppedLeading() {
+	e.mu.Lock()
+	lost := e.leading && !e.stopping
+	e.leading = false
+	e.mu.Unlock()
+	if !lost {
+		return
+	}
+	log.Error().Str("identity", e.identity).Msg("leadership lost")
+	if e.callbacks.OnStoppedLeading != nil {
+		e.callbacks.OnStoppedLeading()
+	}
+}
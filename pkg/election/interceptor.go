@@ -0,0 +1,49 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package election
+
+import (
+	"context"
+
+	"github.com/nalej/derrors"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+)
+
+// Interceptor rejecting the calls to a set of methods when the instance is not the leader. The calls are
+// rejected as unavailable so the clients can retry them against another instance.
+//  params:
+//   leadership of the instance
+//   methods full names of the methods only served by the leader
+//  return:
+//   interceptor
+func LeaderOnlyInterceptor(leadership Leadership, methods []string) grpc.UnaryServerInterceptor {
+	leaderOnly := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		leaderOnly[method] = true
+	}
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if leaderOnly[info.FullMethod] && !leadership.IsLeader() {
+			leader := leadership.GetLeader()
+			log.Warn().Str("method", info.FullMethod).Str("leader", leader).Msg("call rejected, this instance is not the leader")
+			return nil, conversions.ToGRPCError(
+				derrors.NewUnavailableError("this instance is not the leader").WithParams(leader))
+		}
+		return handler(ctx, req)
+	}
+}
@@ -35,3 +35,11 @@ type NetworkDecorator interface {
 	// Remove any unnecessary entries when a deployable element is removed.
 	Undeploy(aux Deployable, args ...interface{}) derrors.Error
 }
+
+// Network decorators creating new entries when a deployable element is deployed can implement this interface
+// to describe those entries without creating them, so they are included when a deployment is rendered.
+type RenderableNetworkDecorator interface {
+
+	// Entries the decorator would create when the deployable element is deployed.
+	Render(aux Deployable, args ...interface{}) ([]interface{}, derrors.Error)
+}
@@ -17,7 +17,9 @@
 package executor
 
 import (
+	"context"
 	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/derrors"
 	pbConductor "github.com/nalej/grpc-conductor-go"
 	pbDeploymentMgr "github.com/nalej/grpc-deployment-manager-go"
 	"github.com/nalej/grpc-storage-fabric-go"
@@ -40,11 +42,12 @@ type Executor interface {
 
 	// Execute any initial preparation to deploy a fragment.
 	//  params:
+	//   ctx context to cancel the preparation
 	//   data information for deployment
 	//   networkDecorator additional network for networking operations
 	//  return:
 	//   error if any
-	PrepareEnvironmentForDeployment(data entities.DeploymentMetadata, networkDecorator NetworkDecorator) (Deployable, error)
+	PrepareEnvironmentForDeployment(ctx context.Context, data entities.DeploymentMetadata, networkDecorator NetworkDecorator) (Deployable, error)
 
 	// Build a deployable object that can be executed into the current platform using its native description.
 	//  params:
@@ -54,14 +57,91 @@ type Executor interface {
 	//   deployable entity or error if any
 	BuildNativeDeployable(data entities.DeploymentMetadata, networkDecorator NetworkDecorator, sfClient grpc_storage_fabric_go.StorageClassClient) (Deployable, error)
 
+	// Render the native objects the deployment of a fragment would create without applying them, including
+	// the entries the network decorators implementing RenderableNetworkDecorator would create.
+	//  params:
+	//   data deployment metadata
+	//   stages of the fragment
+	//   networkDecorator additional processes required to set deployment networking
+	//   sfClient storage fabric client
+	//  return:
+	//   textual description of the native objects or error if any
+	RenderFragment(data entities.DeploymentMetadata, stages []*pbConductor.DeploymentStage, networkDecorator NetworkDecorator,
+		sfClient grpc_storage_fabric_go.StorageClassClient) (string, error)
+
+	// Update in place the native objects of a running fragment. Missing objects are created, the modified ones are
+	// replaced so the platform rolls out the changes and the ones no longer in the fragment are removed.
+	//  params:
+	//   ctx context to cancel the update
+	//   data deployment metadata
+	//   stages of the new version of the fragment
+	//   networkDecorator additional processes required to set deployment networking
+	//   sfClient storage fabric client
+	//  return:
+	//   error if any
+	UpdateFragment(ctx context.Context, data entities.DeploymentMetadata, stages []*pbConductor.DeploymentStage,
+		networkDecorator NetworkDecorator, sfClient grpc_storage_fabric_go.StorageClassClient) error
+
+	// Promote the new version of a service being rolled out by a fragment update. The service runs the new version
+	// and the candidate is removed once it is ready.
+	//  params:
+	//   fragmentId fragment of the service
+	//   serviceInstanceId service being rolled out
+	//  return:
+	//   error if there is no rollout in progress or its candidate is not ready
+	PromoteRollout(fragmentId string, serviceInstanceId string) derrors.Error
+
+	// Abort the rollout of a service removing its candidate. The service keeps running its current version.
+	//  params:
+	//   fragmentId fragment of the service
+	//   serviceInstanceId service being rolled out
+	//  return:
+	//   error if there is no rollout in progress or it is being promoted
+	AbortRollout(fragmentId string, serviceInstanceId string) derrors.Error
+
+	// Set the number of replicas of a service. The service is reported as deploying until all its replicas
+	// are available.
+	//  params:
+	//   namespace of the application
+	//   serviceInstanceId service to be scaled
+	//   replicas new number of replicas
+	//  return:
+	//   error if any
+	ScaleService(namespace string, serviceInstanceId string, replicas int32) derrors.Error
+
+	// Restart all the replicas of a service progressively. The service is reported as deploying until
+	// all its replicas are replaced.
+	//  params:
+	//   namespace of the application
+	//   serviceInstanceId service to be restarted
+	//  return:
+	//   error if any
+	RestartService(namespace string, serviceInstanceId string) derrors.Error
+
+	// Scale down to zero all the workloads of an application keeping the rest of its resources. The current
+	// number of replicas of every workload is stored in the platform so it can be restored later.
+	//  params:
+	//   namespace of the application
+	//  return:
+	//   error if any
+	SuspendNamespace(namespace string) derrors.Error
+
+	// Restore the number of replicas the workloads of a suspended application had before being suspended.
+	//  params:
+	//   namespace of the application
+	//  return:
+	//   error if any
+	ResumeNamespace(namespace string) derrors.Error
+
 	// Execute a deployment stage for the current platform.
 	//  params:
+	//   ctx context to cancel the deployment
 	//   toDeploy items to be deployed
 	//   fragment to the stage belongs to
 	//   stage to be executed
 	//  return:
 	//   deployable object or error if any
-	DeployStage(toDeploy Deployable, fragment *pbConductor.DeploymentFragment, stage *pbConductor.DeploymentStage) error
+	DeployStage(ctx context.Context, toDeploy Deployable, fragment *pbConductor.DeploymentFragment, stage *pbConductor.DeploymentStage) error
 
 	// This operation should be executed after the failed deployment of a deployment stage. The target platform must
 	// be ready to retry again the deployment of this stage. This means, that other deployable entities deployed
@@ -100,6 +180,9 @@ type Monitor interface {
 
 	// Run the service to periodically check pending updates.
 	Run()
+
+	// Stop the service sending any pending update first. It returns once the service is stopped.
+	Stop()
 }
 
 // This interface describes functions to be implemented by any deployable element that can be executed on top
@@ -109,8 +192,9 @@ type Deployable interface {
 	GetId() string
 	// Build the deployable and construct the corresponding internal structures.
 	Build() error
-	// Deploy this element using a deployment controller to check when the operation is fully done.
-	Deploy(controller DeploymentController) error
+	// Deploy this element using a deployment controller to check when the operation is fully done. The deployment
+	// stops as soon as the context is cancelled.
+	Deploy(ctx context.Context, controller DeploymentController) error
 	// Undeploy this element
 	Undeploy() error
 }
@@ -124,6 +208,19 @@ type DeploymentController interface {
 	//  resource
 	AddMonitoredResource(resource *entities.MonitoredPlatformResource)
 
+	// Stop monitoring a resource removed from the native platform.
+	// params:
+	//  fragmentId fragment the resource belongs to
+	//  serviceInstanceID service instance the resource belongs to
+	//  uid native identifier
+	RemoveMonitoredResource(fragmentId string, serviceInstanceID string, uid string)
+
+	// Stop monitoring a service that is no longer part of its fragment.
+	// params:
+	//  fragmentId fragment the service belonged to
+	//  serviceInstanceID service instance removed
+	RemoveMonitoredService(fragmentId string, serviceInstanceID string)
+
 	// Sets the status of a resource in the system. The implementation is in charge of transforming the native
 	// status value into a NalejServiceStatus
 	// params:
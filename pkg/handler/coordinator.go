@@ -0,0 +1,220 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handler
+
+import (
+	"context"
+	"github.com/nalej/deployment-manager/internal/structures"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"sync"
+)
+
+// Type of mutating operation over an application instance
+type OperationType int
+
+const (
+	OperationDeploy OperationType = iota
+	OperationUndeploy
+	OperationUndeployFragment
+	OperationUpdate
+	OperationSuspend
+	OperationResume
+	OperationScale
+	OperationRestart
+)
+
+var OperationTypeToString = map[OperationType]string{
+	OperationDeploy:           "deploy",
+	OperationUndeploy:         "undeploy",
+	OperationUndeployFragment: "undeployFragment",
+	OperationUpdate:           "update",
+	OperationSuspend:          "suspend",
+	OperationResume:           "resume",
+	OperationScale:            "scale",
+	OperationRestart:          "restart",
+}
+
+// Type of the operation of the requests in the queue
+var RequestOperationToType = map[structures.RequestOperation]OperationType{
+	structures.DeployOperation: OperationDeploy,
+	structures.UpdateOperation: OperationUpdate,
+}
+
+// Operation waiting for or holding the turn of an application instance
+type operation struct {
+	// type of operation
+	opType OperationType
+	// fragment the operation refers to
+	fragmentId string
+	// channel closed when the operation can start or is preempted
+	ready chan struct{}
+	// the operation was preempted before starting
+	preempted bool
+}
+
+// Operations of an application instance
+type appOperations struct {
+	// operation being executed, nil if none
+	running *operation
+	// operations waiting for their turn in arrival order
+	pending []*operation
+}
+
+// OperationCoordinator serializes the mutating operations of the same application instance. Operations are
+// executed in arrival order except for undeploy operations that preempt the pending deployments and updates they
+// make useless.
+type OperationCoordinator struct {
+	// operations indexed by application instance id
+	apps map[string]*appOperations
+	// Mutex for coordinator operations
+	mu sync.Mutex
+}
+
+func NewOperationCoordinator() *OperationCoordinator {
+	return &OperationCoordinator{
+		apps: make(map[string]*appOperations, 0),
+	}
+}
+
+// Wait until the operation can be executed for the application instance. Once the operation finishes the returned
+// release function must be called to let the next one in.
+//  params:
+//   ctx context of the operation, the operation stops waiting when it is done
+//   appInstanceId application instance the operation modifies
+//   fragmentId fragment the operation refers to
+//   opType type of operation
+//  returns:
+//   release function or error if the operation was preempted or its context is done before its turn
+func (c *OperationCoordinator) Acquire(ctx context.Context, appInstanceId string, fragmentId string, opType OperationType) (func(), derrors.Error) {
+	op := &operation{opType: opType, fragmentId: fragmentId, ready: make(chan struct{})}
+
+	c.mu.Lock()
+	app, found := c.apps[appInstanceId]
+	if !found {
+		app = &appOperations{pending: make([]*operation, 0)}
+		c.apps[appInstanceId] = app
+	}
+	if opType == OperationUndeploy || opType == OperationUndeployFragment {
+		c.preemptDeployments(appInstanceId, app, op)
+	}
+	if app.running == nil {
+		app.running = op
+		close(op.ready)
+	} else {
+		app.pending = append(app.pending, op)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-op.ready:
+	case <-ctx.Done():
+		if c.removePending(appInstanceId, op) {
+			log.Info().Str("appInstanceId", appInstanceId).Str("fragmentId", fragmentId).
+				Str("operation", OperationTypeToString[opType]).Msg("operation stopped waiting for its turn")
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, derrors.NewDeadlineExceededError("deadline exceeded waiting for the application instance", ctx.Err()).
+					WithParams(appInstanceId, fragmentId)
+			}
+			return nil, derrors.NewCanceledError("operation cancelled waiting for the application instance", ctx.Err()).
+				WithParams(appInstanceId, fragmentId)
+		}
+		// the operation got its turn or was preempted meanwhile
+		<-op.ready
+	}
+	if op.preempted {
+		return nil, derrors.NewAbortedError("operation preempted by an undeploy").WithParams(appInstanceId, fragmentId)
+	}
+	return func() { c.release(appInstanceId, op) }, nil
+}
+
+// Start an operation only if no other operation of the application instance is running or waiting. Queued
+// requests use it so they never hold a worker while waiting for their turn.
+//  params:
+//   appInstanceId application instance the operation modifies
+//   fragmentId fragment the operation refers to
+//   opType type of operation
+//  returns:
+//   release function and true if the operation can start
+func (c *OperationCoordinator) TryAcquire(appInstanceId string, fragmentId string, opType OperationType) (func(), bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, found := c.apps[appInstanceId]; found {
+		return nil, false
+	}
+	op := &operation{opType: opType, fragmentId: fragmentId, ready: make(chan struct{})}
+	close(op.ready)
+	c.apps[appInstanceId] = &appOperations{running: op, pending: make([]*operation, 0)}
+	return func() { c.release(appInstanceId, op) }, true
+}
+
+// Remove the pending deployments affected by an undeploy operation. This function must be called with the lock held.
+func (c *OperationCoordinator) preemptDeployments(appInstanceId string, app *appOperations, undeploy *operation) {
+	remaining := make([]*operation, 0, len(app.pending))
+	for _, op := range app.pending {
+		if (op.opType == OperationDeploy || op.opType == OperationUpdate) &&
+			(undeploy.opType == OperationUndeploy || op.fragmentId == undeploy.fragmentId) {
+			log.Info().Str("appInstanceId", appInstanceId).Str("fragmentId", op.fragmentId).
+				Str("preemptedBy", OperationTypeToString[undeploy.opType]).Msg("pending deployment preempted")
+			op.preempted = true
+			close(op.ready)
+		} else {
+			remaining = append(remaining, op)
+		}
+	}
+	app.pending = remaining
+}
+
+// Remove an operation that is still waiting for its turn.
+//  params:
+//   appInstanceId application instance the operation modifies
+//   op operation to be removed
+//  returns:
+//   true if the operation was waiting, false if it already got its turn or was preempted
+func (c *OperationCoordinator) removePending(appInstanceId string, op *operation) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	app, found := c.apps[appInstanceId]
+	if !found {
+		return false
+	}
+	for i, pending := range app.pending {
+		if pending == op {
+			app.pending = append(app.pending[:i], app.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Release the turn of an application instance and let the next pending operation start.
+func (c *OperationCoordinator) release(appInstanceId string, op *operation) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	app, found := c.apps[appInstanceId]
+	if !found || app.running != op {
+		log.Warn().Str("appInstanceId", appInstanceId).Msg("released an operation that was not running")
+		return
+	}
+	if len(app.pending) == 0 {
+		delete(c.apps, appInstanceId)
+		return
+	}
+	app.running = app.pending[0]
+	app.pending = app.pending[1:]
+	close(app.running.ready)
+}
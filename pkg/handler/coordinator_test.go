@@ -0,0 +1,190 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handler
+
+import (
+	"context"
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"time"
+)
+
+var _ = ginkgo.Describe("operation coordinator", func() {
+
+	ginkgo.It("should serialize operations of the same application in order", func() {
+		coordinator := NewOperationCoordinator()
+		release, err := coordinator.Acquire(context.Background(), "app1", "fragment1", OperationDeploy)
+		gomega.Expect(err).To(gomega.BeNil())
+
+		started := make(chan string, 2)
+		go func() {
+			defer ginkgo.GinkgoRecover()
+			r, err := coordinator.Acquire(context.Background(), "app1", "fragment2", OperationDeploy)
+			gomega.Expect(err).To(gomega.BeNil())
+			started <- "fragment2"
+			r()
+		}()
+		gomega.Eventually(func() int {
+			coordinator.mu.Lock()
+			defer coordinator.mu.Unlock()
+			return len(coordinator.apps["app1"].pending)
+		}).Should(gomega.Equal(1))
+		go func() {
+			defer ginkgo.GinkgoRecover()
+			r, err := coordinator.Acquire(context.Background(), "app1", "fragment3", OperationUndeployFragment)
+			gomega.Expect(err).To(gomega.BeNil())
+			started <- "fragment3"
+			r()
+		}()
+		gomega.Consistently(started, time.Millisecond*100).ShouldNot(gomega.Receive())
+
+		// other applications are not affected
+		other, err := coordinator.Acquire(context.Background(), "app2", "fragment4", OperationDeploy)
+		gomega.Expect(err).To(gomega.BeNil())
+		other()
+
+		release()
+		gomega.Eventually(started).Should(gomega.Receive(gomega.Equal("fragment2")))
+		gomega.Eventually(started).Should(gomega.Receive(gomega.Equal("fragment3")))
+	})
+
+	ginkgo.It("should preempt pending deployments on undeploy", func() {
+		coordinator := NewOperationCoordinator()
+		release, err := coordinator.Acquire(context.Background(), "app1", "fragment1", OperationDeploy)
+		gomega.Expect(err).To(gomega.BeNil())
+
+		preempted := make(chan bool, 1)
+		go func() {
+			defer ginkgo.GinkgoRecover()
+			r, err := coordinator.Acquire(context.Background(), "app1", "fragment2", OperationDeploy)
+			if err == nil {
+				r()
+			}
+			preempted <- err != nil
+		}()
+		gomega.Eventually(func() int {
+			coordinator.mu.Lock()
+			defer coordinator.mu.Unlock()
+			return len(coordinator.apps["app1"].pending)
+		}).Should(gomega.Equal(1))
+
+		undeployed := make(chan bool, 1)
+		go func() {
+			defer ginkgo.GinkgoRecover()
+			r, err := coordinator.Acquire(context.Background(), "app1", "", OperationUndeploy)
+			gomega.Expect(err).To(gomega.BeNil())
+			undeployed <- true
+			r()
+		}()
+		gomega.Eventually(preempted).Should(gomega.Receive(gomega.BeTrue()))
+		gomega.Consistently(undeployed, time.Millisecond*100).ShouldNot(gomega.Receive())
+		release()
+		gomega.Eventually(undeployed).Should(gomega.Receive())
+	})
+
+	ginkgo.It("should preempt pending updates on undeploy but not pending deployments on update", func() {
+		coordinator := NewOperationCoordinator()
+		release, err := coordinator.Acquire(context.Background(), "app1", "fragment1", OperationDeploy)
+		gomega.Expect(err).To(gomega.BeNil())
+
+		deployed := make(chan bool, 1)
+		go func() {
+			defer ginkgo.GinkgoRecover()
+			r, err := coordinator.Acquire(context.Background(), "app1", "fragment2", OperationDeploy)
+			gomega.Expect(err).To(gomega.BeNil())
+			deployed <- true
+			r()
+		}()
+		gomega.Eventually(func() int {
+			coordinator.mu.Lock()
+			defer coordinator.mu.Unlock()
+			return len(coordinator.apps["app1"].pending)
+		}).Should(gomega.Equal(1))
+
+		preempted := make(chan bool, 1)
+		go func() {
+			defer ginkgo.GinkgoRecover()
+			r, err := coordinator.Acquire(context.Background(), "app1", "fragment1", OperationUpdate)
+			if err == nil {
+				r()
+			}
+			preempted <- err != nil
+		}()
+		gomega.Eventually(func() int {
+			coordinator.mu.Lock()
+			defer coordinator.mu.Unlock()
+			return len(coordinator.apps["app1"].pending)
+		}).Should(gomega.Equal(2))
+
+		go func() {
+			defer ginkgo.GinkgoRecover()
+			r, err := coordinator.Acquire(context.Background(), "app1", "fragment1", OperationUndeployFragment)
+			gomega.Expect(err).To(gomega.BeNil())
+			r()
+		}()
+		gomega.Eventually(preempted).Should(gomega.Receive(gomega.BeTrue()))
+		release()
+		gomega.Eventually(deployed).Should(gomega.Receive())
+	})
+
+	ginkgo.It("should only start queued operations of idle applications", func() {
+		coordinator := NewOperationCoordinator()
+		release, acquired := coordinator.TryAcquire("app1", "fragment1", OperationDeploy)
+		gomega.Expect(acquired).To(gomega.BeTrue())
+		_, acquired = coordinator.TryAcquire("app1", "fragment2", OperationDeploy)
+		gomega.Expect(acquired).To(gomega.BeFalse())
+
+		// synchronous operations wait for the queued one
+		undeployed := make(chan bool, 1)
+		go func() {
+			defer ginkgo.GinkgoRecover()
+			r, err := coordinator.Acquire(context.Background(), "app1", "", OperationUndeploy)
+			gomega.Expect(err).To(gomega.BeNil())
+			undeployed <- true
+			r()
+		}()
+		gomega.Consistently(undeployed, time.Millisecond*100).ShouldNot(gomega.Receive())
+		release()
+		gomega.Eventually(undeployed).Should(gomega.Receive())
+		gomega.Eventually(func() bool {
+			r, acquired := coordinator.TryAcquire("app1", "fragment2", OperationDeploy)
+			if acquired {
+				r()
+			}
+			return acquired
+		}).Should(gomega.BeTrue())
+	})
+
+	ginkgo.It("should stop waiting when the context of the operation is done", func() {
+		coordinator := NewOperationCoordinator()
+		release, acquired := coordinator.TryAcquire("app1", "fragment1", OperationDeploy)
+		gomega.Expect(acquired).To(gomega.BeTrue())
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := coordinator.Acquire(ctx, "app1", "", OperationUndeploy)
+		gomega.Expect(err).NotTo(gomega.BeNil())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.DeadlineExceeded))
+
+		// the operation no longer waits for its turn
+		release()
+		coordinator.mu.Lock()
+		defer coordinator.mu.Unlock()
+		gomega.Expect(coordinator.apps).To(gomega.BeEmpty())
+	})
+})
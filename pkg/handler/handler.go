@@ -19,18 +19,22 @@ package handler
 import (
 	"context"
 	"errors"
+	"github.com/nalej/deployment-manager/internal/entities"
 	pbApplication "github.com/nalej/grpc-application-go"
 	"github.com/nalej/grpc-common-go"
 	pbDeploymentMgr "github.com/nalej/grpc-deployment-manager-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
 	"github.com/rs/zerolog/log"
 )
 
 type Handler struct {
 	m *Manager
+	// validators run before accepting a deployment fragment request
+	validators *ValidatorChain
 }
 
-func NewHandler(m *Manager) *Handler {
-	return &Handler{m}
+func NewHandler(m *Manager, validators *ValidatorChain) *Handler {
+	return &Handler{m, validators}
 }
 
 func (h *Handler) Execute(context context.Context, request *pbDeploymentMgr.DeploymentFragmentRequest) (*pbDeploymentMgr.DeploymentFragmentResponse, error) {
@@ -40,13 +44,18 @@ func (h *Handler) Execute(context context.Context, request *pbDeploymentMgr.Depl
 		return nil, theError
 	}
 
-	if !h.ValidDeployFragmentRequest(request) {
-		theError := errors.New("non valid deployment fragment request")
-		return nil, theError
+	if violations := h.validators.Validate(request); len(violations) > 0 {
+		log.Warn().Str("requestId", request.RequestId).Interface("violations", violations).
+			Msg("invalid deployment fragment request")
+		return nil, ViolationsToGRPCError(violations)
 	}
 
 	// Execute operation will take control now in an asynchronous manner
-	h.m.Execute(request)
+	err := h.m.Execute(request)
+	if err != nil {
+		log.Error().Str("err", err.DebugReport()).Str("requestId", request.RequestId).Msg("impossible to queue deployment fragment request")
+		return nil, conversions.ToGRPCError(err)
+	}
 
 	response := pbDeploymentMgr.DeploymentFragmentResponse{RequestId: request.RequestId, Status: pbApplication.ApplicationStatus_DEPLOYING}
 	return &response, nil
@@ -64,7 +73,7 @@ func (h *Handler) Undeploy(context context.Context, request *pbDeploymentMgr.Und
 		return nil, err
 	}
 
-	err := h.m.Undeploy(request)
+	err := h.m.Undeploy(context, request)
 
 	if err != nil {
 		log.Error().Err(err).Str("appInstanceId", request.AppInstanceId).Msg("failed to undeploy application")
@@ -86,7 +95,7 @@ func (h *Handler) UndeployFragment(context context.Context, request *pbDeploymen
 		return nil, err
 	}
 
-	err := h.m.UndeployFragment(request)
+	err := h.m.UndeployFragment(context, request)
 
 	if err != nil {
 		log.Error().Err(err).Str("fragmentId", request.DeploymentFragmentId).Msg("failed to undeploy fragment")
@@ -96,29 +105,170 @@ func (h *Handler) UndeployFragment(context context.Context, request *pbDeploymen
 	return &grpc_common_go.Success{}, nil
 }
 
-func (h *Handler) ValidDeployFragmentRequest(request *pbDeploymentMgr.DeploymentFragmentRequest) bool {
-	if request.RequestId == "" {
-		log.Error().Msg("impossible to process request with no request_id")
-		return false
+func (h *Handler) CancelDeployment(context context.Context, request *pbDeploymentMgr.CancelDeploymentRequest) (*grpc_common_go.Success, error) {
+	log.Debug().Interface("request", request).Msg("requested to cancel deployment")
+	vErr := entities.ValidateCancelDeploymentRequest(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
 	}
 
-	/*
-		// TODO only check if network mode in ZT is enabled
-		if request.ZtNetworkId == "" {
-			log.Error().Msg("impossible to process request with no zt_network_id")
-			return false
-		}
-	*/
-	if request.Fragment == nil || request.Fragment.FragmentId == "" {
-		log.Error().Msg("impossible to process request with no fragment_id")
-		return false
+	err := h.m.CancelDeployment(request)
+	if err != nil {
+		log.Error().Str("err", err.DebugReport()).Str("requestId", request.RequestId).
+			Str("fragmentId", request.DeploymentFragmentId).Msg("failed to cancel deployment")
+		return nil, conversions.ToGRPCError(err)
 	}
-	if request.Fragment.DeploymentId == "" || request.Fragment.AppInstanceId == "" || request.Fragment.OrganizationId == "" {
-		log.Error().Msg("impossible to process request with no deployment_id, app_intance_id or organization_id")
-		return false
+
+	return &grpc_common_go.Success{}, nil
+}
+
+func (h *Handler) RenderFragment(context context.Context, request *pbDeploymentMgr.DeploymentFragmentRequest) (*pbDeploymentMgr.RenderedFragment, error) {
+	log.Debug().Interface("request", request).Msg("requested to render fragment")
+	if request == nil {
+		theError := errors.New("received nil deployment plan request")
+		return nil, theError
 	}
 
-	return true
+	if violations := h.validators.Validate(request); len(violations) > 0 {
+		log.Warn().Str("requestId", request.RequestId).Interface("violations", violations).
+			Msg("invalid deployment fragment request")
+		return nil, ViolationsToGRPCError(violations)
+	}
+
+	rendered, err := h.m.RenderFragment(request)
+	if err != nil {
+		log.Error().Str("err", err.DebugReport()).Str("requestId", request.RequestId).Msg("impossible to render deployment fragment")
+		return nil, conversions.ToGRPCError(err)
+	}
+	return rendered, nil
+}
+
+func (h *Handler) UpdateFragment(context context.Context, request *pbDeploymentMgr.DeploymentFragmentRequest) (*pbDeploymentMgr.DeploymentFragmentResponse, error) {
+	log.Debug().Interface("request", request).Msg("requested to update fragment")
+	if request == nil {
+		theError := errors.New("received nil deployment plan request")
+		return nil, theError
+	}
+
+	if violations := h.validators.Validate(request); len(violations) > 0 {
+		log.Warn().Str("requestId", request.RequestId).Interface("violations", violations).
+			Msg("invalid deployment fragment request")
+		return nil, ViolationsToGRPCError(violations)
+	}
+
+	vErr := entities.ValidateRolloutStrategies(request.RolloutStrategies)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+
+	err := h.m.UpdateFragment(request)
+	if err != nil {
+		log.Error().Str("err", err.DebugReport()).Str("requestId", request.RequestId).Msg("impossible to update deployment fragment")
+		return nil, conversions.ToGRPCError(err)
+	}
+
+	response := pbDeploymentMgr.DeploymentFragmentResponse{RequestId: request.RequestId, Status: pbApplication.ApplicationStatus_DEPLOYING}
+	return &response, nil
+}
+
+func (h *Handler) PromoteRollout(context context.Context, request *pbDeploymentMgr.RolloutRequest) (*grpc_common_go.Success, error) {
+	log.Debug().Interface("request", request).Msg("requested to promote rollout")
+	vErr := entities.ValidateRolloutRequest(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+
+	err := h.m.PromoteRollout(request)
+	if err != nil {
+		log.Error().Str("err", err.DebugReport()).Str("fragmentId", request.DeploymentFragmentId).
+			Str("serviceInstanceId", request.ServiceInstanceId).Msg("failed to promote rollout")
+		return nil, conversions.ToGRPCError(err)
+	}
+
+	return &grpc_common_go.Success{}, nil
+}
+
+func (h *Handler) AbortRollout(context context.Context, request *pbDeploymentMgr.RolloutRequest) (*grpc_common_go.Success, error) {
+	log.Debug().Interface("request", request).Msg("requested to abort rollout")
+	vErr := entities.ValidateRolloutRequest(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+
+	err := h.m.AbortRollout(request)
+	if err != nil {
+		log.Error().Str("err", err.DebugReport()).Str("fragmentId", request.DeploymentFragmentId).
+			Str("serviceInstanceId", request.ServiceInstanceId).Msg("failed to abort rollout")
+		return nil, conversions.ToGRPCError(err)
+	}
+
+	return &grpc_common_go.Success{}, nil
+}
+
+func (h *Handler) ScaleService(context context.Context, request *pbDeploymentMgr.ScaleServiceRequest) (*grpc_common_go.Success, error) {
+	log.Debug().Interface("request", request).Msg("requested to scale service")
+	vErr := entities.ValidateScaleServiceRequest(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+
+	err := h.m.ScaleService(context, request)
+	if err != nil {
+		log.Error().Str("err", err.DebugReport()).Str("appInstanceId", request.AppInstanceId).
+			Str("serviceInstanceId", request.ServiceInstanceId).Msg("failed to scale service")
+		return nil, conversions.ToGRPCError(err)
+	}
+
+	return &grpc_common_go.Success{}, nil
+}
+
+func (h *Handler) RestartService(context context.Context, request *pbDeploymentMgr.RestartServiceRequest) (*grpc_common_go.Success, error) {
+	log.Debug().Interface("request", request).Msg("requested to restart service")
+	vErr := entities.ValidateRestartServiceRequest(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+
+	err := h.m.RestartService(context, request)
+	if err != nil {
+		log.Error().Str("err", err.DebugReport()).Str("appInstanceId", request.AppInstanceId).
+			Str("serviceInstanceId", request.ServiceInstanceId).Msg("failed to restart service")
+		return nil, conversions.ToGRPCError(err)
+	}
+
+	return &grpc_common_go.Success{}, nil
+}
+
+func (h *Handler) SuspendApplication(context context.Context, request *pbApplication.AppInstanceId) (*grpc_common_go.Success, error) {
+	log.Debug().Interface("request", request).Msg("requested to suspend application")
+	vErr := entities.ValidateAppInstanceId(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+
+	err := h.m.SuspendApplication(context, request)
+	if err != nil {
+		log.Error().Str("err", err.DebugReport()).Str("appInstanceId", request.AppInstanceId).Msg("failed to suspend application")
+		return nil, conversions.ToGRPCError(err)
+	}
+
+	return &grpc_common_go.Success{}, nil
+}
+
+func (h *Handler) ResumeApplication(context context.Context, request *pbApplication.AppInstanceId) (*grpc_common_go.Success, error) {
+	log.Debug().Interface("request", request).Msg("requested to resume application")
+	vErr := entities.ValidateAppInstanceId(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+
+	err := h.m.ResumeApplication(context, request)
+	if err != nil {
+		log.Error().Str("err", err.DebugReport()).Str("appInstanceId", request.AppInstanceId).Msg("failed to resume application")
+		return nil, conversions.ToGRPCError(err)
+	}
+
+	return &grpc_common_go.Success{}, nil
 }
 
 func (h *Handler) ValidUndeployRequest(request *pbDeploymentMgr.UndeployRequest) bool {
@@ -23,8 +23,10 @@ import (
 	"github.com/nalej/deployment-manager/internal/entities"
 	"github.com/nalej/deployment-manager/internal/structures"
 	"github.com/nalej/deployment-manager/internal/structures/monitor"
+	"github.com/nalej/deployment-manager/pkg/common"
 	"github.com/nalej/deployment-manager/pkg/executor"
 	"github.com/nalej/deployment-manager/pkg/network"
+	"github.com/nalej/derrors"
 	"github.com/nalej/grpc-application-go"
 	pbConductor "github.com/nalej/grpc-conductor-go"
 	pbDeploymentMgr "github.com/nalej/grpc-deployment-manager-go"
@@ -33,23 +35,11 @@ import (
 	"github.com/nalej/grpc-utils/pkg/conversions"
 	"github.com/rs/zerolog/log"
 	"k8s.io/client-go/kubernetes"
+	"sync"
 	"time"
 )
 
 const (
-	// No rollback stage retries
-	NoRetries = 1
-	// Maximum number of retries for a stage rollback
-	MaxStageRetries = 3
-	// Unlimited retries
-	UnlimitedStageRetries = -1
-	// Sleep between retries in milliseconds
-	SleepBetweenRetries = 10000
-
-	// Time between sleeps to check if an application is up
-	StageCheckTime = 10
-	// Checkout time after a stage is considered to be failed
-	StageCheckTimeout = 480
 	// Time to wait between checks in the queue in milliseconds.
 	CheckQueueSleepTime = 2000
 	DefaultTimeout      = time.Minute
@@ -57,6 +47,9 @@ const (
 	ExpireTimeout       = time.Minute * 3
 )
 
+// Error set as info of cancelled fragments
+var ErrDeploymentCancelled = errors.New("deployment cancelled")
+
 type Manager struct {
 	executor executor.Executor
 	// Conductor address
@@ -79,6 +72,36 @@ type Manager struct {
 	NetUpdater network.NetworkUpdater
 	// Storage Client
 	sfClient grpc_storage_fabric_go.StorageClassClient
+	// Pool limiting the number of fragments processed concurrently
+	pool *WorkerPool
+	// Maximum number of requests waiting to be processed, 0 for no limit
+	maxQueueLength int
+	// Mutex for queue operations
+	queueMu sync.Mutex
+	// Coordinator serializing the operations over the same application instance
+	coordinator *OperationCoordinator
+	// Requests being processed indexed by request id
+	running map[string]*runningRequest
+	// Retry policy applied when requests do not override it
+	retryPolicy RetryPolicy
+	// The manager is shutting down and does not accept new requests
+	shuttingDown bool
+	// Context cancelled when the manager shuts down
+	ctx context.Context
+	// Function to cancel the manager context
+	cancel context.CancelFunc
+	// Requests being processed
+	inFlight sync.WaitGroup
+	// Background tasks
+	background sync.WaitGroup
+}
+
+// Request being processed by the manager
+type runningRequest struct {
+	// request being processed
+	request *pbDeploymentMgr.DeploymentFragmentRequest
+	// function to cancel the processing of the request
+	cancel context.CancelFunc
 }
 
 func NewManager(
@@ -90,8 +113,12 @@ func NewManager(
 	networkDecorator executor.NetworkDecorator,
 	ulClient grpc_unified_logging_go.SlaveClient,
 	K8sClient *kubernetes.Clientset,
-	sfClient grpc_storage_fabric_go.StorageClassClient) *Manager {
+	sfClient grpc_storage_fabric_go.StorageClassClient,
+	pool *WorkerPool,
+	maxQueueLength int,
+	retryPolicy RetryPolicy) *Manager {
 	netUpdater := network.NewKubernetesNetworkUpdater(K8sClient)
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Manager{
 		executor:              *executor,
 		clusterPublicHostname: clusterPublicHostname,
@@ -103,27 +130,157 @@ func NewManager(
 		unifiedLoggingClient:  ulClient,
 		NetUpdater:            netUpdater,
 		sfClient:              sfClient,
+		pool:                  pool,
+		maxQueueLength:        maxQueueLength,
+		coordinator:           NewOperationCoordinator(),
+		running:               make(map[string]*runningRequest, 0),
+		retryPolicy:           retryPolicy,
+		ctx:                   ctx,
+		cancel:                cancel,
 	}
 }
 
 func (m *Manager) Run() {
-	sleep := time.Tick(time.Millisecond * CheckQueueSleepTime)
+	sleep := time.NewTicker(time.Millisecond * CheckQueueSleepTime)
+	defer sleep.Stop()
 	for {
 		select {
-		case <-sleep:
-			for m.queue.AvailableRequests() {
-				log.Info().Int("queued requests", m.queue.Len()).Msg("there are pending deployment requests")
-				go m.processRequest(m.queue.NextRequest())
-				// give it a second
-				time.Sleep(time.Second)
+		case <-sleep.C:
+		case <-m.pool.Released():
+		case <-m.ctx.Done():
+			log.Info().Msg("stop dispatching deployment requests")
+			return
+		}
+		m.dispatchRequests()
+	}
+}
+
+// Shutdown stops accepting new requests and waits for the requests being processed to finish. Requests still
+// running when the context expires are left in the queue; durable queues replay them on the next start.
+//  params:
+//   ctx context with the deadline to wait for the running requests
+func (m *Manager) Shutdown(ctx context.Context) {
+	m.queueMu.Lock()
+	m.shuttingDown = true
+	pending := m.queue.Len()
+	m.queueMu.Unlock()
+	m.cancel()
+
+	log.Info().Int("pending", pending).Int("running", m.pool.Running()).Msg("shutting down deployment requests manager")
+
+	done := make(chan struct{})
+	go func() {
+		m.inFlight.Wait()
+		m.background.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Info().Msg("every running request finished")
+	case <-ctx.Done():
+		m.queueMu.Lock()
+		for requestId := range m.running {
+			log.Warn().Str("requestId", requestId).Msg("request still running at shutdown, it remains in the queue")
+		}
+		m.queueMu.Unlock()
+	}
+	if pending > 0 {
+		log.Warn().Int("pending", pending).Msg("requests not processed remain in the queue")
+	}
+}
+
+// dispatchRequests starts processing as many pending requests as the worker pool allows. Requests whose
+// organization has no free workers or whose application instance is busy with another operation are skipped
+// and stay in the queue keeping their order.
+func (m *Manager) dispatchRequests() {
+	m.queueMu.Lock()
+	defer m.queueMu.Unlock()
+	if m.shuttingDown {
+		return
+	}
+
+	for m.pool.Available() && m.queue.AvailableRequests() {
+		log.Info().Int("queued requests", m.queue.Len()).Int("running", m.pool.Running()).
+			Msg("there are pending deployment requests")
+		// the turn of the application is taken before the worker so waiting requests never hold a worker,
+		// workers are only acquired by the dispatcher with the queue lock held
+		var release func()
+		request, operation := m.queue.NextMatchingRequest(func(req *pbDeploymentMgr.DeploymentFragmentRequest,
+			operation structures.RequestOperation) bool {
+			if !m.pool.CanAcquire(req.Fragment.OrganizationId) {
+				return false
 			}
+			acquired, found := m.coordinator.TryAcquire(req.Fragment.AppInstanceId, req.Fragment.FragmentId,
+				RequestOperationToType[operation])
+			release = acquired
+			return found
+		})
+		if request == nil {
+			log.Debug().Int("queued requests", m.queue.Len()).
+				Msg("queued requests wait for a free worker or for their application instance")
+			return
 		}
+		m.pool.TryAcquire(request.Fragment.OrganizationId)
+		m.startRequest(request, operation, release)
 	}
 }
 
-func (m *Manager) processRequest(request *pbDeploymentMgr.DeploymentFragmentRequest) error {
+// startRequest registers a request as running and processes it in background. This function must be called with
+// the queue lock held.
+//  params:
+//   request request to be processed
+//   operation requested
+//   release function to release the turn of the application instance once the request is done
+func (m *Manager) startRequest(request *pbDeploymentMgr.DeploymentFragmentRequest, operation structures.RequestOperation,
+	release func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.running[request.RequestId] = &runningRequest{request: request, cancel: cancel}
+	m.inFlight.Add(1)
+	go m.runRequest(ctx, request, operation, release)
+}
+
+// runRequest processes a request releasing its worker and the turn of its application instance when done.
+func (m *Manager) runRequest(ctx context.Context, request *pbDeploymentMgr.DeploymentFragmentRequest,
+	operation structures.RequestOperation, release func()) {
+	defer m.inFlight.Done()
+	defer release()
+	defer m.pool.Release(request.Fragment.OrganizationId)
+	defer func() {
+		m.queueMu.Lock()
+		if running, found := m.running[request.RequestId]; found {
+			running.cancel()
+			delete(m.running, request.RequestId)
+		}
+		m.queueMu.Unlock()
+	}()
+	if operation == structures.UpdateOperation {
+		m.processUpdate(ctx, request)
+		return
+	}
+	m.processRequest(ctx, request)
+}
+
+func (m *Manager) processRequest(ctx context.Context, request *pbDeploymentMgr.DeploymentFragmentRequest) error {
 	log.Debug().Msgf("execute plan with id %s", request.RequestId)
 
+	// Track the request as in-flight so durable queues can replay it if the process is interrupted
+	if err := m.queue.MarkInFlight(request.RequestId); err != nil {
+		log.Warn().Err(err).Str("requestId", request.RequestId).Msg("impossible to mark request as in-flight")
+	}
+	defer func() {
+		if err := m.queue.MarkDone(request.RequestId); err != nil {
+			log.Warn().Err(err).Str("requestId", request.RequestId).Msg("impossible to mark request as done")
+		}
+	}()
+
+	// The request may have been cancelled while it was waiting for its turn
+	if ctx.Err() != nil {
+		log.Info().Str("requestId", request.RequestId).Msg("request cancelled before starting")
+		m.setCancelledFragment(request, pendingNamespace(request))
+		return ctx.Err()
+	}
+
 	var executionError error
 
 	// Check the existence of a namespace for this app
@@ -137,27 +294,9 @@ func (m *Manager) processRequest(request *pbDeploymentMgr.DeploymentFragmentRequ
 	// namespace := common.GetNamespace(request.Fragment.OrganizationId, request.Fragment.AppInstanceId, int(request.NumRetry))
 
 	// Build a metadata object
-	metadata := entities.DeploymentMetadata{
-		Namespace:             namespace,
-		AppDescriptorId:       request.Fragment.AppDescriptorId,
-		AppDescriptorName:     request.Fragment.AppDescriptorName,
-		AppInstanceId:         request.Fragment.AppInstanceId,
-		ZtNetworkId:           request.ZtNetworkId,
-		OrganizationName:      request.Fragment.OrganizationName,
-		OrganizationId:        request.Fragment.OrganizationId,
-		DeploymentId:          request.Fragment.DeploymentId,
-		AppName:               request.Fragment.AppInstanceName,
-		NalejVariables:        request.Fragment.NalejVariables,
-		FragmentId:            request.Fragment.FragmentId,
-		DNSHosts:              m.dnsHosts,
-		ClusterPublicHostname: m.clusterPublicHostname,
-		// ----
-		PublicCredentials: m.PublicCredentials,
-		// Set stage in each iteration
-		//Stage:
-	}
+	metadata := m.getDeploymentMetadata(request, namespace)
 
-	preDeployable, executionError := m.executor.PrepareEnvironmentForDeployment(metadata, m.networkDecorator)
+	preDeployable, executionError := m.executor.PrepareEnvironmentForDeployment(ctx, metadata, m.networkDecorator)
 	if executionError != nil {
 		log.Error().Err(executionError).Msgf("failed environment preparation for fragment %s",
 			request.Fragment.FragmentId)
@@ -172,11 +311,14 @@ func (m *Manager) processRequest(request *pbDeploymentMgr.DeploymentFragmentRequ
 			log.Info().Msg("there is no information to undeploy the object!!")
 		}
 
-		m.monitored.SetEntryStatus(request.Fragment.FragmentId, entities.FRAGMENT_ERROR, executionError)
+		m.setFailedFragment(ctx, request, namespace, executionError)
 		return errors.New(fmt.Sprintf("failed environment preparation for fragment %s",
 			request.Fragment.FragmentId))
 	}
 
+	retryPolicy := m.retryPolicy.ForRequest(request)
+	log.Debug().Str("fragmentId", request.Fragment.FragmentId).Interface("retryPolicy", retryPolicy).Msg("retry policy")
+
 	for stageNumber, stage := range request.Fragment.Stages {
 		services := stage.Services
 		log.Info().Msgf("plan %d contains %d services to execute", stageNumber, len(services))
@@ -201,17 +343,14 @@ func (m *Manager) processRequest(request *pbDeploymentMgr.DeploymentFragmentRequ
 		switch request.RollbackPolicy {
 		case pbDeploymentMgr.RollbackPolicy_NONE:
 			log.Info().Msgf("rollback policy was set to %s, stop any deployment", request.RollbackPolicy)
-			executionError = m.deploymentLoopStage(request.Fragment, stage, deployable, namespace, NoRetries)
 		case pbDeploymentMgr.RollbackPolicy_ALWAYS_RETRY:
 			log.Info().Msgf("rollback policy was set to %s, retry until done", request.RollbackPolicy)
-			executionError = m.deploymentLoopStage(request.Fragment, stage, deployable, namespace, UnlimitedStageRetries)
 		case pbDeploymentMgr.RollbackPolicy_LIMITED_RETRY:
 			log.Info().Msgf("rollback policy was set to %s, retry limited times", request.RollbackPolicy)
-			executionError = m.deploymentLoopStage(request.Fragment, stage, deployable, namespace, MaxStageRetries)
 		default:
 			log.Warn().Msgf("unknown rollback policy %s, no rollback by default", request.RollbackPolicy)
-			executionError = m.deploymentLoopStage(request.Fragment, stage, deployable, namespace, NoRetries)
 		}
+		executionError = m.deploymentLoopStage(ctx, request.Fragment, stage, deployable, namespace, retryPolicy)
 		if executionError != nil {
 			log.Error().AnErr("error", executionError).Int("stageNumber", stageNumber).
 				Int("totalStages", len(request.Fragment.Stages)).Msg("error deploying stage")
@@ -222,7 +361,7 @@ func (m *Manager) processRequest(request *pbDeploymentMgr.DeploymentFragmentRequ
 			if err != nil {
 				log.Error().Err(err).Str("fragmentId", request.Fragment.FragmentId).Msgf("impossible to undeploy preparation for fragment")
 			}
-			m.monitored.SetEntryStatus(request.Fragment.FragmentId, entities.FRAGMENT_ERROR, executionError)
+			m.setFailedFragment(ctx, request, namespace, executionError)
 			return executionError
 		}
 
@@ -232,9 +371,449 @@ func (m *Manager) processRequest(request *pbDeploymentMgr.DeploymentFragmentRequ
 	return executionError
 }
 
-func (m *Manager) Execute(request *pbDeploymentMgr.DeploymentFragmentRequest) error {
+// Build the metadata of a fragment deployment. The stage is set for every stage when it is processed.
+func (m *Manager) getDeploymentMetadata(request *pbDeploymentMgr.DeploymentFragmentRequest, namespace string) entities.DeploymentMetadata {
+	return entities.DeploymentMetadata{
+		Namespace:             namespace,
+		AppDescriptorId:       request.Fragment.AppDescriptorId,
+		AppDescriptorName:     request.Fragment.AppDescriptorName,
+		AppInstanceId:         request.Fragment.AppInstanceId,
+		ZtNetworkId:           request.ZtNetworkId,
+		OrganizationName:      request.Fragment.OrganizationName,
+		OrganizationId:        request.Fragment.OrganizationId,
+		DeploymentId:          request.Fragment.DeploymentId,
+		AppName:               request.Fragment.AppInstanceName,
+		NalejVariables:        request.Fragment.NalejVariables,
+		FragmentId:            request.Fragment.FragmentId,
+		DNSHosts:              m.dnsHosts,
+		ClusterPublicHostname: m.clusterPublicHostname,
+		// ----
+		PublicCredentials: m.PublicCredentials,
+		RolloutStrategies: entities.NewRolloutStrategiesFromGRPC(request.RolloutStrategies),
+	}
+}
+
+// Render the native objects the deployment of a fragment would create without applying them.
+//  params:
+//   request deployment request
+//  return:
+//   rendered fragment or error if any
+func (m *Manager) RenderFragment(request *pbDeploymentMgr.DeploymentFragmentRequest) (*pbDeploymentMgr.RenderedFragment, derrors.Error) {
+	namespace, err := m.executor.GetApplicationNamespace(request.Fragment.OrganizationId, request.Fragment.AppInstanceId, int(request.NumRetry))
+	if err != nil {
+		return nil, derrors.AsError(err, "impossible to find a valid namespace name")
+	}
+	metadata := m.getDeploymentMetadata(request, namespace)
+	manifests, err := m.executor.RenderFragment(metadata, request.Fragment.Stages, m.networkDecorator, m.sfClient)
+	if err != nil {
+		return nil, derrors.NewInvalidArgumentError("impossible to render fragment", err).WithParams(request.Fragment.FragmentId)
+	}
+	return &pbDeploymentMgr.RenderedFragment{
+		RequestId:  request.RequestId,
+		FragmentId: request.Fragment.FragmentId,
+		Namespace:  namespace,
+		Manifests:  manifests,
+	}, nil
+}
+
+// Update in place a running fragment. The update is queued as the deployments and it is done once a worker is
+// free and any other operation over the application instance finishes. The status of the updated services is
+// reported by the monitor as Kubernetes rolls out the changes.
+//  params:
+//   request deployment request with the new version of the fragment
+//  return:
+//   error if the fragment is not running or the update cannot be accepted
+func (m *Manager) UpdateFragment(request *pbDeploymentMgr.DeploymentFragmentRequest) derrors.Error {
+	if _, err := m.getUpdatableFragment(request); err != nil {
+		return err
+	}
+
+	m.queueMu.Lock()
+	defer m.queueMu.Unlock()
+	if m.shuttingDown {
+		return derrors.NewUnavailableError("deployment manager is shutting down")
+	}
+	if m.maxQueueLength > 0 && m.queue.Len() >= m.maxQueueLength {
+		return derrors.NewResourceExhaustedError("deployment requests queue is full").WithParams(m.maxQueueLength)
+	}
+	err := m.queue.PushOperation(request, structures.UpdateOperation)
+	if err != nil {
+		if dErr, ok := err.(derrors.Error); ok {
+			return dErr
+		}
+		return derrors.AsError(err, "impossible to queue update request")
+	}
+	return nil
+}
+
+// Get the monitored entry of a fragment to be updated checking that it can be updated.
+func (m *Manager) getUpdatableFragment(request *pbDeploymentMgr.DeploymentFragmentRequest) (*entities.MonitoredAppEntry, derrors.Error) {
+	entry := m.monitored.GetEntryCopy(request.Fragment.FragmentId)
+	if entry == nil {
+		return nil, derrors.NewNotFoundError("deployment fragment not found").WithParams(request.Fragment.FragmentId)
+	}
+	if entry.AppInstanceId != request.Fragment.AppInstanceId || entry.OrganizationId != request.Fragment.OrganizationId {
+		return nil, derrors.NewInvalidArgumentError("deployment fragment belongs to a different application").
+			WithParams(request.Fragment.FragmentId, request.Fragment.AppInstanceId)
+	}
+	if entities.IsFragmentRemoved(entry.Status) {
+		return nil, derrors.NewFailedPreconditionError("deployment fragment is being removed").WithParams(request.Fragment.FragmentId)
+	}
+	if entry.Status == entities.FRAGMENT_SUSPENDED {
+		return nil, derrors.NewFailedPreconditionError("application is suspended").WithParams(request.Fragment.AppInstanceId)
+	}
+	return entry, nil
+}
+
+func (m *Manager) processUpdate(ctx context.Context, request *pbDeploymentMgr.DeploymentFragmentRequest) error {
+	log.Info().Str("requestId", request.RequestId).Str("fragmentId", request.Fragment.FragmentId).Msg("update fragment")
+
+	// Track the request as in-flight so durable queues can replay it if the process is interrupted
+	if err := m.queue.MarkInFlight(request.RequestId); err != nil {
+		log.Warn().Err(err).Str("requestId", request.RequestId).Msg("impossible to mark request as in-flight")
+	}
+	defer func() {
+		if err := m.queue.MarkDone(request.RequestId); err != nil {
+			log.Warn().Err(err).Str("requestId", request.RequestId).Msg("impossible to mark request as done")
+		}
+	}()
+
+	if ctx.Err() != nil {
+		log.Info().Str("requestId", request.RequestId).Msg("update cancelled before starting")
+		return ctx.Err()
+	}
+
+	// the fragment may have changed while the update was waiting in the queue
+	entry, dErr := m.getUpdatableFragment(request)
+	if dErr != nil {
+		log.Warn().Str("requestId", request.RequestId).Str("err", dErr.DebugReport()).Msg("update request discarded")
+		return dErr
+	}
+
+	// services added by the new version are monitored before their resources are created
+	for _, stage := range request.Fragment.Stages {
+		m.monitored.AddEntry(m.getMonitoringData(entry.Namespace, stage, request.Fragment))
+	}
+
+	metadata := m.getDeploymentMetadata(request, entry.Namespace)
+	err := m.executor.UpdateFragment(ctx, metadata, request.Fragment.Stages, m.networkDecorator, m.sfClient)
+	if err != nil {
+		if ctx.Err() != nil {
+			// the objects updated so far are kept, their status is reported by the monitor
+			log.Info().Str("requestId", request.RequestId).Str("fragmentId", request.Fragment.FragmentId).
+				Msg("update cancelled")
+			return err
+		}
+		log.Error().Err(err).Str("fragmentId", request.Fragment.FragmentId).Msg("impossible to update fragment")
+		m.monitored.SetEntryStatus(request.Fragment.FragmentId, entities.FRAGMENT_ERROR, err)
+		return err
+	}
+	log.Info().Str("requestId", request.RequestId).Str("fragmentId", request.Fragment.FragmentId).Msg("fragment updated")
+	return nil
+}
+
+// Promote the new version of a service being rolled out by a fragment update.
+//  params:
+//   request identifying the service
+//  return:
+//   error if there is no rollout in progress or its candidate is not ready
+func (m *Manager) PromoteRollout(request *pbDeploymentMgr.RolloutRequest) derrors.Error {
+	if err := m.checkRolloutFragment(request); err != nil {
+		return err
+	}
+	return m.executor.PromoteRollout(request.DeploymentFragmentId, request.ServiceInstanceId)
+}
+
+// Abort the rollout of a service started by a fragment update.
+//  params:
+//   request identifying the service
+//  return:
+//   error if there is no rollout in progress or it is being promoted
+func (m *Manager) AbortRollout(request *pbDeploymentMgr.RolloutRequest) derrors.Error {
+	if err := m.checkRolloutFragment(request); err != nil {
+		return err
+	}
+	return m.executor.AbortRollout(request.DeploymentFragmentId, request.ServiceInstanceId)
+}
+
+// Check the fragment of a rollout request belongs to the application of the request.
+func (m *Manager) checkRolloutFragment(request *pbDeploymentMgr.RolloutRequest) derrors.Error {
+	entry := m.monitored.GetEntryCopy(request.DeploymentFragmentId)
+	if entry == nil {
+		return derrors.NewNotFoundError("deployment fragment not found").WithParams(request.DeploymentFragmentId)
+	}
+	if entry.AppInstanceId != request.AppInstanceId || entry.OrganizationId != request.OrganizationId {
+		return derrors.NewInvalidArgumentError("deployment fragment belongs to a different application").
+			WithParams(request.DeploymentFragmentId, request.AppInstanceId)
+	}
+	return nil
+}
+
+// Set the number of replicas of a service of a running application.
+//  params:
+//   ctx context of the call
+//   request with the service and its new number of replicas
+//  return:
+//   error if the service is not running or it cannot be scaled
+func (m *Manager) ScaleService(ctx context.Context, request *pbDeploymentMgr.ScaleServiceRequest) derrors.Error {
+	release, err := m.coordinator.Acquire(ctx, request.AppInstanceId, "", OperationScale)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	entry, err := m.getServiceFragment(request.OrganizationId, request.AppInstanceId, request.ServiceInstanceId)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("appInstanceId", request.AppInstanceId).Str("serviceInstanceId", request.ServiceInstanceId).
+		Int32("replicas", request.Replicas).Msg("scale service")
+	return m.executor.ScaleService(entry.Namespace, request.ServiceInstanceId, request.Replicas)
+}
+
+// Restart all the replicas of a service of a running application.
+//  params:
+//   ctx context of the call
+//   request with the service to be restarted
+//  return:
+//   error if the service is not running or it cannot be restarted
+func (m *Manager) RestartService(ctx context.Context, request *pbDeploymentMgr.RestartServiceRequest) derrors.Error {
+	release, err := m.coordinator.Acquire(ctx, request.AppInstanceId, "", OperationRestart)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	entry, err := m.getServiceFragment(request.OrganizationId, request.AppInstanceId, request.ServiceInstanceId)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("appInstanceId", request.AppInstanceId).Str("serviceInstanceId", request.ServiceInstanceId).
+		Msg("restart service")
+	return m.executor.RestartService(entry.Namespace, request.ServiceInstanceId)
+}
+
+// Suspend an application scaling down all its workloads to zero. The namespace, the storage and the network
+// configuration of the application are kept so it can be resumed later.
+//  params:
+//   ctx context of the call
+//   request identifying the application
+//  return:
+//   error if the application is not deployed or it cannot be suspended
+func (m *Manager) SuspendApplication(ctx context.Context, request *grpc_application_go.AppInstanceId) derrors.Error {
+	release, err := m.coordinator.Acquire(ctx, request.AppInstanceId, "", OperationSuspend)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	entries, err := m.getAppFragments(request.OrganizationId, request.AppInstanceId)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Status == entities.FRAGMENT_SUSPENDED {
+			return derrors.NewFailedPreconditionError("application is already suspended").WithParams(request.AppInstanceId)
+		}
+		if entry.Status != entities.FRAGMENT_DONE && entry.Status != entities.FRAGMENT_ERROR {
+			return derrors.NewFailedPreconditionError("application is being deployed").WithParams(request.AppInstanceId, entry.FragmentId)
+		}
+	}
+	// all the fragments of an application share the same namespace
+	entry := entries[0]
+
+	log.Info().Str("appInstanceId", request.AppInstanceId).Str("namespace", entry.Namespace).Msg("suspend application")
+	err = m.executor.SuspendNamespace(entry.Namespace)
+	if err != nil {
+		// restore the workloads suspended before the failure
+		if rErr := m.executor.ResumeNamespace(entry.Namespace); rErr != nil {
+			log.Error().Str("err", rErr.DebugReport()).Str("appInstanceId", request.AppInstanceId).
+				Msg("impossible to resume partially suspended application")
+		}
+		return err
+	}
+	m.monitored.SetAppStatus(request.AppInstanceId, entities.FRAGMENT_SUSPENDED, nil)
+	return nil
+}
+
+// Resume a suspended application restoring the number of replicas its workloads had before being suspended.
+//  params:
+//   ctx context of the call
+//   request identifying the application
+//  return:
+//   error if the application is not suspended or it cannot be resumed
+func (m *Manager) ResumeApplication(ctx context.Context, request *grpc_application_go.AppInstanceId) derrors.Error {
+	release, err := m.coordinator.Acquire(ctx, request.AppInstanceId, "", OperationResume)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	entries, err := m.getAppFragments(request.OrganizationId, request.AppInstanceId)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.Status != entities.FRAGMENT_SUSPENDED {
+			return derrors.NewFailedPreconditionError("application is not suspended").WithParams(request.AppInstanceId, entry.FragmentId)
+		}
+	}
+	entry := entries[0]
+
+	log.Info().Str("appInstanceId", request.AppInstanceId).Str("namespace", entry.Namespace).Msg("resume application")
+	// the application is deploying until its resources are found running again
+	m.monitored.SetAppStatus(request.AppInstanceId, entities.FRAGMENT_DEPLOYING, nil)
+	err = m.executor.ResumeNamespace(entry.Namespace)
+	if err != nil {
+		// the workloads not resumed yet keep their replicas annotated, the resume can be requested again
+		m.monitored.SetAppStatus(request.AppInstanceId, entities.FRAGMENT_SUSPENDED, err)
+		return err
+	}
+	return nil
+}
+
+// Get the monitored fragments of an application.
+func (m *Manager) getAppFragments(organizationId string, appInstanceId string) ([]*entities.MonitoredAppEntry, derrors.Error) {
+	entries := make([]*entities.MonitoredAppEntry, 0)
+	for _, entry := range m.monitored.ListEntries() {
+		if entry.OrganizationId != organizationId || entry.AppInstanceId != appInstanceId {
+			continue
+		}
+		if entities.IsFragmentRemoved(entry.Status) {
+			return nil, derrors.NewFailedPreconditionError("application is being removed").WithParams(appInstanceId)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return nil, derrors.NewNotFoundError("application instance not found").WithParams(appInstanceId)
+	}
+	return entries, nil
+}
+
+// Get the monitored fragment a service instance of an application belongs to.
+func (m *Manager) getServiceFragment(organizationId string, appInstanceId string, serviceInstanceId string) (*entities.MonitoredAppEntry, derrors.Error) {
+	for _, entry := range m.monitored.ListEntries() {
+		if entry.OrganizationId != organizationId || entry.AppInstanceId != appInstanceId {
+			continue
+		}
+		if _, found := entry.Services[serviceInstanceId]; !found {
+			continue
+		}
+		if entities.IsFragmentRemoved(entry.Status) {
+			return nil, derrors.NewFailedPreconditionError("deployment fragment is being removed").WithParams(entry.FragmentId)
+		}
+		if entry.Status == entities.FRAGMENT_SUSPENDED {
+			return nil, derrors.NewFailedPreconditionError("application is suspended").WithParams(appInstanceId)
+		}
+		return entry, nil
+	}
+	return nil, derrors.NewNotFoundError("service instance not found").WithParams(appInstanceId, serviceInstanceId)
+}
+
+// Set the status of a fragment that could not be deployed. Fragments whose context was cancelled are reported
+// as cancelled instead of failed.
+func (m *Manager) setFailedFragment(ctx context.Context, request *pbDeploymentMgr.DeploymentFragmentRequest,
+	namespace string, err error) {
+	if ctx.Err() != nil {
+		m.setCancelledFragment(request, namespace)
+		return
+	}
+	m.monitored.SetEntryStatus(request.Fragment.FragmentId, entities.FRAGMENT_ERROR, err)
+}
+
+// Report a fragment as cancelled. The services of the stages not monitored yet are added so conductor is
+// notified about every service of the fragment.
+func (m *Manager) setCancelledFragment(request *pbDeploymentMgr.DeploymentFragmentRequest, namespace string) {
+	for _, stage := range request.Fragment.Stages {
+		m.monitored.AddEntry(m.getMonitoringData(namespace, stage, request.Fragment))
+	}
+	m.monitored.SetEntryStatus(request.Fragment.FragmentId, entities.FRAGMENT_CANCELLED, ErrDeploymentCancelled)
+}
+
+// Cancel a deployment request. Running requests are stopped and the objects created so far removed. Requests
+// waiting in the queue are discarded.
+//  params:
+//   request cancel request identifying the deployment request or its fragment
+//  return:
+//   error if the request was not found
+func (m *Manager) CancelDeployment(request *pbDeploymentMgr.CancelDeploymentRequest) derrors.Error {
+	match := func(req *pbDeploymentMgr.DeploymentFragmentRequest) bool {
+		if req.Fragment == nil || req.Fragment.OrganizationId != request.OrganizationId {
+			return false
+		}
+		return (request.RequestId != "" && req.RequestId == request.RequestId) ||
+			(request.DeploymentFragmentId != "" && req.Fragment.FragmentId == request.DeploymentFragmentId)
+	}
+
+	m.queueMu.Lock()
+	defer m.queueMu.Unlock()
+
+	if m.cancelRunningRequests(match) > 0 {
+		return nil
+	}
+
+	queued, operation := m.queue.RemoveRequest(match)
+	if queued != nil {
+		m.discardCancelledRequest(queued, operation)
+		return nil
+	}
+
+	return derrors.NewNotFoundError("deployment request not found").WithParams(request.RequestId, request.DeploymentFragmentId)
+}
+
+// Cancel the running requests matching a condition. The cancelled requests stop and remove the objects created so
+// far. This function must be called with the queue lock held.
+//  params:
+//   match function returning true for the requests to be cancelled
+//  return:
+//   number of requests cancelled
+func (m *Manager) cancelRunningRequests(match func(req *pbDeploymentMgr.DeploymentFragmentRequest) bool) int {
+	cancelled := 0
+	for _, running := range m.running {
+		if match(running.request) {
+			log.Info().Str("requestId", running.request.RequestId).Str("fragmentId", running.request.Fragment.FragmentId).
+				Msg("cancel running deployment")
+			running.cancel()
+			cancelled++
+		}
+	}
+	return cancelled
+}
+
+// Discard a cancelled request that was not started. The fragment of a cancelled update keeps running.
+func (m *Manager) discardCancelledRequest(request *pbDeploymentMgr.DeploymentFragmentRequest, operation structures.RequestOperation) {
+	log.Info().Str("requestId", request.RequestId).Str("fragmentId", request.Fragment.FragmentId).
+		Str("operation", structures.RequestOperationToString[operation]).Msg("cancel queued request")
+	if err := m.queue.MarkDone(request.RequestId); err != nil {
+		log.Warn().Err(err).Str("requestId", request.RequestId).Msg("impossible to mark request as done")
+	}
+	if operation == structures.DeployOperation {
+		m.setCancelledFragment(request, pendingNamespace(request))
+	}
+}
+
+// Namespace computed for a fragment whose deployment did not start. The namespace may not exist.
+func pendingNamespace(request *pbDeploymentMgr.DeploymentFragmentRequest) string {
+	return common.GetNamespace(request.Fragment.OrganizationId, request.Fragment.AppInstanceId, int(request.NumRetry))
+}
+
+func (m *Manager) Execute(request *pbDeploymentMgr.DeploymentFragmentRequest) derrors.Error {
+	m.queueMu.Lock()
+	defer m.queueMu.Unlock()
+	if m.shuttingDown {
+		return derrors.NewUnavailableError("deployment manager is shutting down")
+	}
+	if m.maxQueueLength > 0 && m.queue.Len() >= m.maxQueueLength {
+		return derrors.NewResourceExhaustedError("deployment requests queue is full").WithParams(m.maxQueueLength)
+	}
 	// push the request to the queue
-	m.queue.PushRequest(request)
+	err := m.queue.PushRequest(request)
+	if err != nil {
+		if dErr, ok := err.(derrors.Error); ok {
+			return dErr
+		}
+		return derrors.AsError(err, "impossible to queue deployment request")
+	}
 	return nil
 }
 
@@ -256,8 +835,10 @@ func (m *Manager) expireLogs(organizationId string, appInstanceId string) {
 
 // checkNamespaceToExpireLogs runs a loop to check when the application namespace is terminated
 func (m *Manager) checkNamespaceToExpireLogs(request *pbDeploymentMgr.UndeployRequest) {
+	defer m.background.Done()
 
 	sleep := time.NewTicker(CheckSleepTime)
+	defer sleep.Stop()
 
 	ctxExpire, cancelExpire := context.WithTimeout(context.Background(), ExpireTimeout)
 	defer cancelExpire()
@@ -272,9 +853,11 @@ func (m *Manager) checkNamespaceToExpireLogs(request *pbDeploymentMgr.UndeployRe
 			}
 			if !exists {
 				m.expireLogs(request.OrganizationId, request.AppInstanceId)
-				sleep.Stop()
 				return
 			}
+		case <-m.ctx.Done():
+			log.Warn().Str("organizationID", request.OrganizationId).Str("instanceID", request.AppInstanceId).Msg("Unable to expire logs, shutting down")
+			return
 		case <-ctxExpire.Done():
 			log.Warn().Str("organizationID", request.OrganizationId).Str("instanceID", request.AppInstanceId).Msg("Unable to expire logs, context deadline")
 			return
@@ -283,14 +866,31 @@ func (m *Manager) checkNamespaceToExpireLogs(request *pbDeploymentMgr.UndeployRe
 
 }
 
-func (m *Manager) Undeploy(request *pbDeploymentMgr.UndeployRequest) error {
+// Undeploy an application. The queued and running deployments and updates of the application are cancelled so the
+// undeploy does not wait for them.
+//  params:
+//   ctx context of the call
+//   request identifying the application
+//  return:
+//   error if any
+func (m *Manager) Undeploy(ctx context.Context, request *pbDeploymentMgr.UndeployRequest) error {
 	log.Debug().Str("appInstanceID", request.AppInstanceId).Msg("undeploy app instance with id")
 
+	m.discardRequests(func(req *pbDeploymentMgr.DeploymentFragmentRequest) bool {
+		return req.Fragment.OrganizationId == request.OrganizationId && req.Fragment.AppInstanceId == request.AppInstanceId
+	})
+	release, aErr := m.coordinator.Acquire(ctx, request.AppInstanceId, "", OperationUndeploy)
+	if aErr != nil {
+		return aErr
+	}
+	defer release()
+
 	// Undeploy the namespace
 	err := m.executor.UndeployNamespace(request, m.networkDecorator)
 	// set the requested application as terminating
 	m.monitored.SetAppStatus(request.AppInstanceId, entities.FRAGMENT_TERMINATING, nil)
 
+	m.background.Add(1)
 	go m.checkNamespaceToExpireLogs(request)
 
 	if err != nil {
@@ -301,7 +901,23 @@ func (m *Manager) Undeploy(request *pbDeploymentMgr.UndeployRequest) error {
 	return nil
 }
 
-func (m *Manager) UndeployFragment(request *pbDeploymentMgr.UndeployFragmentRequest) error {
+// Undeploy a fragment. The queued and running deployments and updates of the fragment are cancelled so the undeploy
+// does not wait for them.
+//  params:
+//   ctx context of the call
+//   request identifying the fragment
+//  return:
+//   error if any
+func (m *Manager) UndeployFragment(ctx context.Context, request *pbDeploymentMgr.UndeployFragmentRequest) error {
+	m.discardRequests(func(req *pbDeploymentMgr.DeploymentFragmentRequest) bool {
+		return req.Fragment.OrganizationId == request.OrganizationId && req.Fragment.FragmentId == request.DeploymentFragmentId
+	})
+	release, err := m.coordinator.Acquire(ctx, request.AppInstanceId, request.DeploymentFragmentId, OperationUndeployFragment)
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// set this fragment as terminating
 	entry := m.monitored.GetEntry(request.DeploymentFragmentId)
 	if entry == nil {
@@ -328,23 +944,53 @@ func (m *Manager) UndeployFragment(request *pbDeploymentMgr.UndeployFragmentRequ
 	return undeployErr
 }
 
+// Discard the queued requests made useless by an undeploy so they cannot start after it, and cancel the running
+// ones so the undeploy does not wait for them.
+//  params:
+//   match function returning true for the requests to be discarded
+func (m *Manager) discardRequests(match func(req *pbDeploymentMgr.DeploymentFragmentRequest) bool) {
+	m.queueMu.Lock()
+	defer m.queueMu.Unlock()
+	// removed requests are not replayed by durable queues
+	for request, operation := m.queue.RemoveRequest(match); request != nil; request, operation = m.queue.RemoveRequest(match) {
+		log.Info().Str("requestId", request.RequestId).Str("fragmentId", request.Fragment.FragmentId).
+			Str("operation", structures.RequestOperationToString[operation]).Msg("queued request discarded by undeploy")
+	}
+	m.cancelRunningRequests(match)
+}
+
 // Private function to execute a stage in a loop of retries.
 //  params:
+//   ctx context to cancel the deployment
 //   fragment this stage belongs to
 //   stage to be executed
 //   toDeploy deployable objects
 //   namespace name of the target namespace
-//   maxRetries is the maximum number of retries to be done, -1 to retry indefinitely
+//   policy with the number of attempts and the time to wait between them
 //  return:
 //   error if any
-func (m *Manager) deploymentLoopStage(fragment *pbConductor.DeploymentFragment, stage *pbConductor.DeploymentStage,
-	toDeploy executor.Deployable, namespace string, maxRetries int) error {
+func (m *Manager) deploymentLoopStage(ctx context.Context, fragment *pbConductor.DeploymentFragment, stage *pbConductor.DeploymentStage,
+	toDeploy executor.Deployable, namespace string, policy RetryPolicy) error {
+
+	stageCheckTimeout := int(policy.StageCheckTimeout / time.Second)
+	// only the failures of the services of this stage finish the wait
+	stageServices := make([]string, 0, len(stage.Services))
+	for _, service := range stage.Services {
+		stageServices = append(stageServices, service.ServiceInstanceId)
+	}
 
 	// something happened. We reach the retry loop
-	for retries := 0; retries < maxRetries; retries++ {
-		m.monitored.SetEntryStatus(fragment.FragmentId, entities.FRAGMENT_DEPLOYING, nil)
+	for attempt := 1; ; attempt++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		var attemptInfo error
+		if attempt > 1 {
+			attemptInfo = errors.New(policy.AttemptInfo(attempt))
+		}
+		m.monitored.SetEntryStatus(fragment.FragmentId, entities.FRAGMENT_DEPLOYING, attemptInfo)
 
-		err := m.executor.DeployStage(toDeploy, fragment, stage)
+		err := m.executor.DeployStage(ctx, toDeploy, fragment, stage)
 
 		if err != nil {
 			log.Error().Err(err).Msgf("there was a problem when retrying stage %s from fragment %s",
@@ -355,7 +1001,7 @@ func (m *Manager) deploymentLoopStage(fragment *pbConductor.DeploymentFragment,
 		log.Info().Str("namespace", namespace).Str("fragmentIdappInstanceId", fragment.AppInstanceId).
 			Str("fragmentId", fragment.FragmentId).
 			Str("stage", stage.StageId).Msg("wait for pending checks to finish")
-		stageErr := m.monitored.WaitPendingChecks(fragment.FragmentId, StageCheckTime, StageCheckTimeout)
+		stageErr := m.monitored.WaitPendingChecks(ctx, fragment.FragmentId, stageServices, stageCheckTimeout)
 		log.Debug().Msg("Finished waiting for pending checks")
 
 		if stageErr == nil {
@@ -371,10 +1017,27 @@ func (m *Manager) deploymentLoopStage(fragment *pbConductor.DeploymentFragment,
 			return err
 		}
 
-		log.Info().Msgf("failed retry %d out of %d for stage %s in fragment %s", retries+1, maxRetries, stage.StageId, fragment.FragmentId)
+		log.Info().Msgf("failed %s for stage %s in fragment %s", policy.AttemptInfo(attempt), stage.StageId, fragment.FragmentId)
+		if !policy.CanRetry(attempt) {
+			break
+		}
+		// the resources of the undeployed stage must not fail the next attempt
+		for _, serviceInstanceId := range stageServices {
+			m.monitored.RemoveService(fragment.FragmentId, serviceInstanceId)
+		}
+		m.monitored.AddEntry(m.getMonitoringData(namespace, stage, fragment))
 
 		// It didn't work. Go into a retry loop
-		time.Sleep(SleepBetweenRetries * time.Millisecond)
+		backoff := policy.Backoff(attempt)
+		m.monitored.SetEntryStatus(fragment.FragmentId, entities.FRAGMENT_RETRYING,
+			fmt.Errorf("%s failed, next retry at %s: %s", policy.AttemptInfo(attempt),
+				time.Now().Add(backoff).Format(time.RFC3339), stageErr.Error()))
+		select {
+		case <-ctx.Done():
+			log.Info().Str("fragmentId", fragment.FragmentId).Str("stage", stage.StageId).Msg("stage deployment cancelled")
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
 	}
 
 	return errors.New(fmt.Sprintf("exceeded number of retries for stage %s in fragment %s", stage.StageId, fragment.FragmentId))
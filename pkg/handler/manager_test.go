@@ -0,0 +1,230 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package handler
+
+import (
+	"context"
+	"fmt"
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/deployment-manager/internal/structures"
+	"github.com/nalej/deployment-manager/internal/structures/monitor"
+	"github.com/nalej/deployment-manager/pkg/common"
+	"github.com/nalej/derrors"
+	pbConductor "github.com/nalej/grpc-conductor-go"
+	pbDeploymentMgr "github.com/nalej/grpc-deployment-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"time"
+)
+
+// Build a manager with the structures needed to queue and dispatch requests.
+func newTestManager() *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Manager{
+		queue:       structures.NewMemoryRequestQueue(),
+		pool:        NewWorkerPool(1, 0),
+		coordinator: NewOperationCoordinator(),
+		running:     make(map[string]*runningRequest, 0),
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+var _ = ginkgo.Describe("manager shutdown", func() {
+
+	request := &pbDeploymentMgr.DeploymentFragmentRequest{
+		RequestId: "request",
+		Fragment:  &pbConductor.DeploymentFragment{FragmentId: "fragment", OrganizationId: "org", AppInstanceId: "app"},
+	}
+
+	ginkgo.It("should reject requests once shutting down", func() {
+		m := newTestManager()
+		m.Shutdown(context.Background())
+		err := m.Execute(request)
+		gomega.Expect(err).NotTo(gomega.BeNil())
+		// the dispatcher does not start queued requests either
+		m.dispatchRequests()
+		gomega.Expect(m.pool.Running()).To(gomega.Equal(0))
+	})
+
+	ginkgo.It("should stop the dispatcher", func() {
+		m := newTestManager()
+		stopped := make(chan struct{})
+		go func() {
+			m.Run()
+			close(stopped)
+		}()
+		m.Shutdown(context.Background())
+		gomega.Eventually(stopped).Should(gomega.BeClosed())
+	})
+
+	ginkgo.It("should wait for running requests until the deadline", func() {
+		m := newTestManager()
+		m.inFlight.Add(1)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		m.Shutdown(ctx)
+		gomega.Expect(time.Since(start)).To(gomega.BeNumerically(">=", 50*time.Millisecond))
+		m.inFlight.Done()
+	})
+})
+
+var _ = ginkgo.Describe("manager undeploy", func() {
+
+	ginkgo.It("should discard the queued requests of the undeployed application", func() {
+		m := newTestManager()
+		for i, appInstanceId := range []string{"app1", "app2", "app1"} {
+			gomega.Expect(m.Execute(&pbDeploymentMgr.DeploymentFragmentRequest{
+				RequestId: fmt.Sprintf("request-%d", i),
+				Fragment: &pbConductor.DeploymentFragment{FragmentId: fmt.Sprintf("fragment-%d", i),
+					OrganizationId: "org", AppInstanceId: appInstanceId},
+			})).To(gomega.Succeed())
+		}
+		m.discardRequests(func(req *pbDeploymentMgr.DeploymentFragmentRequest) bool {
+			return req.Fragment.AppInstanceId == "app1"
+		})
+		gomega.Expect(m.queue.Len()).To(gomega.Equal(1))
+		gomega.Expect(m.queue.NextRequest().RequestId).To(gomega.Equal("request-1"))
+	})
+
+	ginkgo.It("should cancel the running deployments of the undeployed fragment", func() {
+		m := newTestManager()
+		m.monitored = monitor.NewMemoryMonitoredInstances()
+		request := &pbDeploymentMgr.DeploymentFragmentRequest{
+			RequestId: "request",
+			Fragment:  &pbConductor.DeploymentFragment{FragmentId: "fragment", OrganizationId: "org", AppInstanceId: "app"},
+		}
+		release, acquired := m.coordinator.TryAcquire("app", "fragment", OperationDeploy)
+		gomega.Expect(acquired).To(gomega.BeTrue())
+		ctx, cancel := context.WithCancel(context.Background())
+		m.running[request.RequestId] = &runningRequest{request: request, cancel: cancel}
+		// the running deployment releases the turn of the application once cancelled
+		go func() {
+			<-ctx.Done()
+			release()
+		}()
+
+		err := m.UndeployFragment(context.Background(), &pbDeploymentMgr.UndeployFragmentRequest{
+			OrganizationId: "org", AppInstanceId: "app", DeploymentFragmentId: "fragment"})
+		// the fragment was not monitored yet
+		gomega.Expect(err).NotTo(gomega.BeNil())
+		gomega.Expect(ctx.Err()).To(gomega.Equal(context.Canceled))
+	})
+
+	ginkgo.It("should stop waiting for the application when the undeploy call is done", func() {
+		m := newTestManager()
+		m.monitored = monitor.NewMemoryMonitoredInstances()
+		release, acquired := m.coordinator.TryAcquire("app", "other", OperationUpdate)
+		gomega.Expect(acquired).To(gomega.BeTrue())
+		defer release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := m.UndeployFragment(ctx, &pbDeploymentMgr.UndeployFragmentRequest{
+			OrganizationId: "org", AppInstanceId: "app", DeploymentFragmentId: "fragment"})
+		gomega.Expect(err).NotTo(gomega.BeNil())
+		gomega.Expect(err.(derrors.Error).Type()).To(gomega.Equal(derrors.DeadlineExceeded))
+	})
+})
+
+var _ = ginkgo.Describe("manager cancel", func() {
+
+	ginkgo.It("should monitor the queued deployments cancelled in the namespace of the application", func() {
+		m := newTestManager()
+		m.monitored = monitor.NewMemoryMonitoredInstances()
+		request := &pbDeploymentMgr.DeploymentFragmentRequest{
+			RequestId: "request",
+			Fragment: &pbConductor.DeploymentFragment{FragmentId: "fragment", OrganizationId: "organization-012345678",
+				AppInstanceId: "app", Stages: []*pbConductor.DeploymentStage{{StageId: "stage"}}},
+		}
+		gomega.Expect(m.Execute(request)).To(gomega.Succeed())
+		gomega.Expect(m.CancelDeployment(&pbDeploymentMgr.CancelDeploymentRequest{
+			OrganizationId: "organization-012345678",
+			RequestId:      "request",
+		})).To(gomega.Succeed())
+		entry := m.monitored.GetEntry("fragment")
+		gomega.Expect(entry.Status).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_CANCELLED)))
+		gomega.Expect(entry.Namespace).To(gomega.Equal(common.GetNamespace("organization-012345678", "app", 0)))
+	})
+})
+
+var _ = ginkgo.Describe("manager update", func() {
+
+	var m *Manager
+	request := &pbDeploymentMgr.DeploymentFragmentRequest{
+		RequestId: "update",
+		Fragment:  &pbConductor.DeploymentFragment{FragmentId: "fragment", OrganizationId: "org", AppInstanceId: "app"},
+	}
+
+	ginkgo.BeforeEach(func() {
+		m = newTestManager()
+		m.monitored = monitor.NewMemoryMonitoredInstances()
+		m.monitored.AddEntry(&entities.MonitoredAppEntry{FragmentId: "fragment", OrganizationId: "org",
+			AppInstanceId: "app", Namespace: "ns", Status: entities.FRAGMENT_DONE,
+			Services: make(map[string]*entities.MonitoredServiceEntry, 0)})
+	})
+
+	ginkgo.It("should queue the updates of running fragments", func() {
+		gomega.Expect(m.UpdateFragment(request)).To(gomega.Succeed())
+		gomega.Expect(m.UpdateFragment(&pbDeploymentMgr.DeploymentFragmentRequest{
+			RequestId: "unknown",
+			Fragment:  &pbConductor.DeploymentFragment{FragmentId: "unknown", OrganizationId: "org", AppInstanceId: "app"},
+		})).NotTo(gomega.Succeed())
+
+		gomega.Expect(m.queue.Len()).To(gomega.Equal(1))
+		next, operation := m.queue.NextMatchingRequest(func(req *pbDeploymentMgr.DeploymentFragmentRequest,
+			operation structures.RequestOperation) bool {
+			return true
+		})
+		gomega.Expect(next.RequestId).To(gomega.Equal("update"))
+		gomega.Expect(operation).To(gomega.Equal(structures.UpdateOperation))
+	})
+
+	ginkgo.It("should keep the fragment running when a queued update is cancelled", func() {
+		gomega.Expect(m.UpdateFragment(request)).To(gomega.Succeed())
+		gomega.Expect(m.CancelDeployment(&pbDeploymentMgr.CancelDeploymentRequest{
+			OrganizationId: "org",
+			RequestId:      "update",
+		})).To(gomega.Succeed())
+		gomega.Expect(m.queue.Len()).To(gomega.Equal(0))
+		gomega.Expect(m.monitored.GetEntry("fragment").Status).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_DONE)))
+	})
+})
+
+var _ = ginkgo.Describe("manager service operations", func() {
+
+	ginkgo.It("should wait for the running operations of the application", func() {
+		m := newTestManager()
+		m.monitored = monitor.NewMemoryMonitoredInstances()
+		release, err := m.coordinator.Acquire(context.Background(), "app", "fragment", OperationUpdate)
+		gomega.Expect(err).To(gomega.BeNil())
+
+		done := make(chan struct{})
+		go func() {
+			defer ginkgo.GinkgoRecover()
+			defer close(done)
+			err := m.ScaleService(context.Background(), &pbDeploymentMgr.ScaleServiceRequest{OrganizationId: "org", AppInstanceId: "app",
+				ServiceInstanceId: "service", Replicas: 2})
+			gomega.Expect(err).NotTo(gomega.BeNil())
+		}()
+		gomega.Consistently(done, 100*time.Millisecond).ShouldNot(gomega.BeClosed())
+		release()
+		gomega.Eventually(done).Should(gomega.BeClosed())
+	})
+})
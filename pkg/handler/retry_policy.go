@@ -0,0 +1,126 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package handler
+
+import (
+	"fmt"
+	pbDeploymentMgr "github.com/nalej/grpc-deployment-manager-go"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Number of attempts meaning the stage is retried until it is deployed
+const UnlimitedAttempts = 0
+
+// RetryPolicy defines how many times a stage is deployed and how long to wait between attempts.
+type RetryPolicy struct {
+	// Maximum number of attempts, UnlimitedAttempts to retry until done
+	MaxAttempts int
+	// Time to wait after the first failed attempt
+	InitialBackoff time.Duration
+	// Maximum time to wait between attempts
+	MaxBackoff time.Duration
+	// Fraction of the backoff randomly added or subtracted, between 0 and 1
+	Jitter float64
+	// Time to wait for the resources of a stage before considering the attempt failed
+	StageCheckTimeout time.Duration
+}
+
+// Override the values of this policy with the non empty values of a request policy.
+//  params:
+//   override policy received with the request, it can be nil
+//  return:
+//   resulting policy
+func (p RetryPolicy) Merge(override *pbDeploymentMgr.RetryPolicy) RetryPolicy {
+	if override == nil {
+		return p
+	}
+	if override.MaxAttempts > 0 {
+		p.MaxAttempts = int(override.MaxAttempts)
+	}
+	if override.InitialBackoffMs > 0 {
+		p.InitialBackoff = time.Duration(override.InitialBackoffMs) * time.Millisecond
+	}
+	if override.MaxBackoffMs > 0 {
+		p.MaxBackoff = time.Duration(override.MaxBackoffMs) * time.Millisecond
+	}
+	if override.Jitter > 0 && override.Jitter <= 1 {
+		p.Jitter = float64(override.Jitter)
+	}
+	if override.StageCheckTimeoutSeconds > 0 {
+		p.StageCheckTimeout = time.Duration(override.StageCheckTimeoutSeconds) * time.Second
+	}
+	if p.MaxBackoff < p.InitialBackoff {
+		p.MaxBackoff = p.InitialBackoff
+	}
+	return p
+}
+
+// Policy to be used for a request. The request policy overrides the configured one. The rollback policy determines
+// the number of attempts unless the request sets them: LIMITED_RETRY uses the configured attempts, ALWAYS_RETRY
+// retries until the stage is deployed and NONE makes a single attempt.
+//  params:
+//   request to be deployed
+//  return:
+//   policy to deploy the stages of the request
+func (p RetryPolicy) ForRequest(request *pbDeploymentMgr.DeploymentFragmentRequest) RetryPolicy {
+	toReturn := p.Merge(request.RetryPolicy)
+	if request.RetryPolicy != nil && request.RetryPolicy.MaxAttempts > 0 {
+		return toReturn
+	}
+	switch request.RollbackPolicy {
+	case pbDeploymentMgr.RollbackPolicy_ALWAYS_RETRY:
+		toReturn.MaxAttempts = UnlimitedAttempts
+	case pbDeploymentMgr.RollbackPolicy_LIMITED_RETRY:
+		// the configured attempts
+	default:
+		toReturn.MaxAttempts = 1
+	}
+	return toReturn
+}
+
+// Check if another attempt is allowed after the given one.
+func (p RetryPolicy) CanRetry(attempt int) bool {
+	return p.MaxAttempts == UnlimitedAttempts || attempt < p.MaxAttempts
+}
+
+// Time to wait after a failed attempt. The backoff doubles with every attempt up to the maximum and the
+// jitter is applied on top.
+//  params:
+//   attempt number of the failed attempt starting at 1
+//  return:
+//   time to wait before the next attempt
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := float64(p.InitialBackoff) * math.Pow(2, float64(attempt-1))
+	if backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff = backoff * (1 + p.Jitter*(2*rand.Float64()-1))
+	}
+	return time.Duration(backoff)
+}
+
+// Textual description of an attempt
+func (p RetryPolicy) AttemptInfo(attempt int) string {
+	if p.MaxAttempts == UnlimitedAttempts {
+		return fmt.Sprintf("attempt %d of unlimited", attempt)
+	}
+	return fmt.Sprintf("attempt %d of %d", attempt, p.MaxAttempts)
+}
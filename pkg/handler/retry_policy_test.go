@@ -0,0 +1,104 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package handler
+
+import (
+	pbDeploymentMgr "github.com/nalej/grpc-deployment-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"time"
+)
+
+var _ = ginkgo.Describe("retry policy", func() {
+
+	policy := RetryPolicy{
+		MaxAttempts:       3,
+		InitialBackoff:    time.Second,
+		MaxBackoff:        4 * time.Second,
+		StageCheckTimeout: time.Minute,
+	}
+
+	ginkgo.It("should grow the backoff exponentially up to the maximum", func() {
+		gomega.Expect(policy.Backoff(1)).To(gomega.Equal(time.Second))
+		gomega.Expect(policy.Backoff(2)).To(gomega.Equal(2 * time.Second))
+		gomega.Expect(policy.Backoff(3)).To(gomega.Equal(4 * time.Second))
+		gomega.Expect(policy.Backoff(10)).To(gomega.Equal(4 * time.Second))
+	})
+
+	ginkgo.It("should keep the jitter within bounds", func() {
+		withJitter := policy
+		withJitter.Jitter = 0.5
+		for i := 0; i < 100; i++ {
+			backoff := withJitter.Backoff(2)
+			gomega.Expect(backoff).To(gomega.BeNumerically(">=", time.Second))
+			gomega.Expect(backoff).To(gomega.BeNumerically("<=", 3*time.Second))
+		}
+	})
+
+	ginkgo.It("should set the attempts from the rollback policy", func() {
+		request := &pbDeploymentMgr.DeploymentFragmentRequest{RollbackPolicy: pbDeploymentMgr.RollbackPolicy_NONE}
+		gomega.Expect(policy.ForRequest(request).MaxAttempts).To(gomega.Equal(1))
+		gomega.Expect(policy.ForRequest(request).CanRetry(1)).To(gomega.BeFalse())
+
+		request.RollbackPolicy = pbDeploymentMgr.RollbackPolicy_LIMITED_RETRY
+		gomega.Expect(policy.ForRequest(request).MaxAttempts).To(gomega.Equal(3))
+		gomega.Expect(policy.ForRequest(request).CanRetry(2)).To(gomega.BeTrue())
+		gomega.Expect(policy.ForRequest(request).CanRetry(3)).To(gomega.BeFalse())
+
+		request.RollbackPolicy = pbDeploymentMgr.RollbackPolicy_ALWAYS_RETRY
+		unlimited := policy.ForRequest(request)
+		gomega.Expect(unlimited.MaxAttempts).To(gomega.Equal(UnlimitedAttempts))
+		gomega.Expect(unlimited.CanRetry(1000)).To(gomega.BeTrue())
+		gomega.Expect(unlimited.AttemptInfo(2)).To(gomega.Equal("attempt 2 of unlimited"))
+	})
+
+	ginkgo.It("should apply the request overrides", func() {
+		request := &pbDeploymentMgr.DeploymentFragmentRequest{
+			RollbackPolicy: pbDeploymentMgr.RollbackPolicy_LIMITED_RETRY,
+			RetryPolicy: &pbDeploymentMgr.RetryPolicy{
+				MaxAttempts:              5,
+				InitialBackoffMs:         8000,
+				StageCheckTimeoutSeconds: 30,
+			},
+		}
+		merged := policy.ForRequest(request)
+		gomega.Expect(merged.MaxAttempts).To(gomega.Equal(5))
+		gomega.Expect(merged.InitialBackoff).To(gomega.Equal(8 * time.Second))
+		// the maximum backoff cannot be lower than the initial one
+		gomega.Expect(merged.MaxBackoff).To(gomega.Equal(8 * time.Second))
+		gomega.Expect(merged.StageCheckTimeout).To(gomega.Equal(30 * time.Second))
+		gomega.Expect(merged.AttemptInfo(2)).To(gomega.Equal("attempt 2 of 5"))
+	})
+
+	ginkgo.It("should use the configured attempts for limited retries", func() {
+		configured := policy
+		configured.MaxAttempts = 7
+		request := &pbDeploymentMgr.DeploymentFragmentRequest{RollbackPolicy: pbDeploymentMgr.RollbackPolicy_LIMITED_RETRY}
+		gomega.Expect(configured.ForRequest(request).MaxAttempts).To(gomega.Equal(7))
+	})
+
+	ginkgo.It("should honor the attempts of the request for every rollback policy", func() {
+		request := &pbDeploymentMgr.DeploymentFragmentRequest{
+			RollbackPolicy: pbDeploymentMgr.RollbackPolicy_NONE,
+			RetryPolicy:    &pbDeploymentMgr.RetryPolicy{MaxAttempts: 2},
+		}
+		gomega.Expect(policy.ForRequest(request).MaxAttempts).To(gomega.Equal(2))
+		request.RollbackPolicy = pbDeploymentMgr.RollbackPolicy_ALWAYS_RETRY
+		gomega.Expect(policy.ForRequest(request).MaxAttempts).To(gomega.Equal(2))
+	})
+})
@@ -0,0 +1,333 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handler
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/nalej/deployment-manager/pkg/common"
+	"github.com/nalej/deployment-manager/pkg/kubernetes"
+	"github.com/nalej/derrors"
+	pbApplication "github.com/nalej/grpc-application-go"
+	pbDeploymentMgr "github.com/nalej/grpc-deployment-manager-go"
+	"github.com/nalej/grpc-installer-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Storage types supported by every target platform. Ephemeral storage does not need a storage class.
+var SupportedStorageTypes = map[grpc_installer_go.Platform][]pbApplication.StorageType{
+	grpc_installer_go.Platform_MINIKUBE: {pbApplication.StorageType_EPHEMERAL, pbApplication.StorageType_CLUSTER_LOCAL,
+		pbApplication.StorageType_CLUSTER_REPLICA},
+	grpc_installer_go.Platform_AZURE: {pbApplication.StorageType_EPHEMERAL, pbApplication.StorageType_CLUSTER_LOCAL,
+		pbApplication.StorageType_CLUSTER_REPLICA, pbApplication.StorageType_EXPERIMENTAL_CLUSTER_REPLICA},
+	grpc_installer_go.Platform_BAREMETAL: {pbApplication.StorageType_EPHEMERAL},
+}
+
+// Field of a request that does not satisfy a validation rule.
+type FieldViolation struct {
+	// Path of the field in the request
+	Field string
+	// Description of the rule that is not satisfied
+	Description string
+}
+
+func (v FieldViolation) String() string {
+	return fmt.Sprintf("%s: %s", v.Field, v.Description)
+}
+
+// A FragmentValidator checks a set of rules over the deployment fragment requests.
+type FragmentValidator interface {
+	// Validate a request.
+	//  params:
+	//   request to be validated
+	//  return:
+	//   violations found, empty if the request is valid
+	Validate(request *pbDeploymentMgr.DeploymentFragmentRequest) []FieldViolation
+}
+
+// ValidatorChain runs a list of validators over the deployment fragment requests before they are accepted.
+type ValidatorChain struct {
+	validators []FragmentValidator
+}
+
+func NewValidatorChain(validators ...FragmentValidator) *ValidatorChain {
+	return &ValidatorChain{validators: validators}
+}
+
+// Create the chain with the validators required to deploy a fragment in the target platform.
+//  params:
+//   platform target platform of the cluster
+//   storageQuota maximum storage in bytes requested by a fragment, 0 for no limit
+//  return:
+//   validator chain
+func NewDefaultValidatorChain(platform grpc_installer_go.Platform, storageQuota int64) *ValidatorChain {
+	return NewValidatorChain(
+		RequiredIdsValidator{},
+		ServiceNamesValidator{},
+		PortsValidator{},
+		ReplicasValidator{},
+		NewStorageValidator(platform, storageQuota),
+		ConfigMountPathValidator{},
+	)
+}
+
+// Add a validator at the end of the chain.
+func (c *ValidatorChain) Add(validator FragmentValidator) {
+	c.validators = append(c.validators, validator)
+}
+
+// Run all the validators of the chain.
+//  params:
+//   request to be validated
+//  return:
+//   violations found by every validator, empty if the request is valid
+func (c *ValidatorChain) Validate(request *pbDeploymentMgr.DeploymentFragmentRequest) []FieldViolation {
+	violations := make([]FieldViolation, 0)
+	for _, validator := range c.validators {
+		violations = append(violations, validator.Validate(request)...)
+	}
+	return violations
+}
+
+// Build an invalid argument error with the violations as parameters.
+func ViolationsError(violations []FieldViolation) derrors.Error {
+	params := make([]interface{}, 0, len(violations))
+	for _, v := range violations {
+		params = append(params, v.String())
+	}
+	return derrors.NewInvalidArgumentError("invalid deployment fragment request").WithParams(params...)
+}
+
+// Build an invalid argument gRPC error with the violations attached as bad request details.
+func ViolationsToGRPCError(violations []FieldViolation) error {
+	st, _ := status.FromError(conversions.ToGRPCError(ViolationsError(violations)))
+	badRequest := &errdetails.BadRequest{FieldViolations: make([]*errdetails.BadRequest_FieldViolation, 0, len(violations))}
+	for _, v := range violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations,
+			&errdetails.BadRequest_FieldViolation{Field: v.Field, Description: v.Description})
+	}
+	withDetails, err := st.WithDetails(badRequest)
+	if err != nil {
+		log.Warn().Err(err).Msg("cannot attach field violations to the error")
+		return st.Err()
+	}
+	return withDetails.Err()
+}
+
+// Path of a service in a deployment fragment request
+func serviceField(stageIndex int, serviceIndex int) string {
+	return fmt.Sprintf("fragment.stages[%d].services[%d]", stageIndex, serviceIndex)
+}
+
+// RequiredIdsValidator checks the identifiers required to process a request.
+type RequiredIdsValidator struct{}
+
+func (RequiredIdsValidator) Validate(request *pbDeploymentMgr.DeploymentFragmentRequest) []FieldViolation {
+	violations := make([]FieldViolation, 0)
+	if request.RequestId == "" {
+		violations = append(violations, FieldViolation{"request_id", "cannot be empty"})
+	}
+	if request.Fragment == nil {
+		return append(violations, FieldViolation{"fragment", "cannot be empty"})
+	}
+	required := []struct{ field, value string }{
+		{"fragment.fragment_id", request.Fragment.FragmentId},
+		{"fragment.deployment_id", request.Fragment.DeploymentId},
+		{"fragment.app_instance_id", request.Fragment.AppInstanceId},
+		{"fragment.organization_id", request.Fragment.OrganizationId},
+	}
+	for _, field := range required {
+		if field.value == "" {
+			violations = append(violations, FieldViolation{field.field, "cannot be empty"})
+		}
+	}
+	return violations
+}
+
+// ServiceNamesValidator checks the names of the services are valid kubernetes names once formatted and that
+// they are not repeated inside a stage.
+type ServiceNamesValidator struct{}
+
+func (ServiceNamesValidator) Validate(request *pbDeploymentMgr.DeploymentFragmentRequest) []FieldViolation {
+	violations := make([]FieldViolation, 0)
+	for i, stage := range request.GetFragment().GetStages() {
+		names := make(map[string]int, len(stage.Services))
+		for j, service := range stage.Services {
+			field := fmt.Sprintf("%s.service_name", serviceField(i, j))
+			name := common.FormatName(service.ServiceName)
+			// services are exposed with kubernetes services whose names are DNS-1035 labels
+			for _, msg := range validation.IsDNS1035Label(name) {
+				violations = append(violations, FieldViolation{field, fmt.Sprintf("%s is not a valid name: %s", name, msg)})
+			}
+			if previous, found := names[name]; found {
+				violations = append(violations, FieldViolation{field,
+					fmt.Sprintf("%s is already used by %s", name, serviceField(i, previous))})
+			} else {
+				names[name] = j
+			}
+		}
+	}
+	return violations
+}
+
+// PortsValidator checks the ports exposed by every service are valid and do not conflict between them.
+type PortsValidator struct{}
+
+func (PortsValidator) Validate(request *pbDeploymentMgr.DeploymentFragmentRequest) []FieldViolation {
+	violations := make([]FieldViolation, 0)
+	for i, stage := range request.GetFragment().GetStages() {
+		for j, service := range stage.Services {
+			exposed := make(map[int32]bool, len(service.ExposedPorts))
+			names := make(map[string]bool, len(service.ExposedPorts))
+			for k, port := range service.ExposedPorts {
+				field := fmt.Sprintf("%s.exposed_ports[%d]", serviceField(i, j), k)
+				for _, msg := range validation.IsValidPortNum(int(port.ExposedPort)) {
+					violations = append(violations, FieldViolation{field + ".exposed_port", msg})
+				}
+				// the exposed port is used when no internal port is set
+				if port.InternalPort != 0 {
+					for _, msg := range validation.IsValidPortNum(int(port.InternalPort)) {
+						violations = append(violations, FieldViolation{field + ".internal_port", msg})
+					}
+				}
+				if exposed[port.ExposedPort] {
+					violations = append(violations, FieldViolation{field + ".exposed_port",
+						fmt.Sprintf("port %d is exposed more than once", port.ExposedPort)})
+				}
+				exposed[port.ExposedPort] = true
+				if port.Name == "" {
+					continue
+				}
+				for _, msg := range validation.IsValidPortName(port.Name) {
+					violations = append(violations, FieldViolation{field + ".name", msg})
+				}
+				if names[port.Name] {
+					violations = append(violations, FieldViolation{field + ".name",
+						fmt.Sprintf("port name %s is used more than once", port.Name)})
+				}
+				names[port.Name] = true
+			}
+		}
+	}
+	return violations
+}
+
+// ReplicasValidator checks the number of replicas of the services is not negative.
+type ReplicasValidator struct{}
+
+func (ReplicasValidator) Validate(request *pbDeploymentMgr.DeploymentFragmentRequest) []FieldViolation {
+	violations := make([]FieldViolation, 0)
+	for i, stage := range request.GetFragment().GetStages() {
+		for j, service := range stage.Services {
+			if service.GetSpecs().GetReplicas() < 0 {
+				violations = append(violations, FieldViolation{fmt.Sprintf("%s.specs.replicas", serviceField(i, j)),
+					"cannot be negative"})
+			}
+		}
+	}
+	return violations
+}
+
+// StorageValidator checks the storage requested by the services is supported by the target platform and
+// fits in the storage quota of a fragment. Storages without size are counted with the size allocated by default.
+type StorageValidator struct {
+	// platform of the cluster
+	platform grpc_installer_go.Platform
+	// storage types supported by the platform
+	supported map[pbApplication.StorageType]bool
+	// maximum storage in bytes requested by a fragment, 0 for no limit
+	quota int64
+}
+
+func NewStorageValidator(platform grpc_installer_go.Platform, quota int64) StorageValidator {
+	supported := make(map[pbApplication.StorageType]bool, 0)
+	for _, storageType := range SupportedStorageTypes[platform] {
+		supported[storageType] = true
+	}
+	return StorageValidator{platform: platform, supported: supported, quota: quota}
+}
+
+func (v StorageValidator) Validate(request *pbDeploymentMgr.DeploymentFragmentRequest) []FieldViolation {
+	violations := make([]FieldViolation, 0)
+	total := int64(0)
+	exceeded := false
+	for i, stage := range request.GetFragment().GetStages() {
+		for j, service := range stage.Services {
+			for k, storage := range service.Storage {
+				field := fmt.Sprintf("%s.storage[%d]", serviceField(i, j), k)
+				if !v.supported[storage.Type] {
+					violations = append(violations, FieldViolation{field + ".type",
+						fmt.Sprintf("storage type %s is not supported in %s", storage.Type.String(), v.platform.String())})
+				}
+				if storage.Size < 0 {
+					violations = append(violations, FieldViolation{field + ".size", "cannot be negative"})
+					continue
+				}
+				size := storage.Size
+				if size == 0 {
+					size = kubernetes.DefaultStorageAllocationSize
+				}
+				total = total + size
+				if v.quota > 0 && total > v.quota && !exceeded {
+					// the quota is reported once per fragment
+					exceeded = true
+					violations = append(violations, FieldViolation{field + ".size",
+						fmt.Sprintf("fragment storage quota of %d bytes exceeded, %d bytes requested", v.quota, total)})
+				}
+			}
+		}
+	}
+	return violations
+}
+
+// ConfigMountPathValidator checks the configuration files are mounted on valid absolute paths and that the
+// same path is not used twice by a service.
+type ConfigMountPathValidator struct{}
+
+func (ConfigMountPathValidator) Validate(request *pbDeploymentMgr.DeploymentFragmentRequest) []FieldViolation {
+	violations := make([]FieldViolation, 0)
+	for i, stage := range request.GetFragment().GetStages() {
+		for j, service := range stage.Services {
+			paths := make(map[string]bool, len(service.Configs))
+			for k, config := range service.Configs {
+				field := fmt.Sprintf("%s.configs[%d].mount_path", serviceField(i, j), k)
+				mountPath := config.MountPath
+				if !path.IsAbs(mountPath) || strings.HasSuffix(mountPath, "/") || path.Clean(mountPath) != mountPath {
+					violations = append(violations, FieldViolation{field,
+						fmt.Sprintf("%s must be an absolute and clean path to a file", mountPath)})
+					continue
+				}
+				// the file name is used as key of the config map
+				for _, msg := range validation.IsConfigMapKey(path.Base(mountPath)) {
+					violations = append(violations, FieldViolation{field, msg})
+				}
+				if paths[mountPath] {
+					violations = append(violations, FieldViolation{field,
+						fmt.Sprintf("%s is used by more than one configuration file", mountPath)})
+				}
+				paths[mountPath] = true
+			}
+		}
+	}
+	return violations
+}
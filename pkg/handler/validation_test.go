@@ -0,0 +1,149 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handler
+
+import (
+	"github.com/nalej/deployment-manager/pkg/kubernetes"
+	pbApplication "github.com/nalej/grpc-application-go"
+	pbConductor "github.com/nalej/grpc-conductor-go"
+	pbDeploymentMgr "github.com/nalej/grpc-deployment-manager-go"
+	"github.com/nalej/grpc-installer-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func validationTestRequest(services ...*pbConductor.ServiceInstance) *pbDeploymentMgr.DeploymentFragmentRequest {
+	return &pbDeploymentMgr.DeploymentFragmentRequest{
+		RequestId: "request",
+		Fragment: &pbConductor.DeploymentFragment{
+			OrganizationId: "org",
+			AppInstanceId:  "app",
+			DeploymentId:   "deployment",
+			FragmentId:     "fragment",
+			Stages:         []*pbConductor.DeploymentStage{{StageId: "stage", Services: services}},
+		},
+	}
+}
+
+func validationTestService(name string) *pbConductor.ServiceInstance {
+	return &pbConductor.ServiceInstance{
+		ServiceName:  name,
+		Specs:        &pbApplication.DeploySpecs{Replicas: 1},
+		ExposedPorts: []*pbApplication.Port{{Name: "http", ExposedPort: 80, InternalPort: 8080}},
+		Storage:      []*pbApplication.Storage{{Size: 100, MountPath: "/data", Type: pbApplication.StorageType_CLUSTER_LOCAL}},
+		Configs:      []*pbApplication.ConfigFile{{MountPath: "/etc/app/config.yaml"}},
+	}
+}
+
+func violatedFields(violations []FieldViolation) []string {
+	fields := make([]string, 0, len(violations))
+	for _, v := range violations {
+		fields = append(fields, v.Field)
+	}
+	return fields
+}
+
+var _ = ginkgo.Describe("deployment fragment validation", func() {
+
+	chain := NewDefaultValidatorChain(grpc_installer_go.Platform_MINIKUBE, 1000)
+
+	ginkgo.It("should accept a valid request", func() {
+		request := validationTestRequest(validationTestService("My Web"), validationTestService("db"))
+		gomega.Expect(chain.Validate(request)).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should require the request identifiers", func() {
+		request := validationTestRequest()
+		request.RequestId = ""
+		request.Fragment.AppInstanceId = ""
+		gomega.Expect(violatedFields(chain.Validate(request))).To(
+			gomega.ConsistOf("request_id", "fragment.app_instance_id"))
+	})
+
+	ginkgo.It("should reject invalid and duplicated service names", func() {
+		request := validationTestRequest(validationTestService("web_1"), validationTestService("db"),
+			validationTestService("D B"))
+		gomega.Expect(violatedFields(chain.Validate(request))).To(gomega.ConsistOf(
+			"fragment.stages[0].services[0].service_name", "fragment.stages[0].services[2].service_name"))
+	})
+
+	ginkgo.It("should reject port conflicts and negative replicas", func() {
+		service := validationTestService("web")
+		service.Specs.Replicas = -1
+		service.ExposedPorts = append(service.ExposedPorts, &pbApplication.Port{Name: "http", ExposedPort: 80},
+			&pbApplication.Port{ExposedPort: 70000})
+		gomega.Expect(violatedFields(chain.Validate(validationTestRequest(service)))).To(gomega.ConsistOf(
+			"fragment.stages[0].services[0].specs.replicas",
+			"fragment.stages[0].services[0].exposed_ports[1].exposed_port",
+			"fragment.stages[0].services[0].exposed_ports[1].name",
+			"fragment.stages[0].services[0].exposed_ports[2].exposed_port"))
+	})
+
+	ginkgo.It("should check the storage quota and the storage types of the platform", func() {
+		service := validationTestService("web")
+		service.Storage = append(service.Storage,
+			&pbApplication.Storage{Size: 950, MountPath: "/more", Type: pbApplication.StorageType_CLUSTER_LOCAL},
+			&pbApplication.Storage{MountPath: "/replica", Type: pbApplication.StorageType_EXPERIMENTAL_CLUSTER_REPLICA})
+		gomega.Expect(violatedFields(chain.Validate(validationTestRequest(service)))).To(gomega.ConsistOf(
+			"fragment.stages[0].services[0].storage[1].size",
+			"fragment.stages[0].services[0].storage[2].type"))
+
+		azure := NewDefaultValidatorChain(grpc_installer_go.Platform_AZURE, 0)
+		gomega.Expect(azure.Validate(validationTestRequest(service))).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should count the storage without size with the default size", func() {
+		service := validationTestService("web")
+		service.Storage[0].Size = 0
+		gomega.Expect(violatedFields(chain.Validate(validationTestRequest(service)))).To(gomega.ConsistOf(
+			"fragment.stages[0].services[0].storage[0].size"))
+
+		large := NewDefaultValidatorChain(grpc_installer_go.Platform_MINIKUBE, kubernetes.DefaultStorageAllocationSize)
+		gomega.Expect(large.Validate(validationTestRequest(service))).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should reject malformed config mount paths", func() {
+		service := validationTestService("web")
+		service.Configs = append(service.Configs, &pbApplication.ConfigFile{MountPath: "etc/relative"},
+			&pbApplication.ConfigFile{MountPath: "/etc/app/"}, &pbApplication.ConfigFile{MountPath: "/etc/../app"},
+			&pbApplication.ConfigFile{MountPath: "/etc/app/config.yaml"})
+		gomega.Expect(violatedFields(chain.Validate(validationTestRequest(service)))).To(gomega.ConsistOf(
+			"fragment.stages[0].services[0].configs[1].mount_path",
+			"fragment.stages[0].services[0].configs[2].mount_path",
+			"fragment.stages[0].services[0].configs[3].mount_path",
+			"fragment.stages[0].services[0].configs[4].mount_path"))
+	})
+
+	ginkgo.It("should return the violations as bad request details", func() {
+		violations := []FieldViolation{{"request_id", "cannot be empty"}}
+		st, ok := status.FromError(ViolationsToGRPCError(violations))
+		gomega.Expect(ok).To(gomega.BeTrue())
+		gomega.Expect(st.Code()).To(gomega.Equal(codes.InvalidArgument))
+		var badRequest *errdetails.BadRequest
+		for _, detail := range st.Details() {
+			if br, isBadRequest := detail.(*errdetails.BadRequest); isBadRequest {
+				badRequest = br
+			}
+		}
+		gomega.Expect(badRequest).NotTo(gomega.BeNil())
+		gomega.Expect(badRequest.FieldViolations).To(gomega.HaveLen(1))
+		gomega.Expect(badRequest.FieldViolations[0].Field).To(gomega.Equal("request_id"))
+	})
+})
@@ -0,0 +1,122 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handler
+
+import (
+	"sync"
+)
+
+// WorkerPool limits the number of deployment fragments processed concurrently, globally and per organization.
+type WorkerPool struct {
+	// Maximum number of fragments processed at the same time
+	maxWorkers int
+	// Maximum number of fragments of the same organization processed at the same time, 0 for no limit
+	maxWorkersPerOrg int
+	// Number of running workers
+	running int
+	// Number of running workers per organization
+	runningPerOrg map[string]int
+	// Channel notifying that a worker was released
+	released chan struct{}
+	// Mutex for pool operations
+	mu sync.Mutex
+}
+
+// Create a new worker pool.
+//  params:
+//   maxWorkers maximum number of concurrent workers
+//   maxWorkersPerOrg maximum number of concurrent workers per organization, 0 for no limit
+//  returns:
+//   worker pool
+func NewWorkerPool(maxWorkers int, maxWorkersPerOrg int) *WorkerPool {
+	return &WorkerPool{
+		maxWorkers:       maxWorkers,
+		maxWorkersPerOrg: maxWorkersPerOrg,
+		runningPerOrg:    make(map[string]int, 0),
+		released:         make(chan struct{}, 1),
+	}
+}
+
+// Check if there is room for another worker.
+func (p *WorkerPool) Available() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.running < p.maxWorkers
+}
+
+// Try to acquire a worker for the given organization.
+//  params:
+//   organizationId organization the work belongs to
+//  returns:
+//   true if the worker was acquired
+func (p *WorkerPool) TryAcquire(organizationId string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.canAcquire(organizationId) {
+		return false
+	}
+	p.running++
+	p.runningPerOrg[organizationId]++
+	return true
+}
+
+// Check if a worker could be acquired for the given organization without acquiring it.
+//  params:
+//   organizationId organization the work belongs to
+//  returns:
+//   true if there is a free worker for the organization
+func (p *WorkerPool) CanAcquire(organizationId string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.canAcquire(organizationId)
+}
+
+// Check if there is a free worker for the organization. This function must be called with the lock held.
+func (p *WorkerPool) canAcquire(organizationId string) bool {
+	if p.running >= p.maxWorkers {
+		return false
+	}
+	return p.maxWorkersPerOrg == 0 || p.runningPerOrg[organizationId] < p.maxWorkersPerOrg
+}
+
+// Release a worker previously acquired for the given organization.
+func (p *WorkerPool) Release(organizationId string) {
+	p.mu.Lock()
+	p.running--
+	p.runningPerOrg[organizationId]--
+	if p.runningPerOrg[organizationId] <= 0 {
+		delete(p.runningPerOrg, organizationId)
+	}
+	p.mu.Unlock()
+	// notify without blocking, one pending notification is enough to wake up the dispatcher
+	select {
+	case p.released <- struct{}{}:
+	default:
+	}
+}
+
+// Running returns the number of running workers.
+func (p *WorkerPool) Running() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.running
+}
+
+// Released returns a channel notified every time a worker is released.
+func (p *WorkerPool) Released() <-chan struct{} {
+	return p.released
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handler
+
+import (
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+var _ = ginkgo.Describe("worker pool", func() {
+
+	ginkgo.It("should limit the number of workers", func() {
+		pool := NewWorkerPool(2, 0)
+		gomega.Expect(pool.TryAcquire("org1")).To(gomega.BeTrue())
+		gomega.Expect(pool.TryAcquire("org2")).To(gomega.BeTrue())
+		gomega.Expect(pool.Available()).To(gomega.BeFalse())
+		gomega.Expect(pool.TryAcquire("org3")).To(gomega.BeFalse())
+		pool.Release("org1")
+		gomega.Expect(pool.Released()).Should(gomega.Receive())
+		gomega.Expect(pool.TryAcquire("org3")).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should limit the number of workers per organization", func() {
+		pool := NewWorkerPool(3, 1)
+		gomega.Expect(pool.TryAcquire("org1")).To(gomega.BeTrue())
+		gomega.Expect(pool.TryAcquire("org1")).To(gomega.BeFalse())
+		gomega.Expect(pool.TryAcquire("org2")).To(gomega.BeTrue())
+		gomega.Expect(pool.Running()).To(gomega.Equal(2))
+		gomega.Expect(pool.CanAcquire("org1")).To(gomega.BeFalse())
+		gomega.Expect(pool.CanAcquire("org3")).To(gomega.BeTrue())
+		pool.Release("org1")
+		gomega.Expect(pool.TryAcquire("org1")).To(gomega.BeTrue())
+	})
+})
@@ -0,0 +1,229 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Health and readiness of the deployment manager computed from the state of its dependencies.
+
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// Methods of the gRPC health service. They must be reachable without credentials.
+var GRPCHealthMethods = []string{
+	"/grpc.health.v1.Health/Check",
+	"/grpc.health.v1.Health/Watch",
+}
+
+// Value reported for a passing check
+const CheckOk = "ok"
+
+// A Check verifies a dependency of the service.
+//  params:
+//   ctx context to stop the check
+//  return:
+//   error if the dependency is not available
+type Check func(ctx context.Context) error
+
+// Status of the checks
+type Status struct {
+	// All the checks passed
+	Ready bool `json:"ready"`
+	// Result of every check indexed by its name
+	Checks map[string]string `json:"checks"`
+	// Time of the last check
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Checker runs periodically a set of checks and updates the status of the gRPC health service accordingly.
+type Checker struct {
+	// names of the checks in the order they were added
+	names []string
+	// checks indexed by name
+	checks map[string]Check
+	// gRPC health service
+	server *health.Server
+	// gRPC services whose status is reported
+	services []string
+	// time between checks
+	interval time.Duration
+	// maximum time to wait for a check
+	timeout time.Duration
+	// last status
+	status Status
+	// the checker has been stopped
+	stopped bool
+	// channel to stop the periodic checks
+	stop chan struct{}
+	// Mutex for the status and the checks
+	mu sync.RWMutex
+}
+
+// Create a new checker. The service is not ready until the first checks pass.
+//  params:
+//   server gRPC health service to be updated
+//   interval time between checks
+//   timeout maximum time to wait for a check
+//  return:
+//   checker
+func NewChecker(server *health.Server, interval time.Duration, timeout time.Duration) *Checker {
+	server.SetServingStatus("", healthpb.HealthCheckResponse_NOT_SERVING)
+	return &Checker{
+		names:    make([]string, 0),
+		checks:   make(map[string]Check, 0),
+		server:   server,
+		services: make([]string, 0),
+		interval: interval,
+		timeout:  timeout,
+		status:   Status{Checks: make(map[string]string, 0)},
+		stop:     make(chan struct{}),
+	}
+}
+
+// Add a check required for the service to be ready.
+func (c *Checker) AddCheck(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.names = append(c.names, name)
+	c.checks[name] = check
+}
+
+// Add the gRPC services whose status is reported by the health service along with the overall status.
+func (c *Checker) AddServices(services ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, service := range services {
+		c.services = append(c.services, service)
+		c.server.SetServingStatus(service, servingStatus(c.status.Ready))
+	}
+}
+
+// Run the checks periodically until the checker is stopped.
+func (c *Checker) Run() {
+	c.CheckNow()
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.CheckNow()
+		case <-c.stop:
+			log.Info().Msg("health checker stopped")
+			return
+		}
+	}
+}
+
+// Stop the periodic checks reporting the service as not ready. It is called when the service starts
+// shutting down so no new requests are routed to it.
+func (c *Checker) Stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		return
+	}
+	c.stopped = true
+	c.status.Ready = false
+	c.server.Shutdown()
+	close(c.stop)
+}
+
+// Run all the checks and update the status.
+//  return:
+//   new status
+func (c *Checker) CheckNow() Status {
+	c.mu.RLock()
+	names := c.names
+	checks := c.checks
+	c.mu.RUnlock()
+
+	results := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = c.runCheck(check)
+		}(i, checks[name])
+	}
+	wg.Wait()
+
+	status := Status{Ready: true, Checks: make(map[string]string, len(names)), Timestamp: time.Now()}
+	for i, name := range names {
+		status.Checks[name] = CheckOk
+		if results[i] != nil {
+			status.Ready = false
+			status.Checks[name] = results[i].Error()
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		status.Ready = false
+		return status
+	}
+	if status.Ready != c.status.Ready {
+		log.Info().Bool("ready", status.Ready).Interface("checks", status.Checks).Msg("readiness changed")
+	}
+	c.status = status
+	c.server.SetServingStatus("", servingStatus(status.Ready))
+	for _, service := range c.services {
+		c.server.SetServingStatus(service, servingStatus(status.Ready))
+	}
+	return status
+}
+
+// Run a check waiting at most the checker timeout. Checks not supporting the context are abandoned.
+func (c *Checker) runCheck(check Check) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	result := make(chan error, 1)
+	go func() {
+		result <- check(ctx)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return derrors.NewDeadlineExceededError("check timed out")
+	}
+}
+
+// Get the last status.
+func (c *Checker) GetStatus() Status {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	checks := make(map[string]string, len(c.status.Checks))
+	for name, result := range c.status.Checks {
+		checks[name] = result
+	}
+	return Status{Ready: c.status.Ready, Checks: checks, Timestamp: c.status.Timestamp}
+}
+
+func servingStatus(ready bool) healthpb.HealthCheckResponse_ServingStatus {
+	if ready {
+		return healthpb.HealthCheckResponse_SERVING
+	}
+	return healthpb.HealthCheckResponse_NOT_SERVING
+}
@@ -0,0 +1,143 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const testService = "deployment_manager.DeploymentManager"
+
+// Dependency whose availability is set by the tests
+type testDependency struct {
+	err error
+}
+
+func (d *testDependency) check(ctx context.Context) error {
+	return d.err
+}
+
+func servingStatusOf(server *health.Server, service string) healthpb.HealthCheckResponse_ServingStatus {
+	response, err := server.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	gomega.Expect(err).To(gomega.Succeed())
+	return response.Status
+}
+
+var _ = ginkgo.Describe("Checker", func() {
+
+	var server *health.Server
+	var checker *Checker
+	var dependency *testDependency
+
+	ginkgo.BeforeEach(func() {
+		server = health.NewServer()
+		checker = NewChecker(server, time.Hour, 50*time.Millisecond)
+		dependency = &testDependency{}
+		checker.AddCheck("dependency", dependency.check)
+		checker.AddServices(testService)
+	})
+
+	ginkgo.It("should not be ready before the first check", func() {
+		gomega.Expect(checker.GetStatus().Ready).To(gomega.BeFalse())
+		gomega.Expect(servingStatusOf(server, "")).To(gomega.Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+		gomega.Expect(servingStatusOf(server, testService)).To(gomega.Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+	})
+
+	ginkgo.It("should follow the status of the dependencies", func() {
+		status := checker.CheckNow()
+		gomega.Expect(status.Ready).To(gomega.BeTrue())
+		gomega.Expect(status.Checks).To(gomega.Equal(map[string]string{"dependency": CheckOk}))
+		gomega.Expect(servingStatusOf(server, testService)).To(gomega.Equal(healthpb.HealthCheckResponse_SERVING))
+
+		dependency.err = derrors.NewUnavailableError("dependency down")
+		status = checker.CheckNow()
+		gomega.Expect(status.Ready).To(gomega.BeFalse())
+		gomega.Expect(status.Checks["dependency"]).To(gomega.ContainSubstring("dependency down"))
+		gomega.Expect(servingStatusOf(server, "")).To(gomega.Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+		gomega.Expect(servingStatusOf(server, testService)).To(gomega.Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+	})
+
+	ginkgo.It("should fail the checks that do not finish in time", func() {
+		blocked := make(chan struct{})
+		defer close(blocked)
+		checker.AddCheck("blocked", func(ctx context.Context) error {
+			<-blocked
+			return nil
+		})
+		status := checker.CheckNow()
+		gomega.Expect(status.Ready).To(gomega.BeFalse())
+		gomega.Expect(status.Checks["dependency"]).To(gomega.Equal(CheckOk))
+		gomega.Expect(status.Checks["blocked"]).NotTo(gomega.Equal(CheckOk))
+	})
+
+	ginkgo.It("should not be ready once stopped", func() {
+		done := make(chan struct{})
+		go func() {
+			checker.Run()
+			close(done)
+		}()
+		gomega.Eventually(func() bool { return checker.GetStatus().Ready }).Should(gomega.BeTrue())
+		checker.Stop()
+		gomega.Eventually(done).Should(gomega.BeClosed())
+		gomega.Expect(checker.GetStatus().Ready).To(gomega.BeFalse())
+		gomega.Expect(checker.CheckNow().Ready).To(gomega.BeFalse())
+		gomega.Expect(servingStatusOf(server, testService)).To(gomega.Equal(healthpb.HealthCheckResponse_NOT_SERVING))
+	})
+
+	ginkgo.Context("HTTP handler", func() {
+		var handler *Handler
+
+		ginkgo.BeforeEach(func() {
+			handler = NewHandler(checker)
+		})
+
+		ginkgo.It("should report the process alive regardless of the dependencies", func() {
+			dependency.err = derrors.NewUnavailableError("dependency down")
+			checker.CheckNow()
+			recorder := httptest.NewRecorder()
+			handler.Healthz(recorder, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusOK))
+		})
+
+		ginkgo.It("should report the readiness with the result of the checks", func() {
+			checker.CheckNow()
+			recorder := httptest.NewRecorder()
+			handler.Readyz(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusOK))
+
+			dependency.err = derrors.NewUnavailableError("dependency down")
+			checker.CheckNow()
+			recorder = httptest.NewRecorder()
+			handler.Readyz(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			gomega.Expect(recorder.Code).To(gomega.Equal(http.StatusServiceUnavailable))
+			status := Status{}
+			gomega.Expect(json.Unmarshal(recorder.Body.Bytes(), &status)).To(gomega.Succeed())
+			gomega.Expect(status.Ready).To(gomega.BeFalse())
+			gomega.Expect(status.Checks["dependency"]).To(gomega.ContainSubstring("dependency down"))
+		})
+	})
+})
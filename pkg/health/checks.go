@@ -0,0 +1,77 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package health
+
+import (
+	"context"
+
+	"github.com/nalej/derrors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"k8s.io/client-go/discovery"
+)
+
+// Component with caches that must be synced to serve requests
+type Synced interface {
+	HasSynced() bool
+}
+
+// Component logged into another service
+type LoginStatus interface {
+	Status() derrors.Error
+}
+
+// Check the Kubernetes API is reachable.
+func KubernetesCheck(client discovery.ServerVersionInterface) Check {
+	return func(ctx context.Context) error {
+		if _, err := client.ServerVersion(); err != nil {
+			return derrors.NewUnavailableError("kubernetes API not reachable", err)
+		}
+		return nil
+	}
+}
+
+// Check the caches of a component are synced.
+func SyncedCheck(synced Synced) Check {
+	return func(ctx context.Context) error {
+		if !synced.HasSynced() {
+			return derrors.NewUnavailableError("caches not synced")
+		}
+		return nil
+	}
+}
+
+// Check the login of a component succeeded.
+func LoginCheck(login LoginStatus) Check {
+	return func(ctx context.Context) error {
+		if err := login.Status(); err != nil {
+			return derrors.NewUnauthenticatedError("not logged in", err)
+		}
+		return nil
+	}
+}
+
+// Check a gRPC connection is usable. Connections being established or failing are not usable.
+func ConnectionCheck(conn *grpc.ClientConn) Check {
+	return func(ctx context.Context) error {
+		state := conn.GetState()
+		if state != connectivity.Ready && state != connectivity.Idle {
+			return derrors.NewUnavailableError("connection not ready").WithParams(conn.Target(), state.String())
+		}
+		return nil
+	}
+}
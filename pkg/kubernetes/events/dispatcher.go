@@ -200,6 +200,19 @@ func (d *Dispatcher) OnDelete(obj interface{}) {
 	d.queue.Add(e)
 }
 
+// Dispatch again an update event for every object in the caches of the dispatcher. Dispatch functions must
+// tolerate receiving the current state of an object several times.
+func (d *Dispatcher) Resync() {
+	num := 0
+	for kind, indexer := range d.indexers {
+		for _, key := range indexer.ListKeys() {
+			d.queue.Add(Event{Key: key, Kind: kind, EventType: EventUpdate})
+			num++
+		}
+	}
+	log.Info().Int("events", num).Msg("dispatcher resync")
+}
+
 func (d *Dispatcher) worker() {
 	for {
 		event, quit := d.queue.Get()
@@ -63,6 +63,9 @@ type EventsProvider struct {
 
 	// watchers have started
 	started bool
+
+	// watchers have been stopped
+	stopped bool
 }
 
 // NOTE: There is a simple example on how to deal with Kubernetes events here:
@@ -250,10 +253,24 @@ func (p *EventsProvider) createClient(gv schema.GroupVersion) (rest.Interface, d
 	return client, nil
 }
 
+// Check if the provider is running with the caches of all its watchers synced.
+func (p *EventsProvider) HasSynced() bool {
+	if !p.started || p.stopped {
+		return false
+	}
+	for _, watcher := range p.watchers {
+		if !watcher.HasSynced() {
+			return false
+		}
+	}
+	return true
+}
+
 // Stop collecting metrics
 func (p *EventsProvider) Stop() derrors.Error {
 	log.Info().Msg("stopping kubernetes event provider")
 	// Stop informers
+	p.stopped = true
 	close(p.stopChan)
 	return nil
 }
@@ -103,6 +103,11 @@ func (w *Watcher) Start(stopChan <-chan struct{}) error {
 	return nil
 }
 
+// Check if the informer cache has been synced with the cluster.
+func (w *Watcher) HasSynced() bool {
+	return w.informer.HasSynced()
+}
+
 func (w *Watcher) GetStore() cache.Store {
 	return w.informer.GetStore()
 }
@@ -17,6 +17,7 @@
 package kubernetes
 
 import (
+	"context"
 	"fmt"
 	"github.com/nalej/deployment-manager/internal/entities"
 	"github.com/nalej/deployment-manager/pkg/executor"
@@ -153,10 +154,13 @@ func (dc *DeployableConfigMaps) Build() error {
 	return nil
 }
 
-func (dc *DeployableConfigMaps) Deploy(controller executor.DeploymentController) error {
+func (dc *DeployableConfigMaps) Deploy(ctx context.Context, controller executor.DeploymentController) error {
 	numCreated := 0
 	for serviceId, configmaps := range dc.configmaps {
 		for _, toCreate := range configmaps {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			log.Debug().Interface("toCreate", toCreate).Msg("creating config map")
 			created, err := dc.client.Create(toCreate)
 			if err != nil {
@@ -23,6 +23,7 @@ package kubernetes
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/nalej/deployment-manager/internal/entities"
 	"github.com/nalej/deployment-manager/internal/structures/monitor"
@@ -43,6 +44,26 @@ import (
 type KubernetesController struct {
 	// Pending checks to run
 	monitoredInstances monitor.MonitoredInstances
+	// Observers of the deployments status
+	observers []DeploymentObserver
+	// Store of the deployments to find the deployment of a pod
+	deployments cache.Store
+}
+
+// Reasons of a waiting container that require a change in the deployment to be solved. The deployment
+// of a pod with a container waiting for any of these reasons is considered to be failed.
+var podFailureReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"ErrImageNeverPull":          true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+}
+
+// Component interested in the status the controller finds for the deployments.
+type DeploymentObserver interface {
+	// Called every time the status of a deployment is evaluated.
+	OnDeploymentStatus(dep *appsv1.Deployment, status entities.NalejServiceStatus)
 }
 
 // Create a new kubernetes controller that handles resource events and
@@ -53,8 +74,11 @@ func NewKubernetesController(monitoredInstances monitor.MonitoredInstances) *Kub
 	}
 }
 
-// No-op; these dispatcher functions don't use the object store
+// Only the store of the deployments is used to find the deployment of the pods
 func (c *KubernetesController) SetStore(kind schema.GroupVersionKind, store cache.Store) error {
+	if kind == DeploymentKind {
+		c.deployments = store
+	}
 	return nil
 }
 
@@ -63,15 +87,36 @@ func (c *KubernetesController) SupportedKinds() events.KindList {
 		DeploymentKind,
 		ServiceKind,
 		IngressKind,
+		PodKind,
 		// TODO decide how to proceed with namespaces control
 	}
 }
 
+// Add an application fragment to be monitored. Its resources are added afterwards using AddMonitoredResource.
+func (c *KubernetesController) AddMonitoredEntry(entry *entities.MonitoredAppEntry) {
+	c.monitoredInstances.AddEntry(entry)
+}
+
 // Add a resource to be monitored indicating its id on the target platform (uid) and the stage identifier.
 func (c *KubernetesController) AddMonitoredResource(resource *entities.MonitoredPlatformResource) {
 	c.monitoredInstances.AddPendingResource(resource)
 }
 
+// Stop monitoring a resource removed from the cluster.
+func (c *KubernetesController) RemoveMonitoredResource(fragmentId string, serviceInstanceID string, uid string) {
+	c.monitoredInstances.RemoveResource(fragmentId, serviceInstanceID, uid)
+}
+
+// Stop monitoring a service removed from its fragment.
+func (c *KubernetesController) RemoveMonitoredService(fragmentId string, serviceInstanceID string) {
+	c.monitoredInstances.RemoveService(fragmentId, serviceInstanceID)
+}
+
+// Add an observer of the deployments status. Observers must be added before the events are dispatched.
+func (c *KubernetesController) AddDeploymentObserver(observer DeploymentObserver) {
+	c.observers = append(c.observers, observer)
+}
+
 // Set the status of a native resource
 func (c *KubernetesController) SetResourceStatus(fragmentId string, serviceID string, uid string,
 	status entities.NalejServiceStatus, info string, endpoints []entities.EndpointInstance) error {
@@ -85,12 +130,16 @@ func (c *KubernetesController) OnDeployment(oldObj, obj interface{}, action even
 
 	if action == events.EventDelete {
 		log.Debug().Str("name", dep.GetName()).Msg("deployment deleted")
+		c.notifyObservers(dep, entities.NALEJ_SERVICE_TERMINATING)
 		return nil
 	}
 
 	// This deployment is monitored, and all its replicas are available
-	// if there are enough replicas, we assume this is working
-	if dep.Status.UnavailableReplicas == 0 && dep.Status.AvailableReplicas > 0 {
+	// if there are enough replicas, we assume this is working. Changes of the spec not observed yet
+	// by kubernetes are pending to be rolled out. Deployments scaled to zero have no replicas to wait for.
+	if dep.Status.ObservedGeneration >= dep.Generation && dep.Status.UnavailableReplicas == 0 &&
+		(dep.Status.AvailableReplicas > 0 || scaledToZero(dep)) {
+		c.notifyObservers(dep, entities.NALEJ_SERVICE_RUNNING)
 		return c.monitoredInstances.SetResourceStatus(dep.Labels[utils.NALEJ_ANNOTATION_DEPLOYMENT_FRAGMENT],
 			dep.Labels[utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID], string(dep.GetUID()),
 			entities.NALEJ_SERVICE_RUNNING, "", []entities.EndpointInstance{})
@@ -108,10 +157,22 @@ func (c *KubernetesController) OnDeployment(oldObj, obj interface{}, action even
 		Str(utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID, dep.Labels[utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID]).
 		Str("uid", string(dep.GetUID())).Interface("status", foundStatus).
 		Msg("set deployment status")
+	c.notifyObservers(dep, foundStatus)
 	return c.monitoredInstances.SetResourceStatus(dep.Labels[utils.NALEJ_ANNOTATION_DEPLOYMENT_FRAGMENT],
 		dep.Labels[utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID], string(dep.GetUID()), foundStatus, info, []entities.EndpointInstance{})
 }
 
+// Check if a deployment runs no replicas by definition.
+func scaledToZero(dep *appsv1.Deployment) bool {
+	return dep.Spec.Replicas != nil && *dep.Spec.Replicas == 0 && dep.Status.Replicas == 0
+}
+
+func (c *KubernetesController) notifyObservers(dep *appsv1.Deployment, status entities.NalejServiceStatus) {
+	for _, observer := range c.observers {
+		observer.OnDeploymentStatus(dep, status)
+	}
+}
+
 func (c *KubernetesController) OnService(oldObj, obj interface{}, action events.EventType) error {
 	// TODO determine what do we expect from a service to be deployed
 	dep := obj.(*corev1.Service)
@@ -237,3 +298,59 @@ func (c *KubernetesController) OnIngress(oldObj, obj interface{}, action events.
 
 	return nil
 }
+
+// Pods are not monitored resources. A pod that cannot start because of its definition sets its deployment as
+// failed so the deployment of the stage fails without waiting for the timeout.
+func (c *KubernetesController) OnPod(oldObj, obj interface{}, action events.EventType) error {
+	pod := obj.(*corev1.Pod)
+	if action == events.EventDelete {
+		return nil
+	}
+
+	info := ""
+	for _, status := range append(pod.Status.InitContainerStatuses, pod.Status.ContainerStatuses...) {
+		if status.State.Waiting != nil && podFailureReasons[status.State.Waiting.Reason] {
+			info = fmt.Sprintf("container %s %s: %s", status.Name, status.State.Waiting.Reason, status.State.Waiting.Message)
+			break
+		}
+	}
+	if info == "" {
+		return nil
+	}
+
+	dep := c.getPodDeployment(pod)
+	if dep == nil {
+		log.Debug().Str("name", pod.GetName()).Str("namespace", pod.GetNamespace()).Msg("deployment of the failed pod not found")
+		return nil
+	}
+	fragmentId := dep.Labels[utils.NALEJ_ANNOTATION_DEPLOYMENT_FRAGMENT]
+	serviceInstanceId := dep.Labels[utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID]
+	if !c.monitoredInstances.IsMonitoredResource(fragmentId, serviceInstanceId, string(dep.GetUID())) {
+		return nil
+	}
+	log.Info().Str("name", pod.GetName()).Str("deployment", dep.GetName()).Str("fragmentId", fragmentId).
+		Str("info", info).Msg("pod failed, set deployment status to error")
+	return c.monitoredInstances.SetResourceStatus(fragmentId, serviceInstanceId, string(dep.GetUID()),
+		entities.NALEJ_SERVICE_ERROR, info, []entities.EndpointInstance{})
+}
+
+// Find the deployment of a pod. The pods of a deployment belong to a replica set named after the deployment
+// and the hash of the pod template.
+func (c *KubernetesController) getPodDeployment(pod *corev1.Pod) *appsv1.Deployment {
+	if c.deployments == nil {
+		return nil
+	}
+	hash := pod.Labels[appsv1.DefaultDeploymentUniqueLabelKey]
+	for _, owner := range pod.OwnerReferences {
+		if owner.Kind != "ReplicaSet" || hash == "" || !strings.HasSuffix(owner.Name, "-"+hash) {
+			continue
+		}
+		name := strings.TrimSuffix(owner.Name, "-"+hash)
+		obj, exists, err := c.deployments.GetByKey(fmt.Sprintf("%s/%s", pod.Namespace, name))
+		if err != nil || !exists {
+			return nil
+		}
+		return obj.(*appsv1.Deployment)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kubernetes
+
+import (
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/deployment-manager/internal/structures/monitor"
+	"github.com/nalej/deployment-manager/pkg/kubernetes/events"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+func controllerPod(dep *appsv1.Deployment, reason string) *apiv1.Pod {
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            dep.Name + "-5d4f8-x2k9p",
+			Namespace:       dep.Namespace,
+			Labels:          map[string]string{appsv1.DefaultDeploymentUniqueLabelKey: "5d4f8"},
+			OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: dep.Name + "-5d4f8"}},
+		},
+		Status: apiv1.PodStatus{
+			ContainerStatuses: []apiv1.ContainerStatus{{
+				Name:  "web",
+				State: apiv1.ContainerState{Waiting: &apiv1.ContainerStateWaiting{Reason: reason, Message: "cannot pull image"}},
+			}},
+		},
+	}
+}
+
+var _ = ginkgo.Describe("Kubernetes controller tests", func() {
+
+	var instances monitor.MonitoredInstances
+	var controller *KubernetesController
+	var dep *appsv1.Deployment
+
+	ginkgo.BeforeEach(func() {
+		instances = monitor.NewMemoryMonitoredInstances()
+		controller = NewKubernetesController(instances)
+		dep = testDeployment("ns1", "web", "frag1", "serv1")
+		store := cache.NewStore(cache.MetaNamespaceKeyFunc)
+		gomega.Expect(store.Add(dep)).To(gomega.Succeed())
+		gomega.Expect(controller.SetStore(DeploymentKind, store)).To(gomega.Succeed())
+
+		controller.AddMonitoredEntry(&entities.MonitoredAppEntry{
+			FragmentId:    "frag1",
+			AppInstanceId: "app",
+			Status:        entities.FRAGMENT_DEPLOYING,
+			Services: map[string]*entities.MonitoredServiceEntry{
+				"serv1": {FragmentId: "frag1", ServiceInstanceID: "serv1", Status: entities.NALEJ_SERVICE_SCHEDULED,
+					Resources: make(map[string]*entities.MonitoredPlatformResource, 0)},
+			},
+			TotalServices: 1,
+		})
+		controller.AddMonitoredResource(&entities.MonitoredPlatformResource{FragmentId: "frag1",
+			ServiceInstanceID: "serv1", UID: string(dep.UID), Status: entities.NALEJ_SERVICE_SCHEDULED})
+	})
+
+	ginkgo.It("should set the deployment of a pod that cannot pull its image as failed", func() {
+		gomega.Expect(controller.OnPod(nil, controllerPod(dep, "ImagePullBackOff"), events.EventUpdate)).To(gomega.Succeed())
+		entry := instances.GetEntry("frag1")
+		gomega.Expect(entry.Services["serv1"].Status).To(gomega.Equal(entities.NalejServiceStatus(entities.NALEJ_SERVICE_ERROR)))
+		gomega.Expect(entry.Services["serv1"].Info).To(gomega.ContainSubstring("ImagePullBackOff"))
+	})
+
+	ginkgo.It("should ignore pods that are starting", func() {
+		gomega.Expect(controller.OnPod(nil, controllerPod(dep, "ContainerCreating"), events.EventAdd)).To(gomega.Succeed())
+		entry := instances.GetEntry("frag1")
+		gomega.Expect(entry.Services["serv1"].Status).NotTo(gomega.Equal(entities.NalejServiceStatus(entities.NALEJ_SERVICE_ERROR)))
+	})
+
+	ginkgo.It("should ignore pods of deployments that are not monitored", func() {
+		other := testDeployment("ns1", "other", "frag1", "serv1")
+		gomega.Expect(controller.OnPod(nil, controllerPod(other, "ImagePullBackOff"), events.EventAdd)).To(gomega.Succeed())
+		entry := instances.GetEntry("frag1")
+		gomega.Expect(entry.Services["serv1"].Status).NotTo(gomega.Equal(entities.NalejServiceStatus(entities.NALEJ_SERVICE_ERROR)))
+	})
+
+	ginkgo.It("should set deployments scaled to zero as running", func() {
+		scaled := dep.DeepCopy()
+		scaled.Spec.Replicas = int32Ptr(0)
+		scaled.Status = appsv1.DeploymentStatus{ObservedGeneration: scaled.Generation, Conditions: []appsv1.DeploymentCondition{
+			{Type: appsv1.DeploymentAvailable, Status: apiv1.ConditionTrue},
+			{Type: appsv1.DeploymentProgressing, Status: apiv1.ConditionTrue},
+		}}
+		gomega.Expect(controller.OnDeployment(nil, scaled, events.EventUpdate)).To(gomega.Succeed())
+		entry := instances.GetEntry("frag1")
+		gomega.Expect(entry.Services["serv1"].Status).To(gomega.Equal(entities.NalejServiceStatus(entities.NALEJ_SERVICE_RUNNING)))
+	})
+})
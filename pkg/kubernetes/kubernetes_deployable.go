@@ -17,6 +17,7 @@
 package kubernetes
 
 import (
+	"context"
 	"fmt"
 	"github.com/nalej/deployment-manager/internal/entities"
 	"github.com/nalej/deployment-manager/pkg/executor"
@@ -142,11 +143,13 @@ func (d DeployableKubernetesStage) Build() error {
 	return nil
 }
 
-func (d DeployableKubernetesStage) Deploy(controller executor.DeploymentController) error {
+// Deploy the objects of the stage in order. Every deployable checks the context before creating a new object so
+// a cancelled deployment stops as soon as possible.
+func (d DeployableKubernetesStage) Deploy(ctx context.Context, controller executor.DeploymentController) error {
 
 	// Deploy Secrets
 	log.Debug().Str("stageId", d.data.Stage.StageId).Msg("Deploy Secrets")
-	err := d.Secrets.Deploy(controller)
+	err := d.Secrets.Deploy(ctx, controller)
 	if err != nil {
 		log.Error().Err(err).Msg("error deploying Secrets, aborting")
 		return err
@@ -154,7 +157,7 @@ func (d DeployableKubernetesStage) Deploy(controller executor.DeploymentControll
 
 	// Deploy Configmaps
 	log.Debug().Str("stageId", d.data.Stage.StageId).Msg("Deploy Configmaps")
-	err = d.Configmaps.Deploy(controller)
+	err = d.Configmaps.Deploy(ctx, controller)
 	if err != nil {
 		log.Error().Err(err).Msg("error deploying Configmaps, aborting")
 		return err
@@ -162,7 +165,7 @@ func (d DeployableKubernetesStage) Deploy(controller executor.DeploymentControll
 
 	// Deploy Storage
 	log.Debug().Str("stageId", d.data.Stage.StageId).Msg("Deploy Storage")
-	err = d.Storage.Deploy(controller)
+	err = d.Storage.Deploy(ctx, controller)
 	if err != nil {
 		log.Error().Err(err).Msg("error deploying Storage, aborting")
 		return err
@@ -170,35 +173,35 @@ func (d DeployableKubernetesStage) Deploy(controller executor.DeploymentControll
 
 	// Deploy Deployments
 	log.Debug().Str("stageId", d.data.Stage.StageId).Msg("Deploy Deployments")
-	err = d.Deployments.Deploy(controller)
+	err = d.Deployments.Deploy(ctx, controller)
 	if err != nil {
 		log.Error().Err(err).Msg("error deploying Deployments, aborting")
 		return err
 	}
 	// Deploy Services
 	log.Debug().Str("stageId", d.data.Stage.StageId).Msg("Deploy Services")
-	err = d.Services.Deploy(controller)
+	err = d.Services.Deploy(ctx, controller)
 	if err != nil {
 		log.Error().Err(err).Msg("error deploying Services, aborting")
 		return err
 	}
 
 	log.Debug().Str("stageId", d.data.Stage.StageId).Msg("Deploy Device Group Services")
-	err = d.DeviceGroupServices.Deploy(controller)
+	err = d.DeviceGroupServices.Deploy(ctx, controller)
 	if err != nil {
 		log.Error().Err(err).Msg("error deploying DeviceGroup Services, aborting")
 		return err
 	}
 
 	log.Debug().Str("stageId", d.data.Stage.StageId).Msg("Deploy Ingresses")
-	err = d.Ingresses.Deploy(controller)
+	err = d.Ingresses.Deploy(ctx, controller)
 	if err != nil {
 		log.Error().Err(err).Msg("error deploying Ingresses, aborting")
 		return err
 	}
 
 	log.Debug().Str("stageId", d.data.Stage.StageId).Msg("Deploy Load Balancer")
-	err = d.LoadBalancers.Deploy(controller)
+	err = d.LoadBalancers.Deploy(ctx, controller)
 	if err != nil {
 		log.Error().Err(err).Msg("error deploying Load Balancers, aborting")
 		return err
@@ -17,6 +17,7 @@
 package kubernetes
 
 import (
+	"context"
 	"fmt"
 	"github.com/nalej/deployment-manager/internal/entities"
 	"github.com/nalej/deployment-manager/pkg/common"
@@ -187,6 +188,7 @@ func (d *DeployableDeployments) Build() error {
 		extendedLabels[utils.NALEJ_ANNOTATION_SERVICE_GROUP_NAME] = common.FormatNameWithHyphen(service.ServiceGroupName)
 		extendedLabels[utils.NALEJ_ANNOTATION_SERVICE_GROUP_INSTANCE_ID] = service.ServiceGroupInstanceId
 		extendedLabels[utils.NALEJ_ANNOTATION_IS_PROXY] = "false"
+		extendedLabels[utils.NALEJ_ANNOTATION_ROLLOUT_TRACK] = utils.NALEJ_ANNOTATION_VALUE_STABLE_TRACK
 
 		environmentVariables := d.getEnvVariables(d.Data.NalejVariables, service.EnvironmentVariables)
 		environmentVariables = d.addDeviceGroupEnvVariables(environmentVariables, service.ServiceGroupInstanceId, service.ServiceInstanceId)
@@ -311,10 +313,13 @@ func (d *DeployableDeployments) Build() error {
 	return nil
 }
 
-func (d *DeployableDeployments) Deploy(controller executor.DeploymentController) error {
+func (d *DeployableDeployments) Deploy(ctx context.Context, controller executor.DeploymentController) error {
 
 	// same approach for service Deployments
 	for _, deployment := range d.Deployments {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
 		deployed, err := d.Client.Create(deployment)
 		if err != nil {
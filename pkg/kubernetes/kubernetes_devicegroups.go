@@ -17,6 +17,7 @@
 package kubernetes
 
 import (
+	"context"
 	"fmt"
 	"github.com/nalej/deployment-manager/internal/entities"
 	"github.com/nalej/deployment-manager/pkg/common"
@@ -139,8 +140,11 @@ func (d *DeployableDeviceGroups) Build() error {
 	return nil
 }
 
-func (d *DeployableDeviceGroups) Deploy(controller executor.DeploymentController) error {
+func (d *DeployableDeviceGroups) Deploy(ctx context.Context, controller executor.DeploymentController) error {
 	for _, servInfo := range d.Services {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		created, err := d.client.Create(&servInfo.Service)
 		if err != nil {
 			log.Error().Err(err).Msgf("error creating service %s", servInfo.Service.Name)
@@ -17,6 +17,7 @@
 package kubernetes
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/nalej/deployment-manager/internal/entities"
@@ -39,11 +40,13 @@ type KubernetesExecutor struct {
 	Client *kubernetes.Clientset
 	// Controller that handles Kubernetes events add updates the MonitoredInstance
 	Controller executor.DeploymentController
+	// Controller of the rollouts started by fragment updates
+	Rollouts *RolloutController
 	// mutex
 	mu sync.Mutex
 }
 
-func NewKubernetesExecutor(internal bool, controller executor.DeploymentController) (executor.Executor, error) {
+func NewKubernetesExecutor(internal bool, controller executor.DeploymentController, rollouts *RolloutController) (executor.Executor, error) {
 	var c *kubernetes.Clientset
 	var err error
 
@@ -67,6 +70,7 @@ func NewKubernetesExecutor(internal bool, controller executor.DeploymentControll
 	toReturn := KubernetesExecutor{
 		Client:     c,
 		Controller: controller,
+		Rollouts:   rollouts,
 	}
 	return &toReturn, err
 }
@@ -123,7 +127,7 @@ func (k *KubernetesExecutor) GetApplicationNamespace(organizationId string, appI
 
 // Prepare the Namespace for the deployment. This is a special case because all the Deployments will share a common
 // Namespace. If this step cannot be done, no stage deployment will start.
-func (k *KubernetesExecutor) PrepareEnvironmentForDeployment(metadata entities.DeploymentMetadata,
+func (k *KubernetesExecutor) PrepareEnvironmentForDeployment(ctx context.Context, metadata entities.DeploymentMetadata,
 	networkDecorator executor.NetworkDecorator) (executor.Deployable, error) {
 	log.Debug().Str("fragmentId", metadata.FragmentId).Msg("prepare environment for deployment")
 
@@ -138,7 +142,7 @@ func (k *KubernetesExecutor) PrepareEnvironmentForDeployment(metadata entities.D
 	if !namespaceDeployable.exists() {
 		log.Debug().Str("Namespace", metadata.Namespace).Msg("create Namespace...")
 		// TODO Check if Namespace already exists...
-		err = namespaceDeployable.Deploy(k.Controller)
+		err = namespaceDeployable.Deploy(ctx, k.Controller)
 		if err != nil {
 			log.Error().Err(err).Msgf("impossible to deploy Namespace %s", metadata.Namespace)
 			return nil, err
@@ -151,7 +155,7 @@ func (k *KubernetesExecutor) PrepareEnvironmentForDeployment(metadata entities.D
 		if err != nil {
 			log.Error().Err(err).Msg("impossible to build nalej-public-registry secret")
 		}
-		err = nalejSecret.Deploy(k.Controller)
+		err = nalejSecret.Deploy(ctx, k.Controller)
 		if err != nil {
 			log.Error().Err(err).Msg("impossible to deploy nalej-public-registry secret")
 			return nil, err
@@ -167,12 +171,12 @@ func (k *KubernetesExecutor) PrepareEnvironmentForDeployment(metadata entities.D
 }
 
 // Deploy a stage into kubernetes. This function
-func (k *KubernetesExecutor) DeployStage(toDeploy executor.Deployable, fragment *pbConductor.DeploymentFragment,
+func (k *KubernetesExecutor) DeployStage(ctx context.Context, toDeploy executor.Deployable, fragment *pbConductor.DeploymentFragment,
 	stage *pbConductor.DeploymentStage) error {
 	log.Info().Str("stage", stage.StageId).Msgf("execute stage %s with %d Services", stage.StageId, len(stage.Services))
 
 	// Deploy everything and then start the controller.
-	err := toDeploy.Deploy(k.Controller)
+	err := toDeploy.Deploy(ctx, k.Controller)
 	if err != nil {
 		log.Error().Err(err).Msgf("impossible to deploy resources for stage %s in fragment %s", stage.StageId, stage.FragmentId)
 		return err
@@ -17,8 +17,14 @@
 package kubernetes
 
 import (
+	"fmt"
+	"github.com/nalej/deployment-manager/pkg/utils"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"testing"
 )
 
@@ -26,3 +32,47 @@ func TestKubernetesExectorTest(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "Kubernetes executor Suite")
 }
+
+// Build the deployment of a service as it is deployed for a fragment: labelled with its application, fragment and
+// service, in the stable track and running one replica of a container named after the deployment. Tests modify the
+// returned deployment to fit their needs.
+//  params:
+//   namespace of the deployment
+//   name of the deployment and of its service
+//   fragmentId fragment the deployment belongs to
+//   serviceInstanceId service instance the deployment belongs to
+//  return:
+//   the deployment
+func testDeployment(namespace string, name string, fragmentId string, serviceInstanceId string) *appsv1.Deployment {
+	labels := map[string]string{
+		utils.NALEJ_ANNOTATION_ORGANIZATION_ID:     "org",
+		utils.NALEJ_ANNOTATION_APP_DESCRIPTOR:      "desc",
+		utils.NALEJ_ANNOTATION_APP_INSTANCE_ID:     "app",
+		utils.NALEJ_ANNOTATION_DEPLOYMENT_FRAGMENT: fragmentId,
+		utils.NALEJ_ANNOTATION_SERVICE_ID:          "serv",
+		utils.NALEJ_ANNOTATION_SERVICE_NAME:        name,
+		utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID: serviceInstanceId,
+		utils.NALEJ_ANNOTATION_IS_PROXY:            "false",
+		utils.NALEJ_ANNOTATION_ROLLOUT_TRACK:       utils.NALEJ_ANNOTATION_VALUE_STABLE_TRACK,
+	}
+	templateLabels := make(map[string]string, len(labels))
+	for k, v := range labels {
+		templateLabels[k] = v
+	}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			UID:       types.UID(name + "-uid"),
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: int32Ptr(1),
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{utils.NALEJ_ANNOTATION_SERVICE_NAME: name}},
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: templateLabels},
+				Spec:       apiv1.PodSpec{Containers: []apiv1.Container{{Name: name, Image: fmt.Sprintf("%s:1", name)}}},
+			},
+		},
+	}
+}
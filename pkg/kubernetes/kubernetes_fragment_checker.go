@@ -0,0 +1,93 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kubernetes
+
+import (
+	"fmt"
+
+	"github.com/nalej/deployment-manager/pkg/utils"
+	"github.com/nalej/derrors"
+
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The fragment checker confirms that the objects of a fragment have been removed from the cluster.
+type FragmentChecker struct {
+	// Kubernetes client
+	client kubernetes.Interface
+}
+
+// Create a new fragment checker.
+//  params:
+//   client kubernetes client
+//  return:
+//   fragment checker
+func NewFragmentChecker(client kubernetes.Interface) *FragmentChecker {
+	return &FragmentChecker{client: client}
+}
+
+// Check if any object of a fragment remains in the cluster. The fragment remains while its namespace is being
+// removed. If the namespace is active, as other fragments of the application are running, the fragment remains
+// while any of its deployments, services or ingresses exists.
+//  params:
+//   namespace of the fragment
+//   fragmentId identifier of the fragment
+//  return:
+//   true if the fragment remains in the cluster or error if it cannot be checked
+func (c *FragmentChecker) FragmentExists(namespace string, fragmentId string) (bool, derrors.Error) {
+	if namespace == "" {
+		// the fragment did not reach the creation of its namespace
+		return false, nil
+	}
+	ns, err := c.client.CoreV1().Namespaces().Get(namespace, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, derrors.AsError(err, "impossible to get namespace")
+	}
+	if ns.Status.Phase == apiv1.NamespaceTerminating {
+		return true, nil
+	}
+
+	opts := metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", utils.NALEJ_ANNOTATION_DEPLOYMENT_FRAGMENT, fragmentId),
+		Limit:         1,
+	}
+	deployments, err := c.client.AppsV1().Deployments(namespace).List(opts)
+	if err != nil {
+		return false, derrors.AsError(err, "impossible to list deployments")
+	}
+	if len(deployments.Items) > 0 {
+		return true, nil
+	}
+	services, err := c.client.CoreV1().Services(namespace).List(opts)
+	if err != nil {
+		return false, derrors.AsError(err, "impossible to list services")
+	}
+	if len(services.Items) > 0 {
+		return true, nil
+	}
+	ingresses, err := c.client.ExtensionsV1beta1().Ingresses(namespace).List(opts)
+	if err != nil {
+		return false, derrors.AsError(err, "impossible to list ingresses")
+	}
+	return len(ingresses.Items) > 0, nil
+}
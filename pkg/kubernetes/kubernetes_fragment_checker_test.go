@@ -0,0 +1,55 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kubernetes
+
+import (
+	"github.com/nalej/deployment-manager/pkg/utils"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = ginkgo.Describe("Kubernetes fragment checker tests", func() {
+
+	ginkgo.It("should not find the fragments of a removed namespace", func() {
+		checker := NewFragmentChecker(fake.NewSimpleClientset())
+		exists, err := checker.FragmentExists("ns1", "frag1")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(exists).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should find the fragments of a namespace being removed", func() {
+		checker := NewFragmentChecker(fake.NewSimpleClientset(reconcilerNamespace("ns1", apiv1.NamespaceTerminating)))
+		exists, err := checker.FragmentExists("ns1", "frag1")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(exists).To(gomega.BeTrue())
+	})
+
+	ginkgo.It("should check the objects of the fragments in an active namespace", func() {
+		service := &apiv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns1",
+			Labels: map[string]string{utils.NALEJ_ANNOTATION_DEPLOYMENT_FRAGMENT: "frag1"}}}
+		checker := NewFragmentChecker(fake.NewSimpleClientset(reconcilerNamespace("ns1", apiv1.NamespaceActive), service))
+		exists, err := checker.FragmentExists("ns1", "frag1")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(exists).To(gomega.BeTrue())
+		exists, err = checker.FragmentExists("ns1", "frag2")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(exists).To(gomega.BeFalse())
+	})
+})
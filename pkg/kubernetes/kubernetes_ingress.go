@@ -17,6 +17,7 @@
 package kubernetes
 
 import (
+	"context"
 	"fmt"
 	"github.com/nalej/deployment-manager/internal/entities"
 	"github.com/nalej/deployment-manager/pkg/config"
@@ -268,10 +269,13 @@ func (di *DeployableIngress) Build() error {
 	return nil
 }
 
-func (di *DeployableIngress) Deploy(controller executor.DeploymentController) error {
+func (di *DeployableIngress) Deploy(ctx context.Context, controller executor.DeploymentController) error {
 	numCreated := 0
 	for _, ingresses := range di.Ingresses {
 		for _, toCreate := range ingresses.Ingresses {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			log.Debug().Interface("toCreate", toCreate).Msg("Creating ingress")
 			created, err := di.client.Create(toCreate)
 			if err != nil {
@@ -17,6 +17,7 @@
 package kubernetes
 
 import (
+	"context"
 	"fmt"
 	"github.com/nalej/deployment-manager/internal/entities"
 	"github.com/nalej/deployment-manager/pkg/common"
@@ -153,8 +154,11 @@ func (dl *DeployableLoadBalancer) Build() error {
 	return nil
 }
 
-func (dl *DeployableLoadBalancer) Deploy(controller executor.DeploymentController) error {
+func (dl *DeployableLoadBalancer) Deploy(ctx context.Context, controller executor.DeploymentController) error {
 	for _, servInfo := range dl.loadBalancers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		created, err := dl.client.Create(&servInfo.Service)
 		if err != nil {
 			log.Error().Err(err).Msgf("error creating service %s", servInfo.Service.Name)
@@ -17,6 +17,7 @@
 package kubernetes
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"github.com/nalej/deployment-manager/internal/entities"
@@ -97,9 +98,12 @@ func (ds *DeployableNalejSecret) Build() error {
 	return nil
 }
 
-func (ds *DeployableNalejSecret) Deploy(controller executor.DeploymentController) error {
+func (ds *DeployableNalejSecret) Deploy(ctx context.Context, controller executor.DeploymentController) error {
 	numCreated := 0
 	for serviceId, toCreate := range ds.secrets {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		log.Debug().Interface("toCreate", toCreate).Msg("creating nalej-public-registry secret")
 		created, err := ds.client.Create(toCreate)
 		if err != nil {
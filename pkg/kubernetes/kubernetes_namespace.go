@@ -17,6 +17,7 @@
 package kubernetes
 
 import (
+	"context"
 	"fmt"
 	"github.com/nalej/deployment-manager/internal/entities"
 	"github.com/nalej/deployment-manager/pkg/executor"
@@ -85,7 +86,7 @@ func (n *DeployableNamespace) Build() error {
 	return nil
 }
 
-func (n *DeployableNamespace) Deploy(controller executor.DeploymentController) error {
+func (n *DeployableNamespace) Deploy(ctx context.Context, controller executor.DeploymentController) error {
 	retrieved, err := n.client.Get(n.data.Namespace, metav1.GetOptions{})
 
 	if retrieved.Name != "" {
@@ -100,7 +101,7 @@ func (n *DeployableNamespace) Deploy(controller executor.DeploymentController) e
 	log.Debug().Msgf("invoked Namespace with uid %s", string(created.Namespace))
 	n.Namespace = *created
 
-	netErr := n.Deploy(controller)
+	netErr := n.Deploy(ctx, controller)
 	if netErr != nil {
 		log.Error().Err(netErr).Msg("error running networking decorator during namespace deploy")
 	}
@@ -0,0 +1,152 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kubernetes
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/deployment-manager/pkg/utils"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	v1 "k8s.io/client-go/kubernetes/typed/apps/v1"
+	"time"
+)
+
+// Operations over the deployment of a single service of a running application. The deployments are patched so
+// the changes made concurrently by kubernetes or other operations are not overwritten.
+
+// Annotation of the pod template changed to restart the pods of a deployment
+const RestartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+func (k *KubernetesExecutor) ScaleService(namespace string, serviceInstanceId string, replicas int32) derrors.Error {
+	dep, err := scaleDeployment(k.Client.AppsV1().Deployments(namespace), serviceInstanceId, replicas)
+	if err != nil {
+		return err
+	}
+	// there are no pods to wait for, the controller reports the deployment as running once it is observed
+	if replicas == 0 {
+		return nil
+	}
+	k.setDeploying(dep)
+	return nil
+}
+
+func (k *KubernetesExecutor) RestartService(namespace string, serviceInstanceId string) derrors.Error {
+	dep, err := restartDeployment(k.Client.AppsV1().Deployments(namespace), serviceInstanceId, time.Now())
+	if err != nil {
+		return err
+	}
+	k.setDeploying(dep)
+	return nil
+}
+
+// Report a modified deployment as deploying until the controller finds it running again.
+func (k *KubernetesExecutor) setDeploying(dep *appsv1.Deployment) {
+	err := k.Controller.SetResourceStatus(dep.Labels[utils.NALEJ_ANNOTATION_DEPLOYMENT_FRAGMENT],
+		dep.Labels[utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID], string(dep.GetUID()), entities.NALEJ_SERVICE_DEPLOYING,
+		"", []entities.EndpointInstance{})
+	if err != nil {
+		log.Warn().Err(err).Str("name", dep.Name).Msg("modified deployment is not monitored")
+	}
+}
+
+// Find the deployment of a service instance. Proxies and rollout candidates are ignored.
+//
+//	params:
+//	 client deployments client
+//	 serviceInstanceId service instance
+//	return:
+//	 deployment or error if not found
+func findServiceDeployment(client v1.DeploymentInterface, serviceInstanceId string) (*appsv1.Deployment, derrors.Error) {
+	selector := fmt.Sprintf("%s=%s,%s!=true,%s notin (%s,%s)", utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID, serviceInstanceId,
+		utils.NALEJ_ANNOTATION_IS_PROXY, utils.NALEJ_ANNOTATION_ROLLOUT_TRACK,
+		utils.NALEJ_ANNOTATION_VALUE_CANARY_TRACK, utils.NALEJ_ANNOTATION_VALUE_GREEN_TRACK)
+	list, err := client.List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, derrors.NewInternalError("impossible to list service deployments", err).WithParams(serviceInstanceId)
+	}
+	if len(list.Items) == 0 {
+		return nil, derrors.NewNotFoundError("service deployment not found").WithParams(serviceInstanceId)
+	}
+	if len(list.Items) > 1 {
+		log.Warn().Str("serviceInstanceId", serviceInstanceId).Int("found", len(list.Items)).
+			Msg("more than one deployment found for the service, using the first one")
+	}
+	return &list.Items[0], nil
+}
+
+// Set the number of replicas of the deployment of a service.
+//
+//	params:
+//	 client deployments client
+//	 serviceInstanceId service instance
+//	 replicas new number of replicas
+//	return:
+//	 updated deployment or error if any
+func scaleDeployment(client v1.DeploymentInterface, serviceInstanceId string, replicas int32) (*appsv1.Deployment, derrors.Error) {
+	dep, derr := findServiceDeployment(client, serviceInstanceId)
+	if derr != nil {
+		return nil, derr
+	}
+	patch := map[string]interface{}{"spec": map[string]interface{}{"replicas": replicas}}
+	updated, err := patchDeployment(client, dep.Name, patch)
+	if err != nil {
+		log.Error().Err(err).Str("name", dep.Name).Msg("cannot scale deployment")
+		return nil, derrors.NewInternalError("impossible to scale service deployment", err).WithParams(serviceInstanceId)
+	}
+	log.Info().Str("name", dep.Name).Int32("replicas", replicas).Msg("deployment scaled")
+	return updated, nil
+}
+
+// Restart the pods of the deployment of a service. The pod template is annotated with the restart time so the
+// pods are replaced following the update strategy of the deployment.
+//
+//	params:
+//	 client deployments client
+//	 serviceInstanceId service instance
+//	 at restart time
+//	return:
+//	 updated deployment or error if any
+func restartDeployment(client v1.DeploymentInterface, serviceInstanceId string, at time.Time) (*appsv1.Deployment, derrors.Error) {
+	dep, derr := findServiceDeployment(client, serviceInstanceId)
+	if derr != nil {
+		return nil, derr
+	}
+	patch := map[string]interface{}{"spec": map[string]interface{}{"template": map[string]interface{}{
+		"metadata": map[string]interface{}{"annotations": map[string]string{RestartedAtAnnotation: at.Format(time.RFC3339)}},
+	}}}
+	updated, err := patchDeployment(client, dep.Name, patch)
+	if err != nil {
+		log.Error().Err(err).Str("name", dep.Name).Msg("cannot restart deployment")
+		return nil, derrors.NewInternalError("impossible to restart service deployment", err).WithParams(serviceInstanceId)
+	}
+	log.Info().Str("name", dep.Name).Msg("deployment restarted")
+	return updated, nil
+}
+
+// Apply a merge patch to a deployment.
+func patchDeployment(client v1.DeploymentInterface, name string, patch map[string]interface{}) (*appsv1.Deployment, error) {
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	return client.Patch(name, types.MergePatchType, data)
+}
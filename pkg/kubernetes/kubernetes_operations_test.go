@@ -0,0 +1,78 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kubernetes
+
+import (
+	"github.com/nalej/deployment-manager/pkg/utils"
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"time"
+)
+
+var _ = ginkgo.Describe("Kubernetes service operations tests", func() {
+
+	var client *fake.Clientset
+
+	ginkgo.BeforeEach(func() {
+		proxy := testDeployment("ns", "zt-web", "frag1", "inst1")
+		proxy.Labels[utils.NALEJ_ANNOTATION_IS_PROXY] = "true"
+		canary := testDeployment("ns", "web-canary", "frag1", "inst1")
+		canary.Labels[utils.NALEJ_ANNOTATION_ROLLOUT_TRACK] = utils.NALEJ_ANNOTATION_VALUE_CANARY_TRACK
+		client = fake.NewSimpleClientset(testDeployment("ns", "web", "frag1", "inst1"), proxy, canary)
+	})
+
+	ginkgo.It("should scale only the deployment of the service", func() {
+		updated, err := scaleDeployment(client.AppsV1().Deployments("ns"), "inst1", 3)
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(updated.Name).To(gomega.Equal("web"))
+
+		for name, replicas := range map[string]int32{"web": 3, "zt-web": 1, "web-canary": 1} {
+			dep, err := client.AppsV1().Deployments("ns").Get(name, metav1.GetOptions{})
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(*dep.Spec.Replicas).To(gomega.Equal(replicas))
+		}
+	})
+
+	ginkgo.It("should scale the service down to zero replicas", func() {
+		_, err := scaleDeployment(client.AppsV1().Deployments("ns"), "inst1", 0)
+		gomega.Expect(err).To(gomega.BeNil())
+
+		dep, getErr := client.AppsV1().Deployments("ns").Get("web", metav1.GetOptions{})
+		gomega.Expect(getErr).To(gomega.Succeed())
+		gomega.Expect(*dep.Spec.Replicas).To(gomega.Equal(int32(0)))
+		gomega.Expect(dep.Spec.Template.Spec.Containers).To(gomega.HaveLen(1))
+	})
+
+	ginkgo.It("should annotate the pod template to restart the service", func() {
+		at := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+		_, err := restartDeployment(client.AppsV1().Deployments("ns"), "inst1", at)
+		gomega.Expect(err).To(gomega.BeNil())
+
+		dep, getErr := client.AppsV1().Deployments("ns").Get("web", metav1.GetOptions{})
+		gomega.Expect(getErr).To(gomega.Succeed())
+		gomega.Expect(dep.Spec.Template.Annotations[RestartedAtAnnotation]).To(gomega.Equal("2019-10-01T12:00:00Z"))
+	})
+
+	ginkgo.It("should fail for unknown services", func() {
+		_, err := scaleDeployment(client.AppsV1().Deployments("ns"), "unknown", 3)
+		gomega.Expect(err).NotTo(gomega.BeNil())
+		gomega.Expect(err.Type()).To(gomega.Equal(derrors.NotFound))
+	})
+})
@@ -0,0 +1,239 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package kubernetes
+
+import (
+	"fmt"
+
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/deployment-manager/pkg/utils"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Selector of the platform resources that were registered to be monitored when the fragment was deployed.
+// Proxies added by the network decorators are not monitored.
+var reconcilerResourceSelector = fmt.Sprintf("%s,%s,%s!=true", utils.NALEJ_ANNOTATION_DEPLOYMENT_FRAGMENT,
+	utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID, utils.NALEJ_ANNOTATION_IS_PROXY)
+
+// The reconciler rebuilds the monitored entries from the resources found in the cluster. This permits the
+// deployment manager to be restarted without losing track of the applications that are already running.
+// It must run before the events provider is started so the status of the rebuilt resources is updated by
+// the initial events. It can run again later, for example when a replica takes over the leadership: the entries
+// already monitored keep their state and only the missing services and resources are added, so the events of
+// the added resources must be dispatched again.
+type Reconciler struct {
+	// Kubernetes client
+	client kubernetes.Interface
+	// Controller where the entries and resources are registered
+	controller *KubernetesController
+}
+
+// Create a new reconciler.
+//  params:
+//   client kubernetes client to list the resources
+//   controller where the rebuilt entries are registered
+//  return:
+//   reconciler
+func NewReconciler(client kubernetes.Interface, controller *KubernetesController) *Reconciler {
+	return &Reconciler{
+		client:     client,
+		controller: controller,
+	}
+}
+
+// Rebuild the monitored entries from the cluster and register them with the controller.
+//  return:
+//   error if the resources cannot be listed
+func (r *Reconciler) Reconcile() derrors.Error {
+	namespaces, err := r.activeNamespaces()
+	if err != nil {
+		return err
+	}
+	rebuilt := newReconciledEntries(namespaces)
+
+	opts := metav1.ListOptions{LabelSelector: reconcilerResourceSelector}
+	deployments, listErr := r.client.AppsV1().Deployments(metav1.NamespaceAll).List(opts)
+	if listErr != nil {
+		return derrors.AsError(listErr, "impossible to list deployments")
+	}
+	for i := range deployments.Items {
+		rebuilt.add(&deployments.Items[i])
+	}
+	services, listErr := r.client.CoreV1().Services(metav1.NamespaceAll).List(opts)
+	if listErr != nil {
+		return derrors.AsError(listErr, "impossible to list services")
+	}
+	for i := range services.Items {
+		rebuilt.add(&services.Items[i])
+	}
+	ingresses, listErr := r.client.ExtensionsV1beta1().Ingresses(metav1.NamespaceAll).List(opts)
+	if listErr != nil {
+		return derrors.AsError(listErr, "impossible to list ingresses")
+	}
+	for i := range ingresses.Items {
+		rebuilt.add(&ingresses.Items[i])
+	}
+
+	current := make(map[string]*entities.MonitoredAppEntry, 0)
+	for _, entry := range r.controller.monitoredInstances.ListEntries() {
+		current[entry.FragmentId] = entry
+	}
+
+	// entries must be monitored before adding their resources
+	for _, entry := range rebuilt.entries {
+		suspended := entry.Status == entities.FRAGMENT_SUSPENDED
+		if suspended {
+			for _, service := range entry.Services {
+				service.Status = entities.NALEJ_SERVICE_SUSPENDED
+			}
+		}
+		existing, found := current[entry.FragmentId]
+		log.Info().Str("fragmentId", entry.FragmentId).Str("appInstanceId", entry.AppInstanceId).
+			Str("namespace", entry.Namespace).Int("services", entry.TotalServices).Bool("monitored", found).
+			Msg("reconciled monitored fragment")
+		// missing services are appended to the monitored entries
+		r.controller.AddMonitoredEntry(entry)
+		if found && suspended != (existing.Status == entities.FRAGMENT_SUSPENDED) {
+			// the application was suspended or resumed by another instance
+			status := entities.FragmentStatus(entities.FRAGMENT_DEPLOYING)
+			if suspended {
+				status = entities.FRAGMENT_SUSPENDED
+			}
+			r.controller.monitoredInstances.SetAppStatus(entry.AppInstanceId, status, nil)
+		}
+	}
+	added := 0
+	for _, res := range rebuilt.resources {
+		if r.controller.monitoredInstances.IsMonitoredResource(res.FragmentId, res.ServiceInstanceID, res.UID) {
+			continue
+		}
+		r.controller.AddMonitoredResource(res)
+		added++
+	}
+	log.Info().Int("fragments", len(rebuilt.entries)).Int("resources", len(rebuilt.resources)).Int("added", added).
+		Msg("monitored instances reconciled with the cluster")
+	return nil
+}
+
+// Get the user namespaces that are not being deleted.
+func (r *Reconciler) activeNamespaces() (map[string]bool, derrors.Error) {
+	list, err := r.client.CoreV1().Namespaces().List(metav1.ListOptions{LabelSelector: utils.NALEJ_ANNOTATION_ORGANIZATION_ID})
+	if err != nil {
+		return nil, derrors.AsError(err, "impossible to list namespaces")
+	}
+	toReturn := make(map[string]bool, len(list.Items))
+	for _, ns := range list.Items {
+		if ns.DeletionTimestamp != nil || ns.Status.Phase == apiv1.NamespaceTerminating {
+			log.Debug().Str("namespace", ns.Name).Msg("skip terminating namespace")
+			continue
+		}
+		toReturn[ns.Name] = true
+	}
+	return toReturn, nil
+}
+
+// Entries and resources being rebuilt
+type reconciledEntries struct {
+	// namespaces that can be reconciled
+	namespaces map[string]bool
+	// rebuilt entries indexed by fragment id
+	entries map[string]*entities.MonitoredAppEntry
+	// resources to be monitored
+	resources []*entities.MonitoredPlatformResource
+}
+
+func newReconciledEntries(namespaces map[string]bool) *reconciledEntries {
+	return &reconciledEntries{
+		namespaces: namespaces,
+		entries:    make(map[string]*entities.MonitoredAppEntry, 0),
+		resources:  make([]*entities.MonitoredPlatformResource, 0),
+	}
+}
+
+// Add a platform resource building its fragment and service entries if they were not found before.
+func (re *reconciledEntries) add(obj metav1.Object) {
+	if !re.namespaces[obj.GetNamespace()] {
+		log.Debug().Str("namespace", obj.GetNamespace()).Str("name", obj.GetName()).
+			Msg("skip resource in a non active namespace")
+		return
+	}
+	labels := obj.GetLabels()
+	fragmentId := labels[utils.NALEJ_ANNOTATION_DEPLOYMENT_FRAGMENT]
+	serviceInstanceId := labels[utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID]
+
+	entry, found := re.entries[fragmentId]
+	if !found {
+		entry = &entities.MonitoredAppEntry{
+			OrganizationId:  labels[utils.NALEJ_ANNOTATION_ORGANIZATION_ID],
+			AppDescriptorId: labels[utils.NALEJ_ANNOTATION_APP_DESCRIPTOR],
+			AppInstanceId:   labels[utils.NALEJ_ANNOTATION_APP_INSTANCE_ID],
+			DeploymentId:    labels[utils.NALEJ_ANNOTATION_DEPLOYMENT_ID],
+			FragmentId:      fragmentId,
+			Status:          entities.FRAGMENT_WAITING,
+			Services:        make(map[string]*entities.MonitoredServiceEntry, 0),
+			NewStatus:       true,
+			Namespace:       obj.GetNamespace(),
+		}
+		re.entries[fragmentId] = entry
+	}
+	if entry.DeploymentId == "" {
+		entry.DeploymentId = labels[utils.NALEJ_ANNOTATION_DEPLOYMENT_ID]
+	}
+
+	service, found := entry.Services[serviceInstanceId]
+	if !found {
+		service = &entities.MonitoredServiceEntry{
+			OrganizationId:         labels[utils.NALEJ_ANNOTATION_ORGANIZATION_ID],
+			AppDescriptorId:        labels[utils.NALEJ_ANNOTATION_APP_DESCRIPTOR],
+			AppInstanceId:          labels[utils.NALEJ_ANNOTATION_APP_INSTANCE_ID],
+			ServiceGroupId:         labels[utils.NALEJ_ANNOTATION_SERVICE_GROUP_ID],
+			ServiceGroupInstanceId: labels[utils.NALEJ_ANNOTATION_SERVICE_GROUP_INSTANCE_ID],
+			FragmentId:             fragmentId,
+			ServiceID:              labels[utils.NALEJ_ANNOTATION_SERVICE_ID],
+			ServiceName:            labels[utils.NALEJ_ANNOTATION_SERVICE_NAME],
+			ServiceInstanceID:      serviceInstanceId,
+			Endpoints:              make([]entities.EndpointInstance, 0),
+			Status:                 entities.NALEJ_SERVICE_SCHEDULED,
+			NewStatus:              true,
+			Resources:              make(map[string]*entities.MonitoredPlatformResource, 0),
+		}
+		entry.Services[serviceInstanceId] = service
+		entry.TotalServices++
+		entry.NumPendingChecks++
+	}
+	if service.ServiceName == "" {
+		// only deployments are labelled with the service name
+		service.ServiceName = labels[utils.NALEJ_ANNOTATION_SERVICE_NAME]
+	}
+
+	// workloads of suspended applications keep the replicas they had before being suspended
+	if _, suspended := obj.GetAnnotations()[utils.NALEJ_ANNOTATION_SUSPENDED_REPLICAS]; suspended {
+		entry.Status = entities.FRAGMENT_SUSPENDED
+	}
+
+	res := entities.NewMonitoredPlatformResource(fragmentId, string(obj.GetUID()),
+		labels[utils.NALEJ_ANNOTATION_APP_DESCRIPTOR], labels[utils.NALEJ_ANNOTATION_APP_INSTANCE_ID],
+		labels[utils.NALEJ_ANNOTATION_SERVICE_GROUP_ID], labels[utils.NALEJ_ANNOTATION_SERVICE_GROUP_INSTANCE_ID],
+		labels[utils.NALEJ_ANNOTATION_SERVICE_ID], serviceInstanceId, "")
+	re.resources = append(re.resources, &res)
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package kubernetes
+
+import (
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/deployment-manager/internal/structures/monitor"
+	"github.com/nalej/deployment-manager/pkg/kubernetes/events"
+	"github.com/nalej/deployment-manager/pkg/utils"
+	pbApplication "github.com/nalej/grpc-application-go"
+	pbConductor "github.com/nalej/grpc-conductor-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func reconcilerNamespace(name string, phase apiv1.NamespacePhase) *apiv1.Namespace {
+	return &apiv1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{utils.NALEJ_ANNOTATION_ORGANIZATION_ID: "org"},
+		},
+		Status: apiv1.NamespaceStatus{Phase: phase},
+	}
+}
+
+var _ = ginkgo.Describe("Kubernetes reconciler tests", func() {
+
+	ginkgo.It("should rebuild the monitored entries from the cluster", func() {
+		proxy := testDeployment("ns1", "web-proxy", "frag1", "serv1")
+		proxy.Labels[utils.NALEJ_ANNOTATION_IS_PROXY] = "true"
+		client := fake.NewSimpleClientset(
+			reconcilerNamespace("ns1", apiv1.NamespaceActive),
+			reconcilerNamespace("ns2", apiv1.NamespaceTerminating),
+			testDeployment("ns1", "web", "frag1", "serv1"),
+			testDeployment("ns1", "db", "frag1", "serv2"),
+			proxy,
+			testDeployment("ns2", "old", "frag2", "serv3"),
+		)
+		instances := monitor.NewMemoryMonitoredInstances()
+		controller := NewKubernetesController(instances)
+
+		err := NewReconciler(client, controller).Reconcile()
+		gomega.Expect(err).To(gomega.Succeed())
+
+		gomega.Expect(instances.GetNumFragments()).To(gomega.Equal(1))
+		gomega.Expect(instances.GetEntry("frag2")).To(gomega.BeNil())
+		entry := instances.GetEntry("frag1")
+		gomega.Expect(entry).NotTo(gomega.BeNil())
+		gomega.Expect(entry.Namespace).To(gomega.Equal("ns1"))
+		gomega.Expect(entry.AppInstanceId).To(gomega.Equal("app"))
+		gomega.Expect(entry.TotalServices).To(gomega.Equal(2))
+		gomega.Expect(entry.Services["serv1"].ServiceName).To(gomega.Equal("web"))
+		gomega.Expect(entry.Services["serv1"].Resources).To(gomega.HaveLen(1))
+		gomega.Expect(instances.IsMonitoredResource("frag1", "serv1", "web-uid")).To(gomega.BeTrue())
+		gomega.Expect(instances.IsMonitoredResource("frag1", "serv1", "web-proxy-uid")).To(gomega.BeFalse())
+	})
+
+	ginkgo.It("should update the status of the reconciled resources", func() {
+		running := testDeployment("ns1", "web", "frag1", "serv1")
+		client := fake.NewSimpleClientset(reconcilerNamespace("ns1", apiv1.NamespaceActive), running)
+		instances := monitor.NewMemoryMonitoredInstances()
+		controller := NewKubernetesController(instances)
+		gomega.Expect(NewReconciler(client, controller).Reconcile()).To(gomega.Succeed())
+
+		running.Status.AvailableReplicas = 1
+		gomega.Expect(controller.OnDeployment(nil, running, events.EventAdd)).To(gomega.Succeed())
+		entry := instances.GetEntry("frag1")
+		gomega.Expect(entry.Services["serv1"].Status).To(gomega.Equal(entities.NalejServiceStatus(entities.NALEJ_SERVICE_RUNNING)))
+		gomega.Expect(entry.Status).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_DONE)))
+	})
+
+	ginkgo.It("should keep the monitored status when reconciling again", func() {
+		running := testDeployment("ns1", "web", "frag1", "serv1")
+		client := fake.NewSimpleClientset(reconcilerNamespace("ns1", apiv1.NamespaceActive), running)
+		instances := monitor.NewMemoryMonitoredInstances()
+		controller := NewKubernetesController(instances)
+		reconciler := NewReconciler(client, controller)
+		gomega.Expect(reconciler.Reconcile()).To(gomega.Succeed())
+		running.Status.AvailableReplicas = 1
+		gomega.Expect(controller.OnDeployment(nil, running, events.EventAdd)).To(gomega.Succeed())
+
+		// another instance deploys a new service while this one is not the leader
+		_, err := client.AppsV1().Deployments("ns1").Create(testDeployment("ns1", "db", "frag1", "serv2"))
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(reconciler.Reconcile()).To(gomega.Succeed())
+
+		entry := instances.GetEntry("frag1")
+		gomega.Expect(entry.TotalServices).To(gomega.Equal(2))
+		gomega.Expect(entry.Services["serv1"].Resources).To(gomega.HaveLen(1))
+		gomega.Expect(entry.Services["serv1"].Status).To(gomega.Equal(entities.NalejServiceStatus(entities.NALEJ_SERVICE_RUNNING)))
+		gomega.Expect(instances.IsMonitoredResource("frag1", "serv2", "db-uid")).To(gomega.BeTrue())
+		gomega.Expect(instances.GetNumResources()).To(gomega.Equal(2))
+	})
+
+	ginkgo.It("should rebuild suspended applications as suspended", func() {
+		suspended := testDeployment("ns1", "web", "frag1", "serv1")
+		suspended.Annotations = map[string]string{utils.NALEJ_ANNOTATION_SUSPENDED_REPLICAS: "2"}
+		client := fake.NewSimpleClientset(reconcilerNamespace("ns1", apiv1.NamespaceActive), suspended)
+		instances := monitor.NewMemoryMonitoredInstances()
+		controller := NewKubernetesController(instances)
+		gomega.Expect(NewReconciler(client, controller).Reconcile()).To(gomega.Succeed())
+
+		// the status of the resources of a suspended application does not change its status
+		gomega.Expect(controller.OnDeployment(nil, suspended, events.EventAdd)).To(gomega.Succeed())
+		entry := instances.GetEntry("frag1")
+		gomega.Expect(entry.Services["serv1"].Status).To(gomega.Equal(entities.NalejServiceStatus(entities.NALEJ_SERVICE_SUSPENDED)))
+		gomega.Expect(entry.Status).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_SUSPENDED)))
+
+		instances.SetAppStatus("app", entities.FRAGMENT_DEPLOYING, nil)
+		suspended.Status.AvailableReplicas = 2
+		gomega.Expect(controller.OnDeployment(nil, suspended, events.EventUpdate)).To(gomega.Succeed())
+		gomega.Expect(entry.Services["serv1"].Status).To(gomega.Equal(entities.NalejServiceStatus(entities.NALEJ_SERVICE_RUNNING)))
+		gomega.Expect(entry.Status).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_DONE)))
+	})
+
+	ginkgo.It("should rebuild the kubernetes services of a stage", func() {
+		data := entities.DeploymentMetadata{FragmentId: "frag1", OrganizationId: "org", AppDescriptorId: "desc",
+			AppInstanceId: "app", Namespace: "ns1", Stage: pbConductor.DeploymentStage{StageId: "stage1"}}
+		services := &DeployableServices{Data: data}
+		info := services.buildService(&pbConductor.ServiceInstance{ServiceId: "serv", ServiceInstanceId: "serv1",
+			ServiceName: "web", ExposedPorts: []*pbApplication.Port{{ExposedPort: 80, InternalPort: 80}}})
+		gomega.Expect(info).NotTo(gomega.BeNil())
+		info.Service.UID = "web-service-uid"
+		client := fake.NewSimpleClientset(reconcilerNamespace("ns1", apiv1.NamespaceActive), &info.Service)
+		instances := monitor.NewMemoryMonitoredInstances()
+		gomega.Expect(NewReconciler(client, NewKubernetesController(instances)).Reconcile()).To(gomega.Succeed())
+
+		entry := instances.GetEntry("frag1")
+		gomega.Expect(entry).NotTo(gomega.BeNil())
+		gomega.Expect(entry.TotalServices).To(gomega.Equal(1))
+		gomega.Expect(instances.IsMonitoredResource("frag1", "serv1", "web-service-uid")).To(gomega.BeTrue())
+	})
+})
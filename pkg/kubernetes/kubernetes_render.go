@@ -0,0 +1,235 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package kubernetes
+
+import (
+	"bytes"
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/deployment-manager/pkg/executor"
+	"github.com/nalej/derrors"
+	pbConductor "github.com/nalej/grpc-conductor-go"
+	"github.com/nalej/grpc-storage-fabric-go"
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+	"sort"
+)
+
+/*
+ * Rendering of the objects a fragment deployment would create. The deployables are built as in a regular
+ * deployment, including the changes done by the network decorators and the entries they would create when
+ * deployed, but nothing is sent to the cluster.
+ */
+
+// Value replacing the content of the secrets in rendered manifests
+const RedactedValue = "<redacted>"
+
+// Separator of the documents in a rendered manifest
+const yamlDocumentSeparator = "---\n"
+
+func (k *KubernetesExecutor) RenderFragment(data entities.DeploymentMetadata, stages []*pbConductor.DeploymentStage,
+	networkDecorator executor.NetworkDecorator, sfClient grpc_storage_fabric_go.StorageClassClient) (string, error) {
+	log.Debug().Str("fragmentId", data.FragmentId).Msg("render fragment")
+
+	objects := make([]runtime.Object, 0)
+
+	namespace := NewDeployableNamespace(k.Client, data, networkDecorator)
+	if err := namespace.Build(); err != nil {
+		log.Error().Err(err).Msgf("impossible to build Namespace %s", data.Namespace)
+		return "", err
+	}
+	objects = append(objects, namespace.renderObjects()...)
+
+	nalejSecret := NewDeployableNalejSecret(k.Client, data)
+	if err := nalejSecret.Build(); err != nil {
+		log.Error().Err(err).Msg("impossible to build nalej-public-registry secret")
+		return "", err
+	}
+	objects = append(objects, nalejSecret.renderObjects()...)
+
+	for _, stage := range stages {
+		data.Stage = *stage
+		stageDeployable := NewDeployableKubernetesStage(k.Client, data, networkDecorator, sfClient)
+		if err := stageDeployable.Build(); err != nil {
+			log.Error().Err(err).Msgf("impossible to build resources for stage %s in fragment %s",
+				stage.StageId, data.FragmentId)
+			return "", err
+		}
+		objects = append(objects, stageDeployable.renderObjects()...)
+		decorated, err := renderDecoratorObjects(networkDecorator, stageDeployable.Services)
+		if err != nil {
+			log.Error().Err(err).Msgf("impossible to render the network entries for stage %s in fragment %s",
+				stage.StageId, data.FragmentId)
+			return "", err
+		}
+		objects = append(objects, decorated...)
+	}
+
+	return renderYAML(objects)
+}
+
+// Serialize a list of objects into a multi-document YAML.
+func renderYAML(objects []runtime.Object) (string, error) {
+	var buffer bytes.Buffer
+	for _, obj := range objects {
+		rendered, err := yaml.Marshal(obj)
+		if err != nil {
+			log.Error().Err(err).Msg("impossible to render object")
+			return "", err
+		}
+		buffer.WriteString(yamlDocumentSeparator)
+		buffer.Write(rendered)
+	}
+	return buffer.String(), nil
+}
+
+// Objects the network decorator would create when the deployable is deployed. Decorators not creating
+// any entry at that point do not need to render anything.
+func renderDecoratorObjects(networkDecorator executor.NetworkDecorator, aux executor.Deployable) ([]runtime.Object, derrors.Error) {
+	renderable, ok := networkDecorator.(executor.RenderableNetworkDecorator)
+	if !ok {
+		return nil, nil
+	}
+	entries, err := renderable.Render(aux)
+	if err != nil {
+		return nil, err
+	}
+	toReturn := make([]runtime.Object, 0, len(entries))
+	for _, entry := range entries {
+		obj, ok := entry.(runtime.Object)
+		if !ok {
+			return nil, derrors.NewInternalError("network decorator rendered an entry that is not a kubernetes object")
+		}
+		toReturn = append(toReturn, obj)
+	}
+	return toReturn, nil
+}
+
+// Set the type of an object if the deployable did not set it.
+func setTypeMeta(meta *metav1.TypeMeta, kind string, apiVersion string) {
+	if meta.Kind == "" {
+		meta.Kind = kind
+		meta.APIVersion = apiVersion
+	}
+}
+
+// Copy of a secret whose values are not exposed.
+func redactSecret(secret *v1.Secret) *v1.Secret {
+	toReturn := secret.DeepCopy()
+	setTypeMeta(&toReturn.TypeMeta, "Secret", "v1")
+	for key := range toReturn.Data {
+		toReturn.Data[key] = []byte(RedactedValue)
+	}
+	for key := range toReturn.StringData {
+		toReturn.StringData[key] = RedactedValue
+	}
+	return toReturn
+}
+
+func (n *DeployableNamespace) renderObjects() []runtime.Object {
+	setTypeMeta(&n.Namespace.TypeMeta, "Namespace", "v1")
+	return []runtime.Object{&n.Namespace}
+}
+
+func (ds *DeployableNalejSecret) renderObjects() []runtime.Object {
+	keys := make([]string, 0, len(ds.secrets))
+	for key := range ds.secrets {
+		keys = append(keys, key)
+	}
+	toReturn := make([]runtime.Object, 0, len(ds.secrets))
+	// sort the keys so the rendering is stable
+	sort.Strings(keys)
+	for _, key := range keys {
+		toReturn = append(toReturn, redactSecret(ds.secrets[key]))
+	}
+	return toReturn
+}
+
+// Objects of the stage in the same order they are deployed.
+func (d DeployableKubernetesStage) renderObjects() []runtime.Object {
+	toReturn := make([]runtime.Object, 0)
+
+	secretKeys := make([]string, 0, len(d.Secrets.secrets))
+	for key := range d.Secrets.secrets {
+		secretKeys = append(secretKeys, key)
+	}
+	sort.Strings(secretKeys)
+	for _, key := range secretKeys {
+		for _, secret := range d.Secrets.secrets[key] {
+			toReturn = append(toReturn, redactSecret(secret))
+		}
+	}
+
+	configMapKeys := make([]string, 0, len(d.Configmaps.configmaps))
+	for key := range d.Configmaps.configmaps {
+		configMapKeys = append(configMapKeys, key)
+	}
+	sort.Strings(configMapKeys)
+	for _, key := range configMapKeys {
+		for _, configMap := range d.Configmaps.configmaps[key] {
+			setTypeMeta(&configMap.TypeMeta, "ConfigMap", "v1")
+			toReturn = append(toReturn, configMap)
+		}
+	}
+
+	pvcKeys := make([]string, 0, len(d.Storage.pvcs))
+	for key := range d.Storage.pvcs {
+		pvcKeys = append(pvcKeys, key)
+	}
+	sort.Strings(pvcKeys)
+	for _, key := range pvcKeys {
+		for _, pvc := range d.Storage.pvcs[key] {
+			setTypeMeta(&pvc.TypeMeta, "PersistentVolumeClaim", "v1")
+			toReturn = append(toReturn, pvc)
+		}
+	}
+
+	for _, deployment := range d.Deployments.Deployments {
+		setTypeMeta(&deployment.TypeMeta, "Deployment", "apps/v1")
+		toReturn = append(toReturn, deployment)
+	}
+
+	for i := range d.Services.Services {
+		service := &d.Services.Services[i].Service
+		setTypeMeta(&service.TypeMeta, "Service", "v1")
+		toReturn = append(toReturn, service)
+	}
+
+	for i := range d.DeviceGroupServices.Services {
+		service := &d.DeviceGroupServices.Services[i].Service
+		setTypeMeta(&service.TypeMeta, "Service", "v1")
+		toReturn = append(toReturn, service)
+	}
+
+	for _, info := range d.Ingresses.Ingresses {
+		for _, ingress := range info.Ingresses {
+			setTypeMeta(&ingress.TypeMeta, "Ingress", "extensions/v1beta1")
+			toReturn = append(toReturn, ingress)
+		}
+	}
+
+	for i := range d.LoadBalancers.loadBalancers {
+		service := &d.LoadBalancers.loadBalancers[i].Service
+		setTypeMeta(&service.TypeMeta, "Service", "v1")
+		toReturn = append(toReturn, service)
+	}
+
+	return toReturn
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package kubernetes
+
+import (
+	"encoding/base64"
+	"github.com/nalej/deployment-manager/pkg/executor"
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"strings"
+)
+
+// Network decorator only modifying the deployables.
+type buildTestDecorator struct{}
+
+func (d *buildTestDecorator) Build(aux executor.Deployable, args ...interface{}) derrors.Error {
+	return nil
+}
+
+func (d *buildTestDecorator) Deploy(aux executor.Deployable, args ...interface{}) derrors.Error {
+	return nil
+}
+
+func (d *buildTestDecorator) Undeploy(aux executor.Deployable, args ...interface{}) derrors.Error {
+	return nil
+}
+
+// Network decorator creating a service when a set of services is deployed.
+type renderTestDecorator struct {
+	buildTestDecorator
+}
+
+func (d *renderTestDecorator) Render(aux executor.Deployable, args ...interface{}) ([]interface{}, derrors.Error) {
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "decorated"}}
+	setTypeMeta(&service.TypeMeta, "Service", "v1")
+	return []interface{}{service}, nil
+}
+
+var _ = ginkgo.Describe("Kubernetes render tests", func() {
+
+	ginkgo.It("should redact the secret values", func() {
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "secret"},
+			Data:       map[string][]byte{"password": []byte("s3cret")},
+			StringData: map[string]string{"token": "t0ken"},
+		}
+		rendered, err := renderYAML([]runtime.Object{redactSecret(secret)})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(rendered).To(gomega.ContainSubstring("kind: Secret"))
+		gomega.Expect(rendered).NotTo(gomega.ContainSubstring(base64.StdEncoding.EncodeToString([]byte("s3cret"))))
+		gomega.Expect(rendered).NotTo(gomega.ContainSubstring("t0ken"))
+		// the original secret is not modified
+		gomega.Expect(string(secret.Data["password"])).To(gomega.Equal("s3cret"))
+	})
+
+	ginkgo.It("should render one document per object", func() {
+		namespace := &DeployableNamespace{Namespace: v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns"}}}
+		service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "service"}}
+		setTypeMeta(&service.TypeMeta, "Service", "v1")
+		rendered, err := renderYAML(append(namespace.renderObjects(), service))
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(strings.Count(rendered, yamlDocumentSeparator)).To(gomega.Equal(2))
+		gomega.Expect(rendered).To(gomega.ContainSubstring("kind: Namespace"))
+		gomega.Expect(rendered).To(gomega.ContainSubstring("name: service"))
+	})
+
+	ginkgo.It("should render the entries created by the network decorator", func() {
+		objects, err := renderDecoratorObjects(&renderTestDecorator{}, &DeployableServices{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(objects).To(gomega.HaveLen(1))
+		rendered, rErr := renderYAML(objects)
+		gomega.Expect(rErr).To(gomega.Succeed())
+		gomega.Expect(rendered).To(gomega.ContainSubstring("name: decorated"))
+	})
+
+	ginkgo.It("should not render entries for decorators not creating them", func() {
+		objects, err := renderDecoratorObjects(&buildTestDecorator{}, &DeployableServices{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(objects).To(gomega.BeEmpty())
+	})
+})
@@ -0,0 +1,654 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/deployment-manager/pkg/executor"
+	"github.com/nalej/deployment-manager/pkg/utils"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/workqueue"
+	"strings"
+	"sync"
+)
+
+/*
+ * Rollout strategies applied to the deployments of a service when its fragment is updated. The new version runs in
+ * a candidate deployment next to the stable one. A canary receives a share of the replicas, or of the traffic when
+ * the network decorator supports it, until it is promoted or aborted. A blue/green candidate gets all the traffic
+ * of the kubernetes service as soon as its pods are ready. On promotion the stable deployment is updated with the
+ * new version and the candidate is removed once the stable deployment is running again. Candidates reported in
+ * error by the controller are rolled back. The rollouts found in the cluster when the service starts are recovered
+ * from their candidates.
+ */
+
+type rolloutPhase int
+
+const (
+	// The candidate is being deployed
+	rolloutProgressing rolloutPhase = iota
+	// The candidate is running next to the stable version
+	rolloutCandidateReady
+	// The stable deployment is being updated with the new version
+	rolloutPromoting
+)
+
+// Deployable Rollout
+//-------------------
+
+// Rollout of a new version of a service in a candidate deployment. Rollouts are passed to the network decorator
+// so it can split the traffic between the stable and the candidate versions.
+type DeployableRollout struct {
+	// kubernetes Client
+	Client kubernetes.Interface
+	// Strategy of the rollout
+	Strategy entities.RolloutStrategy
+	// Deployment running the current version
+	Stable *appsv1.Deployment
+	// New version of the stable deployment
+	Desired *appsv1.Deployment
+	// Deployment running the new version during the rollout
+	Candidate *appsv1.Deployment
+	// network decorator object for rollouts
+	networkDecorator executor.NetworkDecorator
+	// current phase of the rollout
+	phase rolloutPhase
+	// the kubernetes service selects the pods of the candidate
+	switched bool
+}
+
+func NewDeployableRollout(client kubernetes.Interface, stable *appsv1.Deployment, desired *appsv1.Deployment,
+	strategy entities.RolloutStrategy, networkDecorator executor.NetworkDecorator) *DeployableRollout {
+	return &DeployableRollout{
+		Client:           client,
+		Strategy:         strategy,
+		Stable:           stable,
+		Desired:          desired,
+		networkDecorator: networkDecorator,
+	}
+}
+
+func (r *DeployableRollout) GetId() string {
+	return r.Desired.Labels[utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID]
+}
+
+// Name of the kubernetes service in front of the rolled out deployment.
+func (r *DeployableRollout) ServiceName() string {
+	return r.Desired.Name
+}
+
+// Track label value of the candidate pods.
+func (r *DeployableRollout) CandidateTrack() string {
+	if r.Strategy.Type == entities.ROLLOUT_BLUE_GREEN {
+		return utils.NALEJ_ANNOTATION_VALUE_GREEN_TRACK
+	}
+	return utils.NALEJ_ANNOTATION_VALUE_CANARY_TRACK
+}
+
+// Build the candidate deployment. A canary runs the configured share of the replicas, at least one, and a
+// blue/green candidate runs as many replicas as the stable deployment.
+func (r *DeployableRollout) Build() error {
+	track := r.CandidateTrack()
+	replicas := int32(1)
+	if r.Desired.Spec.Replicas != nil {
+		replicas = *r.Desired.Spec.Replicas
+	}
+	if r.Strategy.Type == entities.ROLLOUT_CANARY {
+		replicas = canaryReplicas(replicas, r.Strategy.CanaryPercentage)
+	}
+
+	candidate := r.Desired.DeepCopy()
+	candidate.Name = fmt.Sprintf("%s-%s", r.Desired.Name, track)
+	candidate.ResourceVersion = ""
+	candidate.Labels = withTrack(r.Desired.Labels, track)
+	candidate.Spec.Replicas = &replicas
+	candidate.Spec.Template.Labels = withTrack(r.Desired.Spec.Template.Labels, track)
+	candidate.Spec.Selector = &metav1.LabelSelector{MatchLabels: withTrack(r.Desired.Spec.Selector.MatchLabels, track)}
+	r.Candidate = candidate
+	return nil
+}
+
+// Deploy the candidate. A candidate left by a previous rollout of the service is replaced.
+func (r *DeployableRollout) Deploy(ctx context.Context, controller executor.DeploymentController) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	deployments := r.Client.AppsV1().Deployments(r.Desired.Namespace)
+	if err := r.trackStable(); err != nil {
+		return err
+	}
+	if _, err := updateDeployment(deployments, r.Candidate); err != nil {
+		return err
+	}
+	candidate, err := deployments.Get(r.Candidate.Name, metav1.GetOptions{})
+	if err != nil {
+		log.Error().Err(err).Str("name", r.Candidate.Name).Msg("cannot get candidate deployment")
+		return err
+	}
+	r.Candidate = candidate
+	addMonitoredObject(controller, candidate)
+	log.Info().Str("name", candidate.Name).Str("uid", string(candidate.GetUID())).Msg("candidate deployment created")
+
+	if r.networkDecorator != nil {
+		if err := r.networkDecorator.Deploy(r); err != nil {
+			log.Error().Err(err).Str("name", candidate.Name).Msg("error deploying network components for rollout")
+			return err
+		}
+	}
+	return nil
+}
+
+// Undeploy the candidate sending the traffic back to the stable deployment.
+func (r *DeployableRollout) Undeploy() error {
+	if r.networkDecorator != nil {
+		if err := r.networkDecorator.Undeploy(r); err != nil {
+			log.Error().Err(err).Str("name", r.Candidate.Name).Msg("error undeploying network components for rollout")
+			return err
+		}
+	}
+	if r.switched {
+		if err := r.selectTrack(""); err != nil {
+			return err
+		}
+	}
+	err := r.Client.AppsV1().Deployments(r.Desired.Namespace).Delete(r.Candidate.Name, metav1.NewDeleteOptions(DeleteGracePeriod))
+	if err != nil && !errors.IsNotFound(err) {
+		log.Error().Err(err).Str("name", r.Candidate.Name).Msg("cannot delete candidate deployment")
+		return err
+	}
+	return nil
+}
+
+// Label the pods of the stable deployment with their track so the network can tell them from the candidate ones.
+// Deployments created before rollouts were available restart their pods once.
+func (r *DeployableRollout) trackStable() error {
+	if r.Stable.Spec.Template.Labels[utils.NALEJ_ANNOTATION_ROLLOUT_TRACK] == utils.NALEJ_ANNOTATION_VALUE_STABLE_TRACK {
+		return nil
+	}
+	toUpdate := r.Stable.DeepCopy()
+	toUpdate.Labels = withTrack(toUpdate.Labels, utils.NALEJ_ANNOTATION_VALUE_STABLE_TRACK)
+	toUpdate.Spec.Template.Labels = withTrack(toUpdate.Spec.Template.Labels, utils.NALEJ_ANNOTATION_VALUE_STABLE_TRACK)
+	updated, err := r.Client.AppsV1().Deployments(r.Stable.Namespace).Update(toUpdate)
+	if err != nil {
+		log.Error().Err(err).Str("name", r.Stable.Name).Msg("cannot set the track of the stable deployment")
+		return err
+	}
+	r.Stable = updated
+	return nil
+}
+
+// Restrict the selector of the kubernetes service to the pods of a track, or remove the restriction if the track
+// is empty. Services without ports are not created, there is nothing to switch for them.
+func (r *DeployableRollout) selectTrack(track string) error {
+	services := r.Client.CoreV1().Services(r.Desired.Namespace)
+	service, err := services.Get(r.ServiceName(), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		log.Error().Err(err).Str("name", r.ServiceName()).Msg("cannot get service")
+		return err
+	}
+	if track == "" {
+		delete(service.Spec.Selector, utils.NALEJ_ANNOTATION_ROLLOUT_TRACK)
+	} else {
+		service.Spec.Selector = withTrack(service.Spec.Selector, track)
+	}
+	if _, err = services.Update(service); err != nil {
+		log.Error().Err(err).Str("name", r.ServiceName()).Str("track", track).Msg("cannot switch service selector")
+		return err
+	}
+	r.switched = track != ""
+	return nil
+}
+
+// Update the stable deployment with the new version.
+func (r *DeployableRollout) promote() error {
+	if _, err := updateDeployment(r.Client.AppsV1().Deployments(r.Desired.Namespace), r.Desired); err != nil {
+		return err
+	}
+	r.phase = rolloutPromoting
+	return nil
+}
+
+// Number of replicas of a canary.
+func canaryReplicas(replicas int32, percentage int32) int32 {
+	result := (replicas*percentage + 99) / 100
+	if result < 1 {
+		return 1
+	}
+	return result
+}
+
+// Copy a set of labels setting the rollout track.
+func withTrack(labels map[string]string, track string) map[string]string {
+	result := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		result[k] = v
+	}
+	result[utils.NALEJ_ANNOTATION_ROLLOUT_TRACK] = track
+	return result
+}
+
+// Check if all the replicas of a deployment run its current version.
+func rolledOut(dep *appsv1.Deployment) bool {
+	replicas := int32(1)
+	if dep.Spec.Replicas != nil {
+		replicas = *dep.Spec.Replicas
+	}
+	return dep.Status.ObservedGeneration >= dep.Generation && dep.Status.UpdatedReplicas == replicas &&
+		dep.Status.Replicas == replicas
+}
+
+// Rollout Controller
+//-------------------
+
+// The rollout controller drives the rollouts in progress with the status the KubernetesController observes for
+// their deployments. The statuses are queued and processed apart from the informers as driving a rollout requires
+// calls to the kubernetes API.
+type RolloutController struct {
+	// kubernetes Client
+	client kubernetes.Interface
+	// controller monitoring the candidates
+	controller executor.DeploymentController
+	// rollouts in progress indexed by service instance id
+	rollouts map[string]*DeployableRollout
+	// mutex
+	mu sync.Mutex
+	// statuses of the deployments pending to be processed
+	queue workqueue.Interface
+	// Channel closed to stop the controller
+	stop chan struct{}
+	// Channel closed when the controller is stopped
+	done chan struct{}
+}
+
+// Status of a deployment observed by the controller.
+type rolloutStatus struct {
+	dep    *appsv1.Deployment
+	status entities.NalejServiceStatus
+}
+
+func NewRolloutController(client kubernetes.Interface, controller executor.DeploymentController) *RolloutController {
+	return &RolloutController{
+		client:     client,
+		controller: controller,
+		rollouts:   make(map[string]*DeployableRollout, 0),
+		queue:      workqueue.New(),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Process the statuses of the deployments in the order they are observed until the controller is stopped.
+func (rc *RolloutController) Run() {
+	log.Info().Msg("Start rollout controller...")
+	defer close(rc.done)
+	go func() {
+		<-rc.stop
+		rc.queue.ShutDown()
+	}()
+	for {
+		item, quit := rc.queue.Get()
+		if quit {
+			log.Info().Msg("rollout controller stopped")
+			return
+		}
+		observed := item.(*rolloutStatus)
+		rc.onStatus(observed.dep, observed.status)
+		rc.queue.Done(item)
+	}
+}
+
+// Stop the controller. It returns once the queued statuses are processed.
+func (rc *RolloutController) Stop() {
+	close(rc.stop)
+	<-rc.done
+}
+
+// Recover the rollouts in progress from the candidates found in the cluster. Candidates whose stable deployment
+// no longer exists are removed. Rollouts already tracked by the controller are kept.
+//
+//	params:
+//	 networkDecorator additional processes required to split the traffic
+//	return:
+//	 error if the candidates cannot be listed
+func (rc *RolloutController) Recover(networkDecorator executor.NetworkDecorator) derrors.Error {
+	selector := fmt.Sprintf("%s in (%s,%s)", utils.NALEJ_ANNOTATION_ROLLOUT_TRACK,
+		utils.NALEJ_ANNOTATION_VALUE_CANARY_TRACK, utils.NALEJ_ANNOTATION_VALUE_GREEN_TRACK)
+	candidates, err := rc.client.AppsV1().Deployments(metav1.NamespaceAll).List(metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return derrors.NewInternalError("impossible to list candidate deployments", err)
+	}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for i := range candidates.Items {
+		candidate := &candidates.Items[i]
+		id := candidate.Labels[utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID]
+		if _, found := rc.rollouts[id]; found {
+			continue
+		}
+		rollout, err := rc.recoverRollout(candidate, networkDecorator)
+		if err != nil {
+			log.Error().Err(err).Str("serviceInstanceId", id).Str("candidate", candidate.Name).Msg("cannot recover rollout")
+			continue
+		}
+		if rollout == nil {
+			log.Info().Str("serviceInstanceId", id).Str("candidate", candidate.Name).Msg("stable deployment not found, candidate removed")
+			continue
+		}
+		rc.rollouts[id] = rollout
+		log.Info().Str("serviceInstanceId", id).Str("candidate", candidate.Name).Msg("rollout recovered")
+	}
+	return nil
+}
+
+// Rebuild the rollout of a candidate from the cluster. The new version is the one of the candidate. Must be called
+// holding the lock.
+//
+//	return:
+//	 the rollout, nil if the stable deployment no longer exists and the candidate was removed, or error if any
+func (rc *RolloutController) recoverRollout(candidate *appsv1.Deployment, networkDecorator executor.NetworkDecorator) (*DeployableRollout, error) {
+	track := candidate.Labels[utils.NALEJ_ANNOTATION_ROLLOUT_TRACK]
+	strategy := entities.RolloutStrategy{Type: entities.ROLLOUT_CANARY}
+	if track == utils.NALEJ_ANNOTATION_VALUE_GREEN_TRACK {
+		strategy.Type = entities.ROLLOUT_BLUE_GREEN
+	}
+	desired := candidate.DeepCopy()
+	desired.Name = strings.TrimSuffix(candidate.Name, "-"+track)
+	rollout := NewDeployableRollout(rc.client, nil, desired, strategy, networkDecorator)
+	rollout.Candidate = candidate
+	if service, err := rc.client.CoreV1().Services(candidate.Namespace).Get(rollout.ServiceName(), metav1.GetOptions{}); err == nil {
+		rollout.switched = service.Spec.Selector[utils.NALEJ_ANNOTATION_ROLLOUT_TRACK] != ""
+	}
+
+	stable, err := rc.client.AppsV1().Deployments(candidate.Namespace).Get(desired.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		if err := rollout.Undeploy(); err != nil {
+			return nil, err
+		}
+		rc.controller.RemoveMonitoredResource(candidate.Labels[utils.NALEJ_ANNOTATION_DEPLOYMENT_FRAGMENT],
+			rollout.GetId(), string(candidate.GetUID()))
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the new version is the stable deployment running the template of the candidate
+	desired = stable.DeepCopy()
+	desired.ResourceVersion = ""
+	desired.Spec.Template = *candidate.Spec.Template.DeepCopy()
+	desired.Spec.Template.Labels = withTrack(candidate.Spec.Template.Labels, stable.Spec.Template.Labels[utils.NALEJ_ANNOTATION_ROLLOUT_TRACK])
+	if _, tracked := stable.Spec.Template.Labels[utils.NALEJ_ANNOTATION_ROLLOUT_TRACK]; !tracked {
+		delete(desired.Spec.Template.Labels, utils.NALEJ_ANNOTATION_ROLLOUT_TRACK)
+	}
+	rollout.Stable = stable
+	rollout.Desired = desired
+	switch {
+	case equality.Semantic.DeepDerivative(desired.Spec.Template.Spec, stable.Spec.Template.Spec):
+		rollout.phase = rolloutPromoting
+	case strategy.Type == entities.ROLLOUT_CANARY && rolledOut(candidate):
+		// blue/green candidates stay progressing so they are switched and promoted with their next status
+		rollout.phase = rolloutCandidateReady
+	}
+	return rollout, nil
+}
+
+// Roll out a new version of a deployment following a strategy. Deployments not found in the cluster are created,
+// and the ones matching the new version end any rollout in progress for the service. A new version of a service
+// being rolled out replaces its candidate.
+//
+//	params:
+//	 ctx context to cancel the rollout
+//	 desired new version of the deployment
+//	 strategy of the rollout
+//	 networkDecorator additional processes required to split the traffic
+//	return:
+//	 error if any
+func (rc *RolloutController) Rollout(ctx context.Context, desired *appsv1.Deployment, strategy entities.RolloutStrategy,
+	networkDecorator executor.NetworkDecorator) error {
+	deployments := rc.client.AppsV1().Deployments(desired.Namespace)
+	stable, err := deployments.Get(desired.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Debug().Str("name", desired.Name).Msg("creating deployment")
+		created, err := deployments.Create(desired)
+		if err != nil {
+			log.Error().Err(err).Str("name", desired.Name).Msg("cannot create deployment")
+			return err
+		}
+		addMonitoredObject(rc.controller, created)
+		return nil
+	}
+	if err != nil {
+		log.Error().Err(err).Str("name", desired.Name).Msg("cannot get deployment")
+		return err
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	id := desired.Labels[utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID]
+	rollout, inProgress := rc.rollouts[id]
+	if sameMetadata(desired, stable) && equality.Semantic.DeepDerivative(desired.Spec, stable.Spec) {
+		if inProgress {
+			log.Info().Str("serviceInstanceId", id).Msg("stable deployment runs the new version, end rollout")
+			return rc.finish(rollout)
+		}
+		log.Debug().Str("name", desired.Name).Msg("deployment not modified")
+		return nil
+	}
+
+	if inProgress && rollout.Strategy.Type != strategy.Type {
+		log.Info().Str("serviceInstanceId", id).Msg("rollout strategy changed, abort rollout")
+		if err := rc.finish(rollout); err != nil {
+			return err
+		}
+		inProgress = false
+	}
+	if inProgress {
+		rollout.Stable = stable
+		rollout.Desired = desired
+		rollout.Strategy = strategy
+		rollout.networkDecorator = networkDecorator
+		rollout.phase = rolloutProgressing
+	} else {
+		rollout = NewDeployableRollout(rc.client, stable, desired, strategy, networkDecorator)
+	}
+	if err := rollout.Build(); err != nil {
+		return err
+	}
+	if err := rollout.Deploy(ctx, rc.controller); err != nil {
+		if !inProgress {
+			if finishErr := rc.finish(rollout); finishErr != nil {
+				log.Error().Err(finishErr).Str("serviceInstanceId", id).Msg("error removing failed candidate")
+			}
+		}
+		return err
+	}
+	rc.rollouts[id] = rollout
+	log.Info().Str("serviceInstanceId", id).Str("candidate", rollout.Candidate.Name).Msg("rollout started")
+	return nil
+}
+
+// Promote the candidate of a service. The stable deployment is updated with the new version and the candidate is
+// removed once the stable deployment is running again.
+//
+//	params:
+//	 fragmentId fragment of the service
+//	 serviceInstanceId service being rolled out
+//	return:
+//	 error if there is no rollout or its candidate is not running
+func (rc *RolloutController) Promote(fragmentId string, serviceInstanceId string) derrors.Error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rollout, err := rc.get(fragmentId, serviceInstanceId)
+	if err != nil {
+		return err
+	}
+	switch rollout.phase {
+	case rolloutProgressing:
+		return derrors.NewFailedPreconditionError("candidate is not running yet").WithParams(serviceInstanceId)
+	case rolloutPromoting:
+		return nil
+	}
+	if err := rollout.promote(); err != nil {
+		return derrors.NewInternalError("impossible to promote rollout", err).WithParams(serviceInstanceId)
+	}
+	log.Info().Str("serviceInstanceId", serviceInstanceId).Msg("rollout promoted")
+	return nil
+}
+
+// Abort the rollout of a service removing its candidate.
+//
+//	params:
+//	 fragmentId fragment of the service
+//	 serviceInstanceId service being rolled out
+//	return:
+//	 error if there is no rollout or it is being promoted
+func (rc *RolloutController) Abort(fragmentId string, serviceInstanceId string) derrors.Error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rollout, err := rc.get(fragmentId, serviceInstanceId)
+	if err != nil {
+		return err
+	}
+	if rollout.phase == rolloutPromoting {
+		return derrors.NewFailedPreconditionError("rollout is being promoted").WithParams(serviceInstanceId)
+	}
+	if err := rc.finish(rollout); err != nil {
+		return derrors.NewInternalError("impossible to abort rollout", err).WithParams(serviceInstanceId)
+	}
+	log.Info().Str("serviceInstanceId", serviceInstanceId).Msg("rollout aborted")
+	return nil
+}
+
+// Queue the status of a deployment to drive its rollout, if any.
+func (rc *RolloutController) OnDeploymentStatus(dep *appsv1.Deployment, status entities.NalejServiceStatus) {
+	rc.queue.Add(&rolloutStatus{dep: dep, status: status})
+}
+
+// Drive the rollouts with the status of their deployments.
+func (rc *RolloutController) onStatus(dep *appsv1.Deployment, status entities.NalejServiceStatus) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	id := dep.Labels[utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID]
+	rollout, found := rc.rollouts[id]
+	if !found {
+		return
+	}
+	switch dep.Name {
+	case rollout.Candidate.Name:
+		rc.onCandidateStatus(rollout, dep, status)
+	case rollout.Stable.Name:
+		rc.onStableStatus(rollout, dep, status)
+	}
+}
+
+func (rc *RolloutController) onCandidateStatus(rollout *DeployableRollout, dep *appsv1.Deployment, status entities.NalejServiceStatus) {
+	id := rollout.GetId()
+	switch status {
+	case entities.NALEJ_SERVICE_TERMINATING:
+		log.Info().Str("serviceInstanceId", id).Msg("candidate deployment removed, forget rollout")
+		delete(rc.rollouts, id)
+	case entities.NALEJ_SERVICE_ERROR:
+		log.Warn().Str("serviceInstanceId", id).Str("candidate", dep.Name).Msg("candidate failed, roll back")
+		if err := rc.finish(rollout); err != nil {
+			log.Error().Err(err).Str("serviceInstanceId", id).Msg("error rolling back candidate")
+		}
+	case entities.NALEJ_SERVICE_RUNNING:
+		if rollout.phase != rolloutProgressing || !rolledOut(dep) {
+			return
+		}
+		rollout.phase = rolloutCandidateReady
+		log.Info().Str("serviceInstanceId", id).Str("candidate", dep.Name).Msg("candidate is running")
+		if rollout.Strategy.Type != entities.ROLLOUT_BLUE_GREEN {
+			return
+		}
+		if err := rollout.selectTrack(utils.NALEJ_ANNOTATION_VALUE_GREEN_TRACK); err != nil {
+			log.Error().Err(err).Str("serviceInstanceId", id).Msg("error switching traffic to candidate")
+			return
+		}
+		if err := rollout.promote(); err != nil {
+			log.Error().Err(err).Str("serviceInstanceId", id).Msg("error promoting rollout")
+		}
+	}
+}
+
+func (rc *RolloutController) onStableStatus(rollout *DeployableRollout, dep *appsv1.Deployment, status entities.NalejServiceStatus) {
+	id := rollout.GetId()
+	switch status {
+	case entities.NALEJ_SERVICE_TERMINATING:
+		log.Info().Str("serviceInstanceId", id).Msg("stable deployment removed, remove candidate")
+		if err := rc.finish(rollout); err != nil {
+			log.Error().Err(err).Str("serviceInstanceId", id).Msg("error removing candidate")
+		}
+	case entities.NALEJ_SERVICE_ERROR:
+		if rollout.phase == rolloutPromoting {
+			log.Warn().Str("serviceInstanceId", id).Msg("stable deployment failed during promotion, candidate kept")
+		}
+	case entities.NALEJ_SERVICE_RUNNING:
+		if rollout.phase != rolloutPromoting || !rolledOut(dep) {
+			return
+		}
+		log.Info().Str("serviceInstanceId", id).Msg("stable deployment runs the new version, end rollout")
+		if err := rc.finish(rollout); err != nil {
+			log.Error().Err(err).Str("serviceInstanceId", id).Msg("error ending rollout")
+		}
+	}
+}
+
+// Get a rollout in progress. Must be called holding the lock.
+func (rc *RolloutController) get(fragmentId string, serviceInstanceId string) (*DeployableRollout, derrors.Error) {
+	rollout, found := rc.rollouts[serviceInstanceId]
+	if !found || rollout.Desired.Labels[utils.NALEJ_ANNOTATION_DEPLOYMENT_FRAGMENT] != fragmentId {
+		return nil, derrors.NewNotFoundError("no rollout in progress for service").WithParams(fragmentId, serviceInstanceId)
+	}
+	return rollout, nil
+}
+
+// Remove the candidate of a rollout and stop tracking it. Must be called holding the lock.
+func (rc *RolloutController) finish(rollout *DeployableRollout) error {
+	if err := rollout.Undeploy(); err != nil {
+		return err
+	}
+	rc.controller.RemoveMonitoredResource(rollout.Candidate.Labels[utils.NALEJ_ANNOTATION_DEPLOYMENT_FRAGMENT],
+		rollout.GetId(), string(rollout.Candidate.GetUID()))
+	delete(rc.rollouts, rollout.GetId())
+	return nil
+}
+
+func (k *KubernetesExecutor) PromoteRollout(fragmentId string, serviceInstanceId string) derrors.Error {
+	if k.Rollouts == nil {
+		return derrors.NewUnimplementedError("rollouts are not supported")
+	}
+	return k.Rollouts.Promote(fragmentId, serviceInstanceId)
+}
+
+func (k *KubernetesExecutor) AbortRollout(fragmentId string, serviceInstanceId string) derrors.Error {
+	if k.Rollouts == nil {
+		return derrors.NewUnimplementedError("rollouts are not supported")
+	}
+	return k.Rollouts.Abort(fragmentId, serviceInstanceId)
+}
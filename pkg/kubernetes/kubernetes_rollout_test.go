@@ -0,0 +1,216 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kubernetes
+
+import (
+	"context"
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/deployment-manager/internal/structures/monitor"
+	"github.com/nalej/deployment-manager/pkg/utils"
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Get a deployment from the fake cluster with all its replicas running its current version.
+func rolloutRunning(client *fake.Clientset, name string) *appsv1.Deployment {
+	dep, err := client.AppsV1().Deployments("ns").Get(name, metav1.GetOptions{})
+	gomega.Expect(err).To(gomega.Succeed())
+	dep.Status.Replicas = *dep.Spec.Replicas
+	dep.Status.UpdatedReplicas = *dep.Spec.Replicas
+	dep.Status.AvailableReplicas = *dep.Spec.Replicas
+	return dep
+}
+
+// Check if a deployment was removed from the fake cluster.
+func rolloutRemoved(client *fake.Clientset, name string) func() bool {
+	return func() bool {
+		_, err := client.AppsV1().Deployments("ns").Get(name, metav1.GetOptions{})
+		return errors.IsNotFound(err)
+	}
+}
+
+func rolloutImage(client *fake.Clientset, name string) string {
+	dep, err := client.AppsV1().Deployments("ns").Get(name, metav1.GetOptions{})
+	gomega.Expect(err).To(gomega.Succeed())
+	return dep.Spec.Template.Spec.Containers[0].Image
+}
+
+var _ = ginkgo.Describe("Kubernetes rollout tests", func() {
+
+	var client *fake.Clientset
+	var rollouts *RolloutController
+
+	// deployment of the service running the given version
+	version := func(image string) *appsv1.Deployment {
+		dep := testDeployment("ns", "web", "frag1", "inst1")
+		dep.Spec.Replicas = int32Ptr(4)
+		dep.Spec.Template.Spec.Containers[0].Image = image
+		return dep
+	}
+
+	ginkgo.BeforeEach(func() {
+		client = fake.NewSimpleClientset(version("web:1"), &apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns"},
+			Spec: apiv1.ServiceSpec{
+				Ports:    []apiv1.ServicePort{{Port: 80}},
+				Selector: map[string]string{utils.NALEJ_ANNOTATION_SERVICE_NAME: "web"},
+			},
+		})
+		rollouts = NewRolloutController(client, NewKubernetesController(monitor.NewMemoryMonitoredInstances()))
+		go rollouts.Run()
+	})
+
+	ginkgo.AfterEach(func() {
+		rollouts.Stop()
+	})
+
+	// selector of the kubernetes service of the deployment
+	selector := func() map[string]string {
+		service, err := client.CoreV1().Services("ns").Get("web", metav1.GetOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		return service.Spec.Selector
+	}
+	image := func() string {
+		return rolloutImage(client, "web")
+	}
+
+	ginkgo.It("should run a canary until it is promoted", func() {
+		strategy := entities.RolloutStrategy{Type: entities.ROLLOUT_CANARY, CanaryPercentage: 25}
+		err := rollouts.Rollout(context.Background(), version("web:2"), strategy, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		canary, err := client.AppsV1().Deployments("ns").Get("web-canary", metav1.GetOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(*canary.Spec.Replicas).To(gomega.Equal(int32(1)))
+		gomega.Expect(canary.Spec.Template.Labels[utils.NALEJ_ANNOTATION_ROLLOUT_TRACK]).To(gomega.Equal(utils.NALEJ_ANNOTATION_VALUE_CANARY_TRACK))
+		gomega.Expect(canary.Spec.Template.Spec.Containers[0].Image).To(gomega.Equal("web:2"))
+		gomega.Expect(rolloutImage(client, "web")).To(gomega.Equal("web:1"))
+
+		// the canary must be running to be promoted
+		derr := rollouts.Promote("frag1", "inst1")
+		gomega.Expect(derr).NotTo(gomega.BeNil())
+		gomega.Expect(derr.Type()).To(gomega.Equal(derrors.FailedPrecondition))
+
+		rollouts.OnDeploymentStatus(rolloutRunning(client, "web-canary"), entities.NALEJ_SERVICE_RUNNING)
+		gomega.Eventually(func() derrors.Error { return rollouts.Promote("frag1", "inst1") }).Should(gomega.BeNil())
+		gomega.Expect(rolloutImage(client, "web")).To(gomega.Equal("web:2"))
+
+		// the canary is removed once the stable deployment runs the new version
+		rollouts.OnDeploymentStatus(rolloutRunning(client, "web"), entities.NALEJ_SERVICE_RUNNING)
+		gomega.Eventually(rolloutRemoved(client, "web-canary")).Should(gomega.BeTrue())
+		derr = rollouts.Abort("frag1", "inst1")
+		gomega.Expect(derr).NotTo(gomega.BeNil())
+		gomega.Expect(derr.Type()).To(gomega.Equal(derrors.NotFound))
+	})
+
+	ginkgo.It("should switch the service to a blue/green candidate once it is ready", func() {
+		strategy := entities.RolloutStrategy{Type: entities.ROLLOUT_BLUE_GREEN}
+		err := rollouts.Rollout(context.Background(), version("web:2"), strategy, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		green, err := client.AppsV1().Deployments("ns").Get("web-green", metav1.GetOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(*green.Spec.Replicas).To(gomega.Equal(int32(4)))
+		service, err := client.CoreV1().Services("ns").Get("web", metav1.GetOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(service.Spec.Selector).NotTo(gomega.HaveKey(utils.NALEJ_ANNOTATION_ROLLOUT_TRACK))
+
+		rollouts.OnDeploymentStatus(rolloutRunning(client, "web-green"), entities.NALEJ_SERVICE_RUNNING)
+		gomega.Eventually(image).Should(gomega.Equal("web:2"))
+		gomega.Expect(selector()[utils.NALEJ_ANNOTATION_ROLLOUT_TRACK]).To(gomega.Equal(utils.NALEJ_ANNOTATION_VALUE_GREEN_TRACK))
+
+		rollouts.OnDeploymentStatus(rolloutRunning(client, "web"), entities.NALEJ_SERVICE_RUNNING)
+		gomega.Eventually(rolloutRemoved(client, "web-green")).Should(gomega.BeTrue())
+		gomega.Expect(selector()).NotTo(gomega.HaveKey(utils.NALEJ_ANNOTATION_ROLLOUT_TRACK))
+	})
+
+	ginkgo.It("should roll back failed candidates", func() {
+		strategy := entities.RolloutStrategy{Type: entities.ROLLOUT_CANARY, CanaryPercentage: 50}
+		err := rollouts.Rollout(context.Background(), version("web:2"), strategy, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		canary, err := client.AppsV1().Deployments("ns").Get("web-canary", metav1.GetOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(*canary.Spec.Replicas).To(gomega.Equal(int32(2)))
+
+		rollouts.OnDeploymentStatus(canary, entities.NALEJ_SERVICE_ERROR)
+		gomega.Eventually(rolloutRemoved(client, "web-canary")).Should(gomega.BeTrue())
+		gomega.Expect(rolloutImage(client, "web")).To(gomega.Equal("web:1"))
+		gomega.Expect(rollouts.Promote("frag1", "inst1")).NotTo(gomega.BeNil())
+	})
+
+	ginkgo.It("should recover the rollouts found in the cluster", func() {
+		strategy := entities.RolloutStrategy{Type: entities.ROLLOUT_CANARY, CanaryPercentage: 25}
+		err := rollouts.Rollout(context.Background(), version("web:2"), strategy, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		_, err = client.AppsV1().Deployments("ns").UpdateStatus(rolloutRunning(client, "web-canary"))
+		gomega.Expect(err).To(gomega.Succeed())
+
+		// a new controller resumes the rollout left by the previous one
+		recovered := NewRolloutController(client, NewKubernetesController(monitor.NewMemoryMonitoredInstances()))
+		gomega.Expect(recovered.Recover(nil)).To(gomega.BeNil())
+		go recovered.Run()
+		defer recovered.Stop()
+		gomega.Expect(recovered.Promote("frag1", "inst1")).To(gomega.BeNil())
+		gomega.Expect(image()).To(gomega.Equal("web:2"))
+
+		recovered.OnDeploymentStatus(rolloutRunning(client, "web"), entities.NALEJ_SERVICE_RUNNING)
+		gomega.Eventually(rolloutRemoved(client, "web-canary")).Should(gomega.BeTrue())
+	})
+
+	ginkgo.It("should recover blue/green rollouts being promoted", func() {
+		strategy := entities.RolloutStrategy{Type: entities.ROLLOUT_BLUE_GREEN}
+		err := rollouts.Rollout(context.Background(), version("web:2"), strategy, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		rollouts.OnDeploymentStatus(rolloutRunning(client, "web-green"), entities.NALEJ_SERVICE_RUNNING)
+		gomega.Eventually(image).Should(gomega.Equal("web:2"))
+
+		recovered := NewRolloutController(client, NewKubernetesController(monitor.NewMemoryMonitoredInstances()))
+		gomega.Expect(recovered.Recover(nil)).To(gomega.BeNil())
+		go recovered.Run()
+		defer recovered.Stop()
+		derr := recovered.Abort("frag1", "inst1")
+		gomega.Expect(derr).NotTo(gomega.BeNil())
+		gomega.Expect(derr.Type()).To(gomega.Equal(derrors.FailedPrecondition))
+
+		// the service selects all the pods again once the rollout ends
+		recovered.OnDeploymentStatus(rolloutRunning(client, "web"), entities.NALEJ_SERVICE_RUNNING)
+		gomega.Eventually(rolloutRemoved(client, "web-green")).Should(gomega.BeTrue())
+		gomega.Expect(selector()).NotTo(gomega.HaveKey(utils.NALEJ_ANNOTATION_ROLLOUT_TRACK))
+	})
+
+	ginkgo.It("should remove the candidates without stable deployment", func() {
+		strategy := entities.RolloutStrategy{Type: entities.ROLLOUT_CANARY, CanaryPercentage: 25}
+		err := rollouts.Rollout(context.Background(), version("web:2"), strategy, nil)
+		gomega.Expect(err).To(gomega.Succeed())
+		err = client.AppsV1().Deployments("ns").Delete("web", metav1.NewDeleteOptions(0))
+		gomega.Expect(err).To(gomega.Succeed())
+
+		recovered := NewRolloutController(client, NewKubernetesController(monitor.NewMemoryMonitoredInstances()))
+		gomega.Expect(recovered.Recover(nil)).To(gomega.BeNil())
+		gomega.Expect(rolloutRemoved(client, "web-canary")()).To(gomega.BeTrue())
+		derr := recovered.Abort("frag1", "inst1")
+		gomega.Expect(derr).NotTo(gomega.BeNil())
+		gomega.Expect(derr.Type()).To(gomega.Equal(derrors.NotFound))
+	})
+})
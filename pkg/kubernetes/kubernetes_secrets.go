@@ -17,6 +17,7 @@
 package kubernetes
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"github.com/nalej/deployment-manager/internal/entities"
@@ -116,10 +117,13 @@ func (ds *DeployableSecrets) Build() error {
 	return nil
 }
 
-func (ds *DeployableSecrets) Deploy(controller executor.DeploymentController) error {
+func (ds *DeployableSecrets) Deploy(ctx context.Context, controller executor.DeploymentController) error {
 	numCreated := 0
 	for serviceId, secrets := range ds.secrets {
 		for _, toCreate := range secrets {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			log.Debug().Interface("toCreate", toCreate).Msg("creating secret")
 			created, err := ds.client.Create(toCreate)
 			if err != nil {
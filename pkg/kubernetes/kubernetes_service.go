@@ -17,10 +17,12 @@
 package kubernetes
 
 import (
+	"context"
 	"github.com/nalej/deployment-manager/internal/entities"
 	"github.com/nalej/deployment-manager/pkg/common"
 	"github.com/nalej/deployment-manager/pkg/executor"
 	"github.com/nalej/deployment-manager/pkg/utils"
+	pbConductor "github.com/nalej/grpc-conductor-go"
 	"github.com/rs/zerolog/log"
 	apiv1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -77,40 +79,9 @@ func (s *DeployableServices) Build() error {
 
 		log.Debug().Msgf("build service %s %d out of %d", service.ServiceId, serviceIndex+1, len(s.Data.Stage.Services))
 
-		extendedLabels := make(map[string]string, 0)
-		extendedLabels[utils.NALEJ_ANNOTATION_ORGANIZATION_ID] = s.Data.OrganizationId
-		extendedLabels[utils.NALEJ_ANNOTATION_APP_DESCRIPTOR] = s.Data.AppDescriptorId
-		extendedLabels[utils.NALEJ_ANNOTATION_APP_INSTANCE_ID] = s.Data.AppInstanceId
-
-		extendedLabels[utils.NALEJ_ANNOTATION_IS_PROXY] = "false"
-
-		// Labels for the selector
-		selectorLabels := map[string]string{
-			utils.NALEJ_ANNOTATION_APP_INSTANCE_ID: s.Data.AppInstanceId,
-			utils.NALEJ_ANNOTATION_ORGANIZATION_ID: service.OrganizationId,
-			utils.NALEJ_ANNOTATION_SERVICE_NAME:    common.FormatName(service.ServiceName),
-		}
-
-		ports := getServicePorts(service.ExposedPorts)
-		if ports != nil {
-			k8sService := apiv1.Service{
-				ObjectMeta: metav1.ObjectMeta{
-					Namespace: s.Data.Namespace,
-					Name:      common.FormatName(service.ServiceName),
-					Labels:    extendedLabels,
-				},
-				Spec: apiv1.ServiceSpec{
-					ExternalName: common.FormatName(service.ServiceName),
-					Ports:        ports,
-					Type:         apiv1.ServiceTypeClusterIP,
-					Selector:     selectorLabels,
-
-				},
-			}
-			log.Debug().Str("serviceId", service.ServiceId).Str("serviceInstanceId", service.ServiceInstanceId).
-				Interface("apiv1.Service", k8sService).Msg("generated k8s service")
-			s.Services = append(s.Services, ServiceInfo{service.ServiceId, service.ServiceInstanceId, k8sService})
-
+		servInfo := s.buildService(service)
+		if servInfo != nil {
+			s.Services = append(s.Services, *servInfo)
 		} else {
 			log.Debug().Msgf("No k8s service is generated for %s", service.ServiceId)
 		}
@@ -126,10 +97,61 @@ func (s *DeployableServices) Build() error {
 	return nil
 }
 
-func (s *DeployableServices) Deploy(controller executor.DeploymentController) error {
+// Build the kubernetes service of a Nalej service.
+//  params:
+//   service to be exposed
+//  return:
+//   service information or nil if the service does not expose any port
+func (s *DeployableServices) buildService(service *pbConductor.ServiceInstance) *ServiceInfo {
+	extendedLabels := make(map[string]string, 0)
+	extendedLabels[utils.NALEJ_ANNOTATION_DEPLOYMENT_FRAGMENT] = s.Data.FragmentId
+	extendedLabels[utils.NALEJ_ANNOTATION_ORGANIZATION_ID] = s.Data.OrganizationId
+	extendedLabels[utils.NALEJ_ANNOTATION_APP_DESCRIPTOR] = s.Data.AppDescriptorId
+	extendedLabels[utils.NALEJ_ANNOTATION_APP_INSTANCE_ID] = s.Data.AppInstanceId
+	extendedLabels[utils.NALEJ_ANNOTATION_STAGE_ID] = s.Data.Stage.StageId
+	extendedLabels[utils.NALEJ_ANNOTATION_SERVICE_ID] = service.ServiceId
+	extendedLabels[utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID] = service.ServiceInstanceId
+	extendedLabels[utils.NALEJ_ANNOTATION_SERVICE_GROUP_ID] = service.ServiceGroupId
+	extendedLabels[utils.NALEJ_ANNOTATION_SERVICE_GROUP_INSTANCE_ID] = service.ServiceGroupInstanceId
+
+	extendedLabels[utils.NALEJ_ANNOTATION_IS_PROXY] = "false"
+
+	// Labels for the selector
+	selectorLabels := map[string]string{
+		utils.NALEJ_ANNOTATION_APP_INSTANCE_ID: s.Data.AppInstanceId,
+		utils.NALEJ_ANNOTATION_ORGANIZATION_ID: service.OrganizationId,
+		utils.NALEJ_ANNOTATION_SERVICE_NAME:    common.FormatName(service.ServiceName),
+	}
+
+	ports := getServicePorts(service.ExposedPorts)
+	if ports == nil {
+		return nil
+	}
+	k8sService := apiv1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: s.Data.Namespace,
+			Name:      common.FormatName(service.ServiceName),
+			Labels:    extendedLabels,
+		},
+		Spec: apiv1.ServiceSpec{
+			ExternalName: common.FormatName(service.ServiceName),
+			Ports:        ports,
+			Type:         apiv1.ServiceTypeClusterIP,
+			Selector:     selectorLabels,
+		},
+	}
+	log.Debug().Str("serviceId", service.ServiceId).Str("serviceInstanceId", service.ServiceInstanceId).
+		Interface("apiv1.Service", k8sService).Msg("generated k8s service")
+	return &ServiceInfo{service.ServiceId, service.ServiceInstanceId, k8sService}
+}
+
+func (s *DeployableServices) Deploy(ctx context.Context, controller executor.DeploymentController) error {
 	log.Debug().Int("numberServicesToDeploy", len(s.Services)).Msg("deploy deployableServices")
 
 	for _, servInfo := range s.Services {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 
 		// if the service is already there skip
 		_, err := s.Client.Get(servInfo.Service.Name, metav1.GetOptions{})
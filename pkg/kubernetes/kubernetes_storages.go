@@ -207,10 +207,13 @@ func (ds *DeployableStorage) Build() error {
 	return nil
 }
 
-func (ds *DeployableStorage) Deploy(controller executor.DeploymentController) error {
+func (ds *DeployableStorage) Deploy(ctx context.Context, controller executor.DeploymentController) error {
 	numCreated := 0
 	for serviceId, pvcs := range ds.pvcs {
 		for _, toCreate := range pvcs {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
 			log.Debug().Interface("toCreate", toCreate).Msg("creating Persistence Storage ")
 
 			// if the pvc contains the label NALEJ_ANNOTATION_STORAGE_TYPE: StorageType_EXPERIMENTAL_CLUSTER_REPLICA -> create it
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kubernetes
+
+import (
+	"strconv"
+
+	"github.com/nalej/deployment-manager/pkg/utils"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "k8s.io/client-go/kubernetes/typed/apps/v1"
+)
+
+// Suspension of the workloads of an application. Only the deployments are modified, the namespace and the rest
+// of the resources of the application are kept untouched.
+
+func (k *KubernetesExecutor) SuspendNamespace(namespace string) derrors.Error {
+	return suspendDeployments(k.Client.AppsV1().Deployments(namespace))
+}
+
+func (k *KubernetesExecutor) ResumeNamespace(namespace string) derrors.Error {
+	resumed, err := resumeDeployments(k.Client.AppsV1().Deployments(namespace))
+	for _, dep := range resumed {
+		// proxies are not monitored
+		if dep.Labels[utils.NALEJ_ANNOTATION_IS_PROXY] != "true" {
+			k.setDeploying(dep)
+		}
+	}
+	return err
+}
+
+// Scale down to zero all the deployments of a namespace. The number of replicas of every deployment is stored
+// in an annotation. Deployments already annotated are considered suspended and they are not modified again.
+//
+//	params:
+//	 client deployments client of the namespace
+//	return:
+//	 error if any
+func suspendDeployments(client v1.DeploymentInterface) derrors.Error {
+	list, err := client.List(metav1.ListOptions{})
+	if err != nil {
+		return derrors.NewInternalError("impossible to list deployments", err)
+	}
+	for _, dep := range list.Items {
+		if _, suspended := dep.Annotations[utils.NALEJ_ANNOTATION_SUSPENDED_REPLICAS]; suspended {
+			log.Debug().Str("name", dep.Name).Msg("deployment already suspended")
+			continue
+		}
+		// kubernetes defaults to one replica when none is set
+		replicas := int32(1)
+		if dep.Spec.Replicas != nil {
+			replicas = *dep.Spec.Replicas
+		}
+		if dep.Annotations == nil {
+			dep.Annotations = make(map[string]string, 0)
+		}
+		dep.Annotations[utils.NALEJ_ANNOTATION_SUSPENDED_REPLICAS] = strconv.Itoa(int(replicas))
+		zero := int32(0)
+		dep.Spec.Replicas = &zero
+		_, err := client.Update(&dep)
+		if err != nil {
+			log.Error().Err(err).Str("name", dep.Name).Msg("cannot suspend deployment")
+			return derrors.NewInternalError("impossible to suspend deployment", err).WithParams(dep.Name)
+		}
+		log.Info().Str("name", dep.Name).Int32("replicas", replicas).Msg("deployment suspended")
+	}
+	return nil
+}
+
+// Restore the number of replicas of the suspended deployments of a namespace and remove their annotation.
+//
+//	params:
+//	 client deployments client of the namespace
+//	return:
+//	 resumed deployments and error if any
+func resumeDeployments(client v1.DeploymentInterface) ([]*appsv1.Deployment, derrors.Error) {
+	resumed := make([]*appsv1.Deployment, 0)
+	list, err := client.List(metav1.ListOptions{})
+	if err != nil {
+		return resumed, derrors.NewInternalError("impossible to list deployments", err)
+	}
+	for _, dep := range list.Items {
+		stored, suspended := dep.Annotations[utils.NALEJ_ANNOTATION_SUSPENDED_REPLICAS]
+		if !suspended {
+			continue
+		}
+		replicas, err := strconv.ParseInt(stored, 10, 32)
+		if err != nil {
+			return resumed, derrors.NewInternalError("invalid number of suspended replicas", err).WithParams(dep.Name, stored)
+		}
+		restored := int32(replicas)
+		dep.Spec.Replicas = &restored
+		delete(dep.Annotations, utils.NALEJ_ANNOTATION_SUSPENDED_REPLICAS)
+		updated, err := client.Update(&dep)
+		if err != nil {
+			log.Error().Err(err).Str("name", dep.Name).Msg("cannot resume deployment")
+			return resumed, derrors.NewInternalError("impossible to resume deployment", err).WithParams(dep.Name)
+		}
+		log.Info().Str("name", dep.Name).Int32("replicas", restored).Msg("deployment resumed")
+		resumed = append(resumed, updated)
+	}
+	return resumed, nil
+}
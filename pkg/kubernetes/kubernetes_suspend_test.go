@@ -0,0 +1,75 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package kubernetes
+
+import (
+	"github.com/nalej/deployment-manager/pkg/utils"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = ginkgo.Describe("Kubernetes application suspension tests", func() {
+
+	var client *fake.Clientset
+
+	ginkgo.BeforeEach(func() {
+		web := testDeployment("ns", "web", "frag1", "inst1")
+		web.Spec.Replicas = int32Ptr(3)
+		client = fake.NewSimpleClientset(web, testDeployment("ns", "zt-web", "frag1", "inst1"))
+	})
+
+	getDeployment := func(name string) *appsv1.Deployment {
+		dep, err := client.AppsV1().Deployments("ns").Get(name, metav1.GetOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		return dep
+	}
+
+	ginkgo.It("should scale down every deployment storing its replicas", func() {
+		gomega.Expect(suspendDeployments(client.AppsV1().Deployments("ns"))).To(gomega.BeNil())
+
+		for name, stored := range map[string]string{"web": "3", "zt-web": "1"} {
+			dep := getDeployment(name)
+			gomega.Expect(*dep.Spec.Replicas).To(gomega.Equal(int32(0)))
+			gomega.Expect(dep.Annotations[utils.NALEJ_ANNOTATION_SUSPENDED_REPLICAS]).To(gomega.Equal(stored))
+		}
+
+		// suspending again must not overwrite the stored replicas
+		gomega.Expect(suspendDeployments(client.AppsV1().Deployments("ns"))).To(gomega.BeNil())
+		gomega.Expect(getDeployment("web").Annotations[utils.NALEJ_ANNOTATION_SUSPENDED_REPLICAS]).To(gomega.Equal("3"))
+	})
+
+	ginkgo.It("should restore the replicas of the suspended deployments", func() {
+		gomega.Expect(suspendDeployments(client.AppsV1().Deployments("ns"))).To(gomega.BeNil())
+
+		resumed, err := resumeDeployments(client.AppsV1().Deployments("ns"))
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(resumed).To(gomega.HaveLen(2))
+		for name, replicas := range map[string]int32{"web": 3, "zt-web": 1} {
+			dep := getDeployment(name)
+			gomega.Expect(*dep.Spec.Replicas).To(gomega.Equal(replicas))
+			gomega.Expect(dep.Annotations).NotTo(gomega.HaveKey(utils.NALEJ_ANNOTATION_SUSPENDED_REPLICAS))
+		}
+
+		// deployments that are not suspended are not modified
+		resumed, err = resumeDeployments(client.AppsV1().Deployments("ns"))
+		gomega.Expect(err).To(gomega.BeNil())
+		gomega.Expect(resumed).To(gomega.BeEmpty())
+	})
+})
@@ -0,0 +1,497 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/deployment-manager/pkg/executor"
+	"github.com/nalej/deployment-manager/pkg/utils"
+	"github.com/nalej/grpc-application-go"
+	pbConductor "github.com/nalej/grpc-conductor-go"
+	"github.com/nalej/grpc-storage-fabric-go"
+	"github.com/rs/zerolog/log"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	v1 "k8s.io/client-go/kubernetes/typed/apps/v1"
+	coreV1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	extV1Beta1 "k8s.io/client-go/kubernetes/typed/extensions/v1beta1"
+)
+
+/*
+ * In-place update of a running fragment. The deployables are built as in a regular deployment and every object is
+ * created when missing or replaced when it differs from the one found in the cluster, so Kubernetes rolls out the
+ * changes keeping the namespace, the volumes and the network memberships. Objects of the fragment that are no
+ * longer built are removed. Volume claims are only created, they are never modified nor removed.
+ */
+
+// Names of the objects built for the new version of a fragment
+type updatedObjects struct {
+	deployments map[string]bool
+	services    map[string]bool
+	configmaps  map[string]bool
+	secrets     map[string]bool
+	ingresses   map[string]bool
+	// service instances of the new version
+	serviceInstances map[string]bool
+}
+
+func newUpdatedObjects() *updatedObjects {
+	return &updatedObjects{
+		deployments:      make(map[string]bool, 0),
+		services:         make(map[string]bool, 0),
+		configmaps:       make(map[string]bool, 0),
+		secrets:          make(map[string]bool, 0),
+		ingresses:        make(map[string]bool, 0),
+		serviceInstances: make(map[string]bool, 0),
+	}
+}
+
+func (k *KubernetesExecutor) UpdateFragment(ctx context.Context, data entities.DeploymentMetadata, stages []*pbConductor.DeploymentStage,
+	networkDecorator executor.NetworkDecorator, sfClient grpc_storage_fabric_go.StorageClassClient) error {
+	log.Info().Str("fragmentId", data.FragmentId).Str("namespace", data.Namespace).Msg("update fragment")
+
+	updated := newUpdatedObjects()
+	for _, stage := range stages {
+		data.Stage = *stage
+		stageDeployable := NewDeployableKubernetesStage(k.Client, data, networkDecorator, sfClient)
+		if err := stageDeployable.Build(); err != nil {
+			log.Error().Err(err).Msgf("impossible to build resources for stage %s in fragment %s",
+				stage.StageId, data.FragmentId)
+			return err
+		}
+		if err := stageDeployable.update(ctx, k.Controller, k.Rollouts, updated); err != nil {
+			log.Error().Err(err).Msgf("impossible to update resources for stage %s in fragment %s",
+				stage.StageId, data.FragmentId)
+			return err
+		}
+	}
+
+	return pruneFragment(ctx, k.Client, data.Namespace, k.Controller, data.FragmentId, updated)
+}
+
+// Update the objects of the stage in the same order they are deployed. The deployments of the services with a
+// rollout strategy are handed to the rollout controller.
+func (d DeployableKubernetesStage) update(ctx context.Context, controller executor.DeploymentController,
+	rollouts *RolloutController, updated *updatedObjects) error {
+	for _, secrets := range d.Secrets.secrets {
+		for _, secret := range secrets {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if _, err := updateSecret(d.Secrets.client, secret); err != nil {
+				return err
+			}
+			updated.secrets[secret.Name] = true
+		}
+	}
+
+	for _, configmaps := range d.Configmaps.configmaps {
+		for _, configmap := range configmaps {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if _, err := updateConfigMap(d.Configmaps.client, configmap); err != nil {
+				return err
+			}
+			updated.configmaps[configmap.Name] = true
+		}
+	}
+
+	if err := d.Storage.createMissing(ctx); err != nil {
+		return err
+	}
+
+	for _, deployment := range d.Deployments.Deployments {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		updated.deployments[deployment.Name] = true
+		strategy, found := d.data.RolloutStrategies[deployment.Labels[utils.NALEJ_ANNOTATION_SERVICE_ID]]
+		if found && strategy.Type != entities.ROLLOUT_ROLLING && rollouts != nil {
+			if err := rollouts.Rollout(ctx, deployment, strategy, d.Deployments.networkDecorator); err != nil {
+				return err
+			}
+			continue
+		}
+		created, err := updateDeployment(d.Deployments.Client, deployment)
+		if err != nil {
+			return err
+		}
+		if created != nil {
+			addMonitoredObject(controller, created)
+		}
+	}
+
+	// services found in the cluster are not built by the deployable, build them again
+	for _, service := range d.Services.Data.Stage.Services {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		updated.serviceInstances[service.ServiceInstanceId] = true
+		servInfo := d.Services.buildService(service)
+		if servInfo == nil {
+			continue
+		}
+		updated.services[servInfo.Service.Name] = true
+		created, err := updateService(d.Services.Client, &servInfo.Service)
+		if err != nil {
+			return err
+		}
+		if created != nil {
+			addMonitoredObject(controller, created)
+		}
+	}
+
+	for _, ingresses := range d.Ingresses.Ingresses {
+		for _, ingress := range ingresses.Ingresses {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			created, err := updateIngress(d.Ingresses.client, ingress)
+			if err != nil {
+				return err
+			}
+			if created != nil {
+				addMonitoredObject(controller, created)
+			}
+			updated.ingresses[ingress.Name] = true
+		}
+	}
+
+	return nil
+}
+
+// Create the volume claims of the services added to the fragment.
+func (ds *DeployableStorage) createMissing(ctx context.Context) error {
+	for serviceId, pvcs := range ds.pvcs {
+		for _, toCreate := range pvcs {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			_, err := ds.client.Get(toCreate.Name, metav1.GetOptions{})
+			if err == nil {
+				continue
+			}
+			if !errors.IsNotFound(err) {
+				log.Error().Err(err).Str("name", toCreate.Name).Msg("cannot get Persistence Storage")
+				return err
+			}
+			stoType, exists := toCreate.Labels[utils.NALEJ_ANNOTATION_STORAGE_TYPE]
+			if exists && stoType == grpc_application_go.StorageType_EXPERIMENTAL_CLUSTER_REPLICA.String() {
+				go ds.createExperimentalStorage(toCreate)
+			}
+			created, err := ds.client.Create(toCreate)
+			if err != nil {
+				log.Error().Err(err).Interface("toCreate", toCreate).Msg("cannot create Persistence Storage")
+				return err
+			}
+			log.Debug().Str("serviceId", serviceId).Str("uid", string(created.GetUID())).Msg("Persistence Storage has been created")
+		}
+	}
+	return nil
+}
+
+// Register a new object to be monitored by the controller.
+func addMonitoredObject(controller executor.DeploymentController, created metav1.Object) {
+	labels := created.GetLabels()
+	res := entities.NewMonitoredPlatformResource(labels[utils.NALEJ_ANNOTATION_DEPLOYMENT_FRAGMENT], string(created.GetUID()),
+		labels[utils.NALEJ_ANNOTATION_APP_DESCRIPTOR], labels[utils.NALEJ_ANNOTATION_APP_INSTANCE_ID],
+		labels[utils.NALEJ_ANNOTATION_SERVICE_GROUP_ID], labels[utils.NALEJ_ANNOTATION_SERVICE_GROUP_INSTANCE_ID],
+		labels[utils.NALEJ_ANNOTATION_SERVICE_ID], labels[utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID], "")
+	controller.AddMonitoredResource(&res)
+}
+
+// Check if the metadata of an object found in the cluster contains the desired one.
+func sameMetadata(desired metav1.Object, found metav1.Object) bool {
+	return equality.Semantic.DeepDerivative(desired.GetLabels(), found.GetLabels()) &&
+		equality.Semantic.DeepDerivative(desired.GetAnnotations(), found.GetAnnotations())
+}
+
+// Create a deployment or replace the one found in the cluster if it is different. The spec of the desired deployment
+// is compared ignoring the fields it does not set, so the defaults filled by Kubernetes do not trigger an update.
+//  params:
+//   client deployments client
+//   desired deployment
+//  return:
+//   the created deployment, nil if it already existed, or error if any
+func updateDeployment(client v1.DeploymentInterface, desired *appsv1.Deployment) (*appsv1.Deployment, error) {
+	found, err := client.Get(desired.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Debug().Str("name", desired.Name).Msg("creating deployment")
+		return client.Create(desired)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("name", desired.Name).Msg("cannot get deployment")
+		return nil, err
+	}
+	if sameMetadata(desired, found) && equality.Semantic.DeepDerivative(desired.Spec, found.Spec) {
+		log.Debug().Str("name", desired.Name).Msg("deployment not modified")
+		return nil, nil
+	}
+	toUpdate := desired.DeepCopy()
+	toUpdate.ResourceVersion = found.ResourceVersion
+	log.Debug().Str("name", desired.Name).Msg("updating deployment")
+	_, err = client.Update(toUpdate)
+	if err != nil {
+		log.Error().Err(err).Str("name", desired.Name).Msg("cannot update deployment")
+	}
+	return nil, err
+}
+
+// Create a service or replace the one found in the cluster if it is different. The cluster IP and the node
+// ports assigned by Kubernetes are kept.
+//  params:
+//   client services client
+//   desired service
+//  return:
+//   the created service, nil if it already existed, or error if any
+func updateService(client coreV1.ServiceInterface, desired *apiv1.Service) (*apiv1.Service, error) {
+	found, err := client.Get(desired.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Debug().Str("name", desired.Name).Msg("creating service")
+		return client.Create(desired)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("name", desired.Name).Msg("cannot get service")
+		return nil, err
+	}
+	if sameMetadata(desired, found) && equality.Semantic.DeepDerivative(desired.Spec, found.Spec) {
+		log.Debug().Str("name", desired.Name).Msg("service not modified")
+		return nil, nil
+	}
+	toUpdate := desired.DeepCopy()
+	toUpdate.ResourceVersion = found.ResourceVersion
+	toUpdate.Spec.ClusterIP = found.Spec.ClusterIP
+	// the traffic of a service being rolled out is sent to the track selected by its rollout
+	if track, found := found.Spec.Selector[utils.NALEJ_ANNOTATION_ROLLOUT_TRACK]; found {
+		toUpdate.Spec.Selector = withTrack(toUpdate.Spec.Selector, track)
+	}
+	for i, port := range toUpdate.Spec.Ports {
+		for _, foundPort := range found.Spec.Ports {
+			if port.NodePort == 0 && port.Port == foundPort.Port && port.Protocol == foundPort.Protocol {
+				toUpdate.Spec.Ports[i].NodePort = foundPort.NodePort
+			}
+		}
+	}
+	log.Debug().Str("name", desired.Name).Msg("updating service")
+	_, err = client.Update(toUpdate)
+	if err != nil {
+		log.Error().Err(err).Str("name", desired.Name).Msg("cannot update service")
+	}
+	return nil, err
+}
+
+// Create an ingress or replace the one found in the cluster if it is different.
+//  params:
+//   client ingresses client
+//   desired ingress
+//  return:
+//   the created ingress, nil if it already existed, or error if any
+func updateIngress(client extV1Beta1.IngressInterface, desired *v1beta1.Ingress) (*v1beta1.Ingress, error) {
+	found, err := client.Get(desired.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Debug().Str("name", desired.Name).Msg("creating ingress")
+		return client.Create(desired)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("name", desired.Name).Msg("cannot get ingress")
+		return nil, err
+	}
+	if sameMetadata(desired, found) && equality.Semantic.DeepDerivative(desired.Spec, found.Spec) {
+		log.Debug().Str("name", desired.Name).Msg("ingress not modified")
+		return nil, nil
+	}
+	toUpdate := desired.DeepCopy()
+	toUpdate.ResourceVersion = found.ResourceVersion
+	log.Debug().Str("name", desired.Name).Msg("updating ingress")
+	_, err = client.Update(toUpdate)
+	if err != nil {
+		log.Error().Err(err).Str("name", desired.Name).Msg("cannot update ingress")
+	}
+	return nil, err
+}
+
+// Create a config map or replace the one found in the cluster if its content is different.
+//  params:
+//   client config maps client
+//   desired config map
+//  return:
+//   the created config map, nil if it already existed, or error if any
+func updateConfigMap(client coreV1.ConfigMapInterface, desired *apiv1.ConfigMap) (*apiv1.ConfigMap, error) {
+	found, err := client.Get(desired.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Debug().Str("name", desired.Name).Msg("creating config map")
+		return client.Create(desired)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("name", desired.Name).Msg("cannot get config map")
+		return nil, err
+	}
+	if sameMetadata(desired, found) && equality.Semantic.DeepEqual(desired.Data, found.Data) &&
+		equality.Semantic.DeepEqual(desired.BinaryData, found.BinaryData) {
+		log.Debug().Str("name", desired.Name).Msg("config map not modified")
+		return nil, nil
+	}
+	toUpdate := desired.DeepCopy()
+	toUpdate.ResourceVersion = found.ResourceVersion
+	log.Debug().Str("name", desired.Name).Msg("updating config map")
+	_, err = client.Update(toUpdate)
+	if err != nil {
+		log.Error().Err(err).Str("name", desired.Name).Msg("cannot update config map")
+	}
+	return nil, err
+}
+
+// Create a secret or replace the one found in the cluster if its content is different.
+//  params:
+//   client secrets client
+//   desired secret
+//  return:
+//   the created secret, nil if it already existed, or error if any
+func updateSecret(client coreV1.SecretInterface, desired *apiv1.Secret) (*apiv1.Secret, error) {
+	found, err := client.Get(desired.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		log.Debug().Str("name", desired.Name).Msg("creating secret")
+		return client.Create(desired)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("name", desired.Name).Msg("cannot get secret")
+		return nil, err
+	}
+	if sameMetadata(desired, found) && desired.Type == found.Type &&
+		equality.Semantic.DeepEqual(desired.Data, found.Data) && len(desired.StringData) == 0 {
+		log.Debug().Str("name", desired.Name).Msg("secret not modified")
+		return nil, nil
+	}
+	toUpdate := desired.DeepCopy()
+	toUpdate.ResourceVersion = found.ResourceVersion
+	log.Debug().Str("name", desired.Name).Msg("updating secret")
+	_, err = client.Update(toUpdate)
+	if err != nil {
+		log.Error().Err(err).Str("name", desired.Name).Msg("cannot update secret")
+	}
+	return nil, err
+}
+
+// Remove the objects of the fragment that were not built for its new version. The registry secret is shared by
+// every fragment in the namespace and it is never removed. Candidate deployments belong to their rollouts and the
+// services with a purpose are managed with the load balancers and device groups. The services of the fragment
+// whose objects are all removed are no longer monitored.
+func pruneFragment(ctx context.Context, client kubernetes.Interface, namespace string,
+	controller executor.DeploymentController, fragmentId string, updated *updatedObjects) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	opts := metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", utils.NALEJ_ANNOTATION_DEPLOYMENT_FRAGMENT, fragmentId)}
+	deleteOptions := metav1.NewDeleteOptions(DeleteGracePeriod)
+	// service instances with removed objects
+	removed := make(map[string]bool, 0)
+
+	deployments := client.AppsV1().Deployments(namespace)
+	deploymentList, err := deployments.List(opts)
+	if err != nil {
+		return err
+	}
+	for _, found := range deploymentList.Items {
+		track := found.Labels[utils.NALEJ_ANNOTATION_ROLLOUT_TRACK]
+		if !updated.deployments[found.Name] && (track == "" || track == utils.NALEJ_ANNOTATION_VALUE_STABLE_TRACK) {
+			log.Info().Str("fragmentId", fragmentId).Str("name", found.Name).Msg("remove deployment no longer in the fragment")
+			if err := deployments.Delete(found.Name, deleteOptions); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			removed[found.Labels[utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID]] = true
+		}
+	}
+
+	services := client.CoreV1().Services(namespace)
+	serviceList, err := services.List(opts)
+	if err != nil {
+		return err
+	}
+	for _, found := range serviceList.Items {
+		if _, purpose := found.Labels[utils.NALEJ_ANNOTATION_SERVICE_PURPOSE]; purpose {
+			continue
+		}
+		if !updated.services[found.Name] {
+			log.Info().Str("fragmentId", fragmentId).Str("name", found.Name).Msg("remove service no longer in the fragment")
+			if err := services.Delete(found.Name, deleteOptions); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			removed[found.Labels[utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID]] = true
+		}
+	}
+
+	configmaps := client.CoreV1().ConfigMaps(namespace)
+	configmapList, err := configmaps.List(opts)
+	if err != nil {
+		return err
+	}
+	for _, found := range configmapList.Items {
+		if !updated.configmaps[found.Name] {
+			log.Info().Str("fragmentId", fragmentId).Str("name", found.Name).Msg("remove config map no longer in the fragment")
+			if err := configmaps.Delete(found.Name, deleteOptions); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+
+	secrets := client.CoreV1().Secrets(namespace)
+	secretList, err := secrets.List(opts)
+	if err != nil {
+		return err
+	}
+	for _, found := range secretList.Items {
+		if !updated.secrets[found.Name] && found.Name != NalejPublicRegistryName {
+			log.Info().Str("fragmentId", fragmentId).Str("name", found.Name).Msg("remove secret no longer in the fragment")
+			if err := secrets.Delete(found.Name, deleteOptions); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+
+	ingresses := client.ExtensionsV1beta1().Ingresses(namespace)
+	ingressList, err := ingresses.List(opts)
+	if err != nil {
+		return err
+	}
+	for _, found := range ingressList.Items {
+		if !updated.ingresses[found.Name] {
+			log.Info().Str("fragmentId", fragmentId).Str("name", found.Name).Msg("remove ingress no longer in the fragment")
+			if err := ingresses.Delete(found.Name, deleteOptions); err != nil && !errors.IsNotFound(err) {
+				return err
+			}
+			removed[found.Labels[utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID]] = true
+		}
+	}
+
+	for serviceInstanceId := range removed {
+		if serviceInstanceId != "" && !updated.serviceInstances[serviceInstanceId] {
+			log.Info().Str("fragmentId", fragmentId).Str("serviceInstanceId", serviceInstanceId).
+				Msg("stop monitoring service no longer in the fragment")
+			controller.RemoveMonitoredService(fragmentId, serviceInstanceId)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package kubernetes
+
+import (
+	"context"
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/deployment-manager/internal/structures/monitor"
+	"github.com/nalej/deployment-manager/pkg/utils"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+var _ = ginkgo.Describe("Kubernetes update tests", func() {
+
+	ginkgo.It("should create missing deployments and replace the modified ones", func() {
+		client := fake.NewSimpleClientset()
+		deployments := client.AppsV1().Deployments("ns")
+
+		created, err := updateDeployment(deployments, testDeployment("ns", "web", "frag1", "inst1"))
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(created).NotTo(gomega.BeNil())
+
+		// defaults set by the cluster do not trigger an update
+		found, err := deployments.Get("web", metav1.GetOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		found.Spec.RevisionHistoryLimit = int32Ptr(10)
+		_, err = deployments.Update(found)
+		gomega.Expect(err).To(gomega.Succeed())
+		created, err = updateDeployment(deployments, testDeployment("ns", "web", "frag1", "inst1"))
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(created).To(gomega.BeNil())
+		found, err = deployments.Get("web", metav1.GetOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(*found.Spec.RevisionHistoryLimit).To(gomega.Equal(int32(10)))
+
+		newVersion := testDeployment("ns", "web", "frag1", "inst1")
+		newVersion.Spec.Template.Spec.Containers[0].Image = "web:2"
+		created, err = updateDeployment(deployments, newVersion)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(created).To(gomega.BeNil())
+		found, err = deployments.Get("web", metav1.GetOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(found.Spec.Template.Spec.Containers[0].Image).To(gomega.Equal("web:2"))
+	})
+
+	ginkgo.It("should keep the cluster IP of updated services", func() {
+		client := fake.NewSimpleClientset(&apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns"},
+			Spec: apiv1.ServiceSpec{
+				ClusterIP: "10.0.0.1",
+				Ports:     []apiv1.ServicePort{{Port: 80}},
+			},
+		})
+		services := client.CoreV1().Services("ns")
+		desired := &apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns"},
+			Spec:       apiv1.ServiceSpec{Ports: []apiv1.ServicePort{{Port: 80}, {Port: 443}}},
+		}
+		created, err := updateService(services, desired)
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(created).To(gomega.BeNil())
+		found, err := services.Get("web", metav1.GetOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(found.Spec.ClusterIP).To(gomega.Equal("10.0.0.1"))
+		gomega.Expect(found.Spec.Ports).To(gomega.HaveLen(2))
+	})
+
+	ginkgo.It("should remove the objects no longer in the fragment", func() {
+		fragmentLabels := map[string]string{utils.NALEJ_ANNOTATION_DEPLOYMENT_FRAGMENT: "frag1"}
+		serviceLabels := func(serviceInstanceId string) map[string]string {
+			return map[string]string{utils.NALEJ_ANNOTATION_DEPLOYMENT_FRAGMENT: "frag1",
+				utils.NALEJ_ANNOTATION_SERVICE_INSTANCE_ID: serviceInstanceId}
+		}
+		client := fake.NewSimpleClientset(
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns", Labels: serviceLabels("serv1")}},
+			&appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "old", Namespace: "ns", Labels: serviceLabels("serv2")}},
+			&apiv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "ns", Labels: serviceLabels("serv1")}},
+			&apiv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "old", Namespace: "ns", Labels: serviceLabels("serv2")}},
+			&apiv1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web-lb", Namespace: "ns", Labels: map[string]string{
+				utils.NALEJ_ANNOTATION_DEPLOYMENT_FRAGMENT: "frag1",
+				utils.NALEJ_ANNOTATION_SERVICE_PURPOSE:     utils.NALEJ_ANNOTATION_VALUE_LOAD_BALANCER_SERVICE}}},
+			&apiv1.Secret{ObjectMeta: metav1.ObjectMeta{Name: NalejPublicRegistryName, Namespace: "ns", Labels: fragmentLabels}},
+			&apiv1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "old-config", Namespace: "ns", Labels: fragmentLabels}},
+		)
+		instances := monitor.NewMemoryMonitoredInstances()
+		instances.AddEntry(&entities.MonitoredAppEntry{FragmentId: "frag1", TotalServices: 2,
+			Services: map[string]*entities.MonitoredServiceEntry{
+				"serv1": {ServiceInstanceID: "serv1", Resources: make(map[string]*entities.MonitoredPlatformResource, 0)},
+				"serv2": {ServiceInstanceID: "serv2", Resources: make(map[string]*entities.MonitoredPlatformResource, 0)},
+			}})
+		updated := newUpdatedObjects()
+		updated.deployments["web"] = true
+		updated.services["web"] = true
+		updated.serviceInstances["serv1"] = true
+
+		err := pruneFragment(context.Background(), client, "ns", NewKubernetesController(instances), "frag1", updated)
+		gomega.Expect(err).To(gomega.Succeed())
+
+		deployments, err := client.AppsV1().Deployments("ns").List(metav1.ListOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(deployments.Items).To(gomega.HaveLen(1))
+		gomega.Expect(deployments.Items[0].Name).To(gomega.Equal("web"))
+		services, err := client.CoreV1().Services("ns").List(metav1.ListOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(services.Items).To(gomega.HaveLen(2))
+		_, err = client.CoreV1().Services("ns").Get("old", metav1.GetOptions{})
+		gomega.Expect(errors.IsNotFound(err)).To(gomega.BeTrue())
+		configmaps, err := client.CoreV1().ConfigMaps("ns").List(metav1.ListOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(configmaps.Items).To(gomega.BeEmpty())
+		_, err = client.CoreV1().Secrets("ns").Get(NalejPublicRegistryName, metav1.GetOptions{})
+		gomega.Expect(err).To(gomega.Succeed())
+
+		entry := instances.GetEntry("frag1")
+		gomega.Expect(entry.TotalServices).To(gomega.Equal(1))
+		gomega.Expect(entry.Services).To(gomega.HaveKey("serv1"))
+		gomega.Expect(entry.Services).NotTo(gomega.HaveKey("serv2"))
+	})
+})
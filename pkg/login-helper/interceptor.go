@@ -0,0 +1,95 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package login_helper
+
+import (
+	"context"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpc_status "google.golang.org/grpc/status"
+	"time"
+)
+
+// UnaryClientInterceptor returns an interceptor that sends the calls with the token of the current credentials.
+// If a call is rejected as unauthenticated, the helper logs in again and the call is retried once with the remaining
+// deadline of the caller. The call is not retried if it was cancelled or its deadline passed while logging in.
+func (l *LoginHelper) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+		invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		token := l.token()
+		err := invoker(withToken(ctx, token), method, req, reply, cc, opts...)
+		if grpc_status.Convert(err).Code() != codes.Unauthenticated {
+			return err
+		}
+		log.Warn().Str("method", method).Msg("call not authenticated, login again")
+		if authErr := l.reauthenticate(token); authErr != nil {
+			log.Error().Str("err", authErr.DebugReport()).Str("method", method).Msg("impossible to login again")
+			return err
+		}
+		if ctxErr := expired(ctx); ctxErr != nil {
+			log.Warn().Str("method", method).Msg("call expired while logging in again, it will not be retried")
+			return ctxErr
+		}
+		return invoker(withToken(ctx, l.token()), method, req, reply, cc, opts...)
+	}
+}
+
+// Check if the caller is still waiting for a call.
+//  params:
+//   ctx context of the call
+//  return:
+//   status error of the context if it was cancelled or its deadline passed, nil otherwise
+func expired(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return grpc_status.FromContextError(err).Err()
+	}
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		return grpc_status.FromContextError(context.DeadlineExceeded).Err()
+	}
+	return nil
+}
+
+// Login again after a call is rejected. Calls rejected at the same time only trigger one login.
+//  params:
+//   rejectedToken token of the rejected call
+//  return:
+//   error if the login fails
+func (l *LoginHelper) reauthenticate(rejectedToken string) derrors.Error {
+	l.reauthMu.Lock()
+	defer l.reauthMu.Unlock()
+	if l.token() != rejectedToken {
+		// another call already logged in again
+		return nil
+	}
+	return l.RerunAuthentication()
+}
+
+// Add the token to the outgoing metadata of a context.
+func withToken(ctx context.Context, token string) context.Context {
+	md, found := metadata.FromOutgoingContext(ctx)
+	if found {
+		md = md.Copy()
+	} else {
+		md = metadata.New(nil)
+	}
+	md.Set(AuthHeader, token)
+	return metadata.NewOutgoingContext(ctx, md)
+}
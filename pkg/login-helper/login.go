@@ -18,6 +18,7 @@ package login_helper
 
 import (
 	"context"
+	"github.com/dgrijalva/jwt-go"
 	"github.com/nalej/derrors"
 	"github.com/nalej/grpc-authx-go"
 	"github.com/nalej/grpc-login-api-go"
@@ -27,11 +28,16 @@ import (
 	"google.golang.org/grpc/codes"
 	grpc_status "google.golang.org/grpc/status"
 	"sync"
+	"time"
 )
 
 const (
 	// Maximum number of retries for authentication
 	MaxAuthRetries = 10
+	// Time before the expiration of the token when a new one is requested
+	RefreshMargin = time.Minute
+	// Time to wait before retrying a failed refresh of the token
+	RefreshRetryPeriod = 10 * time.Second
 )
 
 type LoginHelper struct {
@@ -40,25 +46,151 @@ type LoginHelper struct {
 	email       string
 	password    string
 	Credentials *Credentials
-	mu          sync.RWMutex
+	// expiration of the token of the credentials, zero if the token does not expire
+	expiresAt time.Time
+	// time the token of the credentials was obtained
+	loggedAt time.Time
+	// result of the last login
+	loginErr derrors.Error
+	// function obtaining new credentials
+	authenticate func() (*Credentials, derrors.Error)
+	mu           sync.RWMutex
+	// Mutex serializing the logins
+	loginMu sync.Mutex
+	// Mutex serializing the logins of the calls rejected as unauthenticated
+	reauthMu sync.Mutex
+	// Channel closed to stop refreshing the token
+	stop chan struct{}
+	// Channel closed when the refresh is stopped
+	done chan struct{}
 }
 
 // NewLogin creates a new LoginHelper structure.
 func NewLogin(hostname string, port int, useTLS bool, email string, password string, caCertPath string, clientCertPath string, skipCAValidation bool) *LoginHelper {
-	return &LoginHelper{
+	helper := &LoginHelper{
 		Connection: *NewConnection(hostname, port, useTLS, caCertPath, clientCertPath, skipCAValidation),
 		email:      email,
 		password:   password,
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
 	}
+	helper.authenticate = helper.login
+	return helper
 }
 
 func (l *LoginHelper) Login() derrors.Error {
 	// Lock incoming
+	l.loginMu.Lock()
+	defer l.loginMu.Unlock()
+	// the current credentials can be used while the new ones are requested
+	credentials, err := l.authenticate()
 	l.mu.Lock()
 	defer l.mu.Unlock()
+	l.loginErr = err
+	if err == nil {
+		l.Credentials = credentials
+		l.loggedAt = time.Now()
+		l.expiresAt = tokenExpiration(credentials.Token)
+	}
+	return l.loginErr
+}
+
+// Get the expiration time of a JWT token. The signature is not verified as the token is only used to
+// know when it must be refreshed.
+//  params:
+//   token JWT token
+//  return:
+//   expiration time or zero if the token does not expire or cannot be parsed
+func tokenExpiration(token string) time.Time {
+	claims := &jwt.StandardClaims{}
+	_, _, err := new(jwt.Parser).ParseUnverified(token, claims)
+	if err != nil || claims.ExpiresAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.ExpiresAt, 0)
+}
+
+// Get the time the token of the current credentials must be refreshed. Short-lived tokens are refreshed
+// once half of their lifetime has passed.
+//  return:
+//   refresh time and false if the token does not need to be refreshed
+func (l *LoginHelper) refreshTime() (time.Time, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.expiresAt.IsZero() {
+		return time.Time{}, false
+	}
+	margin := RefreshMargin
+	if lifetime := l.expiresAt.Sub(l.loggedAt); lifetime < 2*margin {
+		margin = lifetime / 2
+	}
+	return l.expiresAt.Add(-margin), true
+}
+
+// Refresh the token of the credentials before it expires. The refresh is retried until a new token is
+// obtained or the helper is stopped.
+func (l *LoginHelper) Run() {
+	log.Info().Msg("start refreshing the login token...")
+	defer close(l.done)
+	for {
+		next, refresh := l.refreshTime()
+		if !refresh {
+			// the token may change with the next login
+			next = time.Now().Add(RefreshRetryPeriod)
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+			// the token may have been refreshed by a call rejected as unauthenticated
+			if current, refresh := l.refreshTime(); !refresh || time.Now().Before(current) {
+				continue
+			}
+			log.Debug().Msg("refreshing the login token")
+			if err := l.Login(); err != nil {
+				log.Error().Str("err", err.DebugReport()).Msg("impossible to refresh the login token")
+				l.retryRefresh()
+			}
+		case <-l.stop:
+			timer.Stop()
+			log.Info().Msg("login token refresh stopped")
+			return
+		}
+	}
+}
+
+// Wait before retrying a failed refresh.
+func (l *LoginHelper) retryRefresh() {
+	select {
+	case <-time.After(RefreshRetryPeriod):
+	case <-l.stop:
+	}
+}
+
+// Stop refreshing the token. It returns once the refresh is stopped.
+func (l *LoginHelper) Stop() {
+	close(l.stop)
+	<-l.done
+}
+
+// Status of the login with the management cluster.
+//  return:
+//   error if the helper is not logged in or its last login failed
+func (l *LoginHelper) Status() derrors.Error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.loginErr != nil {
+		return l.loginErr
+	}
+	if l.Credentials == nil {
+		return derrors.NewUnauthenticatedError("login not done")
+	}
+	return nil
+}
+
+func (l *LoginHelper) login() (*Credentials, derrors.Error) {
 	c, err := l.GetConnection()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer c.Close()
 	loginClient := grpc_login_api_go.NewLoginClient(c)
@@ -70,20 +202,36 @@ func (l *LoginHelper) Login() derrors.Error {
 	}
 	response, lErr := loginClient.LoginWithBasicCredentials(ctx, loginRequest)
 	if lErr != nil {
-		return conversions.ToDerror(lErr)
+		return nil, conversions.ToDerror(lErr)
 	}
 	// log.Debug().Str("token", response.Token).Msg("LoginHelper success")
-	l.Credentials = NewCredentials(DefaultPath, response.Token, response.RefreshToken)
-	sErr := l.Credentials.Store()
+	credentials := NewCredentials(DefaultPath, response.Token, response.RefreshToken)
+	sErr := credentials.Store()
 	if sErr != nil {
-		return sErr
+		return nil, sErr
 	}
 
-	return nil
+	return credentials, nil
+}
+
+// Get the current credentials.
+func (l *LoginHelper) getCredentials() *Credentials {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.Credentials
+}
+
+// Get the token of the current credentials, empty if the helper is not logged in.
+func (l *LoginHelper) token() string {
+	credentials := l.getCredentials()
+	if credentials == nil {
+		return ""
+	}
+	return credentials.Token
 }
 
 func (l *LoginHelper) GetContext() (context.Context, context.CancelFunc) {
-	return l.Credentials.GetContext()
+	return l.getCredentials().GetContext()
 }
 
 type GenericGRPCCall func(context.Context, interface{}, ...grpc.CallOption) (interface{}, error)
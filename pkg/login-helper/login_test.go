@@ -0,0 +1,239 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package login_helper
+
+import (
+	"context"
+	"fmt"
+	"github.com/dgrijalva/jwt-go"
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpc_status "google.golang.org/grpc/status"
+	"sync"
+	"time"
+)
+
+const testMethod = "/cluster_api.Conductor/UpdateServiceStatus"
+
+// Sign a token expiring after the given lifetime.
+func testToken(id int, lifetime time.Duration) string {
+	claims := jwt.StandardClaims{Id: fmt.Sprintf("%d", id), ExpiresAt: time.Now().Add(lifetime).Unix()}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte("secret"))
+	gomega.Expect(err).ShouldNot(gomega.HaveOccurred())
+	return token
+}
+
+// Authenticator returning a new token with the given lifetime on every login.
+type testAuthenticator struct {
+	sync.Mutex
+	lifetime time.Duration
+	logins   int
+	fail     bool
+}
+
+func (a *testAuthenticator) authenticate() (*Credentials, derrors.Error) {
+	a.Lock()
+	defer a.Unlock()
+	if a.fail {
+		return nil, derrors.NewUnavailableError("login service not available")
+	}
+	a.logins++
+	return NewCredentials(DefaultPath, testToken(a.logins, a.lifetime), ""), nil
+}
+
+func (a *testAuthenticator) numLogins() int {
+	a.Lock()
+	defer a.Unlock()
+	return a.logins
+}
+
+func newTestLogin(authenticator *testAuthenticator) *LoginHelper {
+	helper := NewLogin("localhost", 8000, false, "user@nalej.com", "password", "", "", false)
+	helper.authenticate = authenticator.authenticate
+	return helper
+}
+
+// Invoker recording the token of every call and rejecting the first calls as unauthenticated.
+type testInvoker struct {
+	tokens   []string
+	rejected int
+	err      error
+}
+
+func (i *testInvoker) invoke(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn,
+	opts ...grpc.CallOption) error {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	i.tokens = append(i.tokens, md.Get(AuthHeader)...)
+	if i.rejected > 0 {
+		i.rejected--
+		return grpc_status.Error(codes.Unauthenticated, "token expired")
+	}
+	return i.err
+}
+
+var _ = ginkgo.Describe("Login helper", func() {
+
+	ginkgo.Context("token refresh", func() {
+		ginkgo.It("should get the expiration of the token", func() {
+			expiration := tokenExpiration(testToken(1, time.Hour))
+			gomega.Expect(expiration).Should(gomega.BeTemporally("~", time.Now().Add(time.Hour), time.Second))
+			gomega.Expect(tokenExpiration("not a token").IsZero()).To(gomega.BeTrue())
+		})
+
+		ginkgo.It("should refresh the token before it expires", func() {
+			authenticator := &testAuthenticator{lifetime: time.Hour}
+			helper := newTestLogin(authenticator)
+			gomega.Expect(helper.Login()).To(gomega.Succeed())
+			refresh, found := helper.refreshTime()
+			gomega.Expect(found).To(gomega.BeTrue())
+			gomega.Expect(refresh).Should(gomega.BeTemporally("~", time.Now().Add(time.Hour-RefreshMargin), time.Second))
+		})
+
+		ginkgo.It("should refresh short-lived tokens in the middle of their lifetime", func() {
+			authenticator := &testAuthenticator{lifetime: time.Minute}
+			helper := newTestLogin(authenticator)
+			gomega.Expect(helper.Login()).To(gomega.Succeed())
+			refresh, found := helper.refreshTime()
+			gomega.Expect(found).To(gomega.BeTrue())
+			gomega.Expect(refresh).Should(gomega.BeTemporally("~", time.Now().Add(30*time.Second), time.Second))
+		})
+
+		ginkgo.It("should login again when the token is about to expire", func() {
+			authenticator := &testAuthenticator{lifetime: 2 * time.Second}
+			helper := newTestLogin(authenticator)
+			gomega.Expect(helper.Login()).To(gomega.Succeed())
+			token := helper.token()
+			go helper.Run()
+			gomega.Eventually(authenticator.numLogins, 3*time.Second).Should(gomega.BeNumerically(">=", 2))
+			helper.Stop()
+			gomega.Expect(helper.token()).ShouldNot(gomega.Equal(token))
+			gomega.Expect(helper.Status()).To(gomega.Succeed())
+		})
+
+		ginkgo.It("should keep the current credentials if the login fails", func() {
+			authenticator := &testAuthenticator{lifetime: time.Hour}
+			helper := newTestLogin(authenticator)
+			gomega.Expect(helper.Login()).To(gomega.Succeed())
+			token := helper.token()
+			authenticator.fail = true
+			gomega.Expect(helper.Login()).ShouldNot(gomega.Succeed())
+			gomega.Expect(helper.token()).Should(gomega.Equal(token))
+		})
+	})
+
+	ginkgo.Context("client interceptor", func() {
+		var authenticator *testAuthenticator
+		var helper *LoginHelper
+
+		ginkgo.BeforeEach(func() {
+			authenticator = &testAuthenticator{lifetime: time.Hour}
+			helper = newTestLogin(authenticator)
+			gomega.Expect(helper.Login()).To(gomega.Succeed())
+		})
+
+		ginkgo.It("should send the calls with the current token", func() {
+			invoker := &testInvoker{}
+			ctx := metadata.AppendToOutgoingContext(context.Background(), "request-id", "1")
+			interceptor := helper.UnaryClientInterceptor()
+			err := interceptor(ctx, testMethod, nil, nil, nil, func(ctx context.Context, method string,
+				req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				md, _ := metadata.FromOutgoingContext(ctx)
+				gomega.Expect(md.Get("request-id")).Should(gomega.Equal([]string{"1"}))
+				return invoker.invoke(ctx, method, req, reply, cc, opts...)
+			})
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(invoker.tokens).Should(gomega.Equal([]string{helper.token()}))
+		})
+
+		ginkgo.It("should login again and retry the unauthenticated calls", func() {
+			token := helper.token()
+			invoker := &testInvoker{rejected: 1}
+			err := helper.UnaryClientInterceptor()(context.Background(), testMethod, nil, nil, nil, invoker.invoke)
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(authenticator.numLogins()).Should(gomega.Equal(2))
+			gomega.Expect(invoker.tokens).Should(gomega.Equal([]string{token, helper.token()}))
+		})
+
+		ginkgo.It("should retry the unauthenticated calls only once", func() {
+			invoker := &testInvoker{rejected: 2}
+			err := helper.UnaryClientInterceptor()(context.Background(), testMethod, nil, nil, nil, invoker.invoke)
+			gomega.Expect(grpc_status.Code(err)).Should(gomega.Equal(codes.Unauthenticated))
+			gomega.Expect(invoker.tokens).Should(gomega.HaveLen(2))
+		})
+
+		ginkgo.It("should not retry the calls failing for other reasons", func() {
+			invoker := &testInvoker{err: grpc_status.Error(codes.Unavailable, "conductor not available")}
+			err := helper.UnaryClientInterceptor()(context.Background(), testMethod, nil, nil, nil, invoker.invoke)
+			gomega.Expect(grpc_status.Code(err)).Should(gomega.Equal(codes.Unavailable))
+			gomega.Expect(authenticator.numLogins()).Should(gomega.Equal(1))
+			gomega.Expect(invoker.tokens).Should(gomega.HaveLen(1))
+		})
+
+		ginkgo.It("should retry the unauthenticated calls with the deadline of the caller", func() {
+			invoker := &testInvoker{rejected: 1}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			expected, _ := ctx.Deadline()
+			deadlines := make([]time.Time, 0)
+			err := helper.UnaryClientInterceptor()(ctx, testMethod, nil, nil, nil, func(ctx context.Context, method string,
+				req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				deadline, _ := ctx.Deadline()
+				deadlines = append(deadlines, deadline)
+				return invoker.invoke(ctx, method, req, reply, cc, opts...)
+			})
+			gomega.Expect(err).To(gomega.Succeed())
+			gomega.Expect(deadlines).Should(gomega.Equal([]time.Time{expected, expected}))
+		})
+
+		ginkgo.It("should not retry the calls cancelled while logging in again", func() {
+			invoker := &testInvoker{rejected: 1}
+			ctx, cancel := context.WithCancel(context.Background())
+			err := helper.UnaryClientInterceptor()(ctx, testMethod, nil, nil, nil, func(ctx context.Context, method string,
+				req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				cancel()
+				return invoker.invoke(ctx, method, req, reply, cc, opts...)
+			})
+			gomega.Expect(grpc_status.Code(err)).Should(gomega.Equal(codes.Canceled))
+			gomega.Expect(invoker.tokens).Should(gomega.HaveLen(1))
+		})
+
+		ginkgo.It("should not retry the calls whose deadline passed while logging in again", func() {
+			invoker := &testInvoker{rejected: 1}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			err := helper.UnaryClientInterceptor()(ctx, testMethod, nil, nil, nil, func(ctx context.Context, method string,
+				req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				time.Sleep(20 * time.Millisecond)
+				return invoker.invoke(ctx, method, req, reply, cc, opts...)
+			})
+			gomega.Expect(grpc_status.Code(err)).Should(gomega.Equal(codes.DeadlineExceeded))
+			gomega.Expect(invoker.tokens).Should(gomega.HaveLen(1))
+		})
+
+		ginkgo.It("should not login again if another call already did", func() {
+			rejected := helper.token()
+			gomega.Expect(helper.Login()).To(gomega.Succeed())
+			gomega.Expect(helper.reauthenticate(rejected)).To(gomega.Succeed())
+			gomega.Expect(authenticator.numLogins()).Should(gomega.Equal(2))
+		})
+	})
+})
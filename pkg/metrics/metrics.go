@@ -40,6 +40,8 @@ const (
 	MetricDeleted MetricCounter = "deleted"
 	MetricErrors  MetricCounter = "errors"
 	MetricRunning MetricCounter = "running"
+	// Entries of the fragments removed from the cluster still retained
+	MetricRetained MetricCounter = "retained"
 )
 
 func (m MetricCounter) String() string {
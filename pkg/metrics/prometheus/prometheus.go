@@ -104,6 +104,23 @@ func (p *MetricsProvider) GetMetrics(types ...metrics.MetricType) (metrics.Metri
 	return parseMetrics(pMetrics)
 }
 
+// Register a gauge whose value is read on every collection.
+//  params:
+//   t metric the gauge belongs to
+//   name of the gauge
+//   help description of the gauge
+//   value function returning the current value
+//  return:
+//   error if the gauge cannot be registered
+func (p *MetricsProvider) AddGaugeFunc(t metrics.MetricType, name string, help string, value func() float64) derrors.Error {
+	gauge := prometheus.NewGaugeFunc(prometheus.GaugeOpts{Subsystem: string(t), Name: name, Help: help}, value)
+	err := p.registry.Register(gauge)
+	if err != nil {
+		return derrors.NewAlreadyExistsError("gauge already registered", err).WithParams(string(t), name)
+	}
+	return nil
+}
+
 // As we implement Collector, we can just return ourselves
 func (p *MetricsProvider) GetCollector() metrics.Collector {
 	return p
@@ -0,0 +1,169 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package monitor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/deployment-manager/internal/structures/monitor"
+	"github.com/nalej/derrors"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Time between the checks of the janitor
+	JanitorCheckTime = 30 * time.Second
+)
+
+// Platform where the objects of the fragments are deployed.
+type FragmentChecker interface {
+	// Check if any object of a fragment remains in the platform.
+	FragmentExists(namespace string, fragmentId string) (bool, derrors.Error)
+}
+
+// The janitor removes the monitored entries of the fragments that are no longer in the platform. Once the removal
+// of a fragment is confirmed, the fragment is set as terminated so conductor receives a final notification and
+// its entry is retained to report its status for some time.
+type Janitor struct {
+	// Structure containing monitored entries
+	monitored monitor.MonitoredInstances
+	// Platform checker
+	checker FragmentChecker
+	// Time the entries of the terminated fragments are retained
+	retention time.Duration
+	// Time the fragments were confirmed as terminated
+	// fragment id -> time
+	terminated map[string]time.Time
+	// Number of entries of fragments being removed or removed
+	retained int
+	// Mutex for the number of retained entries
+	mu sync.RWMutex
+	// Channel closed to stop the janitor
+	stop chan struct{}
+	// Channel closed when the janitor is stopped
+	done chan struct{}
+}
+
+// Create a new janitor.
+//  params:
+//   monitored entries to be removed
+//   checker of the fragments in the platform
+//   retention time the entries of the terminated fragments are retained
+//  return:
+//   janitor
+func NewJanitor(monitored monitor.MonitoredInstances, checker FragmentChecker, retention time.Duration) *Janitor {
+	return &Janitor{
+		monitored:  monitored,
+		checker:    checker,
+		retention:  retention,
+		terminated: make(map[string]time.Time, 0),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// Periodically remove the entries of the terminated fragments.
+func (j *Janitor) Run() {
+	log.Info().Str("retention", j.retention.String()).Msg("Start janitor...")
+	defer close(j.done)
+	tick := time.NewTicker(JanitorCheckTime)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			j.Clean()
+		case <-j.stop:
+			log.Info().Msg("janitor stopped")
+			return
+		}
+	}
+}
+
+// Stop the janitor. It returns once the janitor is stopped.
+func (j *Janitor) Stop() {
+	close(j.stop)
+	<-j.done
+}
+
+// Get the number of entries of fragments being removed or already removed that are retained.
+func (j *Janitor) GetNumRetained() int {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	return j.retained
+}
+
+// Check the fragments being removed. The ones no longer in the platform are set as terminated and the
+// terminated ones are removed once they have been notified and their retention expires.
+func (j *Janitor) Clean() {
+	now := time.Now()
+	retained := 0
+	monitored := make(map[string]bool, 0)
+	for _, entry := range j.monitored.ListEntries() {
+		if !entities.IsFragmentRemoved(entry.Status) {
+			continue
+		}
+		monitored[entry.FragmentId] = true
+		retained++
+
+		if entry.Status != entities.FRAGMENT_TERMINATED {
+			exists, err := j.checker.FragmentExists(entry.Namespace, entry.FragmentId)
+			if err != nil {
+				log.Warn().Str("fragmentId", entry.FragmentId).Str("namespace", entry.Namespace).
+					Str("err", err.DebugReport()).Msg("impossible to check if fragment was removed")
+				continue
+			}
+			if !exists {
+				log.Info().Str("fragmentId", entry.FragmentId).Str("namespace", entry.Namespace).
+					Msg("fragment removed from the platform")
+				// conductor is notified again with the final status of the fragment
+				j.monitored.SetEntryStatus(entry.FragmentId, entities.FRAGMENT_TERMINATED, nil)
+				j.terminated[entry.FragmentId] = now
+			}
+			continue
+		}
+
+		terminatedAt, found := j.terminated[entry.FragmentId]
+		if !found {
+			terminatedAt = now
+			j.terminated[entry.FragmentId] = now
+		}
+		// the entry is kept until its final notification is sent
+		if entry.NewStatus || now.Sub(terminatedAt) < j.retention {
+			continue
+		}
+		log.Info().Str("fragmentId", entry.FragmentId).Str("appInstanceId", entry.AppInstanceId).
+			Msg("remove terminated fragment from the monitored entries")
+		// the fragment may have been deployed again since the entries were listed
+		if !j.monitored.RemoveTerminatedEntry(entry.FragmentId) {
+			log.Info().Str("fragmentId", entry.FragmentId).Msg("fragment deployed again, entry kept")
+		}
+		delete(j.terminated, entry.FragmentId)
+		retained--
+	}
+	// forget the fragments removed or deployed again
+	for fragmentId := range j.terminated {
+		if !monitored[fragmentId] {
+			delete(j.terminated, fragmentId)
+		}
+	}
+
+	j.mu.Lock()
+	j.retained = retained
+	j.mu.Unlock()
+}
@@ -0,0 +1,144 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package monitor
+
+import (
+	"time"
+
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/deployment-manager/internal/structures/monitor"
+	"github.com/nalej/derrors"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+// Platform where the existing fragments are set by the tests
+type testFragmentChecker struct {
+	existing map[string]bool
+}
+
+func (c *testFragmentChecker) FragmentExists(namespace string, fragmentId string) (bool, derrors.Error) {
+	return c.existing[fragmentId], nil
+}
+
+// Set as notified all the pending notifications.
+func resetNotifiedStatus(monitored monitor.MonitoredInstances) {
+	for _, notified := range monitored.GetPendingNotifications() {
+		monitored.ResetNotifiedStatus(notified)
+	}
+}
+
+// Monitored instances where the terminated fragments are deployed again right before being removed
+type redeployingMonitoredInstances struct {
+	monitor.MonitoredInstances
+}
+
+func (m *redeployingMonitoredInstances) RemoveTerminatedEntry(fragmentId string) bool {
+	entry := m.GetEntry(fragmentId)
+	m.AddEntry(janitorEntry(fragmentId, entry.AppInstanceId))
+	return m.MonitoredInstances.RemoveTerminatedEntry(fragmentId)
+}
+
+func janitorEntry(fragmentId string, appInstanceId string) *entities.MonitoredAppEntry {
+	return &entities.MonitoredAppEntry{
+		OrganizationId: "org",
+		AppInstanceId:  appInstanceId,
+		FragmentId:     fragmentId,
+		Namespace:      "ns-" + appInstanceId,
+		Status:         entities.FRAGMENT_DONE,
+		Services: map[string]*entities.MonitoredServiceEntry{
+			"service": {
+				OrganizationId:    "org",
+				AppInstanceId:     appInstanceId,
+				FragmentId:        fragmentId,
+				ServiceInstanceID: "service",
+				Status:            entities.NALEJ_SERVICE_RUNNING,
+				Resources:         make(map[string]*entities.MonitoredPlatformResource, 0),
+			},
+		},
+	}
+}
+
+var _ = ginkgo.Describe("janitor", func() {
+
+	var monitored monitor.MonitoredInstances
+	var checker *testFragmentChecker
+
+	ginkgo.BeforeEach(func() {
+		monitored = monitor.NewMemoryMonitoredInstances()
+		monitored.AddEntry(janitorEntry("running", "app1"))
+		monitored.AddEntry(janitorEntry("removed", "app2"))
+		monitored.SetAppStatus("app2", entities.FRAGMENT_TERMINATING, nil)
+		resetNotifiedStatus(monitored)
+		checker = &testFragmentChecker{existing: map[string]bool{"running": true, "removed": true}}
+	})
+
+	ginkgo.It("should keep the fragments while they remain in the platform", func() {
+		janitor := NewJanitor(monitored, checker, 0)
+		janitor.Clean()
+		gomega.Expect(monitored.GetNumFragments()).To(gomega.Equal(2))
+		gomega.Expect(monitored.GetEntry("removed").Status).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_TERMINATING)))
+		gomega.Expect(janitor.GetNumRetained()).To(gomega.Equal(1))
+	})
+
+	ginkgo.It("should notify the terminated fragments before removing them", func() {
+		janitor := NewJanitor(monitored, checker, 0)
+		delete(checker.existing, "removed")
+		janitor.Clean()
+
+		entry := monitored.GetEntry("removed")
+		gomega.Expect(entry.Status).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_TERMINATED)))
+		pending := monitored.GetPendingNotifications()
+		gomega.Expect(pending).To(gomega.HaveLen(1))
+		gomega.Expect(pending[0].FragmentId).To(gomega.Equal("removed"))
+		gomega.Expect(pending[0].Services).To(gomega.HaveKey("service"))
+
+		// not removed until notified
+		janitor.Clean()
+		gomega.Expect(monitored.GetEntry("removed")).NotTo(gomega.BeNil())
+		resetNotifiedStatus(monitored)
+		janitor.Clean()
+		gomega.Expect(monitored.GetEntry("removed")).To(gomega.BeNil())
+		gomega.Expect(monitored.GetEntry("running")).NotTo(gomega.BeNil())
+		gomega.Expect(janitor.GetNumRetained()).To(gomega.Equal(0))
+	})
+
+	ginkgo.It("should retain the terminated fragments", func() {
+		janitor := NewJanitor(monitored, checker, time.Hour)
+		delete(checker.existing, "removed")
+		janitor.Clean()
+		resetNotifiedStatus(monitored)
+		janitor.Clean()
+		gomega.Expect(monitored.GetEntry("removed")).NotTo(gomega.BeNil())
+		gomega.Expect(janitor.GetNumRetained()).To(gomega.Equal(1))
+		status, err := monitored.GetAppStatus("app2")
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(*status).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_TERMINATED)))
+	})
+
+	ginkgo.It("should keep the fragments deployed again before their removal", func() {
+		janitor := NewJanitor(&redeployingMonitoredInstances{MonitoredInstances: monitored}, checker, 0)
+		delete(checker.existing, "removed")
+		janitor.Clean()
+		resetNotifiedStatus(monitored)
+		janitor.Clean()
+		entry := monitored.GetEntry("removed")
+		gomega.Expect(entry).NotTo(gomega.BeNil())
+		gomega.Expect(entry.Status).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_DONE)))
+	})
+})
+
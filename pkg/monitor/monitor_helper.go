@@ -19,6 +19,7 @@
 package monitor
 
 import (
+	"context"
 	"github.com/nalej/deployment-manager/internal/entities"
 	"github.com/nalej/deployment-manager/internal/structures/monitor"
 	"github.com/nalej/deployment-manager/pkg/config"
@@ -29,8 +30,6 @@ import (
 	pbConductor "github.com/nalej/grpc-conductor-go"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	grpc_status "google.golang.org/grpc/status"
 	"time"
 )
 
@@ -40,68 +39,97 @@ const (
 )
 
 type MonitorHelper struct {
-	// Client
+	// Client authenticated by the interceptor of the connection
 	Client grpc_cluster_api_go.ConductorClient
-	// LoginHelper Helper
-	ClusterAPILoginHelper *login_helper.LoginHelper
 	// Structure containing monitored entries
 	Monitored monitor.MonitoredInstances
+	// Notifications waiting to be acknowledged by conductor
+	outbox *NotificationOutbox
+	// Time between notifications of every service of the monitored fragments
+	resyncPeriod time.Duration
+	// Time of the last notification of every service
+	lastResync time.Time
+	// Channel closed to stop the helper
+	stop chan struct{}
+	// Channel closed when the helper is stopped
+	done chan struct{}
 }
 
-func NewMonitorHelper(conn *grpc.ClientConn, loginHelper *login_helper.LoginHelper,
-	monitored monitor.MonitoredInstances) executor.Monitor {
+func NewMonitorHelper(conn *grpc.ClientConn, monitored monitor.MonitoredInstances,
+	resyncPeriod time.Duration) executor.Monitor {
 	client := grpc_cluster_api_go.NewConductorClient(conn)
-	return &MonitorHelper{Client: client, ClusterAPILoginHelper: loginHelper, Monitored: monitored}
+	helper := &MonitorHelper{Client: client, Monitored: monitored, resyncPeriod: resyncPeriod, stop: make(chan struct{}), done: make(chan struct{})}
+	helper.outbox = NewNotificationOutbox(helper, monitored, NotificationInitialBackoff, NotificationMaxBackoff)
+	return helper
 }
 
 // This function periodically informs conductor about the status of deployed and on deployment services.
 func (m *MonitorHelper) Run() {
 	log.Info().Msg("Start monitor helper...")
-	tick := time.Tick(time.Second * CheckSleepTime)
+	defer close(m.done)
+	tick := time.NewTicker(time.Second * CheckSleepTime)
+	defer tick.Stop()
+	// conductor may have missed notifications while this helper was not running
+	m.resync()
+	m.UpdateStatus()
 	for {
 		select {
-		case <-tick:
+		case <-tick.C:
+			if time.Since(m.lastResync) >= m.resyncPeriod {
+				m.resync()
+			}
+			m.UpdateStatus()
+		case <-m.stop:
+			// flush the notifications pending before stopping
 			m.UpdateStatus()
+			log.Info().Msg("monitor helper stopped")
+			return
 		}
 	}
 }
 
-func (m *MonitorHelper) sendFragmentStatus(req pbConductor.DeploymentFragmentUpdateRequest) {
+// Stop the helper once the pending notifications are sent.
+func (m *MonitorHelper) Stop() {
+	close(m.stop)
+	<-m.done
+}
+
+func (m *MonitorHelper) sendFragmentStatus(req pbConductor.DeploymentFragmentUpdateRequest) error {
 	log.Debug().Str("status", req.Status.String()).Str("fragmentId", req.FragmentId).
 		Str("deploymentId", req.DeploymentId).Str("organizationId", req.OrganizationId).
 		Msg("send update fragment status")
 
-	ctx, cancel := m.ClusterAPILoginHelper.GetContext()
+	ctx, cancel := context.WithTimeout(context.Background(), login_helper.DefaultTimeout)
 	defer cancel()
 
 	_, err := m.Client.UpdateDeploymentFragmentStatus(ctx, &req)
-	if err != nil {
-		st := grpc_status.Convert(err).Code()
-		if st == codes.Unauthenticated {
-			errLogin := m.ClusterAPILoginHelper.RerunAuthentication()
-			if errLogin != nil {
-				log.Error().Err(errLogin).Msg("error during reauthentication")
-			}
-			ctx2, cancel2 := m.ClusterAPILoginHelper.GetContext()
-			defer cancel2()
-			_, err = m.Client.UpdateDeploymentFragmentStatus(ctx2, &req)
-		} else {
-			log.Error().Err(err).Msgf("error updating service status when sending fragment status")
-		}
-	}
-
 	if err != nil {
 		log.Error().Err(err).Msg("error updating fragment status")
+		return err
 	}
 	log.Debug().Str("status", req.Status.String()).Str("fragmentId", req.FragmentId).
 		Str("deploymentId", req.DeploymentId).Str("organizationId", req.OrganizationId).
 		Msg("send fragment update done")
+	return nil
+}
+
+// Add to the outbox a notification with every service of every monitored fragment so conductor can recover from
+// any gap in the notifications.
+func (m *MonitorHelper) resync() {
+	entries := m.Monitored.ListEntries()
+	log.Debug().Int("fragments", len(entries)).Msg("full resync of the monitored fragments")
+	for _, entry := range entries {
+		m.outbox.Add(entry, true)
+	}
+	m.lastResync = time.Now()
 }
 
+// The pending notifications are added to the outbox and the ones not failed recently are sent. The notified flags
+// are only reset once conductor acknowledges the notification.
 func (m *MonitorHelper) UpdateStatus() {
 
 	notificationPending := m.Monitored.GetPendingNotifications()
-	if len(notificationPending) == 0 {
+	if len(notificationPending) == 0 && m.outbox.Len() == 0 {
 		// nothing to do
 		return
 	}
@@ -110,80 +138,90 @@ func (m *MonitorHelper) UpdateStatus() {
 		m.Monitored.GetNumServices(), m.Monitored.GetNumResources())
 
 	log.Debug().Int("pendingNotifications", len(notificationPending)).Msg("there are pending notifications")
-	clusterId := config.GetConfig().ClusterId
-	// notify fragments. This is equivalent to notify an entry
 	for _, entry := range notificationPending {
-		list := make([]*pbConductor.ServiceUpdate, 0)
-		for _, serv := range entry.Services {
+		m.outbox.Add(entry, false)
+	}
+	m.outbox.Flush()
+}
 
-			endpoints := make([]*pbApplication.EndpointInstance, len(serv.Endpoints))
-			for i, e := range serv.Endpoints {
-				endpoints[i] = e.ToGRPC()
-			}
+// Send the status of the services of a fragment followed by the status of the fragment. This is equivalent to
+// notify an entry. Both updates share the sequence number assigned by the outbox to the notification.
+//  params:
+//   entry fragment with the services to be notified
+//   full true if the entry contains every service of the fragment
+//   sequence number of the notification
+//  return:
+//   error if any of the updates is not acknowledged
+func (m *MonitorHelper) SendNotification(entry *entities.MonitoredAppEntry, full bool, sequence uint64) error {
+	clusterId := config.GetConfig().ClusterId
+	list := make([]*pbConductor.ServiceUpdate, 0)
+	for _, serv := range entry.Services {
 
-			x := &pbConductor.ServiceUpdate{
-				ApplicationId:          serv.AppDescriptorId,
-				ApplicationInstanceId:  serv.AppInstanceId,
-				ServiceGroupId:         serv.ServiceGroupId,
-				ServiceGroupInstanceId: serv.ServiceGroupInstanceId,
-				ServiceId:              serv.ServiceID,
-				ServiceName:            serv.ServiceName,
-				ServiceInstanceId:      serv.ServiceInstanceID,
-				OrganizationId:         serv.OrganizationId,
-				Status:                 entities.ServiceStatusToGRPC[serv.Status],
-				ClusterId:              clusterId,
-				Endpoints:              endpoints,
-				Info:                   serv.Info,
-			}
-			list = append(list, x)
+		endpoints := make([]*pbApplication.EndpointInstance, len(serv.Endpoints))
+		for i, e := range serv.Endpoints {
+			endpoints[i] = e.ToGRPC()
 		}
 
+		x := &pbConductor.ServiceUpdate{
+			ApplicationId:          serv.AppDescriptorId,
+			ApplicationInstanceId:  serv.AppInstanceId,
+			ServiceGroupId:         serv.ServiceGroupId,
+			ServiceGroupInstanceId: serv.ServiceGroupInstanceId,
+			ServiceId:              serv.ServiceID,
+			ServiceName:            serv.ServiceName,
+			ServiceInstanceId:      serv.ServiceInstanceID,
+			OrganizationId:         serv.OrganizationId,
+			Status:                 entities.ServiceStatusToGRPC[serv.Status],
+			ClusterId:              clusterId,
+			Endpoints:              endpoints,
+			Info:                   serv.Info,
+		}
+		list = append(list, x)
+	}
+
+	// the services are only sent if any of them changed or in a full resync
+	if len(list) > 0 || full {
 		req := pbConductor.DeploymentServiceUpdateRequest{
 			OrganizationId: entry.OrganizationId,
 			FragmentId:     entry.FragmentId,
 			ClusterId:      clusterId,
 			List:           list,
+			Sequence:       sequence,
+			FullResync:     full,
 		}
-		m.sendUpdateService(req)
-
-		// Set a request for the fragment
-		reqApp := pbConductor.DeploymentFragmentUpdateRequest{
-			OrganizationId: entry.OrganizationId,
-			DeploymentId:   entry.DeploymentId,
-			FragmentId:     entry.FragmentId,
-			Status:         entities.FragmentStatusToGRPC[entry.Status],
-			ClusterId:      clusterId,
-			AppInstanceId:  entry.AppInstanceId,
-			Info:           entry.Info,
+		if err := m.sendUpdateService(req); err != nil {
+			return err
 		}
-		m.sendFragmentStatus(reqApp)
 	}
-	m.Monitored.ResetServicesUnnotifiedStatus()
+
+	// Set a request for the fragment
+	reqApp := pbConductor.DeploymentFragmentUpdateRequest{
+		OrganizationId: entry.OrganizationId,
+		DeploymentId:   entry.DeploymentId,
+		FragmentId:     entry.FragmentId,
+		Status:         entities.FragmentStatusToGRPC[entry.Status],
+		ClusterId:      clusterId,
+		AppInstanceId:  entry.AppInstanceId,
+		Info:           entry.Info,
+		Sequence:       sequence,
+	}
+	return m.sendFragmentStatus(reqApp)
 }
 
-func (m *MonitorHelper) sendUpdateService(req pbConductor.DeploymentServiceUpdateRequest) {
+func (m *MonitorHelper) sendUpdateService(req pbConductor.DeploymentServiceUpdateRequest) error {
 	log.Debug().Str("fragmentId", req.FragmentId).
 		Str("organizationId", req.OrganizationId).
 		Msg("send update service status")
-	ctx, cancel := m.ClusterAPILoginHelper.GetContext()
+	ctx, cancel := context.WithTimeout(context.Background(), login_helper.DefaultTimeout)
 	defer cancel()
 
 	_, err := m.Client.UpdateServiceStatus(ctx, &req)
 	if err != nil {
-		st := grpc_status.Convert(err).Code()
-		if st == codes.Unauthenticated {
-			errLogin := m.ClusterAPILoginHelper.RerunAuthentication()
-			if errLogin != nil {
-				log.Error().Err(errLogin).Msg("error during reauthentication")
-			}
-			ctx2, cancel2 := m.ClusterAPILoginHelper.GetContext()
-			defer cancel2()
-			_, err = m.Client.UpdateServiceStatus(ctx2, &req)
-		} else {
-			log.Error().Err(err).Msgf("error updating service status when sending update service")
-		}
+		log.Error().Err(err).Msg("error updating service status")
+		return err
 	}
 	log.Debug().Str("fragmentId", req.FragmentId).
 		Str("organizationId", req.OrganizationId).
 		Msg("send update service status done")
+	return nil
 }
@@ -0,0 +1,194 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package monitor
+
+import (
+	"time"
+
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/deployment-manager/internal/structures/monitor"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	grpc_status "google.golang.org/grpc/status"
+)
+
+const (
+	// Time to wait after the first failed attempt to deliver a notification
+	NotificationInitialBackoff = time.Second * CheckSleepTime
+	// Maximum time to wait between attempts to deliver a notification
+	NotificationMaxBackoff = 2 * time.Minute
+)
+
+// Sender of the notifications to conductor.
+type NotificationSender interface {
+	// Send the status of the services and the status of a fragment.
+	// params:
+	//  entry fragment with the services to be notified
+	//  full true if the entry contains every service of the fragment
+	//  sequence number of the notification, the same in every attempt to deliver it
+	// return:
+	//  error if the notification was not acknowledged
+	SendNotification(entry *entities.MonitoredAppEntry, full bool, sequence uint64) error
+}
+
+// Notification waiting to be delivered
+type outboxEntry struct {
+	// latest status of the fragment and of the services pending to be notified
+	notification *entities.MonitoredAppEntry
+	// the notification contains every service of the fragment
+	full bool
+	// sequence number assigned when the notification was added, kept while it is retried
+	sequence uint64
+	// number of failed attempts to deliver the notification
+	attempts int
+	// time of the next attempt
+	nextAttempt time.Time
+}
+
+// The notification outbox keeps the notifications to conductor until they are acknowledged. The notifications of the
+// same fragment are coalesced so only the latest status of the fragment and of every service is sent, and there is
+// never more than one notification of a fragment being delivered. The failed notifications are retried with an
+// exponential backoff and the notified flags of the monitored instances are only reset once conductor acknowledges
+// them, so the notifications not delivered before a restart are built again from a persistent store.
+// The outbox is used by the monitor loop and it is not safe for concurrent use.
+type NotificationOutbox struct {
+	// Sender of the notifications
+	sender NotificationSender
+	// Structure containing monitored entries
+	monitored monitor.MonitoredInstances
+	// Time to wait after the first failed attempt
+	initialBackoff time.Duration
+	// Maximum time to wait between attempts
+	maxBackoff time.Duration
+	// Pending notifications
+	// fragment id -> entry
+	pending map[string]*outboxEntry
+	// Fragments with pending notifications in arrival order
+	order []string
+}
+
+// Create a new notification outbox.
+//  params:
+//   sender of the notifications
+//   monitored entries to be reset once notified
+//   initialBackoff time to wait after the first failed attempt to deliver a notification
+//   maxBackoff maximum time to wait between attempts
+//  return:
+//   notification outbox
+func NewNotificationOutbox(sender NotificationSender, monitored monitor.MonitoredInstances,
+	initialBackoff time.Duration, maxBackoff time.Duration) *NotificationOutbox {
+	return &NotificationOutbox{
+		sender:         sender,
+		monitored:      monitored,
+		initialBackoff: initialBackoff,
+		maxBackoff:     maxBackoff,
+		pending:        make(map[string]*outboxEntry, 0),
+		order:          make([]string, 0),
+	}
+}
+
+// Add a notification to the outbox. If a notification of the same fragment is already waiting, both are merged
+// keeping the latest status of the fragment and of every service. The notification takes the next sequence number
+// of the fragment, so the merged notification is never sent with the sequence of an older status.
+//  params:
+//   notification entry with the services to be notified
+//   full true if the notification contains every service of the fragment
+func (o *NotificationOutbox) Add(notification *entities.MonitoredAppEntry, full bool) {
+	sequence := o.nextSequence(notification)
+	current, found := o.pending[notification.FragmentId]
+	if !found {
+		o.pending[notification.FragmentId] = &outboxEntry{notification: notification, full: full, sequence: sequence}
+		o.order = append(o.order, notification.FragmentId)
+		return
+	}
+	merged := *notification
+	merged.Services = make(map[string]*entities.MonitoredServiceEntry, len(current.notification.Services)+len(notification.Services))
+	for id, serv := range current.notification.Services {
+		merged.Services[id] = serv
+	}
+	for id, serv := range notification.Services {
+		merged.Services[id] = serv
+	}
+	current.notification = &merged
+	current.full = current.full || full
+	current.sequence = sequence
+}
+
+// Sequence number of a new notification of a fragment.
+func (o *NotificationOutbox) nextSequence(notification *entities.MonitoredAppEntry) uint64 {
+	sequence, found := o.monitored.NextNotificationSequence(notification.FragmentId)
+	if !found {
+		// the fragment is no longer monitored, this is its last notification
+		sequence = notification.NotificationSequence + 1
+	}
+	return sequence
+}
+
+// Try to deliver the pending notifications in arrival order. The notifications failed recently are skipped until
+// their backoff expires and the delivery stops as soon as conductor is not reachable.
+func (o *NotificationOutbox) Flush() {
+	now := time.Now()
+	remaining := make([]string, 0, len(o.order))
+	unreachable := false
+	for _, fragmentId := range o.order {
+		entry := o.pending[fragmentId]
+		if unreachable || now.Before(entry.nextAttempt) {
+			remaining = append(remaining, fragmentId)
+			continue
+		}
+		err := o.sender.SendNotification(entry.notification, entry.full, entry.sequence)
+		if err == nil {
+			o.monitored.ResetNotifiedStatus(entry.notification)
+			delete(o.pending, fragmentId)
+			continue
+		}
+		code := grpc_status.Convert(err).Code()
+		if code == codes.InvalidArgument || code == codes.NotFound {
+			// conductor will never accept it, do not notify it again
+			log.Error().Err(err).Str("fragmentId", fragmentId).Msg("notification rejected by conductor, discarding it")
+			o.monitored.ResetNotifiedStatus(entry.notification)
+			delete(o.pending, fragmentId)
+			continue
+		}
+		entry.attempts++
+		entry.nextAttempt = now.Add(o.backoff(entry.attempts))
+		log.Warn().Err(err).Str("fragmentId", fragmentId).Int("attempts", entry.attempts).
+			Time("nextAttempt", entry.nextAttempt).Msg("impossible to deliver notification, it will be retried")
+		if code == codes.Unavailable || code == codes.DeadlineExceeded {
+			unreachable = true
+		}
+		remaining = append(remaining, fragmentId)
+	}
+	o.order = remaining
+}
+
+// Time to wait after a number of failed attempts.
+func (o *NotificationOutbox) backoff(attempts int) time.Duration {
+	backoff := o.initialBackoff
+	for i := 1; i < attempts && backoff < o.maxBackoff; i++ {
+		backoff = backoff * 2
+	}
+	if backoff > o.maxBackoff {
+		backoff = o.maxBackoff
+	}
+	return backoff
+}
+
+// Return the number of fragments with notifications waiting to be delivered.
+func (o *NotificationOutbox) Len() int {
+	return len(o.pending)
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package monitor
+
+import (
+	"time"
+
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/deployment-manager/internal/structures/monitor"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc/codes"
+	grpc_status "google.golang.org/grpc/status"
+)
+
+// Sender recording the delivered notifications and failing with the given errors
+type testNotificationSender struct {
+	sent      []*entities.MonitoredAppEntry
+	full      []bool
+	sequences []uint64
+	attempted []uint64
+	failures  map[string]error
+}
+
+func (s *testNotificationSender) SendNotification(entry *entities.MonitoredAppEntry, full bool, sequence uint64) error {
+	s.attempted = append(s.attempted, sequence)
+	if err := s.failures[entry.FragmentId]; err != nil {
+		return err
+	}
+	s.sequences = append(s.sequences, sequence)
+	s.sent = append(s.sent, entry)
+	s.full = append(s.full, full)
+	return nil
+}
+
+// Add the pending notifications to the outbox and deliver them as the monitor helper does.
+func updateStatus(monitored monitor.MonitoredInstances, outbox *NotificationOutbox) {
+	for _, entry := range monitored.GetPendingNotifications() {
+		outbox.Add(entry, false)
+	}
+	outbox.Flush()
+}
+
+var _ = ginkgo.Describe("notification outbox", func() {
+
+	var monitored monitor.MonitoredInstances
+	var sender *testNotificationSender
+	var outbox *NotificationOutbox
+
+	ginkgo.BeforeEach(func() {
+		monitored = monitor.NewMemoryMonitoredInstances()
+		monitored.AddEntry(janitorEntry("fragment-1", "app1"))
+		monitored.AddEntry(janitorEntry("fragment-2", "app2"))
+		monitored.SetEntryStatus("fragment-1", entities.FRAGMENT_DEPLOYING, nil)
+		monitored.SetEntryStatus("fragment-2", entities.FRAGMENT_DEPLOYING, nil)
+		sender = &testNotificationSender{failures: make(map[string]error, 0)}
+		outbox = NewNotificationOutbox(sender, monitored, 50*time.Millisecond, 100*time.Millisecond)
+	})
+
+	ginkgo.It("should reset the notified flags once the notifications are delivered", func() {
+		updateStatus(monitored, outbox)
+		gomega.Expect(sender.sent).To(gomega.HaveLen(2))
+		gomega.Expect(outbox.Len()).To(gomega.Equal(0))
+		gomega.Expect(monitored.GetPendingNotifications()).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should keep the failed notifications and retry them after a backoff", func() {
+		sender.failures["fragment-1"] = grpc_status.Error(codes.Internal, "failed")
+		updateStatus(monitored, outbox)
+		gomega.Expect(sender.sent).To(gomega.HaveLen(1))
+		gomega.Expect(outbox.Len()).To(gomega.Equal(1))
+		pending := monitored.GetPendingNotifications()
+		gomega.Expect(pending).To(gomega.HaveLen(1))
+		gomega.Expect(pending[0].FragmentId).To(gomega.Equal("fragment-1"))
+
+		// not retried until the backoff expires
+		delete(sender.failures, "fragment-1")
+		updateStatus(monitored, outbox)
+		gomega.Expect(sender.sent).To(gomega.HaveLen(1))
+		time.Sleep(60 * time.Millisecond)
+		updateStatus(monitored, outbox)
+		gomega.Expect(sender.sent).To(gomega.HaveLen(2))
+		gomega.Expect(outbox.Len()).To(gomega.Equal(0))
+		gomega.Expect(monitored.GetPendingNotifications()).To(gomega.BeEmpty())
+	})
+
+	ginkgo.It("should keep the sequence of a notification while it is retried", func() {
+		sender.failures["fragment-1"] = grpc_status.Error(codes.Internal, "failed")
+		outbox.Add(monitored.GetEntry("fragment-1").Copy(), false)
+		outbox.Flush()
+		time.Sleep(60 * time.Millisecond)
+		outbox.Flush()
+		delete(sender.failures, "fragment-1")
+		time.Sleep(110 * time.Millisecond)
+		outbox.Flush()
+		gomega.Expect(sender.attempted).To(gomega.Equal([]uint64{1, 1, 1}))
+		gomega.Expect(sender.sequences).To(gomega.Equal([]uint64{1}))
+	})
+
+	ginkgo.It("should assign a new sequence to the coalesced notifications", func() {
+		sender.failures["fragment-1"] = grpc_status.Error(codes.Internal, "failed")
+		outbox.Add(monitored.GetEntry("fragment-1").Copy(), false)
+		outbox.Flush()
+		monitored.SetEntryStatus("fragment-1", entities.FRAGMENT_ERROR, nil)
+		outbox.Add(monitored.GetEntry("fragment-1").Copy(), false)
+		delete(sender.failures, "fragment-1")
+		time.Sleep(60 * time.Millisecond)
+		outbox.Flush()
+		gomega.Expect(sender.attempted).To(gomega.Equal([]uint64{1, 2}))
+		gomega.Expect(sender.sent[0].Status).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_ERROR)))
+	})
+
+	ginkgo.It("should coalesce the notifications of the same fragment", func() {
+		sender.failures["fragment-1"] = grpc_status.Error(codes.Internal, "failed")
+		updateStatus(monitored, outbox)
+		monitored.SetEntryStatus("fragment-1", entities.FRAGMENT_ERROR, nil)
+		updateStatus(monitored, outbox)
+		gomega.Expect(outbox.Len()).To(gomega.Equal(1))
+
+		delete(sender.failures, "fragment-1")
+		time.Sleep(60 * time.Millisecond)
+		updateStatus(monitored, outbox)
+		gomega.Expect(sender.sent).To(gomega.HaveLen(2))
+		gomega.Expect(sender.sent[1].FragmentId).To(gomega.Equal("fragment-1"))
+		gomega.Expect(sender.sent[1].Status).To(gomega.Equal(entities.FragmentStatus(entities.FRAGMENT_ERROR)))
+	})
+
+	ginkgo.It("should keep the flags of the fragments changed while being delivered", func() {
+		notification := monitored.GetPendingNotifications()[0]
+		monitored.SetEntryStatus(notification.FragmentId, entities.FRAGMENT_ERROR, nil)
+		outbox.Add(notification, false)
+		outbox.Flush()
+		gomega.Expect(sender.sent).To(gomega.HaveLen(1))
+		pending := monitored.GetPendingNotifications()
+		gomega.Expect(pending).To(gomega.HaveLen(2))
+	})
+
+	ginkgo.It("should stop delivering while conductor is unreachable", func() {
+		sender.failures["fragment-1"] = grpc_status.Error(codes.Unavailable, "unreachable")
+		sender.failures["fragment-2"] = grpc_status.Error(codes.Unavailable, "unreachable")
+		outbox.Add(monitored.GetEntry("fragment-1").Copy(), true)
+		outbox.Add(monitored.GetEntry("fragment-2").Copy(), true)
+		outbox.Flush()
+		gomega.Expect(outbox.Len()).To(gomega.Equal(2))
+
+		// the fragment failed is retried after its backoff, the rest are delivered once conductor is reachable
+		sender.failures = make(map[string]error, 0)
+		outbox.Flush()
+		gomega.Expect(sender.sent).To(gomega.HaveLen(1))
+		gomega.Expect(sender.sent[0].FragmentId).To(gomega.Equal("fragment-2"))
+		time.Sleep(60 * time.Millisecond)
+		outbox.Flush()
+		gomega.Expect(sender.sent).To(gomega.HaveLen(2))
+		gomega.Expect(outbox.Len()).To(gomega.Equal(0))
+	})
+
+	ginkgo.It("should keep a full resync when coalescing it with the changes", func() {
+		sender.failures["fragment-1"] = grpc_status.Error(codes.Internal, "failed")
+		outbox.Add(monitored.GetEntry("fragment-1").Copy(), true)
+		outbox.Flush()
+		updateStatus(monitored, outbox)
+		delete(sender.failures, "fragment-1")
+		time.Sleep(60 * time.Millisecond)
+		outbox.Flush()
+		gomega.Expect(sender.sent).To(gomega.HaveLen(2))
+		gomega.Expect(sender.sent[1].FragmentId).To(gomega.Equal("fragment-1"))
+		gomega.Expect(sender.full).To(gomega.Equal([]bool{false, true}))
+	})
+
+	ginkgo.It("should discard the notifications rejected by conductor", func() {
+		sender.failures["fragment-1"] = grpc_status.Error(codes.InvalidArgument, "rejected")
+		updateStatus(monitored, outbox)
+		gomega.Expect(outbox.Len()).To(gomega.Equal(0))
+		gomega.Expect(monitored.GetPendingNotifications()).To(gomega.BeEmpty())
+	})
+})
@@ -17,6 +17,7 @@
 package network
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -29,28 +30,23 @@ import (
 	"github.com/nalej/grpc-zt-nalej-go"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	grpc_status "google.golang.org/grpc/status"
 	"k8s.io/client-go/kubernetes"
 )
 
 type Manager struct {
 	// ClusterAPI to send information back related to the network manager.
 	ClusterAPIClient grpc_cluster_api_go.NetworkManagerClient
-	// LoginHelper Helper
-	ClusterAPILoginHelper *login_helper.LoginHelper
 	// NetUpdater with the network updater for deployed pods.
 	NetUpdater NetworkUpdater
 }
 
-func NewManager(connection *grpc.ClientConn, helper *login_helper.LoginHelper, K8sClient *kubernetes.Clientset) *Manager {
+func NewManager(connection *grpc.ClientConn, K8sClient *kubernetes.Clientset) *Manager {
 	// Network & DNS client
 	clusterAPIClient := grpc_cluster_api_go.NewNetworkManagerClient(connection)
 	netUpdater := NewKubernetesNetworkUpdater(K8sClient)
 	return &Manager{
-		ClusterAPIClient:      clusterAPIClient,
-		ClusterAPILoginHelper: helper,
-		NetUpdater:            netUpdater,
+		ClusterAPIClient: clusterAPIClient,
+		NetUpdater:       netUpdater,
 	}
 }
 
@@ -66,26 +62,12 @@ func (m *Manager) AuthorizeNetworkMembership(organizationId string, appInstanceI
 		IsProxy:                      isProxy,
 	}
 
-	ctx, cancel := m.ClusterAPILoginHelper.GetContext()
+	ctx, cancel := context.WithTimeout(context.Background(), login_helper.DefaultTimeout)
 	defer cancel()
 	_, errAuth := m.ClusterAPIClient.AuthorizeMember(ctx, &req)
 
 	if errAuth != nil {
-		st := grpc_status.Convert(errAuth).Code()
-		if st == codes.Unauthenticated {
-			errLogin := m.ClusterAPILoginHelper.RerunAuthentication()
-			if errLogin != nil {
-				log.Error().Err(errLogin).Msg("error during reauthentication")
-			}
-			ctx2, cancel2 := m.ClusterAPILoginHelper.GetContext()
-			defer cancel2()
-			_, errAuth = m.ClusterAPIClient.AuthorizeMember(ctx2, &req)
-		} else {
-			log.Error().Err(errAuth).Msgf("error updating service status when authorizing network membership")
-		}
-	}
-
-	if errAuth != nil {
+		log.Error().Err(errAuth).Msgf("error updating service status when authorizing network membership")
 		return derrors.NewGenericError(errAuth.Error())
 	}
 
@@ -114,27 +96,13 @@ func (m *Manager) RegisterNetworkEntry(organizationId string, appInstanceId stri
 		},
 	}
 
-	ctx, cancel := m.ClusterAPILoginHelper.GetContext()
+	ctx, cancel := context.WithTimeout(context.Background(), login_helper.DefaultTimeout)
 	defer cancel()
 
 	_, err := m.ClusterAPIClient.AddDNSEntry(ctx, &req)
 
 	if err != nil {
-		st := grpc_status.Convert(err).Code()
-		if st == codes.Unauthenticated {
-			errLogin := m.ClusterAPILoginHelper.RerunAuthentication()
-			if errLogin != nil {
-				log.Error().Err(errLogin).Msg("error during reauthentication")
-			}
-			ctx2, cancel2 := m.ClusterAPILoginHelper.GetContext()
-			defer cancel2()
-			_, err = m.ClusterAPIClient.AddDNSEntry(ctx2, &req)
-		} else {
-			log.Error().Err(err).Msgf("error updating service status when registering network entry")
-		}
-	}
-
-	if err != nil {
+		log.Error().Err(err).Msgf("error updating service status when registering network entry")
 		return derrors.NewGenericError(err.Error())
 	}
 
@@ -225,27 +193,13 @@ func (m *Manager) LeaveZTNetwork(request *grpc_deployment_manager_go.LeaveZTNetw
 }
 
 func (m *Manager) AuthorizeZTConnection(request *pbNetwork.AuthorizeZTConnectionRequest) error {
-	ctx, cancel := m.ClusterAPILoginHelper.GetContext()
+	ctx, cancel := context.WithTimeout(context.Background(), login_helper.DefaultTimeout)
 	defer cancel()
 	_, errAuth := m.ClusterAPIClient.AuthorizeZTConnection(ctx, request)
 
 	if errAuth != nil {
-		st := grpc_status.Convert(errAuth).Code()
-		if st == codes.Unauthenticated {
-			errLogin := m.ClusterAPILoginHelper.RerunAuthentication()
-			if errLogin != nil {
-				log.Error().Err(errLogin).Msg("error during re-authentication")
-			}
-			ctx2, cancel2 := m.ClusterAPILoginHelper.GetContext()
-			defer cancel2()
-			_, errAuth = m.ClusterAPIClient.AuthorizeZTConnection(ctx2, request)
-			if errAuth != nil {
-				log.Error().Err(errAuth).Msg("error authorizing ZT-connection")
-				return errAuth
-			}
-		} else {
-			log.Error().Err(errAuth).Msgf("error authorizing ZT-Connection ")
-		}
+		log.Error().Err(errAuth).Msg("error authorizing ZT-connection")
+		return errAuth
 	}
 
 	return nil
@@ -17,14 +17,13 @@
 package proxy
 
 import (
+	"context"
 	"github.com/nalej/deployment-manager/pkg/login-helper"
 	"github.com/nalej/derrors"
 	"github.com/nalej/grpc-cluster-api-go"
 	"github.com/nalej/grpc-network-go"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	grpc_status "google.golang.org/grpc/status"
 )
 
 /*
@@ -33,39 +32,23 @@ import (
  */
 
 type Manager struct {
-	// Client
+	// Client authenticated by the interceptor of the connection
 	Client grpc_cluster_api_go.NetworkManagerClient
-	// LoginHelper Helper
-	ClusterAPILoginHelper *login_helper.LoginHelper
 }
 
-func NewManager(conn *grpc.ClientConn, clusterApiLoginHelper *login_helper.LoginHelper) *Manager {
+func NewManager(conn *grpc.ClientConn) *Manager {
 	client := grpc_cluster_api_go.NewNetworkManagerClient(conn)
-	return &Manager{Client: client, ClusterAPILoginHelper: clusterApiLoginHelper}
+	return &Manager{Client: client}
 }
 
 func (m *Manager) RegisterInboundServiceProxy(request *grpc_network_go.InboundServiceProxy) derrors.Error {
-	ctx, cancel := m.ClusterAPILoginHelper.GetContext()
+	ctx, cancel := context.WithTimeout(context.Background(), login_helper.DefaultTimeout)
 	defer cancel()
 
 	_, err := m.Client.RegisterInboundServiceProxy(ctx, request)
 
 	if err != nil {
-		st := grpc_status.Convert(err).Code()
-		if st == codes.Unauthenticated {
-			errLogin := m.ClusterAPILoginHelper.RerunAuthentication()
-			if errLogin != nil {
-				log.Error().Err(errLogin).Msg("error during reauthentication")
-			}
-			ctx2, cancel2 := m.ClusterAPILoginHelper.GetContext()
-			defer cancel2()
-			_, err = m.Client.RegisterInboundServiceProxy(ctx2, request)
-		} else {
-			log.Error().Err(err).Msgf("error updating service status when registering inbound service proxy")
-		}
-	}
-
-	if err != nil {
+		log.Error().Err(err).Msgf("error updating service status when registering inbound service proxy")
 		return derrors.NewGenericError(err.Error())
 	}
 
@@ -73,27 +56,13 @@ func (m *Manager) RegisterInboundServiceProxy(request *grpc_network_go.InboundSe
 }
 
 func (m *Manager) RegisterOutboundProxy(request *grpc_network_go.OutboundService) derrors.Error {
-	ctx, cancel := m.ClusterAPILoginHelper.GetContext()
+	ctx, cancel := context.WithTimeout(context.Background(), login_helper.DefaultTimeout)
 	defer cancel()
 
 	_, err := m.Client.RegisterOutboundProxy(ctx, request)
 
 	if err != nil {
-		st := grpc_status.Convert(err).Code()
-		if st == codes.Unauthenticated {
-			errLogin := m.ClusterAPILoginHelper.RerunAuthentication()
-			if errLogin != nil {
-				log.Error().Err(errLogin).Msg("error during reauthentication")
-			}
-			ctx2, cancel2 := m.ClusterAPILoginHelper.GetContext()
-			defer cancel2()
-			_, err = m.Client.RegisterOutboundProxy(ctx2, request)
-		} else {
-			log.Error().Err(err).Msgf("error updating service status when registering outbound proxy")
-		}
-	}
-
-	if err != nil {
+		log.Error().Err(err).Msgf("error updating service status when registering outbound proxy")
 		return derrors.NewGenericError(err.Error())
 	}
 
@@ -101,27 +70,13 @@ func (m *Manager) RegisterOutboundProxy(request *grpc_network_go.OutboundService
 }
 
 func (m *Manager) RegisterZTConnection(request *grpc_network_go.RegisterZTConnectionRequest) derrors.Error {
-	ctx, cancel := m.ClusterAPILoginHelper.GetContext()
+	ctx, cancel := context.WithTimeout(context.Background(), login_helper.DefaultTimeout)
 	defer cancel()
 
 	_, err := m.Client.RegisterZTConnection(ctx, request)
 
 	if err != nil {
-		st := grpc_status.Convert(err).Code()
-		if st == codes.Unauthenticated {
-			errLogin := m.ClusterAPILoginHelper.RerunAuthentication()
-			if errLogin != nil {
-				log.Error().Err(errLogin).Msg("error during reauthentication")
-			}
-			ctx2, cancel2 := m.ClusterAPILoginHelper.GetContext()
-			defer cancel2()
-			_, err = m.Client.RegisterZTConnection(ctx2, request)
-		} else {
-			log.Error().Err(err).Msgf("error updating service status when registering zt connection")
-		}
-	}
-
-	if err != nil {
+		log.Error().Err(err).Msgf("error updating service status when registering zt connection")
 		return derrors.NewGenericError(err.Error())
 	}
 
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package query
+
+import (
+	"context"
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/derrors"
+	pbApplication "github.com/nalej/grpc-application-go"
+	pbDeploymentMgr "github.com/nalej/grpc-deployment-manager-go"
+	"github.com/nalej/grpc-utils/pkg/conversions"
+	"github.com/rs/zerolog/log"
+)
+
+type Handler struct {
+	Manager *Manager
+}
+
+func NewHandler(manager *Manager) *Handler {
+	return &Handler{Manager: manager}
+}
+
+func (h *Handler) GetFragment(_ context.Context, request *pbDeploymentMgr.DeploymentFragmentId) (*pbDeploymentMgr.MonitoredFragment, error) {
+	log.Debug().Interface("request", request).Msg("get fragment request")
+	vErr := entities.ValidateDeploymentFragmentId(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	fragment, err := h.Manager.GetFragment(request)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return fragment, nil
+}
+
+func (h *Handler) ListFragments(_ context.Context, request *pbDeploymentMgr.ListFragmentsRequest) (*pbDeploymentMgr.MonitoredFragmentList, error) {
+	log.Debug().Interface("request", request).Msg("list fragments request")
+	vErr := entities.ValidateListFragmentsRequest(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	fragments, err := h.Manager.ListFragments(request)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return fragments, nil
+}
+
+func (h *Handler) GetAppStatus(_ context.Context, request *pbApplication.AppInstanceId) (*pbDeploymentMgr.AppStatus, error) {
+	log.Debug().Interface("request", request).Msg("get app status request")
+	vErr := entities.ValidateAppInstanceId(request)
+	if vErr != nil {
+		return nil, conversions.ToGRPCError(vErr)
+	}
+	status, err := h.Manager.GetAppStatus(request)
+	if err != nil {
+		return nil, conversions.ToGRPCError(err)
+	}
+	return status, nil
+}
+
+func (h *Handler) WatchStatus(request *pbDeploymentMgr.WatchStatusRequest, stream pbDeploymentMgr.DeploymentManagerQuery_WatchStatusServer) error {
+	log.Debug().Interface("request", request).Msg("watch status request")
+	vErr := entities.ValidateWatchStatusRequest(request)
+	if vErr != nil {
+		return conversions.ToGRPCError(vErr)
+	}
+	subscription, err := h.Manager.WatchStatus(request)
+	if err != nil {
+		return conversions.ToGRPCError(err)
+	}
+	defer subscription.Close()
+	for {
+		select {
+		case <-stream.Context().Done():
+			log.Debug().Str("organizationId", request.OrganizationId).Msg("status watcher disconnected")
+			return nil
+		case event, ok := <-subscription.Events:
+			if !ok {
+				if subscription.Overflowed() {
+					return conversions.ToGRPCError(derrors.NewResourceExhaustedError("watcher too slow, resume from the last received token"))
+				}
+				return nil
+			}
+			if sErr := stream.Send(event.ToGRPC()); sErr != nil {
+				log.Warn().Err(sErr).Str("organizationId", request.OrganizationId).Msg("error sending status event")
+				return sErr
+			}
+		}
+	}
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package query
+
+import (
+	"context"
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/deployment-manager/internal/structures/monitor"
+	pbApplication "github.com/nalej/grpc-application-go"
+	pbDeploymentMgr "github.com/nalej/grpc-deployment-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpc_status "google.golang.org/grpc/status"
+)
+
+// Stream recording the events sent to a watcher.
+type testWatchStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan *pbDeploymentMgr.StatusEvent
+}
+
+func (s *testWatchStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *testWatchStream) Send(event *pbDeploymentMgr.StatusEvent) error {
+	select {
+	case s.sent <- event:
+	case <-s.ctx.Done():
+	}
+	return nil
+}
+
+var _ = ginkgo.Describe("query handler", func() {
+
+	var handler *Handler
+
+	ginkgo.BeforeEach(func() {
+		handler = NewHandler(NewManager(testMonitored()))
+	})
+
+	ginkgo.It("should reject invalid requests", func() {
+		_, err := handler.GetFragment(context.Background(), &pbDeploymentMgr.DeploymentFragmentId{OrganizationId: "org"})
+		gomega.Expect(grpc_status.Code(err)).To(gomega.Equal(codes.InvalidArgument))
+		_, err = handler.ListFragments(context.Background(), &pbDeploymentMgr.ListFragmentsRequest{})
+		gomega.Expect(grpc_status.Code(err)).To(gomega.Equal(codes.InvalidArgument))
+		_, err = handler.GetAppStatus(context.Background(), &pbApplication.AppInstanceId{OrganizationId: "org"})
+		gomega.Expect(grpc_status.Code(err)).To(gomega.Equal(codes.InvalidArgument))
+		err = handler.WatchStatus(&pbDeploymentMgr.WatchStatusRequest{}, &testWatchStream{ctx: context.Background()})
+		gomega.Expect(grpc_status.Code(err)).To(gomega.Equal(codes.InvalidArgument))
+	})
+
+	ginkgo.It("should return not found for unknown fragments", func() {
+		_, err := handler.GetFragment(context.Background(), &pbDeploymentMgr.DeploymentFragmentId{OrganizationId: "org", FragmentId: "unknown"})
+		gomega.Expect(grpc_status.Code(err)).To(gomega.Equal(codes.NotFound))
+		_, err = handler.GetAppStatus(context.Background(), &pbApplication.AppInstanceId{OrganizationId: "org", AppInstanceId: "unknown"})
+		gomega.Expect(grpc_status.Code(err)).To(gomega.Equal(codes.NotFound))
+	})
+
+	ginkgo.It("should answer valid requests", func() {
+		fragment, err := handler.GetFragment(context.Background(), &pbDeploymentMgr.DeploymentFragmentId{OrganizationId: "org", FragmentId: "fragment1"})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(fragment.FragmentId).To(gomega.Equal("fragment1"))
+		list, err := handler.ListFragments(context.Background(), &pbDeploymentMgr.ListFragmentsRequest{OrganizationId: "org"})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(list.Fragments).To(gomega.HaveLen(3))
+		status, err := handler.GetAppStatus(context.Background(), &pbApplication.AppInstanceId{OrganizationId: "org", AppInstanceId: "app2"})
+		gomega.Expect(err).To(gomega.Succeed())
+		gomega.Expect(status.Fragments).To(gomega.HaveLen(1))
+	})
+
+	ginkgo.It("should stream the status transitions until the watcher disconnects", func() {
+		monitored := monitor.NewMemoryMonitoredInstances()
+		monitored.AddEntry(testEntry("org", "app1", "fragment1"))
+		handler = NewHandler(NewManager(monitored))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		stream := &testWatchStream{ctx: ctx, sent: make(chan *pbDeploymentMgr.StatusEvent, 10)}
+		finished := make(chan error, 1)
+		go func() {
+			finished <- handler.WatchStatus(&pbDeploymentMgr.WatchStatusRequest{OrganizationId: "org"}, stream)
+		}()
+
+		// the subscription starts in background, keep changing the status until an event is received
+		var event *pbDeploymentMgr.StatusEvent
+		gomega.Eventually(func() bool {
+			monitored.SetEntryStatus("fragment1", entities.FRAGMENT_DEPLOYING, nil)
+			monitored.SetEntryStatus("fragment1", entities.FRAGMENT_DONE, nil)
+			select {
+			case event = <-stream.sent:
+				return true
+			default:
+				return false
+			}
+		}).Should(gomega.BeTrue())
+		gomega.Expect(event.FragmentId).To(gomega.Equal("fragment1"))
+
+		cancel()
+		gomega.Eventually(finished).Should(gomega.Receive(gomega.BeNil()))
+	})
+})
@@ -0,0 +1,117 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package query
+
+import (
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/deployment-manager/internal/structures/monitor"
+	"github.com/nalej/derrors"
+	pbApplication "github.com/nalej/grpc-application-go"
+	pbConductor "github.com/nalej/grpc-conductor-go"
+	pbDeploymentMgr "github.com/nalej/grpc-deployment-manager-go"
+)
+
+// Manager answering read-only queries over the monitored instances.
+type Manager struct {
+	// Structure containing monitored entries
+	monitored monitor.MonitoredInstances
+}
+
+func NewManager(monitored monitor.MonitoredInstances) *Manager {
+	return &Manager{monitored: monitored}
+}
+
+// Get a monitored fragment.
+//  params:
+//   request identifier of the fragment
+//  return:
+//   the fragment or error if not found
+func (m *Manager) GetFragment(request *pbDeploymentMgr.DeploymentFragmentId) (*pbDeploymentMgr.MonitoredFragment, derrors.Error) {
+	for _, entry := range m.monitored.ListEntries() {
+		if entry.FragmentId == request.FragmentId && entry.OrganizationId == request.OrganizationId {
+			return entry.ToGRPC(), nil
+		}
+	}
+	return nil, derrors.NewNotFoundError("fragment not monitored").WithParams(request.FragmentId)
+}
+
+// List the monitored fragments of an organization.
+//  params:
+//   request with the organization and optional application instance and status filters
+//  return:
+//   list of matching fragments
+func (m *Manager) ListFragments(request *pbDeploymentMgr.ListFragmentsRequest) (*pbDeploymentMgr.MonitoredFragmentList, derrors.Error) {
+	statuses := make(map[pbConductor.DeploymentFragmentStatus]bool, len(request.Status))
+	for _, status := range request.Status {
+		statuses[status] = true
+	}
+	fragments := make([]*pbDeploymentMgr.MonitoredFragment, 0)
+	for _, entry := range m.monitored.ListEntries() {
+		if entry.OrganizationId != request.OrganizationId {
+			continue
+		}
+		if request.AppInstanceId != "" && entry.AppInstanceId != request.AppInstanceId {
+			continue
+		}
+		if len(statuses) > 0 && !statuses[entities.FragmentStatusToGRPC[entry.Status]] {
+			continue
+		}
+		fragments = append(fragments, entry.ToGRPC())
+	}
+	return &pbDeploymentMgr.MonitoredFragmentList{Fragments: fragments}, nil
+}
+
+// Get the status of an application instance and its fragments.
+//  params:
+//   request application instance identifier
+//  return:
+//   status of the application or error if not monitored
+func (m *Manager) GetAppStatus(request *pbApplication.AppInstanceId) (*pbDeploymentMgr.AppStatus, derrors.Error) {
+	fragments := make([]*pbDeploymentMgr.MonitoredFragment, 0)
+	for _, entry := range m.monitored.ListEntries() {
+		if entry.OrganizationId == request.OrganizationId && entry.AppInstanceId == request.AppInstanceId {
+			fragments = append(fragments, entry.ToGRPC())
+		}
+	}
+	if len(fragments) == 0 {
+		return nil, derrors.NewNotFoundError("application instance not monitored").WithParams(request.AppInstanceId)
+	}
+	status, err := m.monitored.GetAppStatus(request.AppInstanceId)
+	if err != nil {
+		return nil, derrors.NewNotFoundError("application instance not monitored", err).WithParams(request.AppInstanceId)
+	}
+	return &pbDeploymentMgr.AppStatus{
+		OrganizationId: request.OrganizationId,
+		AppInstanceId:  request.AppInstanceId,
+		Status:         entities.FragmentStatusToGRPC[*status],
+		Fragments:      fragments,
+	}, nil
+}
+
+// Watch the status transitions of the monitored entries of an organization.
+//  params:
+//   request with the organization, optional application instance and fragment filters and resume token
+//  return:
+//   subscription to the transitions or error if the watch cannot be resumed
+func (m *Manager) WatchStatus(request *pbDeploymentMgr.WatchStatusRequest) (*monitor.StatusSubscription, derrors.Error) {
+	filter := entities.StatusEventFilter{
+		OrganizationId: request.OrganizationId,
+		AppInstanceId:  request.AppInstanceId,
+		FragmentId:     request.FragmentId,
+	}
+	return m.monitored.WatchStatus(filter, request.ResumeToken)
+}
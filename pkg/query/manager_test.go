@@ -0,0 +1,141 @@
+/*
+ * Copyright 2019 Nalej
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+
+package query
+
+import (
+	"github.com/nalej/deployment-manager/internal/entities"
+	"github.com/nalej/deployment-manager/internal/structures/monitor"
+	pbApplication "github.com/nalej/grpc-application-go"
+	pbConductor "github.com/nalej/grpc-conductor-go"
+	pbDeploymentMgr "github.com/nalej/grpc-deployment-manager-go"
+	"github.com/onsi/ginkgo"
+	"github.com/onsi/gomega"
+)
+
+// Build a monitored fragment with a single service.
+func testEntry(organizationId string, appInstanceId string, fragmentId string) *entities.MonitoredAppEntry {
+	return &entities.MonitoredAppEntry{
+		OrganizationId: organizationId,
+		AppInstanceId:  appInstanceId,
+		FragmentId:     fragmentId,
+		Status:         entities.FRAGMENT_WAITING,
+		Services: map[string]*entities.MonitoredServiceEntry{
+			fragmentId + "-service": {
+				OrganizationId:    organizationId,
+				AppInstanceId:     appInstanceId,
+				FragmentId:        fragmentId,
+				ServiceInstanceID: fragmentId + "-service",
+				Status:            entities.NALEJ_SERVICE_SCHEDULED,
+				Resources:         make(map[string]*entities.MonitoredPlatformResource, 0),
+			},
+		},
+		TotalServices: 1,
+	}
+}
+
+// Build monitored instances with two applications of the same organization and one of another organization.
+func testMonitored() monitor.MonitoredInstances {
+	monitored := monitor.NewMemoryMonitoredInstances()
+	monitored.AddEntry(testEntry("org", "app1", "fragment1"))
+	monitored.AddEntry(testEntry("org", "app1", "fragment2"))
+	monitored.AddEntry(testEntry("org", "app2", "fragment3"))
+	monitored.AddEntry(testEntry("other", "app3", "fragment4"))
+	monitored.SetEntryStatus("fragment1", entities.FRAGMENT_DONE, nil)
+	monitored.SetEntryStatus("fragment2", entities.FRAGMENT_DONE, nil)
+	monitored.SetEntryStatus("fragment3", entities.FRAGMENT_ERROR, nil)
+	return monitored
+}
+
+var _ = ginkgo.Describe("query manager", func() {
+
+	var manager *Manager
+
+	ginkgo.BeforeEach(func() {
+		manager = NewManager(testMonitored())
+	})
+
+	ginkgo.Context("get fragment", func() {
+		ginkgo.It("should return a fragment of the organization", func() {
+			fragment, err := manager.GetFragment(&pbDeploymentMgr.DeploymentFragmentId{OrganizationId: "org", FragmentId: "fragment3"})
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(fragment.AppInstanceId).To(gomega.Equal("app2"))
+			gomega.Expect(fragment.Status).To(gomega.Equal(pbConductor.DeploymentFragmentStatus_ERROR))
+			gomega.Expect(fragment.Services).To(gomega.HaveLen(1))
+		})
+
+		ginkgo.It("should not return fragments of other organizations", func() {
+			_, err := manager.GetFragment(&pbDeploymentMgr.DeploymentFragmentId{OrganizationId: "org", FragmentId: "fragment4"})
+			gomega.Expect(err).NotTo(gomega.BeNil())
+		})
+	})
+
+	ginkgo.Context("list fragments", func() {
+		ginkgo.It("should list the fragments of the organization", func() {
+			list, err := manager.ListFragments(&pbDeploymentMgr.ListFragmentsRequest{OrganizationId: "org"})
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(list.Fragments).To(gomega.HaveLen(3))
+		})
+
+		ginkgo.It("should filter by application instance", func() {
+			list, err := manager.ListFragments(&pbDeploymentMgr.ListFragmentsRequest{OrganizationId: "org", AppInstanceId: "app1"})
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(list.Fragments).To(gomega.HaveLen(2))
+		})
+
+		ginkgo.It("should filter by status", func() {
+			list, err := manager.ListFragments(&pbDeploymentMgr.ListFragmentsRequest{OrganizationId: "org",
+				Status: []pbConductor.DeploymentFragmentStatus{pbConductor.DeploymentFragmentStatus_ERROR}})
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(list.Fragments).To(gomega.HaveLen(1))
+			gomega.Expect(list.Fragments[0].FragmentId).To(gomega.Equal("fragment3"))
+		})
+	})
+
+	ginkgo.Context("get application status", func() {
+		ginkgo.It("should return the status with every fragment", func() {
+			status, err := manager.GetAppStatus(&pbApplication.AppInstanceId{OrganizationId: "org", AppInstanceId: "app1"})
+			gomega.Expect(err).To(gomega.BeNil())
+			gomega.Expect(status.Status).To(gomega.Equal(pbConductor.DeploymentFragmentStatus_DONE))
+			gomega.Expect(status.Fragments).To(gomega.HaveLen(2))
+		})
+
+		ginkgo.It("should not return applications of other organizations", func() {
+			_, err := manager.GetAppStatus(&pbApplication.AppInstanceId{OrganizationId: "org", AppInstanceId: "app3"})
+			gomega.Expect(err).NotTo(gomega.BeNil())
+		})
+	})
+
+	ginkgo.Context("watch status", func() {
+		ginkgo.It("should receive the transitions of the organization", func() {
+			monitored := monitor.NewMemoryMonitoredInstances()
+			manager = NewManager(monitored)
+			monitored.AddEntry(testEntry("org", "app1", "fragment1"))
+			monitored.AddEntry(testEntry("other", "app3", "fragment4"))
+
+			subscription, err := manager.WatchStatus(&pbDeploymentMgr.WatchStatusRequest{OrganizationId: "org"})
+			gomega.Expect(err).To(gomega.BeNil())
+			defer subscription.Close()
+			monitored.SetEntryStatus("fragment4", entities.FRAGMENT_DONE, nil)
+			monitored.SetEntryStatus("fragment1", entities.FRAGMENT_DONE, nil)
+
+			var event *entities.StatusEvent
+			gomega.Eventually(subscription.Events).Should(gomega.Receive(&event))
+			gomega.Expect(event.FragmentId).To(gomega.Equal("fragment1"))
+		})
+	})
+})
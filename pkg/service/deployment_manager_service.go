@@ -32,21 +32,29 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/nalej/deployment-manager/internal/collect"
 	"github.com/nalej/deployment-manager/internal/structures"
 	"github.com/nalej/deployment-manager/internal/structures/monitor"
 
+	"github.com/nalej/deployment-manager/pkg/audit"
+	"github.com/nalej/deployment-manager/pkg/auth"
 	"github.com/nalej/deployment-manager/pkg/config"
+	"github.com/nalej/deployment-manager/pkg/election"
 	"github.com/nalej/deployment-manager/pkg/handler"
+	"github.com/nalej/deployment-manager/pkg/health"
 	"github.com/nalej/deployment-manager/pkg/kubernetes"
 	"github.com/nalej/deployment-manager/pkg/kubernetes/events"
 	"github.com/nalej/deployment-manager/pkg/login-helper"
+	"github.com/nalej/deployment-manager/pkg/metrics"
 	"github.com/nalej/deployment-manager/pkg/metrics/prometheus"
 	monitor2 "github.com/nalej/deployment-manager/pkg/monitor"
 	"github.com/nalej/deployment-manager/pkg/network"
 	"github.com/nalej/deployment-manager/pkg/proxy"
+	"github.com/nalej/deployment-manager/pkg/query"
 	"github.com/nalej/deployment-manager/pkg/utils"
 
 	"github.com/nalej/derrors"
@@ -58,9 +66,14 @@ import (
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
+	grpcHealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 )
 
+// Maximum time to wait for the servers to stop
+const DefaultServerStopTimeout = 10 * time.Second
+
 type DeploymentManagerService struct {
 	// Manager with the logic for incoming requests
 	mgr *handler.Manager
@@ -72,11 +85,48 @@ type DeploymentManagerService struct {
 	netProxy *proxy.Manager
 	// Offline Policy Manager
 	offlinePolicy *offline_policy.Manager
+	// Manager for read-only queries
+	query *query.Manager
+	// Helper notifying conductor about status changes
+	monitor executor.Monitor
+	// Janitor removing the entries of the fragments removed from the cluster
+	janitor *monitor2.Janitor
+	// Provider of kubernetes events
+	events *events.EventsProvider
+	// Audit trail of the mutating operations
+	auditor *audit.Auditor
+	// Checker of the dependencies of the service
+	health *health.Checker
+	// gRPC health service updated by the checker
+	healthServer *grpcHealth.Server
+	// Helper keeping the login with the cluster API
+	loginHelper *login_helper.LoginHelper
+	// Elector of the leader among the replicas, nil if the instance runs alone
+	elector *election.Elector
+	// Leadership of the instance
+	leadership election.Leadership
+	// Reconciler rebuilding the monitored instances when taking over the leadership
+	reconciler *kubernetes.Reconciler
+	// Dispatcher of the events of the monitored resources
+	dispatcher *events.Dispatcher
+	// Controller of the rollouts in progress
+	rollouts *kubernetes.RolloutController
+	// Network decorator splitting the traffic of the recovered rollouts
+	networkDecorator executor.NetworkDecorator
+	// The instance runs the work of the leader
+	leading bool
+	// The service is shutting down and must not start the work of the leader
+	shuttingDown bool
+	// Channel closed when the leadership is lost
+	lostLeadership chan struct{}
+	// Mutex for the leadership state
+	leadingMu sync.Mutex
 	// configuration
 	configuration config.Config
 }
 
-func getClusterAPIConnection(hostname string, port int, caCertPath string, clientCertPath string, skipCAValidation bool) (*grpc.ClientConn, derrors.Error) {
+func getClusterAPIConnection(hostname string, port int, caCertPath string, clientCertPath string, skipCAValidation bool,
+	loginHelper *login_helper.LoginHelper) (*grpc.ClientConn, derrors.Error) {
 	// Build connection with cluster API
 	rootCAs := x509.NewCertPool()
 	tlsConfig := &tls.Config{
@@ -118,7 +168,9 @@ func getClusterAPIConnection(hostname string, port int, caCertPath string, clien
 	creds := credentials.NewTLS(tlsConfig)
 
 	log.Debug().Interface("creds", creds.Info()).Msg("Secure credentials")
-	sConn, dErr := grpc.Dial(targetAddress, grpc.WithTransportCredentials(creds))
+	// the calls are authenticated with the credentials of the login helper
+	sConn, dErr := grpc.Dial(targetAddress, grpc.WithTransportCredentials(creds),
+		grpc.WithUnaryInterceptor(loginHelper.UnaryClientInterceptor()))
 	if dErr != nil {
 		return nil, derrors.AsError(dErr, "cannot create connection with the cluster API service")
 	}
@@ -151,21 +203,22 @@ func NewDeploymentManagerService(cfg *config.Config) (*DeploymentManagerService,
 
 	// Build connection with conductor
 	log.Debug().Str("hostname", cfg.ClusterAPIHostname).Msg("connecting with cluster api")
-	clusterAPIConn, errCond := getClusterAPIConnection(cfg.ClusterAPIHostname, int(cfg.ClusterAPIPort), cfg.CACertPath, cfg.ClientCertPath, cfg.SkipServerCertValidation)
+	clusterAPIConn, errCond := getClusterAPIConnection(cfg.ClusterAPIHostname, int(cfg.ClusterAPIPort), cfg.CACertPath, cfg.ClientCertPath, cfg.SkipServerCertValidation,
+		clusterAPILoginHelper)
 	if errCond != nil {
 		log.Panic().Err(err).Str("hostname", cfg.ClusterAPIHostname).Msg("impossible to connect with cluster api")
 		panic(err.Error())
 		return nil, errCond
 	}
 
-	log.Info().Msg("instantiate memory based instances monitor structure...")
-	instanceMonitor := monitor.NewMemoryMonitoredInstances()
-	log.Info().Msg("done")
+	instanceMonitor, derr := getMonitoredInstances(cfg)
+	if derr != nil {
+		log.Error().Str("err", derr.DebugReport()).Msg("impossible to create the monitored instances")
+		return nil, derr
+	}
 
-	log.Info().Msg("start monitor helper service...")
-	monitorService := monitor2.NewMonitorHelper(clusterAPIConn, clusterAPILoginHelper, instanceMonitor)
-	go monitorService.Run()
-	log.Info().Msg("done")
+	// The monitor helper only runs in the leader
+	monitorService := monitor2.NewMonitorHelper(clusterAPIConn, instanceMonitor, cfg.NotificationResyncPeriod)
 
 	// Create Kubernetes Event provider
 	// Only get events relevant for user applications
@@ -188,12 +241,39 @@ func NewDeploymentManagerService(cfg *config.Config) (*DeploymentManagerService,
 		return nil, derr
 	}
 
+	k8sClient, derr := kubernetes.GetKubernetesClient(cfg.Local)
+	if derr != nil {
+		return nil, derr
+	}
+
+	// Rollouts follow the status the controller observes for their deployments
+	rollouts := kubernetes.NewRolloutController(k8sClient, controller)
+	controller.AddDeploymentObserver(rollouts)
+
+	// Rebuild the monitored instances from the cluster before receiving any event
+	log.Info().Msg("reconcile monitored instances with the cluster...")
+	reconciler := kubernetes.NewReconciler(k8sClient, controller)
+	derr = reconciler.Reconcile()
+	if derr != nil {
+		return nil, derr
+	}
+	log.Info().Msg("done")
+
+	// The janitor only runs in the leader
+	janitor := monitor2.NewJanitor(instanceMonitor, kubernetes.NewFragmentChecker(k8sClient), cfg.TerminatedRetention)
+
 	// Create metrics endpoint provider
 	promMetrics, derr := prometheus.NewMetricsProvider()
 	if derr != nil {
 		return nil, derr
 	}
 	collector := promMetrics.GetCollector()
+	derr = promMetrics.AddGaugeFunc(metrics.MetricFragments, metrics.MetricRetained.String(),
+		"Number of monitored fragments being removed or removed from the cluster",
+		func() float64 { return float64(janitor.GetNumRetained()) })
+	if derr != nil {
+		return nil, derr
+	}
 
 	// Create the dispatcher to handle metrics
 	metricsTranslator := kubernetes.NewMetricsTranslator(collector)
@@ -220,7 +300,7 @@ func NewDeploymentManagerService(cfg *config.Config) (*DeploymentManagerService,
 	}
 
 	// Create the Kubernetes executor
-	exec, kubErr := kubernetes.NewKubernetesExecutor(cfg.Local, controller)
+	exec, kubErr := kubernetes.NewKubernetesExecutor(cfg.Local, controller, rollouts)
 	if kubErr != nil {
 		log.Panic().Err(err).Msg("there was an error creating kubernetes client")
 		panic(err.Error())
@@ -230,8 +310,11 @@ func NewDeploymentManagerService(cfg *config.Config) (*DeploymentManagerService,
 	nalejDNSForPods := strings.Split(cfg.DNS, ",")
 	nalejDNSForPods = append(nalejDNSForPods, "8.8.8.8")
 
-	// Instantiate a memory queue for requests
-	requestsQueue := structures.NewMemoryRequestQueue()
+	// Instantiate the queue for requests
+	requestsQueue, derr := getRequestsQueue(cfg)
+	if derr != nil {
+		return nil, derr
+	}
 
 	// Build the network decorator according to config info
 	networkDecorator, errNetworkDecorator := getNetworkDecorator(cfg)
@@ -240,6 +323,12 @@ func NewDeploymentManagerService(cfg *config.Config) (*DeploymentManagerService,
 		return nil, errNetworkDecorator
 	}
 
+	// Resume the rollouts left in progress, their statuses are processed once the rollout controller runs
+	derr = rollouts.Recover(networkDecorator)
+	if derr != nil {
+		return nil, derr
+	}
+
 	// Instantiate deployment manager service
 	log.Info().Msg("star deployment requests manager")
 
@@ -249,12 +338,6 @@ func NewDeploymentManagerService(cfg *config.Config) (*DeploymentManagerService,
 	}
 	ulClient := grpc_unified_logging_go.NewSlaveClient(ulConn)
 
-	// Instantiate network manager service
-	k8sClient, err := kubernetes.GetKubernetesClient(cfg.Local)
-	if err != nil {
-		return nil, err
-	}
-
 	sfConn, sfErr := grpc.Dial(cfg.StorageFabricAddress, grpc.WithInsecure())
 	if sfErr != nil {
 		return nil, derrors.AsError(sfErr, "cannot create connection with storage fabric")
@@ -262,25 +345,88 @@ func NewDeploymentManagerService(cfg *config.Config) (*DeploymentManagerService,
 	sfClient := grpc_storage_fabric_go.NewStorageClassClient(sfConn)
 
 	mgr := handler.NewManager(&exec, cfg.ClusterPublicHostname, requestsQueue, nalejDNSForPods, instanceMonitor,
-		cfg.PublicCredentials, networkDecorator, ulClient, k8sClient, sfClient)
-	go mgr.Run()
+		cfg.PublicCredentials, networkDecorator, ulClient, k8sClient, sfClient,
+		handler.NewWorkerPool(cfg.MaxConcurrentFragments, cfg.MaxConcurrentFragmentsPerOrg), cfg.MaxQueueLength,
+		handler.RetryPolicy{
+			MaxAttempts:       cfg.RetryMaxAttempts,
+			InitialBackoff:    cfg.RetryInitialBackoff,
+			MaxBackoff:        cfg.RetryMaxBackoff,
+			Jitter:            cfg.RetryJitter,
+			StageCheckTimeout: cfg.StageCheckTimeout,
+		})
 	log.Info().Msg("done")
 
-	net := network.NewManager(clusterAPIConn, clusterAPILoginHelper, k8sClient)
+	net := network.NewManager(clusterAPIConn, k8sClient)
 
 	// Instantiate app network manager service
-	netProxy := proxy.NewManager(clusterAPIConn, clusterAPILoginHelper)
+	netProxy := proxy.NewManager(clusterAPIConn)
 
 	// Instantiate offline policy service
 	offlinePolicy := offline_policy.NewManager()
 
+	// Instantiate query service
+	queryManager := query.NewManager(instanceMonitor)
+
+	// The service is ready when it can reach all its dependencies
+	healthServer := grpcHealth.NewServer()
+	checker := health.NewChecker(healthServer, cfg.HealthCheckInterval, cfg.HealthCheckTimeout)
+	checker.AddCheck("kubernetes", health.KubernetesCheck(k8sClient.Discovery()))
+	checker.AddCheck("informers", health.SyncedCheck(kubernetesEvents))
+	checker.AddCheck("clusterAPILogin", health.LoginCheck(clusterAPILoginHelper))
+	checker.AddCheck("unifiedLogging", health.ConnectionCheck(ulConn))
+	checker.AddCheck("storageFabric", health.ConnectionCheck(sfConn))
+
+	auditor := audit.NewAuditor(audit.DefaultAuditedMethods)
+	if cfg.AuditLogPath != "" {
+		auditFile, err := audit.NewFileSink(cfg.AuditLogPath, cfg.AuditLogMaxSize, cfg.AuditLogMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		auditor.AddSink(auditFile)
+	}
+	if cfg.AuditLogStdout {
+		// the logs of the deployment manager go to the standard error
+		auditor.AddSink(audit.NewStreamSink(os.Stdout))
+	}
+
 	instance := &DeploymentManagerService{
-		mgr:           mgr,
-		net:           net,
-		collect:       collectManager,
-		netProxy:      netProxy,
-		offlinePolicy: offlinePolicy,
-		configuration: *cfg,
+		mgr:              mgr,
+		net:              net,
+		collect:          collectManager,
+		netProxy:         netProxy,
+		offlinePolicy:    offlinePolicy,
+		query:            queryManager,
+		monitor:          monitorService,
+		janitor:          janitor,
+		events:           kubernetesEvents,
+		auditor:          auditor,
+		health:           checker,
+		healthServer:     healthServer,
+		loginHelper:      clusterAPILoginHelper,
+		leadership:       election.AlwaysLeader{},
+		reconciler:       reconciler,
+		dispatcher:       deploymentDispatcher,
+		rollouts:         rollouts,
+		networkDecorator: networkDecorator,
+		lostLeadership:   make(chan struct{}),
+		configuration:    *cfg,
+	}
+
+	if cfg.LeaderElection {
+		identity, derr := election.DefaultIdentity()
+		if derr != nil {
+			return nil, derr
+		}
+		elector, derr := election.NewElector(k8sClient, cfg.LeaseNamespace, cfg.LeaseName, identity,
+			cfg.LeaseDuration, cfg.LeaseRenewDeadline, cfg.LeaseRetryPeriod, election.Callbacks{
+				OnStartedLeading: func() { instance.startLeading(true) },
+				OnStoppedLeading: instance.stopLeading,
+			})
+		if derr != nil {
+			return nil, derr
+		}
+		instance.elector = elector
+		instance.leadership = elector
 	}
 
 	return instance, nil
@@ -306,13 +452,22 @@ func (d *DeploymentManagerService) Run() {
 	if derr != nil {
 		log.Fatal().Err(derr).Str("err", derr.DebugReport()).Msg("failed to start metrics server")
 	}
-	defer httpServer.Shutdown(context.TODO()) // Add timeout in context
 
 	grpcServer, derr := d.startGRPC(grpcListener, errChan)
 	if derr != nil {
 		log.Fatal().Err(derr).Str("err", derr.DebugReport()).Msg("failed to start gRPC server")
 	}
-	defer grpcServer.GracefulStop()
+	defer d.shutdown(grpcServer, httpServer)
+
+	go d.health.Run()
+	go d.loginHelper.Run()
+	go d.rollouts.Run()
+
+	if d.elector != nil {
+		go d.elector.Run()
+	} else {
+		d.startLeading(false)
+	}
 
 	// Wait for termination signal
 	sigterm := make(chan os.Signal, 1)
@@ -326,22 +481,124 @@ func (d *DeploymentManagerService) Run() {
 		if err != nil {
 			log.Fatal().Err(err).Msg("error running server")
 		}
+	case <-d.lostLeadership:
+		log.Error().Msg("leadership lost, shutting down so another instance takes over")
+	}
+}
+
+// Start the work of the leader: processing the deployment requests and notifying conductor.
+//  params:
+//   takeover the leadership is taken over from another instance whose changes must be reconciled
+func (d *DeploymentManagerService) startLeading(takeover bool) {
+	if takeover {
+		// the previous leader may have deployed or changed fragments after the monitored instances were rebuilt
+		log.Info().Msg("reconcile monitored instances to take over the leadership...")
+		if derr := d.reconciler.Reconcile(); derr != nil {
+			log.Error().Str("err", derr.DebugReport()).Msg("cannot reconcile monitored instances, status may be outdated")
+		}
+		if derr := d.rollouts.Recover(d.networkDecorator); derr != nil {
+			log.Error().Str("err", derr.DebugReport()).Msg("cannot recover the rollouts started by the previous leader")
+		}
+		d.dispatcher.Resync()
+	}
+	d.leadingMu.Lock()
+	defer d.leadingMu.Unlock()
+	if d.shuttingDown {
+		return
+	}
+	d.leading = true
+	log.Info().Msg("start deployment requests manager, monitor helper and janitor")
+	go d.mgr.Run()
+	go d.monitor.Run()
+	go d.janitor.Run()
+}
+
+// Signal that the leadership was lost. The service must stop as another instance may take over at any time.
+func (d *DeploymentManagerService) stopLeading() {
+	close(d.lostLeadership)
+}
+
+// Check if the instance runs the work of the leader. The work of the leader cannot start after this check.
+func (d *DeploymentManagerService) stopStartingLeader() bool {
+	d.leadingMu.Lock()
+	defer d.leadingMu.Unlock()
+	d.shuttingDown = true
+	return d.leading
+}
+
+// Stop the service in order. New deployments are rejected while the running ones finish, then the pending
+// notifications are sent to conductor and finally the events provider and the servers are stopped.
+func (d *DeploymentManagerService) shutdown(grpcServer *grpc.Server, httpServer *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.configuration.ShutdownTimeout)
+	defer cancel()
+
+	// stop being ready first so no new requests are routed to this instance
+	d.health.Stop()
+	leading := d.stopStartingLeader()
+	d.mgr.Shutdown(ctx)
+	if leading {
+		d.monitor.Stop()
+		d.janitor.Stop()
+	}
+	// the lease is released once the work of the leader is finished
+	if d.elector != nil {
+		d.elector.Stop()
+	}
+	// the token is no longer needed once conductor has been notified
+	d.loginHelper.Stop()
+	if derr := d.events.Stop(); derr != nil {
+		log.Error().Str("err", derr.DebugReport()).Msg("error stopping kubernetes events provider")
+	}
+	d.rollouts.Stop()
+
+	// streaming calls may never end, stop the server if they do not finish in time
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(DefaultServerStopTimeout):
+		log.Warn().Msg("gRPC server did not stop gracefully, forcing it")
+		grpcServer.Stop()
+	}
+
+	httpCtx, httpCancel := context.WithTimeout(context.Background(), DefaultServerStopTimeout)
+	defer httpCancel()
+	if err := httpServer.Shutdown(httpCtx); err != nil {
+		log.Error().Err(err).Msg("error stopping http server")
 	}
+	d.auditor.Close()
+	log.Info().Msg("deployment manager stopped")
 }
 
 func (d *DeploymentManagerService) startGRPC(grpcListener net.Listener, errChan chan<- error) (*grpc.Server, derrors.Error) {
 	// Create handlers
-	deployment := handler.NewHandler(d.mgr)
+	deployment := handler.NewHandler(d.mgr,
+		handler.NewDefaultValidatorChain(d.configuration.TargetPlatform, d.configuration.FragmentStorageQuota))
 	network := network.NewHandler(d.net)
 	netProxy := proxy.NewHandler(d.netProxy)
 	offlinePolicy := offline_policy.NewHandler(d.offlinePolicy)
+	query := query.NewHandler(d.query)
+
+	options, derr := d.getServerOptions()
+	if derr != nil {
+		return nil, derr
+	}
 
 	// Register handlers with server
-	grpcServer := grpc.NewServer()
+	grpcServer := grpc.NewServer(options...)
 	pbDeploymentMgr.RegisterDeploymentManagerServer(grpcServer, deployment)
 	pbDeploymentMgr.RegisterDeploymentManagerNetworkServer(grpcServer, network)
 	pbDeploymentMgr.RegisterApplicationProxyServer(grpcServer, netProxy)
 	pbDeploymentMgr.RegisterOfflinePolicyServer(grpcServer, offlinePolicy)
+	pbDeploymentMgr.RegisterDeploymentManagerQueryServer(grpcServer, query)
+	// the health service reports the status of every service registered
+	for service := range grpcServer.GetServiceInfo() {
+		d.health.AddServices(service)
+	}
+	healthpb.RegisterHealthServer(grpcServer, d.healthServer)
 
 	if d.configuration.Debug {
 		reflection.Register(grpcServer)
@@ -361,6 +618,57 @@ func (d *DeploymentManagerService) startGRPC(grpcListener net.Listener, errChan
 	return grpcServer, nil
 }
 
+// Get the options of the gRPC server. The calls are authorized before being audited so the audit log contains
+// the verified identity of the callers.
+func (d *DeploymentManagerService) getServerOptions() ([]grpc.ServerOption, derrors.Error) {
+	options := make([]grpc.ServerOption, 0)
+	if d.configuration.ServerCertPath != "" {
+		creds, err := auth.NewServerCredentials(d.configuration.ServerCertPath, d.configuration.ClientCAPath)
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, grpc.Creds(creds))
+	}
+
+	// the calls are audited before being authorized so the rejected ones are recorded too
+	unary := []grpc.UnaryServerInterceptor{d.auditor.UnaryServerInterceptor()}
+	if d.configuration.AuthSecret != "" {
+		permissions := auth.DefaultPermissions
+		if d.configuration.AuthPermissionsPath != "" {
+			loaded, err := auth.LoadPermissions(d.configuration.AuthPermissionsPath)
+			if err != nil {
+				return nil, err
+			}
+			permissions = loaded
+		}
+		authorizer := auth.NewAuthorizer([]byte(d.configuration.AuthSecret), permissions, health.GRPCHealthMethods...)
+		unary = append(unary, authorizer.UnaryServerInterceptor())
+		options = append(options, grpc.StreamInterceptor(authorizer.StreamServerInterceptor()))
+	} else {
+		log.Warn().Msg("authentication of the gRPC calls is disabled")
+	}
+	// mutating operations, the audited ones, are only served by the leader
+	unary = append(unary, d.auditor.IdentityServerInterceptor(),
+		election.LeaderOnlyInterceptor(d.leadership, audit.DefaultAuditedMethods))
+	options = append(options, grpc.UnaryInterceptor(chainUnaryInterceptors(unary...)))
+
+	return options, nil
+}
+
+// Chain a set of unary interceptors, the first one is the outermost.
+func chainUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return chained(ctx, req)
+	}
+}
+
 func (d *DeploymentManagerService) startMetrics(httpListener net.Listener, errChan chan<- error) (*http.Server, derrors.Error) {
 	// Create handler
 	handler, derr := collect.NewHandler(d.collect)
@@ -368,9 +676,15 @@ func (d *DeploymentManagerService) startMetrics(httpListener net.Listener, errCh
 		return nil, derr
 	}
 
-	// Create server with metrics handler
+	healthHandler := health.NewHandler(d.health)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", handler)
+	mux.HandleFunc("/healthz", healthHandler.Healthz)
+	mux.HandleFunc("/readyz", healthHandler.Readyz)
+
+	// Create server with metrics and health handlers
 	httpServer := &http.Server{
-		Handler: handler,
+		Handler: mux,
 	}
 
 	// Start manager
@@ -394,6 +708,28 @@ func (d *DeploymentManagerService) startMetrics(httpListener net.Listener, errCh
 	return httpServer, nil
 }
 
+func getRequestsQueue(configuration *config.Config) (structures.RequestsQueue, derrors.Error) {
+	switch configuration.QueueType {
+	case config.QueueTypeFile:
+		log.Info().Str("path", configuration.QueuePath).Msg("instantiate file based requests queue")
+		return structures.NewFileRequestQueue(configuration.QueuePath)
+	default:
+		log.Info().Msg("instantiate memory based requests queue")
+		return structures.NewMemoryRequestQueue(), nil
+	}
+}
+
+func getMonitoredInstances(configuration *config.Config) (monitor.MonitoredInstances, derrors.Error) {
+	switch configuration.MonitorStoreType {
+	case config.MonitorStoreTypeFile:
+		log.Info().Str("path", configuration.MonitorStorePath).Msg("instantiate file based instances monitor structure")
+		return monitor.NewFileMonitoredInstances(configuration.MonitorStorePath)
+	default:
+		log.Info().Msg("instantiate memory based instances monitor structure")
+		return monitor.NewMemoryMonitoredInstances(), nil
+	}
+}
+
 func getNetworkDecorator(configuration *config.Config) (executor.NetworkDecorator, derrors.Error) {
 	switch configuration.NetworkType {
 	case config.NetworkTypeZt:
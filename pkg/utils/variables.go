@@ -68,6 +68,13 @@ const (
 	NALEJ_ANNOTATION_INGRESS_ENDPOINT = "nalej-endpoint"
 	// Annotation for metadata to identify the security rule.
 	NALEJ_ANNOTATION_SECURITY_RULE_ID = "nalej-security-rule-id"
+	// Annotation for metadata to identify the version of a service during a rollout
+	NALEJ_ANNOTATION_ROLLOUT_TRACK      = "nalej-rollout-track"
+	NALEJ_ANNOTATION_VALUE_STABLE_TRACK = "stable"
+	NALEJ_ANNOTATION_VALUE_CANARY_TRACK = "canary"
+	NALEJ_ANNOTATION_VALUE_GREEN_TRACK  = "green"
+	// Annotation with the number of replicas a deployment had before its application was suspended
+	NALEJ_ANNOTATION_SUSPENDED_REPLICAS = "nalej-suspended-replicas"
 
 	// TODO review this notation. It must be uppercase
 	NALEJ_ANNOTATION_SERVICE_PURPOSE             = "nalej-service-purpose"